@@ -0,0 +1,290 @@
+// Package abi implements the solidity contract ABI, which parses the json
+// interface of contracts, encodes method calls and decodes return values
+// and event logs.
+package abi
+
+import (
+	"tinychain/common"
+	"tinychain/core/types"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"github.com/ethereum/go-ethereum/crypto"
+	json "github.com/json-iterator/go"
+)
+
+var (
+	ErrMethodNotFound = errors.New("abi: method not found")
+	ErrEventNotFound  = errors.New("abi: event not found")
+	ErrNoTopics       = errors.New("abi: log topics not match event")
+
+	// revertSelector is the selector of Error(string) of solidity revert
+	revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+)
+
+// Method is a callable function of contract
+type Method struct {
+	Name            string // unique name in ABI, overloaded functions are suffixed by index
+	RawName         string // function name in solidity
+	Constant        bool
+	Payable         bool
+	StateMutability string
+	Inputs          Arguments
+	Outputs         Arguments
+}
+
+// Sig returns the signature of method, e.g. "transfer(address,uint256)"
+func (m Method) Sig() string {
+	types := make([]string, len(m.Inputs))
+	for i, input := range m.Inputs {
+		types[i] = input.Type.String()
+	}
+	return fmt.Sprintf("%s(%s)", m.RawName, strings.Join(types, ","))
+}
+
+// ID returns the 4 bytes selector of method
+func (m Method) ID() []byte {
+	return crypto.Keccak256([]byte(m.Sig()))[:4]
+}
+
+// Event is an event emitted by contract in logs
+type Event struct {
+	Name      string
+	RawName   string
+	Anonymous bool // anonymous event has no signature topic
+	Inputs    Arguments
+}
+
+// Sig returns the signature of event, e.g. "Transfer(address,address,uint256)"
+func (e Event) Sig() string {
+	types := make([]string, len(e.Inputs))
+	for i, input := range e.Inputs {
+		types[i] = input.Type.String()
+	}
+	return fmt.Sprintf("%s(%s)", e.RawName, strings.Join(types, ","))
+}
+
+// ID returns the signature hash of event, which is the first topic of logs
+func (e Event) ID() common.Hash {
+	return common.BytesToHash(crypto.Keccak256([]byte(e.Sig())))
+}
+
+// ABI is the json interface of contract
+type ABI struct {
+	Constructor Method
+	Methods     map[string]Method
+	Events      map[string]Event
+}
+
+// JSON parses the json ABI from reader
+func JSON(reader io.Reader) (ABI, error) {
+	var abi ABI
+	if err := json.NewDecoder(reader).Decode(&abi); err != nil {
+		return ABI{}, err
+	}
+	return abi, nil
+}
+
+func (abi *ABI) UnmarshalJSON(data []byte) error {
+	var fields []struct {
+		Type            string    `json:"type"`
+		Name            string    `json:"name"`
+		Constant        bool      `json:"constant"`
+		Payable         bool      `json:"payable"`
+		StateMutability string    `json:"stateMutability"`
+		Anonymous       bool      `json:"anonymous"`
+		Inputs          Arguments `json:"inputs"`
+		Outputs         Arguments `json:"outputs"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	abi.Methods = make(map[string]Method)
+	abi.Events = make(map[string]Event)
+	for _, field := range fields {
+		switch field.Type {
+		case "constructor":
+			abi.Constructor = Method{
+				Payable:         field.Payable || field.StateMutability == "payable",
+				StateMutability: field.StateMutability,
+				Inputs:          field.Inputs,
+			}
+		case "function", "":
+			name := overloadedName(field.Name, func(name string) bool { _, ok := abi.Methods[name]; return ok })
+			abi.Methods[name] = Method{
+				Name:            name,
+				RawName:         field.Name,
+				Constant:        field.Constant || field.StateMutability == "view" || field.StateMutability == "pure",
+				Payable:         field.Payable || field.StateMutability == "payable",
+				StateMutability: field.StateMutability,
+				Inputs:          field.Inputs,
+				Outputs:         field.Outputs,
+			}
+		case "event":
+			name := overloadedName(field.Name, func(name string) bool { _, ok := abi.Events[name]; return ok })
+			abi.Events[name] = Event{
+				Name:      name,
+				RawName:   field.Name,
+				Anonymous: field.Anonymous,
+				Inputs:    field.Inputs,
+			}
+		case "fallback", "receive", "error":
+			// Not callable by name
+		default:
+			return fmt.Errorf("abi: unknown entry type %s", field.Type)
+		}
+	}
+	return nil
+}
+
+// overloadedName returns the unused name of overloaded functions, e.g. foo, foo0, foo1
+func overloadedName(raw string, exists func(string) bool) string {
+	name := raw
+	for i := 0; exists(name); i++ {
+		name = fmt.Sprintf("%s%d", raw, i)
+	}
+	return name
+}
+
+// Pack encodes the call of method with selector, or the constructor
+// arguments without selector if name is empty
+func (abi ABI) Pack(name string, args ...interface{}) ([]byte, error) {
+	if name == "" {
+		return abi.Constructor.Inputs.Pack(args...)
+	}
+	method, ok := abi.Methods[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", ErrMethodNotFound, name)
+	}
+	input, err := method.Inputs.Pack(args...)
+	if err != nil {
+		return nil, err
+	}
+	return append(method.ID(), input...), nil
+}
+
+// Unpack decodes the return values of method
+func (abi ABI) Unpack(name string, data []byte) ([]interface{}, error) {
+	method, ok := abi.Methods[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", ErrMethodNotFound, name)
+	}
+	return method.Outputs.Unpack(data)
+}
+
+// UnpackInto decodes the return values of method into v
+func (abi ABI) UnpackInto(v interface{}, name string, data []byte) error {
+	values, err := abi.Unpack(name, data)
+	if err != nil {
+		return err
+	}
+	return abi.Methods[name].Outputs.Copy(v, values)
+}
+
+// MethodByID returns the method of the selector in call data
+func (abi ABI) MethodByID(data []byte) (*Method, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("abi: call data too short, %d bytes", len(data))
+	}
+	for _, method := range abi.Methods {
+		if bytes.Equal(method.ID(), data[:4]) {
+			return &method, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: selector %x", ErrMethodNotFound, data[:4])
+}
+
+// EventByID returns the event of signature topic
+func (abi ABI) EventByID(topic common.Hash) (*Event, error) {
+	for _, event := range abi.Events {
+		if !event.Anonymous && event.ID() == topic {
+			return &event, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: topic %x", ErrEventNotFound, topic)
+}
+
+// UnpackLog decodes the arguments of event from log, indexed arguments
+// from topics and others from data. Indexed arguments of dynamic types are
+// stored as hash, and decoded as common.Hash.
+func (abi ABI) UnpackLog(name string, log *types.Log) (map[string]interface{}, error) {
+	event, ok := abi.Events[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", ErrEventNotFound, name)
+	}
+	values, err := event.unpack(log)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]interface{}, len(values))
+	for i, input := range event.Inputs {
+		ret[input.Name] = values[i]
+	}
+	return ret, nil
+}
+
+// UnpackLogInto decodes the arguments of event from log into v
+func (abi ABI) UnpackLogInto(v interface{}, name string, log *types.Log) error {
+	event, ok := abi.Events[name]
+	if !ok {
+		return fmt.Errorf("%s: %s", ErrEventNotFound, name)
+	}
+	values, err := event.unpack(log)
+	if err != nil {
+		return err
+	}
+	return event.Inputs.Copy(v, values)
+}
+
+// unpack decodes the arguments of event from log in order
+func (e Event) unpack(log *types.Log) ([]interface{}, error) {
+	topics := log.Topics
+	if !e.Anonymous {
+		if len(topics) == 0 || topics[0] != e.ID() {
+			return nil, ErrNoTopics
+		}
+		topics = topics[1:]
+	}
+	data, err := e.Inputs.NonIndexed().Unpack(log.Data)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(e.Inputs))
+	for i, input := range e.Inputs {
+		if !input.Indexed {
+			values[i], data = data[0], data[1:]
+			continue
+		}
+		if len(topics) == 0 {
+			return nil, ErrNoTopics
+		}
+		topic := topics[0]
+		topics = topics[1:]
+		// Values of reference types are hashed in topics
+		if input.Type.IsDynamic() || input.Type.Kind == ArrayTy || input.Type.Kind == TupleTy {
+			values[i] = topic
+			continue
+		}
+		v, err := unpack(input.Type, topic[:])
+		if err != nil {
+			return nil, fmt.Errorf("abi: invalid topic of %s, %s", fieldName(input.Name, i), err)
+		}
+		values[i] = v.Interface()
+	}
+	return values, nil
+}
+
+// UnpackRevert decodes the reason of solidity revert, Error(string)
+func UnpackRevert(data []byte) (string, error) {
+	if len(data) < 4 || !bytes.Equal(data[:4], revertSelector) {
+		return "", errors.New("abi: invalid revert data")
+	}
+	t, _ := NewType("string", "", nil)
+	v, err := unpackTuple([]*Type{t}, data[4:])
+	if err != nil {
+		return "", err
+	}
+	return v[0].String(), nil
+}
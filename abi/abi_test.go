@@ -0,0 +1,209 @@
+package abi
+
+import (
+	"tinychain/common"
+	"tinychain/core/types"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+const testABI = `[
+	{"type":"constructor","inputs":[{"name":"owner","type":"address"}]},
+	{"type":"function","name":"transfer","stateMutability":"nonpayable","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"baz","inputs":[{"name":"x","type":"uint32"},{"name":"y","type":"bool"}],"outputs":[]},
+	{"type":"function","name":"sam","inputs":[{"name":"name","type":"bytes"},{"name":"z","type":"bool"},{"name":"data","type":"uint256[]"}],"outputs":[]},
+	{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"balance","type":"uint256"},{"name":"frozen","type":"int8"}]},
+	{"type":"function","name":"order","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"tuple","internalType":"struct Pool.Order","components":[{"name":"maker","type":"address"},{"name":"amounts","type":"uint64[2]"},{"name":"memo","type":"string"}]}]},
+	{"type":"function","name":"foo","inputs":[{"name":"a","type":"uint8"}],"outputs":[]},
+	{"type":"function","name":"foo","inputs":[{"name":"a","type":"string"}],"outputs":[]},
+	{"type":"event","name":"Transfer","inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}]},
+	{"type":"event","name":"Named","inputs":[{"name":"name","type":"string","indexed":true},{"name":"id","type":"int64","indexed":true},{"name":"memo","type":"string","indexed":false}]},
+	{"type":"fallback"}
+]`
+
+func word(s string) string {
+	return strings.Repeat("0", 64-len(s)) + s
+}
+
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func newTestABI(t *testing.T) ABI {
+	abi, err := JSON(strings.NewReader(testABI))
+	assert.Nil(t, err)
+	return abi
+}
+
+func TestJSON(t *testing.T) {
+	abi := newTestABI(t)
+	assert.Equal(t, 7, len(abi.Methods))
+	assert.Equal(t, 2, len(abi.Events))
+	assert.Equal(t, 1, len(abi.Constructor.Inputs))
+
+	assert.True(t, abi.Methods["balanceOf"].Constant)
+	assert.False(t, abi.Methods["transfer"].Constant)
+	assert.Equal(t, "foo(uint8)", abi.Methods["foo"].Sig())
+	assert.Equal(t, "foo(string)", abi.Methods["foo0"].Sig())
+	assert.Equal(t, "foo", abi.Methods["foo0"].RawName)
+
+	order := abi.Methods["order"].Outputs[0].Type
+	assert.Equal(t, "(address,uint64[2],string)", order.String())
+	assert.Equal(t, "Order", order.TupleRaw)
+	assert.True(t, order.IsDynamic())
+
+	_, err := JSON(strings.NewReader(`[{"type":"function","name":"f","inputs":[{"type":"uint7"}]}]`))
+	assert.NotNil(t, err)
+}
+
+func TestSelector(t *testing.T) {
+	abi := newTestABI(t)
+	assert.Equal(t, "a9059cbb", hex.EncodeToString(abi.Methods["transfer"].ID()))
+	assert.Equal(t, "cdcd77c0", hex.EncodeToString(abi.Methods["baz"].ID()))
+	assert.Equal(t, "a5643bf2", hex.EncodeToString(abi.Methods["sam"].ID()))
+	assert.Equal(t, "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef", hex.EncodeToString(abi.Events["Transfer"].ID().Bytes()))
+
+	method, err := abi.MethodByID(mustDecode("cdcd77c0" + word("45")))
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", method.Name)
+	event, err := abi.EventByID(abi.Events["Transfer"].ID())
+	assert.Nil(t, err)
+	assert.Equal(t, "Transfer", event.Name)
+}
+
+func TestPack(t *testing.T) {
+	abi := newTestABI(t)
+
+	// Static arguments
+	input, err := abi.Pack("baz", uint32(69), true)
+	assert.Nil(t, err)
+	assert.Equal(t, "cdcd77c0"+word("45")+word("1"), hex.EncodeToString(input))
+
+	// Dynamic arguments, the example of solidity documents
+	input, err = abi.Pack("sam", []byte("dave"), true, []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3)})
+	assert.Nil(t, err)
+	expected := "a5643bf2" + word("60") + word("1") + word("a0") +
+		word("4") + "6461766500000000000000000000000000000000000000000000000000000000" +
+		word("3") + word("1") + word("2") + word("3")
+	assert.Equal(t, expected, hex.EncodeToString(input))
+
+	// Constructor arguments without selector
+	input, err = abi.Pack("", common.Address{0xaa})
+	assert.Nil(t, err)
+	assert.Equal(t, word("aa"+strings.Repeat("0", 38)), hex.EncodeToString(input))
+
+	// Invalid values
+	_, err = abi.Pack("baz", uint32(69))
+	assert.NotNil(t, err)
+	_, err = abi.Pack("baz", "69", true)
+	assert.NotNil(t, err)
+	_, err = abi.Pack("foo", 256)
+	assert.NotNil(t, err)
+	_, err = abi.Pack("missing")
+	assert.NotNil(t, err)
+}
+
+type order struct {
+	Maker   common.Address
+	Amounts [2]uint64
+	Memo    string
+}
+
+func TestUnpack(t *testing.T) {
+	abi := newTestABI(t)
+
+	// Negative int and named outputs
+	data := mustDecode(word("2a") + strings.Repeat("f", 64))
+	values, err := abi.Unpack("balanceOf", data)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(42), values[0])
+	assert.Equal(t, int8(-1), values[1])
+
+	var balance struct {
+		Balance *big.Int
+		Frozen  int8
+	}
+	assert.Nil(t, abi.UnpackInto(&balance, "balanceOf", data))
+	assert.Equal(t, big.NewInt(42), balance.Balance)
+	assert.Equal(t, int8(-1), balance.Frozen)
+
+	var ok bool
+	assert.Nil(t, abi.UnpackInto(&ok, "transfer", mustDecode(word("1"))))
+	assert.True(t, ok)
+	assert.NotNil(t, abi.UnpackInto(&ok, "transfer", mustDecode(word("2"))))
+
+	// Tuple round trip through the packer of arguments
+	expected := order{Maker: common.Address{1, 2, 3}, Amounts: [2]uint64{7, 9}, Memo: "tinychain"}
+	data, err = abi.Methods["order"].Outputs.Pack(expected)
+	assert.Nil(t, err)
+	var res order
+	assert.Nil(t, abi.UnpackInto(&res, "order", data))
+	assert.Equal(t, expected, res)
+
+	// Malformed data
+	_, err = abi.Unpack("balanceOf", data[:40])
+	assert.NotNil(t, err)
+	_, err = abi.Unpack("order", mustDecode(strings.Repeat("f", 64)))
+	assert.NotNil(t, err)
+}
+
+func TestUnpackLog(t *testing.T) {
+	abi := newTestABI(t)
+	from, to := common.Address{1}, common.Address{2}
+	log := &types.Log{
+		Topics: []common.Hash{
+			abi.Events["Transfer"].ID(),
+			common.BytesToHash(mustDecode(word("01" + strings.Repeat("0", 38)))),
+			common.BytesToHash(mustDecode(word("02" + strings.Repeat("0", 38)))),
+		},
+		Data: mustDecode(word("64")),
+	}
+	values, err := abi.UnpackLog("Transfer", log)
+	assert.Nil(t, err)
+	assert.Equal(t, from, values["from"])
+	assert.Equal(t, to, values["to"])
+	assert.Equal(t, big.NewInt(100), values["value"])
+
+	var transfer struct {
+		From  common.Address
+		To    common.Address
+		Value *big.Int
+	}
+	assert.Nil(t, abi.UnpackLogInto(&transfer, "Transfer", log))
+	assert.Equal(t, to, transfer.To)
+	assert.Equal(t, big.NewInt(100), transfer.Value)
+
+	// Indexed string is the hash in topic
+	nameHash := common.Hash{0xab}
+	memo, _ := abi.Events["Named"].Inputs.NonIndexed().Pack("memo")
+	values, err = abi.UnpackLog("Named", &types.Log{
+		Topics: []common.Hash{abi.Events["Named"].ID(), nameHash, common.BytesToHash(mustDecode(strings.Repeat("f", 64)))},
+		Data:   memo,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, nameHash, values["name"])
+	assert.Equal(t, int64(-1), values["id"])
+	assert.Equal(t, "memo", values["memo"])
+
+	// Topics of another event
+	log.Topics[0] = abi.Events["Named"].ID()
+	_, err = abi.UnpackLog("Transfer", log)
+	assert.Equal(t, ErrNoTopics, err)
+}
+
+func TestUnpackRevert(t *testing.T) {
+	data := mustDecode("08c379a0" + word("20") + word("d") + hex.EncodeToString([]byte("out of stock!")) + strings.Repeat("0", 38))
+	reason, err := UnpackRevert(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "out of stock!", reason)
+
+	_, err = UnpackRevert(mustDecode("deadbeef"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,158 @@
+package abi
+
+import (
+	"fmt"
+	"reflect"
+	json "github.com/json-iterator/go"
+)
+
+// ArgumentMarshaling is the json form of argument and tuple component
+type ArgumentMarshaling struct {
+	Name         string               `json:"name"`
+	Type         string               `json:"type"`
+	InternalType string               `json:"internalType,omitempty"`
+	Components   []ArgumentMarshaling `json:"components,omitempty"`
+	Indexed      bool                 `json:"indexed,omitempty"`
+}
+
+// Argument is the input or output of method and event
+type Argument struct {
+	Name    string
+	Type    *Type
+	Indexed bool // indexed argument of event, stored in topics
+}
+
+func (arg *Argument) UnmarshalJSON(data []byte) error {
+	var m ArgumentMarshaling
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("abi: failed to unmarshal argument, %s", err)
+	}
+	typ, err := NewType(m.Type, m.InternalType, m.Components)
+	if err != nil {
+		return err
+	}
+	arg.Name, arg.Type, arg.Indexed = m.Name, typ, m.Indexed
+	return nil
+}
+
+type Arguments []Argument
+
+// Types returns the types of arguments
+func (args Arguments) Types() []*Type {
+	types := make([]*Type, len(args))
+	for i, arg := range args {
+		types[i] = arg.Type
+	}
+	return types
+}
+
+// NonIndexed returns the arguments not indexed in topics
+func (args Arguments) NonIndexed() Arguments {
+	var ret Arguments
+	for _, arg := range args {
+		if !arg.Indexed {
+			ret = append(ret, arg)
+		}
+	}
+	return ret
+}
+
+// Pack encodes values of arguments as tuple
+func (args Arguments) Pack(values ...interface{}) ([]byte, error) {
+	if len(values) != len(args) {
+		return nil, fmt.Errorf("abi: %d values for %d arguments", len(values), len(args))
+	}
+	rvs := make([]reflect.Value, len(values))
+	for i, v := range values {
+		rvs[i] = reflect.ValueOf(v)
+	}
+	return packTuple(args.Types(), rvs)
+}
+
+// Unpack decodes values of arguments from tuple encoding
+func (args Arguments) Unpack(data []byte) ([]interface{}, error) {
+	rvs, err := unpackTuple(args.Types(), data)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(rvs))
+	for i, rv := range rvs {
+		values[i] = rv.Interface()
+	}
+	return values, nil
+}
+
+// Copy stores the unpacked values in v, which is one of:
+//   - pointer to struct, fields matched by json tag or camel case name
+//   - pointer to the value of the only argument
+//   - pointer to []interface{}
+func (args Arguments) Copy(v interface{}, values []interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("abi: can not copy to non-pointer %T", v)
+	}
+	if len(values) != len(args) {
+		return fmt.Errorf("abi: %d values for %d arguments", len(values), len(args))
+	}
+	dst := rv.Elem()
+	if all, ok := v.(*[]interface{}); ok {
+		*all = values
+		return nil
+	}
+	// The only argument may be a tuple copied to struct as a whole
+	if len(args) == 1 && (dst.Kind() != reflect.Struct || args[0].Type.Kind == TupleTy) {
+		if err := set(dst, reflect.ValueOf(values[0])); err == nil || dst.Kind() != reflect.Struct {
+			return err
+		}
+	}
+	if dst.Kind() == reflect.Struct {
+		for i, arg := range args {
+			field, ok := structField(dst, arg.Name, i)
+			if !ok {
+				return fmt.Errorf("abi: field %s not found in %s", arg.Name, dst.Type())
+			}
+			if err := set(field, reflect.ValueOf(values[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("abi: can not copy %d values to %s", len(args), dst.Type())
+}
+
+// set assigns src to dst, converting between types of the same layout, e.g.
+// anonymous struct of tuple to the named struct
+func set(dst, src reflect.Value) error {
+	switch {
+	case src.Type().AssignableTo(dst.Type()):
+		dst.Set(src)
+	case src.Type().ConvertibleTo(dst.Type()):
+		dst.Set(src.Convert(dst.Type()))
+	case dst.Kind() == reflect.Slice && src.Kind() == reflect.Slice:
+		dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			if err := set(dst.Index(i), src.Index(i)); err != nil {
+				return err
+			}
+		}
+	case dst.Kind() == reflect.Array && src.Kind() == reflect.Array && dst.Len() == src.Len():
+		for i := 0; i < src.Len(); i++ {
+			if err := set(dst.Index(i), src.Index(i)); err != nil {
+				return err
+			}
+		}
+	case dst.Kind() == reflect.Struct && src.Kind() == reflect.Struct:
+		for i := 0; i < src.NumField(); i++ {
+			field, ok := structField(dst, src.Type().Field(i).Tag.Get("json"), i)
+			if !ok {
+				return fmt.Errorf("abi: field %s not found in %s", src.Type().Field(i).Name, dst.Type())
+			}
+			if err := set(field, src.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("abi: can not copy %s to %s", src.Type(), dst.Type())
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package bind
+
+import (
+	"tinychain/common"
+	"tinychain/core"
+	"tinychain/core/types"
+	"errors"
+	"math/big"
+)
+
+var ErrNoCode = errors.New("bind: no contract code at given address")
+
+// ContractCaller is the backend of read-only contract calls
+type ContractCaller interface {
+	// CodeAt returns the code of contract at block blockRef, zero blockRef means the latest block
+	CodeAt(contract common.Address, blockRef common.Hash) ([]byte, error)
+	// CallContract executes msg at block blockRef without creating transaction
+	CallContract(msg *core.CallMsg, blockRef common.Hash) ([]byte, error)
+}
+
+// ContractTransactor is the backend of contract transactions
+type ContractTransactor interface {
+	// PendingNonceAt returns the next nonce of account, including pending transactions
+	PendingNonceAt(account common.Address) (uint64, error)
+	// EstimateGas returns the gas needed to execute msg at the latest block
+	EstimateGas(msg *core.CallMsg) (uint64, error)
+	// SendTransaction sends the signed transaction to the network
+	SendTransaction(tx *types.Transaction) error
+}
+
+// FilterQuery selects the logs of committed blocks
+type FilterQuery struct {
+	FromBlock *big.Int // nil means the genesis block
+	ToBlock   *big.Int // nil means the latest block
+	Addresses []common.Address
+	// Topics at every position, where empty list matches any topic, e.g.
+	//	{} or nil          matches any topics
+	//	{{A}}              matches A in the first position
+	//	{{}, {B}}          matches any in the first position and B in the second
+	//	{{A, B}, {C, D}}   matches A or B in the first position and C or D in the second
+	Topics [][]common.Hash
+}
+
+// Matches reports whether the log is selected by query, regardless of blocks
+func (q *FilterQuery) Matches(log *types.Log) bool {
+	if len(q.Addresses) > 0 {
+		found := false
+		for _, addr := range q.Addresses {
+			if addr == log.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.Topics) > len(log.Topics) {
+		return false
+	}
+	for i, topics := range q.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		found := false
+		for _, topic := range topics {
+			if topic == log.Topics[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscription is the subscription of logs, which stops sending logs after unsubscribed
+type Subscription interface {
+	Unsubscribe()
+}
+
+// ContractFilterer is the backend of contract event logs
+type ContractFilterer interface {
+	// FilterLogs returns the logs of committed blocks selected by query
+	FilterLogs(query *FilterQuery) ([]*types.Log, error)
+	// SubscribeFilterLogs sends logs of new committed blocks selected by query to ch
+	SubscribeFilterLogs(query *FilterQuery, ch chan<- *types.Log) (Subscription, error)
+}
+
+// ContractBackend is the backend of contract bindings
+type ContractBackend interface {
+	ContractCaller
+	ContractTransactor
+	ContractFilterer
+}
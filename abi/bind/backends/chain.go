@@ -0,0 +1,147 @@
+// Package backends implements the contract backends of bindings.
+package backends
+
+import (
+	"tinychain/abi/bind"
+	"tinychain/common"
+	"tinychain/core"
+	"tinychain/core/types"
+	"tinychain/event"
+	"tinychain/executor/txpool"
+	"math/big"
+	"sync"
+)
+
+var log = common.GetLogger("backends")
+
+// ChainBackend is the contract backend of local blockchain, which calls
+// contracts on chain state, sends transactions to tx pool, and filters logs
+// in receipts of committed blocks.
+type ChainBackend struct {
+	chain *core.Blockchain
+	pool  *txpool.TxPool
+	event *event.TypeMux
+}
+
+func NewChainBackend(chain *core.Blockchain, pool *txpool.TxPool) *ChainBackend {
+	return &ChainBackend{
+		chain: chain,
+		pool:  pool,
+		event: event.GetEventhub(),
+	}
+}
+
+func (b *ChainBackend) CodeAt(contract common.Address, blockRef common.Hash) ([]byte, error) {
+	if blockRef.Nil() {
+		blockRef = b.chain.GetLastBlock().Hash()
+	}
+	statedb, err := b.chain.ReadOnlyStateAt(blockRef)
+	if err != nil {
+		return nil, err
+	}
+	return statedb.GetCode(contract), nil
+}
+
+func (b *ChainBackend) CallContract(msg *core.CallMsg, blockRef common.Hash) ([]byte, error) {
+	ret, _, err := b.chain.Call(msg, blockRef)
+	return ret, err
+}
+
+// PendingNonceAt returns the nonce next to pending transactions of account in
+// tx pool, or the nonce in the latest state
+func (b *ChainBackend) PendingNonceAt(account common.Address) (uint64, error) {
+	statedb, err := b.chain.ReadOnlyStateAt(b.chain.GetLastBlock().Hash())
+	if err != nil {
+		return 0, err
+	}
+	nonce := statedb.GetNonce(account)
+	for _, tx := range b.pool.Pending() {
+		if tx.From == account && tx.Nonce >= nonce {
+			nonce = tx.Nonce + 1
+		}
+	}
+	return nonce, nil
+}
+
+func (b *ChainBackend) EstimateGas(msg *core.CallMsg) (uint64, error) {
+	return b.chain.EstimateGas(msg, common.Hash{})
+}
+
+func (b *ChainBackend) SendTransaction(tx *types.Transaction) error {
+	return b.pool.Add(tx)
+}
+
+func (b *ChainBackend) FilterLogs(query *bind.FilterQuery) ([]*types.Log, error) {
+	from := query.FromBlock
+	if from == nil {
+		from = new(big.Int)
+	}
+	to := query.ToBlock
+	if last := b.chain.GetLastBlock().Header.Height; to == nil || to.Cmp(last) > 0 {
+		to = last
+	}
+	var logs []*types.Log
+	for height := new(big.Int).Set(from); height.Cmp(to) <= 0; height.Add(height, big.NewInt(1)) {
+		block, err := b.chain.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, filterLogs(block, query)...)
+	}
+	return logs, nil
+}
+
+// SubscribeFilterLogs sends logs of blocks committed after subscription
+func (b *ChainBackend) SubscribeFilterLogs(query *bind.FilterQuery, ch chan<- *types.Log) (bind.Subscription, error) {
+	sub := &chainSub{sub: b.event.Subscribe(&core.BlockCommitEvent{}), quit: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case ev := <-sub.sub.Chan():
+				height := ev.(*core.BlockCommitEvent).Height
+				block, err := b.chain.GetBlockByHeight(height)
+				if err != nil {
+					log.Errorf("Failed to get committed block at %s, %s", height, err)
+					continue
+				}
+				for _, l := range filterLogs(block, query) {
+					select {
+					case ch <- l:
+					case <-sub.quit:
+						return
+					}
+				}
+			case <-sub.quit:
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// filterLogs returns the logs in receipts of block selected by query
+func filterLogs(block *types.Block, query *bind.FilterQuery) []*types.Log {
+	var logs []*types.Log
+	for _, receipt := range block.Receipts {
+		for _, l := range receipt.Logs {
+			if query.Matches(l) {
+				logs = append(logs, l)
+			}
+		}
+	}
+	return logs
+}
+
+// chainSub stops sending logs when unsubscribed
+type chainSub struct {
+	sub  event.Subscription
+	quit chan struct{}
+	once sync.Once
+}
+
+func (s *chainSub) Unsubscribe() {
+	s.once.Do(func() {
+		s.sub.Unsubscribe()
+		close(s.quit)
+	})
+}
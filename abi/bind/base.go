@@ -0,0 +1,304 @@
+package bind
+
+import (
+	"tinychain/abi"
+	"tinychain/account"
+	"tinychain/common"
+	"tinychain/core"
+	"tinychain/core/types"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const logBuffer = 128 // Buffer of logs channel of watching
+
+var ErrNoSigner = errors.New("bind: no account or wallet to sign transaction")
+
+// CallOpts is the options of read-only contract calls
+type CallOpts struct {
+	From     common.Address // Caller of the call
+	BlockRef common.Hash    // Block to call at, zero means the latest block
+}
+
+// TransactOpts is the options of contract transactions
+type TransactOpts struct {
+	Account  *account.Account // Sender of transaction
+	Wallet   account.Wallet   // Wallet signing transaction, which unlocks the sender
+	Nonce    *uint64          // Nonce of transaction, nil means the pending nonce of sender
+	Value    *big.Int         // Transferring value, nil means 0
+	GasPrice uint64
+	GasLimit uint64 // Gas limit of transaction, 0 means estimated
+}
+
+// NewTransactOpts creates the transact options of account signed by wallet
+func NewTransactOpts(wallet account.Wallet, acc *account.Account) *TransactOpts {
+	return &TransactOpts{Account: acc, Wallet: wallet}
+}
+
+// FilterOpts is the options of filtering logs of committed blocks
+type FilterOpts struct {
+	Start uint64  // Start block height
+	End   *uint64 // End block height, nil means the latest block
+}
+
+// BoundContract is the contract at address bound to backend, which is the
+// base of generated bindings
+type BoundContract struct {
+	address    common.Address
+	abi        abi.ABI
+	caller     ContractCaller
+	transactor ContractTransactor
+	filterer   ContractFilterer
+}
+
+func NewBoundContract(address common.Address, abi abi.ABI, caller ContractCaller, transactor ContractTransactor, filterer ContractFilterer) *BoundContract {
+	return &BoundContract{
+		address:    address,
+		abi:        abi,
+		caller:     caller,
+		transactor: transactor,
+		filterer:   filterer,
+	}
+}
+
+// DeployContract sends the transaction creating contract of bytecode, with
+// constructor params, and binds the contract at the address to be created
+func DeployContract(opts *TransactOpts, abi abi.ABI, bytecode []byte, backend ContractBackend, params ...interface{}) (common.Address, *types.Transaction, *BoundContract, error) {
+	c := NewBoundContract(common.Address{}, abi, backend, backend, backend)
+	input, err := abi.Pack("", params...)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	tx, err := c.transact(opts, append(append([]byte{}, bytecode...), input...))
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	c.address = common.CreateAddress(tx.From, tx.Nonce)
+	return c.address, tx, c, nil
+}
+
+func (c *BoundContract) Address() common.Address {
+	return c.address
+}
+
+// Call calls the method with params without creating transaction, and stores
+// the outputs in out, which is one of the forms accepted by abi.Arguments.Copy
+func (c *BoundContract) Call(opts *CallOpts, out interface{}, method string, params ...interface{}) error {
+	if opts == nil {
+		opts = new(CallOpts)
+	}
+	input, err := c.abi.Pack(method, params...)
+	if err != nil {
+		return err
+	}
+	msg := &core.CallMsg{From: opts.From, To: c.address, Data: input, Static: true}
+	output, err := c.caller.CallContract(msg, opts.BlockRef)
+	if err != nil {
+		return err
+	}
+	outputs := c.abi.Methods[method].Outputs
+	if len(outputs) == 0 {
+		return nil
+	}
+	// Empty output of method with outputs, check whether the contract exists
+	if len(output) == 0 {
+		code, err := c.caller.CodeAt(c.address, opts.BlockRef)
+		if err != nil {
+			return err
+		}
+		if len(code) == 0 {
+			return ErrNoCode
+		}
+	}
+	return c.abi.UnpackInto(out, method, output)
+}
+
+// Transact sends the transaction calling method with params
+func (c *BoundContract) Transact(opts *TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	input, err := c.abi.Pack(method, params...)
+	if err != nil {
+		return nil, err
+	}
+	return c.transact(opts, input)
+}
+
+// Transfer sends the transaction transferring value to contract without payload
+func (c *BoundContract) Transfer(opts *TransactOpts) (*types.Transaction, error) {
+	return c.transact(opts, nil)
+}
+
+// transact signs and sends the transaction of input, to contract creation
+// if the contract address is not set
+func (c *BoundContract) transact(opts *TransactOpts, input []byte) (*types.Transaction, error) {
+	if opts == nil || opts.Account == nil || opts.Wallet == nil {
+		return nil, ErrNoSigner
+	}
+	from := opts.Account.Address
+	value := opts.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	var nonce uint64
+	if opts.Nonce != nil {
+		nonce = *opts.Nonce
+	} else {
+		var err error
+		if nonce, err = c.transactor.PendingNonceAt(from); err != nil {
+			return nil, fmt.Errorf("bind: failed to get nonce of %x, %s", from, err)
+		}
+	}
+	gasLimit := opts.GasLimit
+	if gasLimit == 0 {
+		var err error
+		msg := &core.CallMsg{From: from, To: c.address, Value: value, Data: input}
+		if gasLimit, err = c.transactor.EstimateGas(msg); err != nil {
+			return nil, fmt.Errorf("bind: failed to estimate gas, %s", err)
+		}
+	}
+	tx := types.NewTransaction(nonce, opts.GasPrice, gasLimit, value, input, from, c.address)
+	signed, err := opts.Wallet.SignTx(opts.Account, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.transactor.SendTransaction(signed); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// FilterLogs returns the logs of event name in committed blocks, whose indexed
+// arguments match any of values in query at the same position
+func (c *BoundContract) FilterLogs(opts *FilterOpts, name string, query ...[]interface{}) ([]*types.Log, error) {
+	if opts == nil {
+		opts = new(FilterOpts)
+	}
+	q, err := c.filterQuery(name, query)
+	if err != nil {
+		return nil, err
+	}
+	q.FromBlock = new(big.Int).SetUint64(opts.Start)
+	if opts.End != nil {
+		q.ToBlock = new(big.Int).SetUint64(*opts.End)
+	}
+	return c.filterer.FilterLogs(q)
+}
+
+// WatchLogs subscribes logs of event name in new committed blocks, and calls
+// handle with every log in a new goroutine until unsubscribed
+func (c *BoundContract) WatchLogs(name string, handle func(log *types.Log), query ...[]interface{}) (Subscription, error) {
+	q, err := c.filterQuery(name, query)
+	if err != nil {
+		return nil, err
+	}
+	logs := make(chan *types.Log, logBuffer)
+	sub, err := c.filterer.SubscribeFilterLogs(q, logs)
+	if err != nil {
+		return nil, err
+	}
+	ls := &logSub{sub: sub, quit: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case log := <-logs:
+				handle(log)
+			case <-ls.quit:
+				return
+			}
+		}
+	}()
+	return ls, nil
+}
+
+// UnpackLog decodes the arguments of event name from log into out
+func (c *BoundContract) UnpackLog(out interface{}, name string, log *types.Log) error {
+	return c.abi.UnpackLogInto(out, name, log)
+}
+
+// filterQuery returns the query of contract logs of event name
+func (c *BoundContract) filterQuery(name string, query [][]interface{}) (*FilterQuery, error) {
+	event, ok := c.abi.Events[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", abi.ErrEventNotFound, name)
+	}
+	topics, err := makeTopics(event, query)
+	if err != nil {
+		return nil, err
+	}
+	if !event.Anonymous {
+		topics = append([][]common.Hash{{event.ID()}}, topics...)
+	}
+	return &FilterQuery{Addresses: []common.Address{c.address}, Topics: topics}, nil
+}
+
+// makeTopics encodes the values of indexed arguments of event as topics
+func makeTopics(event abi.Event, query [][]interface{}) ([][]common.Hash, error) {
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if len(query) > len(indexed) {
+		return nil, fmt.Errorf("bind: %d topics for %d indexed arguments of %s", len(query), len(indexed), event.Name)
+	}
+	topics := make([][]common.Hash, len(query))
+	for i, values := range query {
+		arg := indexed[i]
+		for _, v := range values {
+			topic, err := makeTopic(arg, v)
+			if err != nil {
+				return nil, err
+			}
+			topics[i] = append(topics[i], topic)
+		}
+	}
+	return topics, nil
+}
+
+// makeTopic encodes value of indexed argument, static values are encoded as
+// words, and strings and bytes are hashed
+func makeTopic(arg abi.Argument, v interface{}) (common.Hash, error) {
+	switch arg.Type.Kind {
+	case abi.StringTy:
+		s, ok := v.(string)
+		if !ok {
+			return common.Hash{}, fmt.Errorf("bind: can not use %T as topic of %s", v, arg.Type)
+		}
+		return common.BytesToHash(crypto.Keccak256([]byte(s))), nil
+	case abi.BytesTy:
+		b, ok := v.([]byte)
+		if !ok {
+			return common.Hash{}, fmt.Errorf("bind: can not use %T as topic of %s", v, arg.Type)
+		}
+		return common.BytesToHash(crypto.Keccak256(b)), nil
+	}
+	if arg.Type.IsDynamic() || arg.Type.Kind == abi.ArrayTy || arg.Type.Kind == abi.TupleTy {
+		// Hash of the encoding in memory, only accepted as hash
+		if hash, ok := v.(common.Hash); ok {
+			return hash, nil
+		}
+		return common.Hash{}, fmt.Errorf("bind: topic of %s must be hash", arg.Type)
+	}
+	enc, err := abi.Arguments{arg}.Pack(v)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(enc), nil
+}
+
+// logSub stops handling logs and unsubscribes backend when unsubscribed
+type logSub struct {
+	sub  Subscription
+	quit chan struct{}
+	once sync.Once
+}
+
+func (ls *logSub) Unsubscribe() {
+	ls.once.Do(func() {
+		ls.sub.Unsubscribe()
+		close(ls.quit)
+	})
+}
@@ -0,0 +1,280 @@
+// Package bind generates Go bindings of solidity contracts from their ABI
+// and bytecode, and implements the runtime of the generated bindings: typed
+// deployment, transactions signed by wallet, read-only calls and event
+// filtering on a contract backend.
+package bind
+
+import (
+	"tinychain/abi"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidBinding = errors.New("bind: invalid binding input")
+
+// Names of the generated function parameters and locals, renamed in arguments
+var reservedNames = map[string]bool{
+	"opts": true, "backend": true, "sink": true, "out": true, "err": true,
+	"log": true, "logs": true, "event": true, "events": true, "parsed": true,
+	"bytecode": true, "address": true, "tx": true, "contract": true,
+}
+
+type tmplData struct {
+	Package   string
+	Contracts []*tmplContract
+	Structs   []*tmplStruct
+}
+
+type tmplContract struct {
+	Type        string
+	InputABI    string
+	InputBin    string
+	Constructor *tmplMethod
+	Calls       []*tmplMethod
+	Transacts   []*tmplMethod
+	Events      []*tmplEvent
+}
+
+type tmplMethod struct {
+	Original   abi.Method
+	Normalized string // Go name of method
+	ID         string // Hex of selector
+	Inputs     []*tmplArg
+	Outputs    []*tmplArg
+	Structured bool // Multiple outputs returned in struct
+}
+
+type tmplEvent struct {
+	Original   abi.Event
+	Normalized string
+	ID         string
+	Fields     []*tmplArg
+	Indexed    []*tmplArg
+}
+
+type tmplArg struct {
+	Name       string // Go name of parameter or field
+	Type       string // Go type
+	FilterType string // Go type of topic values of indexed event argument
+}
+
+type tmplStruct struct {
+	Name   string
+	Fields []*tmplArg
+}
+
+// generator keeps the structs of tuples shared by all contracts
+type generator struct {
+	structs map[string]*tmplStruct // Keyed by struct name and canonical tuple type
+	names   map[string]bool
+	order   []*tmplStruct
+}
+
+// Bind generates the Go bindings of contracts in package pkg. Contract i is
+// named types[i], with json ABI abis[i] and hex bytecode bytecodes[i], which
+// may be empty for contracts not deployable by the binding.
+func Bind(types []string, abis []string, bytecodes []string, pkg string) (string, error) {
+	if len(types) != len(abis) || len(types) != len(bytecodes) {
+		return "", fmt.Errorf("%s, %d types, %d abis and %d bytecodes", ErrInvalidBinding, len(types), len(abis), len(bytecodes))
+	}
+	gen := &generator{structs: make(map[string]*tmplStruct), names: make(map[string]bool)}
+	data := &tmplData{Package: pkg}
+	for i, typ := range types {
+		contract, err := gen.contract(typ, abis[i], bytecodes[i])
+		if err != nil {
+			return "", err
+		}
+		data.Contracts = append(data.Contracts, contract)
+	}
+	data.Structs = gen.order
+
+	var buf bytes.Buffer
+	if err := bindTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("bind: failed to format generated code, %s\n%s", err, buf.String())
+	}
+	return string(code), nil
+}
+
+func (gen *generator) contract(typ, abiJSON, bytecode string) (*tmplContract, error) {
+	if !token.IsIdentifier(typ) {
+		return nil, fmt.Errorf("%s, type name %q", ErrInvalidBinding, typ)
+	}
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(abiJSON)); err != nil {
+		return nil, err
+	}
+	bytecode = strings.TrimPrefix(strings.TrimSpace(bytecode), "0x")
+	if _, err := hex.DecodeString(bytecode); err != nil {
+		return nil, fmt.Errorf("%s, bytecode of %s, %s", ErrInvalidBinding, typ, err)
+	}
+
+	contract := &tmplContract{
+		Type:        abi.ToCamelCase(typ),
+		InputABI:    compact.String(),
+		InputBin:    bytecode,
+		Constructor: &tmplMethod{Original: parsed.Constructor, Inputs: gen.args(parsed.Constructor.Inputs, "arg")},
+	}
+
+	var names []string
+	for name := range parsed.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		original := parsed.Methods[name]
+		method := &tmplMethod{
+			Original:   original,
+			Normalized: abi.ToCamelCase(original.Name),
+			ID:         hex.EncodeToString(original.ID()),
+			Inputs:     gen.args(original.Inputs, "arg"),
+		}
+		if original.Constant {
+			method.Outputs = gen.fields(original.Outputs)
+			method.Structured = len(method.Outputs) > 1
+			contract.Calls = append(contract.Calls, method)
+		} else {
+			contract.Transacts = append(contract.Transacts, method)
+		}
+	}
+
+	names = names[:0]
+	for name := range parsed.Events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		original := parsed.Events[name]
+		event := &tmplEvent{
+			Original:   original,
+			Normalized: abi.ToCamelCase(original.Name),
+			ID:         hex.EncodeToString(original.ID().Bytes()),
+		}
+		params := gen.args(original.Inputs, "arg")
+		for i, input := range original.Inputs {
+			field := &tmplArg{Name: fieldName(input.Name, i), Type: gen.goType(input.Type)}
+			if input.Indexed {
+				param := params[i]
+				param.FilterType = field.Type
+				// Reference types are hashed in topics
+				if isHashedTopic(input.Type) {
+					field.Type = "common.Hash"
+					if input.Type.Kind != abi.StringTy && input.Type.Kind != abi.BytesTy {
+						param.FilterType = "common.Hash"
+					}
+				}
+				event.Indexed = append(event.Indexed, param)
+			}
+			event.Fields = append(event.Fields, field)
+		}
+		contract.Events = append(contract.Events, event)
+	}
+	return contract, nil
+}
+
+// args returns the Go parameters of arguments
+func (gen *generator) args(args abi.Arguments, prefix string) []*tmplArg {
+	ret := make([]*tmplArg, len(args))
+	for i, arg := range args {
+		ret[i] = &tmplArg{Name: paramName(arg.Name, prefix, i), Type: gen.goType(arg.Type)}
+	}
+	return ret
+}
+
+// fields returns the Go struct fields of arguments
+func (gen *generator) fields(args abi.Arguments) []*tmplArg {
+	ret := make([]*tmplArg, len(args))
+	for i, arg := range args {
+		ret[i] = &tmplArg{Name: fieldName(arg.Name, i), Type: gen.goType(arg.Type)}
+	}
+	return ret
+}
+
+// goType returns the Go type of values of t, declaring structs of tuples
+func (gen *generator) goType(t *abi.Type) string {
+	switch t.Kind {
+	case abi.IntTy, abi.UintTy:
+		// Sized Go integers or *big.Int
+		return t.GoType().String()
+	case abi.BoolTy:
+		return "bool"
+	case abi.AddressTy:
+		return "common.Address"
+	case abi.FixedBytesTy:
+		return fmt.Sprintf("[%d]byte", t.Size)
+	case abi.BytesTy:
+		return "[]byte"
+	case abi.StringTy:
+		return "string"
+	case abi.SliceTy:
+		return "[]" + gen.goType(t.Elem)
+	case abi.ArrayTy:
+		return fmt.Sprintf("[%d]%s", t.Size, gen.goType(t.Elem))
+	case abi.TupleTy:
+		return gen.tuple(t).Name
+	}
+	return "interface{}"
+}
+
+// tuple returns the struct of tuple, named by the struct name in solidity
+func (gen *generator) tuple(t *abi.Type) *tmplStruct {
+	key := t.TupleRaw + t.String()
+	if s, ok := gen.structs[key]; ok {
+		return s
+	}
+	name := abi.ToCamelCase(t.TupleRaw)
+	if name == "" {
+		name = "Tuple"
+	}
+	for base, i := name, 0; gen.names[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	s := &tmplStruct{Name: name}
+	gen.structs[key], gen.names[name] = s, true
+	for i, elem := range t.TupleElems {
+		s.Fields = append(s.Fields, &tmplArg{Name: fieldName(t.TupleNames[i], i), Type: gen.goType(elem)})
+	}
+	gen.order = append(gen.order, s)
+	return s
+}
+
+// isHashedTopic reports whether values of t are hashed in topics
+func isHashedTopic(t *abi.Type) bool {
+	return t.IsDynamic() || t.Kind == abi.ArrayTy || t.Kind == abi.TupleTy
+}
+
+// fieldName returns the exported Go field name of the i-th argument, the
+// same as struct fields decoded by package abi
+func fieldName(name string, i int) string {
+	if name = abi.ToCamelCase(name); name == "" {
+		return fmt.Sprintf("Field%d", i)
+	}
+	return name
+}
+
+// paramName returns the unexported Go parameter name of the i-th argument
+func paramName(name, prefix string, i int) string {
+	name = abi.ToCamelCase(name)
+	if name == "" {
+		return fmt.Sprintf("%s%d", prefix, i)
+	}
+	name = strings.ToLower(name[:1]) + name[1:]
+	if token.Lookup(name).IsKeyword() || reservedNames[name] {
+		name += "_"
+	}
+	return name
+}
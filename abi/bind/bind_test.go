@@ -0,0 +1,226 @@
+package bind
+
+import (
+	"tinychain/abi"
+	"tinychain/account"
+	"tinychain/common"
+	"tinychain/core"
+	"tinychain/core/types"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+const tokenABI = `[
+	{"type":"constructor","inputs":[{"name":"supply","type":"uint256"}]},
+	{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"info","stateMutability":"view","inputs":[],"outputs":[{"name":"name","type":"string"},{"name":"decimals","type":"uint8"}]},
+	{"type":"function","name":"transfer","stateMutability":"nonpayable","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"place","stateMutability":"payable","inputs":[{"name":"order","type":"tuple","internalType":"struct Market.Order","components":[{"name":"maker","type":"address"},{"name":"amounts","type":"uint64[]"}]}],"outputs":[]},
+	{"type":"event","name":"Transfer","inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}]},
+	{"type":"event","name":"Memo","inputs":[{"name":"text","type":"string","indexed":true},{"name":"type","type":"uint8","indexed":false}]}
+]`
+
+// testBackend is the contract backend recording transactions, and returning
+// the configured call output and logs
+type testBackend struct {
+	nonce  uint64
+	gas    uint64
+	output []byte
+	code   []byte
+	logs   []*types.Log
+
+	calls []*core.CallMsg
+	txs   []*types.Transaction
+	query *FilterQuery
+	sub   chan<- *types.Log
+}
+
+func (b *testBackend) CodeAt(contract common.Address, blockRef common.Hash) ([]byte, error) {
+	return b.code, nil
+}
+
+func (b *testBackend) CallContract(msg *core.CallMsg, blockRef common.Hash) ([]byte, error) {
+	b.calls = append(b.calls, msg)
+	return b.output, nil
+}
+
+func (b *testBackend) PendingNonceAt(account common.Address) (uint64, error) {
+	return b.nonce, nil
+}
+
+func (b *testBackend) EstimateGas(msg *core.CallMsg) (uint64, error) {
+	return b.gas, nil
+}
+
+func (b *testBackend) SendTransaction(tx *types.Transaction) error {
+	b.txs = append(b.txs, tx)
+	return nil
+}
+
+func (b *testBackend) FilterLogs(query *FilterQuery) ([]*types.Log, error) {
+	b.query = query
+	var logs []*types.Log
+	for _, log := range b.logs {
+		if query.Matches(log) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+func (b *testBackend) SubscribeFilterLogs(query *FilterQuery, ch chan<- *types.Log) (Subscription, error) {
+	b.query, b.sub = query, ch
+	return b, nil
+}
+
+func (b *testBackend) Unsubscribe() {}
+
+// testWallet signs transactions with fixed signature
+type testWallet struct {
+	account.Wallet
+}
+
+func (w testWallet) SignTx(acc *account.Account, tx *types.Transaction) (*types.Transaction, error) {
+	tx.Signature = []byte("signed")
+	return tx, nil
+}
+
+func word(n int64) []byte {
+	return common.BigToHash(big.NewInt(n)).Bytes()
+}
+
+func addressTopic(addr common.Address) common.Hash {
+	return common.BytesToHash(common.LeftPadBytes(addr.Bytes(), 32))
+}
+
+func TestBind(t *testing.T) {
+	code, err := Bind([]string{"Token"}, []string{tokenABI}, []string{"0x6080"}, "token")
+	assert.Nil(t, err)
+	for _, expected := range []string{
+		"package token",
+		`const TokenBin = "0x6080"`,
+		"func DeployToken(opts *bind.TransactOpts, backend bind.ContractBackend, supply *big.Int) (common.Address, *types.Transaction, *Token, error)",
+		"func NewToken(address common.Address, backend bind.ContractBackend) (*Token, error)",
+		"func (_Token *TokenCaller) BalanceOf(opts *bind.CallOpts, owner common.Address) (*big.Int, error)",
+		"func (_Token *TokenCaller) Info(opts *bind.CallOpts) (struct {",
+		"func (_Token *TokenTransactor) Transfer(opts *bind.TransactOpts, to common.Address, value *big.Int) (*types.Transaction, error)",
+		"func (_Token *TokenTransactor) Place(opts *bind.TransactOpts, order Order) (*types.Transaction, error)",
+		"type Order struct {",
+		"Amounts []uint64",
+		"func (_Token *TokenFilterer) FilterTransfer(opts *bind.FilterOpts, from []common.Address, to []common.Address) ([]*TokenTransfer, error)",
+		"func (_Token *TokenFilterer) WatchTransfer(sink chan<- *TokenTransfer, from []common.Address, to []common.Address) (bind.Subscription, error)",
+		"func (_Token *TokenFilterer) FilterMemo(opts *bind.FilterOpts, text []string) ([]*TokenMemo, error)",
+		"Text common.Hash",
+	} {
+		assert.True(t, strings.Contains(code, expected), "missing %q", expected)
+	}
+
+	_, err = Bind([]string{"my-token"}, []string{tokenABI}, []string{""}, "token")
+	assert.NotNil(t, err)
+	_, err = Bind([]string{"Token"}, []string{tokenABI}, []string{"0xzz"}, "token")
+	assert.NotNil(t, err)
+}
+
+func TestBoundContract(t *testing.T) {
+	parsed, err := abi.JSON(strings.NewReader(tokenABI))
+	assert.Nil(t, err)
+	backend := &testBackend{nonce: 7, gas: 50000}
+	opts := NewTransactOpts(testWallet{}, &account.Account{Address: common.Address{1}})
+
+	// Deploy with constructor arguments after bytecode
+	addr, tx, contract, err := DeployContract(opts, parsed, []byte{0x60, 0x80}, backend, big.NewInt(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, common.CreateAddress(common.Address{1}, 7), addr)
+	assert.Equal(t, append([]byte{0x60, 0x80}, word(1000)...), tx.Payload)
+	assert.True(t, tx.To.Nil())
+	assert.Equal(t, uint64(50000), tx.GasLimit)
+	assert.Equal(t, []byte("signed"), tx.Signature)
+
+	// Transaction with explicit nonce and gas
+	nonce := uint64(9)
+	opts.Nonce, opts.GasLimit = &nonce, 30000
+	tx, err = contract.Transact(opts, "transfer", common.Address{2}, big.NewInt(5))
+	assert.Nil(t, err)
+	assert.Equal(t, addr, tx.To)
+	assert.Equal(t, uint64(9), tx.Nonce)
+	assert.Equal(t, uint64(30000), tx.GasLimit)
+	assert.Equal(t, 2, len(backend.txs))
+	_, err = contract.Transact(&TransactOpts{}, "transfer", common.Address{2}, big.NewInt(5))
+	assert.Equal(t, ErrNoSigner, err)
+
+	// Read-only call
+	backend.output = word(42)
+	var balance *big.Int
+	assert.Nil(t, contract.Call(nil, &balance, "balanceOf", common.Address{2}))
+	assert.Equal(t, big.NewInt(42), balance)
+	assert.True(t, backend.calls[0].Static)
+	backend.output = nil
+	assert.Equal(t, ErrNoCode, contract.Call(nil, &balance, "balanceOf", common.Address{2}))
+}
+
+func TestFilterLogs(t *testing.T) {
+	parsed, err := abi.JSON(strings.NewReader(tokenABI))
+	assert.Nil(t, err)
+	addr := common.Address{9}
+	transfer := parsed.Events["Transfer"].ID()
+	backend := &testBackend{logs: []*types.Log{
+		{Address: addr, Topics: []common.Hash{transfer, addressTopic(common.Address{1}), addressTopic(common.Address{2})}, Data: word(10)},
+		{Address: addr, Topics: []common.Hash{transfer, addressTopic(common.Address{3}), addressTopic(common.Address{2})}, Data: word(20)},
+		{Address: common.Address{8}, Topics: []common.Hash{transfer, addressTopic(common.Address{1}), addressTopic(common.Address{2})}, Data: word(30)},
+	}}
+	contract := NewBoundContract(addr, parsed, backend, backend, backend)
+
+	logs, err := contract.FilterLogs(nil, "Transfer", []interface{}{common.Address{1}, common.Address{4}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logs))
+	var event struct {
+		From  common.Address
+		To    common.Address
+		Value *big.Int
+	}
+	assert.Nil(t, contract.UnpackLog(&event, "Transfer", logs[0]))
+	assert.Equal(t, common.Address{2}, event.To)
+	assert.Equal(t, big.NewInt(10), event.Value)
+
+	// Any sender to the recipient
+	logs, err = contract.FilterLogs(&FilterOpts{Start: 5}, "Transfer", nil, []interface{}{common.Address{2}})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(logs))
+	assert.Equal(t, big.NewInt(5), backend.query.FromBlock)
+
+	// Indexed string is hashed
+	_, err = contract.FilterLogs(nil, "Memo", []interface{}{"hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(backend.query.Topics))
+	_, err = contract.FilterLogs(nil, "Memo", []interface{}{1})
+	assert.NotNil(t, err)
+	_, err = contract.FilterLogs(nil, "Transfer", nil, nil, nil)
+	assert.NotNil(t, err)
+
+	// Watch logs until unsubscribed
+	received := make(chan *types.Log, 1)
+	sub, err := contract.WatchLogs("Transfer", func(log *types.Log) { received <- log })
+	assert.Nil(t, err)
+	backend.sub <- backend.logs[0]
+	select {
+	case log := <-received:
+		assert.Equal(t, backend.logs[0], log)
+	case <-time.After(time.Second):
+		t.Fatal("log not received")
+	}
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+}
+
+func TestFilterQueryMatches(t *testing.T) {
+	log := &types.Log{Address: common.Address{1}, Topics: []common.Hash{{1}, {2}}}
+	assert.True(t, (&FilterQuery{}).Matches(log))
+	assert.True(t, (&FilterQuery{Addresses: []common.Address{{2}, {1}}}).Matches(log))
+	assert.False(t, (&FilterQuery{Addresses: []common.Address{{2}}}).Matches(log))
+	assert.True(t, (&FilterQuery{Topics: [][]common.Hash{nil, {{3}, {2}}}}).Matches(log))
+	assert.False(t, (&FilterQuery{Topics: [][]common.Hash{{{2}}}}).Matches(log))
+	assert.False(t, (&FilterQuery{Topics: [][]common.Hash{nil, nil, nil}}).Matches(log))
+}
@@ -0,0 +1,231 @@
+package bind
+
+import "text/template"
+
+var bindTemplate = template.Must(template.New("bind").Parse(tmplSource))
+
+// tmplSource is the template of generated bindings
+const tmplSource = `// Code generated by abigen. DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package {{.Package}}
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+
+	"tinychain/abi"
+	"tinychain/abi/bind"
+	"tinychain/common"
+	"tinychain/core/types"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = hex.DecodeString
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = abi.JSON
+	_ = common.Address{}
+	_ = types.Log{}
+)
+{{range .Structs}}
+// {{.Name}} is a generated binding of the solidity struct.
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}}
+{{- end}}
+}
+{{end}}
+{{- range .Contracts}}
+{{- $T := .Type}}
+// {{$T}}ABI is the input ABI used to generate the binding from.
+const {{$T}}ABI = {{printf "%q" .InputABI}}
+{{if .InputBin}}
+// {{$T}}Bin is the compiled bytecode used for deploying new contracts.
+const {{$T}}Bin = "0x{{.InputBin}}"
+
+// Deploy{{$T}} deploys a new {{$T}} contract, binding an instance of {{$T}} to it.
+func Deploy{{$T}}(opts *bind.TransactOpts, backend bind.ContractBackend{{range .Constructor.Inputs}}, {{.Name}} {{.Type}}{{end}}) (common.Address, *types.Transaction, *{{$T}}, error) {
+	parsed, err := abi.JSON(strings.NewReader({{$T}}ABI))
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	bytecode, err := hex.DecodeString(strings.TrimPrefix({{$T}}Bin, "0x"))
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	address, tx, contract, err := bind.DeployContract(opts, parsed, bytecode, backend{{range .Constructor.Inputs}}, {{.Name}}{{end}})
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &{{$T}}{ {{- $T}}Caller: {{$T}}Caller{contract: contract}, {{$T}}Transactor: {{$T}}Transactor{contract: contract}, {{$T}}Filterer: {{$T}}Filterer{contract: contract}}, nil
+}
+{{end}}
+// {{$T}} is a Go binding around the {{$T}} contract.
+type {{$T}} struct {
+	{{$T}}Caller     // Read-only binding to the contract
+	{{$T}}Transactor // Write-only binding to the contract
+	{{$T}}Filterer   // Log filterer for contract events
+}
+
+// {{$T}}Caller is a read-only Go binding around the {{$T}} contract.
+type {{$T}}Caller struct {
+	contract *bind.BoundContract
+}
+
+// {{$T}}Transactor is a write-only Go binding around the {{$T}} contract.
+type {{$T}}Transactor struct {
+	contract *bind.BoundContract
+}
+
+// {{$T}}Filterer is a log filtering Go binding around the {{$T}} contract events.
+type {{$T}}Filterer struct {
+	contract *bind.BoundContract
+}
+
+// New{{$T}} creates a new instance of {{$T}}, bound to a specific deployed contract.
+func New{{$T}}(address common.Address, backend bind.ContractBackend) (*{{$T}}, error) {
+	contract, err := bind{{$T}}(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &{{$T}}{ {{- $T}}Caller: {{$T}}Caller{contract: contract}, {{$T}}Transactor: {{$T}}Transactor{contract: contract}, {{$T}}Filterer: {{$T}}Filterer{contract: contract}}, nil
+}
+
+// New{{$T}}Caller creates a new read-only instance of {{$T}}, bound to a specific deployed contract.
+func New{{$T}}Caller(address common.Address, caller bind.ContractCaller) (*{{$T}}Caller, error) {
+	contract, err := bind{{$T}}(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &{{$T}}Caller{contract: contract}, nil
+}
+
+// New{{$T}}Transactor creates a new write-only instance of {{$T}}, bound to a specific deployed contract.
+func New{{$T}}Transactor(address common.Address, transactor bind.ContractTransactor) (*{{$T}}Transactor, error) {
+	contract, err := bind{{$T}}(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &{{$T}}Transactor{contract: contract}, nil
+}
+
+// New{{$T}}Filterer creates a new log filterer instance of {{$T}}, bound to a specific deployed contract.
+func New{{$T}}Filterer(address common.Address, filterer bind.ContractFilterer) (*{{$T}}Filterer, error) {
+	contract, err := bind{{$T}}(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &{{$T}}Filterer{contract: contract}, nil
+}
+
+// bind{{$T}} binds a generic wrapper to an already deployed contract.
+func bind{{$T}}(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader({{$T}}ABI))
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, parsed, caller, transactor, filterer), nil
+}
+{{range .Calls}}
+// {{.Normalized}} is a free data retrieval call binding the contract method 0x{{.ID}}.
+//
+// Solidity: {{.Original.Sig}}
+func (_{{$T}} *{{$T}}Caller) {{.Normalized}}(opts *bind.CallOpts{{range .Inputs}}, {{.Name}} {{.Type}}{{end}}) ({{if .Structured}}struct {
+{{- range .Outputs}}
+	{{.Name}} {{.Type}}
+{{- end}}
+}, {{else}}{{range .Outputs}}{{.Type}}, {{end}}{{end}}error) {
+{{- if .Structured}}
+	out := new(struct {
+	{{- range .Outputs}}
+		{{.Name}} {{.Type}}
+	{{- end}}
+	})
+	err := _{{$T}}.contract.Call(opts, out, "{{.Original.Name}}"{{range .Inputs}}, {{.Name}}{{end}})
+	return *out, err
+{{- else if .Outputs}}
+	var out {{(index .Outputs 0).Type}}
+	err := _{{$T}}.contract.Call(opts, &out, "{{.Original.Name}}"{{range .Inputs}}, {{.Name}}{{end}})
+	return out, err
+{{- else}}
+	return _{{$T}}.contract.Call(opts, nil, "{{.Original.Name}}"{{range .Inputs}}, {{.Name}}{{end}})
+{{- end}}
+}
+{{end}}
+{{- range .Transacts}}
+// {{.Normalized}} is a paid mutator transaction binding the contract method 0x{{.ID}}.
+//
+// Solidity: {{.Original.Sig}}
+func (_{{$T}} *{{$T}}Transactor) {{.Normalized}}(opts *bind.TransactOpts{{range .Inputs}}, {{.Name}} {{.Type}}{{end}}) (*types.Transaction, error) {
+	return _{{$T}}.contract.Transact(opts, "{{.Original.Name}}"{{range .Inputs}}, {{.Name}}{{end}})
+}
+{{end}}
+{{- range .Events}}
+// {{$T}}{{.Normalized}} represents a {{.Original.RawName}} event raised by the {{$T}} contract.
+type {{$T}}{{.Normalized}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}}
+{{- end}}
+	Raw types.Log // Log of the event
+}
+
+// Filter{{.Normalized}} is a free log retrieval operation binding the contract event 0x{{.ID}}.
+//
+// Solidity: {{.Original.Sig}}
+func (_{{$T}} *{{$T}}Filterer) Filter{{.Normalized}}(opts *bind.FilterOpts{{range .Indexed}}, {{.Name}} []{{.FilterType}}{{end}}) ([]*{{$T}}{{.Normalized}}, error) {
+{{- range .Indexed}}
+	var {{.Name}}Rule []interface{}
+	for _, {{.Name}}Item := range {{.Name}} {
+		{{.Name}}Rule = append({{.Name}}Rule, {{.Name}}Item)
+	}
+{{- end}}
+	logs, err := _{{$T}}.contract.FilterLogs(opts, "{{.Original.Name}}"{{range .Indexed}}, {{.Name}}Rule{{end}})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*{{$T}}{{.Normalized}}, 0, len(logs))
+	for _, log := range logs {
+		event, err := _{{$T}}.Parse{{.Normalized}}(*log)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Watch{{.Normalized}} is a free log subscription operation binding the contract event 0x{{.ID}}.
+// Events of new committed blocks are sent to sink until unsubscribed.
+//
+// Solidity: {{.Original.Sig}}
+func (_{{$T}} *{{$T}}Filterer) Watch{{.Normalized}}(sink chan<- *{{$T}}{{.Normalized}}{{range .Indexed}}, {{.Name}} []{{.FilterType}}{{end}}) (bind.Subscription, error) {
+{{- range .Indexed}}
+	var {{.Name}}Rule []interface{}
+	for _, {{.Name}}Item := range {{.Name}} {
+		{{.Name}}Rule = append({{.Name}}Rule, {{.Name}}Item)
+	}
+{{- end}}
+	return _{{$T}}.contract.WatchLogs("{{.Original.Name}}", func(log *types.Log) {
+		if event, err := _{{$T}}.Parse{{.Normalized}}(*log); err == nil {
+			sink <- event
+		}
+	}{{range .Indexed}}, {{.Name}}Rule{{end}})
+}
+
+// Parse{{.Normalized}} is a log parse operation binding the contract event 0x{{.ID}}.
+//
+// Solidity: {{.Original.Sig}}
+func (_{{$T}} *{{$T}}Filterer) Parse{{.Normalized}}(log types.Log) (*{{$T}}{{.Normalized}}, error) {
+	event := new({{$T}}{{.Normalized}})
+	if err := _{{$T}}.contract.UnpackLog(event, "{{.Original.Name}}", &log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+{{end}}
+{{- end}}
+`
@@ -0,0 +1,218 @@
+package abi
+
+import (
+	"tinychain/common"
+	"fmt"
+	"math/big"
+	"reflect"
+)
+
+var (
+	tt256   = new(big.Int).Lsh(big.NewInt(1), 256) // 2^256
+	byteTyp = reflect.TypeOf(byte(0))
+)
+
+// packTuple encodes values of types as tuple, heads of static values and
+// offsets of dynamic values followed by tails of dynamic values
+func packTuple(types []*Type, values []reflect.Value) ([]byte, error) {
+	if len(types) != len(values) {
+		return nil, fmt.Errorf("abi: %d values for %d types", len(values), len(types))
+	}
+	headSize := 0
+	for _, t := range types {
+		headSize += t.headSize()
+	}
+	var heads, tails []byte
+	for i, t := range types {
+		enc, err := pack(t, values[i])
+		if err != nil {
+			return nil, err
+		}
+		if t.IsDynamic() {
+			heads = append(heads, packUint(uint64(headSize+len(tails)))...)
+			tails = append(tails, enc...)
+		} else {
+			heads = append(heads, enc...)
+		}
+	}
+	return append(heads, tails...), nil
+}
+
+// pack encodes the value of type t
+func pack(t *Type, v reflect.Value) ([]byte, error) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return nil, fmt.Errorf("abi: nil value for %s", t)
+	}
+	switch t.Kind {
+	case IntTy, UintTy:
+		n, err := toBig(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s for %s", err, t)
+		}
+		if !fitsInt(t, n) {
+			return nil, fmt.Errorf("abi: %s overflows %s", n, t)
+		}
+		return packBig(n), nil
+	case BoolTy:
+		if v.Kind() != reflect.Bool {
+			return nil, typeError(t, v)
+		}
+		if v.Bool() {
+			return packUint(1), nil
+		}
+		return packUint(0), nil
+	case AddressTy:
+		if !isBytes(v) || v.Len() != common.AddressLength {
+			return nil, typeError(t, v)
+		}
+		return common.LeftPadBytes(bytesOf(v), 32), nil
+	case FixedBytesTy:
+		if !isBytes(v) || v.Len() > t.Size || (v.Kind() == reflect.Array && v.Len() != t.Size) {
+			return nil, typeError(t, v)
+		}
+		return common.RightPadBytes(bytesOf(v), 32), nil
+	case BytesTy:
+		if !isBytes(v) {
+			return nil, typeError(t, v)
+		}
+		return packBytes(bytesOf(v)), nil
+	case StringTy:
+		if v.Kind() != reflect.String {
+			return nil, typeError(t, v)
+		}
+		return packBytes([]byte(v.String())), nil
+	case SliceTy, ArrayTy:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return nil, typeError(t, v)
+		}
+		if t.Kind == ArrayTy && v.Len() != t.Size {
+			return nil, fmt.Errorf("abi: %d elements for %s", v.Len(), t)
+		}
+		types := make([]*Type, v.Len())
+		values := make([]reflect.Value, v.Len())
+		for i := range types {
+			types[i], values[i] = t.Elem, v.Index(i)
+		}
+		enc, err := packTuple(types, values)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == SliceTy {
+			enc = append(packUint(uint64(v.Len())), enc...)
+		}
+		return enc, nil
+	case TupleTy:
+		values, err := tupleValues(t, v)
+		if err != nil {
+			return nil, err
+		}
+		return packTuple(t.TupleElems, values)
+	}
+	return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+}
+
+// tupleValues returns the elements of tuple from struct fields by name,
+// or from slice by position
+func tupleValues(t *Type, v reflect.Value) ([]reflect.Value, error) {
+	values := make([]reflect.Value, len(t.TupleElems))
+	switch v.Kind() {
+	case reflect.Struct:
+		for i, name := range t.TupleNames {
+			field, ok := structField(v, name, i)
+			if !ok {
+				return nil, fmt.Errorf("abi: field %s of %s not found in %s", name, t, v.Type())
+			}
+			values[i] = field
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Len() != len(values) {
+			return nil, fmt.Errorf("abi: %d elements for %s", v.Len(), t)
+		}
+		for i := range values {
+			values[i] = v.Index(i)
+		}
+	default:
+		return nil, typeError(t, v)
+	}
+	return values, nil
+}
+
+// structField returns the field of solidity name, matched by json tag or go name
+func structField(v reflect.Value, name string, i int) (reflect.Value, bool) {
+	for j := 0; j < v.NumField(); j++ {
+		if v.Type().Field(j).Tag.Get("json") == name && name != "" {
+			return v.Field(j), true
+		}
+	}
+	field := v.FieldByName(fieldName(name, i))
+	return field, field.IsValid()
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr && v.Type() != bigType) || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v
+}
+
+func isBytes(v reflect.Value) bool {
+	return (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem() == byteTyp
+}
+
+func bytesOf(v reflect.Value) []byte {
+	if v.Kind() == reflect.Slice {
+		return v.Bytes()
+	}
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	return b
+}
+
+func typeError(t *Type, v reflect.Value) error {
+	return fmt.Errorf("abi: can not use %s as %s", v.Type(), t)
+}
+
+// toBig converts go integers and *big.Int to big.Int
+func toBig(v reflect.Value) (*big.Int, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(v.Uint()), nil
+	}
+	if v.Type() == bigType && !v.IsNil() {
+		return v.Interface().(*big.Int), nil
+	}
+	return nil, fmt.Errorf("abi: can not use %s as integer", v.Type())
+}
+
+// fitsInt reports whether n is in the range of int or uint type t
+func fitsInt(t *Type, n *big.Int) bool {
+	if t.Kind == UintTy {
+		return n.Sign() >= 0 && n.BitLen() <= t.Size
+	}
+	if n.Sign() >= 0 {
+		return n.BitLen() < t.Size
+	}
+	// -2^(size-1) <= n
+	return new(big.Int).Add(n, big.NewInt(1)).BitLen() < t.Size
+}
+
+// packBig encodes n as 256 bits two's complement
+func packBig(n *big.Int) []byte {
+	if n.Sign() < 0 {
+		n = new(big.Int).Add(n, tt256)
+	}
+	return common.LeftPadBytes(n.Bytes(), 32)
+}
+
+func packUint(n uint64) []byte {
+	return packBig(new(big.Int).SetUint64(n))
+}
+
+// packBytes encodes length followed by right padded bytes
+func packBytes(b []byte) []byte {
+	padded := (len(b) + 31) / 32 * 32
+	return append(packUint(uint64(len(b))), common.RightPadBytes(b, padded)...)
+}
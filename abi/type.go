@@ -0,0 +1,267 @@
+package abi
+
+import (
+	"tinychain/common"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kind is the kind of solidity type
+type Kind int
+
+const (
+	IntTy Kind = iota
+	UintTy
+	BoolTy
+	AddressTy
+	FixedBytesTy // bytes1 to bytes32
+	BytesTy
+	StringTy
+	SliceTy // T[]
+	ArrayTy // T[k]
+	TupleTy
+)
+
+var (
+	ErrInvalidType = errors.New("abi: invalid type")
+
+	bigType     = reflect.TypeOf(&big.Int{})
+	addressType = reflect.TypeOf(common.Address{})
+	hashType    = reflect.TypeOf(common.Hash{})
+
+	typeRegex = regexp.MustCompile(`^([a-z]+)([0-9]*)$`)
+)
+
+// Type is the solidity type of arguments
+type Type struct {
+	Kind       Kind
+	Size       int      // bits of int and uint, length of fixed bytes and array
+	Elem       *Type    // element type of slice and array
+	TupleElems []*Type  // element types of tuple
+	TupleNames []string // element names of tuple
+	TupleRaw   string   // struct name of tuple from internalType, e.g. "Order"
+
+	stringKind string // canonical type name, e.g. "uint256[2]" or "(address,bytes)"
+}
+
+// NewType parses the solidity type, with components of tuple
+func NewType(t string, internalType string, components []ArgumentMarshaling) (*Type, error) {
+	// Array and slice, parse the last dimension
+	if strings.HasSuffix(t, "]") {
+		i := strings.LastIndex(t, "[")
+		if i < 0 {
+			return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+		}
+		elemInternal := internalType
+		if j := strings.LastIndex(internalType, "["); j >= 0 {
+			elemInternal = internalType[:j]
+		}
+		elem, err := NewType(t[:i], elemInternal, components)
+		if err != nil {
+			return nil, err
+		}
+		dim := t[i+1 : len(t)-1]
+		if dim == "" {
+			return &Type{Kind: SliceTy, Elem: elem, stringKind: elem.stringKind + "[]"}, nil
+		}
+		size, err := strconv.Atoi(dim)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+		}
+		return &Type{Kind: ArrayTy, Size: size, Elem: elem, stringKind: elem.stringKind + "[" + dim + "]"}, nil
+	}
+
+	if t == "tuple" {
+		typ := &Type{Kind: TupleTy, TupleRaw: tupleRawName(internalType)}
+		var names []string
+		for _, c := range components {
+			elem, err := NewType(c.Type, c.InternalType, c.Components)
+			if err != nil {
+				return nil, err
+			}
+			typ.TupleElems = append(typ.TupleElems, elem)
+			typ.TupleNames = append(typ.TupleNames, c.Name)
+			names = append(names, elem.stringKind)
+		}
+		typ.stringKind = "(" + strings.Join(names, ",") + ")"
+		return typ, nil
+	}
+
+	match := typeRegex.FindStringSubmatch(t)
+	if match == nil {
+		return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+	}
+	base, size := match[1], 0
+	if match[2] != "" {
+		var err error
+		if size, err = strconv.Atoi(match[2]); err != nil {
+			return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+		}
+	}
+	typ := &Type{stringKind: t}
+	switch base {
+	case "int", "uint":
+		if match[2] == "" {
+			size = 256
+			typ.stringKind = base + "256"
+		}
+		if size == 0 || size > 256 || size%8 != 0 {
+			return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+		}
+		typ.Kind, typ.Size = IntTy, size
+		if base == "uint" {
+			typ.Kind = UintTy
+		}
+	case "bool", "address", "string":
+		if match[2] != "" {
+			return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+		}
+		typ.Kind = map[string]Kind{"bool": BoolTy, "address": AddressTy, "string": StringTy}[base]
+	case "bytes":
+		if match[2] == "" {
+			typ.Kind = BytesTy
+			break
+		}
+		if size == 0 || size > 32 {
+			return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+		}
+		typ.Kind, typ.Size = FixedBytesTy, size
+	case "function":
+		// Address and selector
+		if match[2] != "" {
+			return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+		}
+		typ.Kind, typ.Size = FixedBytesTy, 24
+	default:
+		return nil, fmt.Errorf("%s %s", ErrInvalidType, t)
+	}
+	return typ, nil
+}
+
+// tupleRawName returns the struct name in internal type, e.g. "struct Pool.Order[]" -> "Order"
+func tupleRawName(internalType string) string {
+	name := strings.TrimPrefix(internalType, "struct ")
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// String returns the canonical type name used in signatures
+func (t *Type) String() string {
+	return t.stringKind
+}
+
+// IsDynamic reports whether the encoding of t has dynamic size
+func (t *Type) IsDynamic() bool {
+	switch t.Kind {
+	case BytesTy, StringTy, SliceTy:
+		return true
+	case ArrayTy:
+		return t.Elem.IsDynamic()
+	case TupleTy:
+		for _, elem := range t.TupleElems {
+			if elem.IsDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize returns the size of t in the head of tuple encoding
+func (t *Type) headSize() int {
+	if t.IsDynamic() {
+		return 32
+	}
+	switch t.Kind {
+	case ArrayTy:
+		return t.Size * t.Elem.headSize()
+	case TupleTy:
+		size := 0
+		for _, elem := range t.TupleElems {
+			size += elem.headSize()
+		}
+		return size
+	}
+	return 32
+}
+
+// GoType returns the go type of values decoded from t:
+//   - int and uint of 8, 16, 32 and 64 bits are int8 to uint64, others are *big.Int
+//   - address is common.Address, and bytesN is [N]byte
+//   - T[] and T[k] are slices and arrays of element type
+//   - tuple is struct with fields of camel case names
+func (t *Type) GoType() reflect.Type {
+	switch t.Kind {
+	case IntTy, UintTy:
+		return intGoType(t.Kind, t.Size)
+	case BoolTy:
+		return reflect.TypeOf(false)
+	case AddressTy:
+		return addressType
+	case FixedBytesTy:
+		return reflect.ArrayOf(t.Size, reflect.TypeOf(byte(0)))
+	case BytesTy:
+		return reflect.TypeOf([]byte{})
+	case StringTy:
+		return reflect.TypeOf("")
+	case SliceTy:
+		return reflect.SliceOf(t.Elem.GoType())
+	case ArrayTy:
+		return reflect.ArrayOf(t.Size, t.Elem.GoType())
+	case TupleTy:
+		fields := make([]reflect.StructField, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			fields[i] = reflect.StructField{
+				Name: fieldName(t.TupleNames[i], i),
+				Type: elem.GoType(),
+				Tag:  reflect.StructTag(fmt.Sprintf(`json:"%s"`, t.TupleNames[i])),
+			}
+		}
+		return reflect.StructOf(fields)
+	}
+	return nil
+}
+
+func intGoType(kind Kind, size int) reflect.Type {
+	var (
+		ints  = map[int]reflect.Type{8: reflect.TypeOf(int8(0)), 16: reflect.TypeOf(int16(0)), 32: reflect.TypeOf(int32(0)), 64: reflect.TypeOf(int64(0))}
+		uints = map[int]reflect.Type{8: reflect.TypeOf(uint8(0)), 16: reflect.TypeOf(uint16(0)), 32: reflect.TypeOf(uint32(0)), 64: reflect.TypeOf(uint64(0))}
+	)
+	types := ints
+	if kind == UintTy {
+		types = uints
+	}
+	if typ, ok := types[size]; ok {
+		return typ
+	}
+	return bigType
+}
+
+// fieldName returns the go field name of the i-th element named name
+func fieldName(name string, i int) string {
+	if name = ToCamelCase(name); name == "" {
+		return fmt.Sprintf("Field%d", i)
+	}
+	return name
+}
+
+// ToCamelCase converts solidity name to exported go name, e.g. "_to_addr" -> "ToAddr"
+func ToCamelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,175 @@
+package abi
+
+import (
+	"tinychain/common"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+)
+
+var ErrInvalidData = errors.New("abi: invalid encoded data")
+
+// unpackTuple decodes values of types from tuple encoding
+func unpackTuple(types []*Type, data []byte) ([]reflect.Value, error) {
+	values := make([]reflect.Value, len(types))
+	pos := 0
+	for i, t := range types {
+		start := pos
+		if t.IsDynamic() {
+			offset, err := readLength(data, pos)
+			if err != nil {
+				return nil, err
+			}
+			start = offset
+		} else if pos+t.headSize() > len(data) {
+			return nil, fmt.Errorf("%s, %s out of range", ErrInvalidData, t)
+		}
+		v, err := unpack(t, data[start:])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+		pos += t.headSize()
+	}
+	return values, nil
+}
+
+// unpack decodes the value of type t at the start of data
+func unpack(t *Type, data []byte) (reflect.Value, error) {
+	switch t.Kind {
+	case IntTy, UintTy:
+		word, err := readWord(data, 0)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return unpackInt(t, word)
+	case BoolTy:
+		word, err := readWord(data, 0)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		n := new(big.Int).SetBytes(word)
+		if n.BitLen() > 1 {
+			return reflect.Value{}, fmt.Errorf("%s, invalid bool", ErrInvalidData)
+		}
+		return reflect.ValueOf(n.Sign() == 1), nil
+	case AddressTy:
+		word, err := readWord(data, 0)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(common.BytesToAddress(word[32-common.AddressLength:])), nil
+	case FixedBytesTy:
+		word, err := readWord(data, 0)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.GoType()).Elem()
+		reflect.Copy(v, reflect.ValueOf(word[:t.Size]))
+		return v, nil
+	case BytesTy, StringTy:
+		length, err := readLength(data, 0)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if 32+length > len(data) {
+			return reflect.Value{}, fmt.Errorf("%s, %s out of range", ErrInvalidData, t)
+		}
+		b := make([]byte, length)
+		copy(b, data[32:])
+		if t.Kind == StringTy {
+			return reflect.ValueOf(string(b)), nil
+		}
+		return reflect.ValueOf(b), nil
+	case SliceTy:
+		length, err := readLength(data, 0)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		// Every element takes one word at least
+		if length > (len(data)-32)/32 {
+			return reflect.Value{}, fmt.Errorf("%s, %s of %d elements out of range", ErrInvalidData, t, length)
+		}
+		elems, err := unpackTuple(repeat(t.Elem, length), data[32:])
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.MakeSlice(t.GoType(), length, length)
+		for i, elem := range elems {
+			v.Index(i).Set(elem)
+		}
+		return v, nil
+	case ArrayTy:
+		elems, err := unpackTuple(repeat(t.Elem, t.Size), data)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.GoType()).Elem()
+		for i, elem := range elems {
+			v.Index(i).Set(elem)
+		}
+		return v, nil
+	case TupleTy:
+		elems, err := unpackTuple(t.TupleElems, data)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.GoType()).Elem()
+		for i, elem := range elems {
+			v.Field(i).Set(elem)
+		}
+		return v, nil
+	}
+	return reflect.Value{}, fmt.Errorf("%s %s", ErrInvalidType, t)
+}
+
+// unpackInt decodes the 256 bits two's complement word as go type of t
+func unpackInt(t *Type, word []byte) (reflect.Value, error) {
+	n := new(big.Int).SetBytes(word)
+	if t.Kind == IntTy && word[0]&0x80 != 0 {
+		n.Sub(n, tt256)
+	}
+	if !fitsInt(t, n) {
+		return reflect.Value{}, fmt.Errorf("%s, %s overflows %s", ErrInvalidData, n, t)
+	}
+	typ := t.GoType()
+	if typ == bigType {
+		return reflect.ValueOf(n), nil
+	}
+	v := reflect.New(typ).Elem()
+	if t.Kind == IntTy {
+		v.SetInt(n.Int64())
+	} else {
+		v.SetUint(n.Uint64())
+	}
+	return v, nil
+}
+
+func repeat(t *Type, n int) []*Type {
+	types := make([]*Type, n)
+	for i := range types {
+		types[i] = t
+	}
+	return types
+}
+
+func readWord(data []byte, pos int) ([]byte, error) {
+	if pos < 0 || pos+32 > len(data) {
+		return nil, fmt.Errorf("%s, word at %d out of range", ErrInvalidData, pos)
+	}
+	return data[pos : pos+32], nil
+}
+
+// readLength reads an offset or length, which must be in range of data
+func readLength(data []byte, pos int) (int, error) {
+	word, err := readWord(data, pos)
+	if err != nil {
+		return 0, err
+	}
+	n := new(big.Int).SetBytes(word)
+	if !n.IsInt64() || n.Int64() > int64(len(data)) {
+		return 0, fmt.Errorf("%s, length %s out of range", ErrInvalidData, n)
+	}
+	return int(n.Int64()), nil
+}
@@ -107,7 +107,8 @@ func (tw *TinyWallet) SignHash(account *Account, hash []byte) ([]byte, error) {
 	return key.privKey.Sign(hash)
 }
 
-func (tw *TinyWallet) SignTx(account *Account, tx *types.Transaction) ([]byte, error) {
+// SignTx signs the transaction by the unlocked account, and returns the signed transaction
+func (tw *TinyWallet) SignTx(account *Account, tx *types.Transaction) (*types.Transaction, error) {
 	if !tw.Contains(account) {
 		return nil, ErrNotFoundAcc
 	}
@@ -115,5 +116,8 @@ func (tw *TinyWallet) SignTx(account *Account, tx *types.Transaction) ([]byte, e
 	if !ok {
 		return nil, ErrNotUnlock
 	}
-	return tx.Sign(key.privKey)
+	if _, err := tx.Sign(key.privKey); err != nil {
+		return nil, err
+	}
+	return tx, nil
 }
@@ -4,9 +4,7 @@ import (
 	"tinychain/common"
 	"encoding/binary"
 	"sync"
-	json "github.com/json-iterator/go"
 	"bytes"
-	"errors"
 	"sort"
 )
 
@@ -27,22 +25,51 @@ func (bk *Bucket) Hash() common.Hash {
 	return bk.H
 }
 
-// Compute hash
-func (bk *Bucket) computHash() common.Hash {
+// Compute hash with the hash scheme of tree
+func (bk *Bucket) computHash(scheme HashScheme) common.Hash {
 	bk.lock.Lock()
 	defer bk.lock.Unlock()
-	var bytes []byte
+	// Empty bucket is regarded as never created
+	if len(bk.Keys) == 0 {
+		bk.H = common.Hash{}
+		return bk.H
+	}
 	// Sort the keys array in increasing order
 	if !sort.StringsAreSorted(bk.Keys) {
 		sort.Strings(bk.Keys)
 	}
-	for _, key := range bk.Keys {
-		bytes = append(bytes, bk.Slots[key]...)
+	if scheme == HashLegacy {
+		bk.H = hashLegacySlots(bk.Keys, bk.Slots)
+	} else {
+		bk.H = hashSlots(bk.Keys, bk.Slots)
 	}
-	bk.H = common.Sha256(bytes)
 	return bk.H
 }
 
+// hashSlots computes the bucket hash of slots in the order of sorted keys.
+// Both key and value are committed, so that a value cannot be claimed under
+// another key of the same bucket.
+func hashSlots(keys []string, slots map[string][]byte) common.Hash {
+	var bytes []byte
+	for _, key := range keys {
+		kh := common.Sha256([]byte(key))
+		vh := common.Sha256(slots[key])
+		bytes = append(bytes, kh[:]...)
+		bytes = append(bytes, vh[:]...)
+	}
+	return common.Sha256(bytes)
+}
+
+// hashLegacySlots computes the bucket hash of HashLegacy scheme, which is
+// the hash of values in the order of sorted keys.
+func hashLegacySlots(keys []string, slots map[string][]byte) common.Hash {
+	var bytes []byte
+	for _, key := range keys {
+		bytes = append(bytes, slots[key]...)
+	}
+	return common.Sha256(bytes)
+}
+
 // Check key is existed or not
 func (bk *Bucket) hasKey(key string) bool {
 	for _, v := range bk.Keys {
@@ -62,12 +89,42 @@ func (bk *Bucket) addKey(key string) {
 	bk.Keys = append(bk.Keys, key)
 }
 
+func (bk *Bucket) removeKey(key string) {
+	bk.lock.Lock()
+	defer bk.lock.Unlock()
+	for i, v := range bk.Keys {
+		if v == key {
+			bk.Keys = append(bk.Keys[:i], bk.Keys[i+1:]...)
+			break
+		}
+	}
+	delete(bk.Slots, key)
+}
+
+func (bk *Bucket) copy() *Bucket {
+	bk.lock.RLock()
+	defer bk.lock.RUnlock()
+	nb := &Bucket{
+		H:     bk.H,
+		Slots: make(map[string][]byte, len(bk.Slots)),
+		Keys:  make([]string, len(bk.Keys)),
+	}
+	// Values are never modified in place, so they are shared
+	for k, v := range bk.Slots {
+		nb.Slots[k] = v
+	}
+	copy(nb.Keys, bk.Keys)
+	return nb
+}
+
 func (bk *Bucket) serialize() ([]byte, error) {
-	return json.Marshal(bk)
+	bk.lock.RLock()
+	defer bk.lock.RUnlock()
+	return encodeBucket(bk), nil
 }
 
 func (bk *Bucket) deserialize(d []byte) error {
-	return json.Unmarshal(d, bk)
+	return decodeBucket(d, bk)
 }
 
 // Wrapper of buckets
@@ -75,15 +132,17 @@ type HashTable struct {
 	db         *BmtDB
 	Cap        int           `json:"cap"`
 	BucketHash []common.Hash `json:"bucket_hash"`
+	Scheme     HashScheme    `json:"-"` // json encoded tables are HashLegacy
 	buckets    []*Bucket
 	dirty      []bool
 	lock       sync.RWMutex
 }
 
-func NewHashTable(db *BmtDB, cap int) *HashTable {
+func NewHashTable(db *BmtDB, cap int, scheme HashScheme) *HashTable {
 	return &HashTable{
 		db:         db,
 		Cap:        cap,
+		Scheme:     scheme,
 		buckets:    make([]*Bucket, cap, cap),
 		BucketHash: make([]common.Hash, cap, cap),
 		dirty:      make([]bool, cap, cap),
@@ -91,27 +150,123 @@ func NewHashTable(db *BmtDB, cap int) *HashTable {
 }
 
 func (ht *HashTable) copy() *HashTable {
-	var newBuckets []*Bucket
-	newHT := *ht
-	for _, bucket := range ht.buckets {
-		nb := *bucket
-		newBuckets = append(newBuckets, &nb)
+	ht.lock.RLock()
+	defer ht.lock.RUnlock()
+	newHT := &HashTable{
+		db:         ht.db,
+		Cap:        ht.Cap,
+		Scheme:     ht.Scheme,
+		buckets:    make([]*Bucket, len(ht.buckets)),
+		BucketHash: make([]common.Hash, len(ht.BucketHash)),
+		dirty:      make([]bool, len(ht.dirty)),
+	}
+	copy(newHT.BucketHash, ht.BucketHash)
+	copy(newHT.dirty, ht.dirty)
+	for i, bucket := range ht.buckets {
+		if bucket != nil {
+			newHT.buckets[i] = bucket.copy()
+		}
+	}
+	return newHT
+}
+
+// Collect all slots in hash table, buckets will be loaded from db
+func (ht *HashTable) slots() (WriteSet, error) {
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+	set := NewWriteSet()
+	for i := range ht.buckets {
+		bucket, err := ht.getBucket(uint32(i))
+		if err == ErrKeyNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for k, v := range bucket.Slots {
+			set[k] = v
+		}
+	}
+	return set, nil
+}
+
+func (ht *HashTable) forEach(fn func(key, value []byte) bool) error {
+	for i := 0; i < ht.Cap; i++ {
+		keys, values, err := ht.bucketSlots(uint32(i))
+		if err != nil {
+			return err
+		}
+		// Call fn without lock, so that it could access the table
+		for j, k := range keys {
+			if !fn([]byte(k), values[j]) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Get sorted slots of bucket at index
+func (ht *HashTable) bucketSlots(index uint32) ([]string, [][]byte, error) {
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+	bucket, err := ht.getBucket(index)
+	if err == ErrKeyNotFound {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(bucket.Slots))
+	for k := range bucket.Slots {
+		keys = append(keys, k)
 	}
-	newHT.buckets = newBuckets
-	return &newHT
+	sort.Strings(keys)
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		values[i] = bucket.Slots[k]
+	}
+	return keys, values, nil
 }
 
 func (ht *HashTable) serialize() ([]byte, error) {
-	return json.Marshal(ht)
+	return encodeHashTable(ht), nil
 }
 
 func (ht *HashTable) deserialize(d []byte) error {
-	return json.Unmarshal(d, ht)
+	return decodeHashTable(d, ht)
 }
 
 func (ht *HashTable) getIndex(key string) uint32 {
-	val := binary.BigEndian.Uint32([]byte(key))
-	return val % uint32(ht.Cap)
+	return bucketIndex(key, ht.Cap)
+}
+
+// bucketIndex locates the bucket of key in a hash table with the given capacity.
+// Keys shorter than 4 bytes are left-padded with zero. Such keys could not be
+// stored by older nodes, so all schemes share the same index.
+func bucketIndex(key string, cap int) uint32 {
+	var buf [4]byte
+	if len(key) >= len(buf) {
+		copy(buf[:], key)
+	} else {
+		copy(buf[len(buf)-len(key):], key)
+	}
+	val := binary.BigEndian.Uint32(buf[:])
+	return val % uint32(cap)
+}
+
+// Get bucket at index, load it from db if not cached
+func (ht *HashTable) getBucket(index uint32) (*Bucket, error) {
+	if bucket := ht.buckets[index]; bucket != nil {
+		return bucket, nil
+	}
+	if hash := ht.BucketHash[index]; !hash.Nil() && ht.db != nil {
+		bucket, err := ht.db.GetBucket(hash)
+		if err != nil {
+			return nil, err
+		}
+		ht.buckets[index] = bucket
+		return bucket, nil
+	}
+	return nil, ErrKeyNotFound
 }
 
 func (ht *HashTable) put(key string, value []byte) error {
@@ -138,33 +293,48 @@ func (ht *HashTable) put(key string, value []byte) error {
 	oldVal := bucket.Slots[key]
 	if bytes.Compare(oldVal, value) != 0 {
 		bucket.addKey(key)
+		bucket.lock.Lock()
 		bucket.Slots[key] = value
+		bucket.lock.Unlock()
+		ht.dirty[index] = true
+	}
+	return nil
+}
+
+func (ht *HashTable) delete(key string) error {
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+	index := ht.getIndex(key)
+	bucket, err := ht.getBucket(index)
+	if err == ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, ok := bucket.Slots[key]; ok {
+		bucket.removeKey(key)
 		ht.dirty[index] = true
 	}
 	return nil
 }
 
 func (ht *HashTable) get(key string) ([]byte, error) {
-	var (
-		err    error
-		bucket *Bucket
-	)
-	ht.lock.RLock()
-	defer ht.lock.RUnlock()
 	index := ht.getIndex(key)
-	bucket = ht.buckets[index]
+	ht.lock.RLock()
+	bucket := ht.buckets[index]
+	ht.lock.RUnlock()
 	if bucket == nil {
-		if hash := ht.BucketHash[index]; !hash.Nil() && ht.db != nil {
-			// Get bucket from db by bucket_hash
-			bucket, err = ht.db.GetBucket(ht.BucketHash[index])
-			if err != nil {
-				return nil, err
-			}
-			ht.buckets[index] = bucket
-		} else {
-			return nil, errors.New("value not found")
+		// Load bucket from db
+		var err error
+		ht.lock.Lock()
+		bucket, err = ht.getBucket(index)
+		ht.lock.Unlock()
+		if err != nil {
+			return nil, err
 		}
 	}
+	bucket.lock.RLock()
+	defer bucket.lock.RUnlock()
 	return bucket.Slots[key], nil
 }
 
@@ -175,7 +345,7 @@ func (ht *HashTable) store() error {
 	ht.lock.Lock()
 	defer ht.lock.Unlock()
 	for i, dirty := range ht.dirty {
-		if dirty {
+		if dirty && !ht.buckets[i].Hash().Nil() {
 			bucket := ht.buckets[i]
 			err := ht.db.PutBucket(bucket.Hash(), bucket)
 			if err != nil {
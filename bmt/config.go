@@ -0,0 +1,43 @@
+package bmt
+
+import "errors"
+
+var (
+	ErrInvalidConfig = errors.New("invalid bucket tree config")
+)
+
+// HashScheme is the way bucket and merkle node hashes are computed. It's decided
+// when a tree is created and kept by the committed hash table, so the roots of
+// trees committed by older nodes never change.
+type HashScheme uint8
+
+const (
+	// HashKeyed commits both keys and values of a bucket. It's the scheme of
+	// new trees.
+	HashKeyed HashScheme = iota
+	// HashLegacy commits only values of a bucket. Trees committed before
+	// HashKeyed was introduced use it, and proofs are not supported since
+	// values are not bound to keys.
+	HashLegacy
+)
+
+type Config struct {
+	Capacity   int        // Capacity of hash table, the amount of buckets
+	Aggreation int        // Aggreation of merkle node, the amount of children
+	Scheme     HashScheme // Hash scheme of new tree
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Capacity:   defaultHashTableCap,
+		Aggreation: defaultAggreation,
+		Scheme:     HashKeyed,
+	}
+}
+
+func (c *Config) validate() error {
+	if c == nil || c.Capacity <= 0 || c.Capacity > maxHashTableCap || c.Aggreation <= 1 || c.Scheme > HashLegacy {
+		return ErrInvalidConfig
+	}
+	return nil
+}
@@ -27,8 +27,11 @@ func (bdb *BmtDB) GetNode(key common.Hash) (*MerkleNode, error) {
 		return nil, err
 	}
 	node := &MerkleNode{db: bdb}
-	node.deserialize(data)
+	if err := node.deserialize(data); err != nil {
+		return nil, err
+	}
 	node.childNodes = make([]*MerkleNode, len(node.Children))
+	node.dirty = make([]bool, len(node.Children))
 	return node, nil
 }
 
@@ -37,7 +40,9 @@ func (bdb *BmtDB) PutNode(key common.Hash, node *MerkleNode) error {
 	if err != nil {
 		return err
 	}
-	err = bdb.db.Put([]byte(NodeKeyPrefix+key.String()), data)
+	dbKey := []byte(NodeKeyPrefix + key.String())
+	touchKey(bdb.db, dbKey)
+	err = bdb.db.Put(dbKey, data)
 	if err != nil {
 		log.Errorf("Failed to put node to BmtDB: %s", err)
 		return err
@@ -63,7 +68,9 @@ func (bdb *BmtDB) PutBucket(key common.Hash, bucket *Bucket) error {
 	if err != nil {
 		return nil
 	}
-	err = bdb.db.Put([]byte(BucketKeyPrefix+key.String()), data)
+	dbKey := []byte(BucketKeyPrefix + key.String())
+	touchKey(bdb.db, dbKey)
+	err = bdb.db.Put(dbKey, data)
 	if err != nil {
 		log.Errorf("Failed to put bucket to BmtDB: %s", err)
 		return err
@@ -76,11 +83,13 @@ func (bdb *BmtDB) GetHashTable(key common.Hash) (*HashTable, error) {
 	if err != nil {
 		return nil, err
 	}
-	ht := &HashTable{}
+	ht := &HashTable{db: bdb}
 	err = ht.deserialize(data)
 	if err != nil {
 		return nil, err
 	}
+	ht.buckets = make([]*Bucket, ht.Cap)
+	ht.dirty = make([]bool, ht.Cap)
 	return ht, nil
 }
 
@@ -89,5 +98,7 @@ func (bdb *BmtDB) PutHashTable(key common.Hash, ht *HashTable) error {
 	if err != nil {
 		return err
 	}
-	return bdb.db.Put([]byte(HashTableKeyPrefix+key.String()), data)
+	dbKey := []byte(HashTableKeyPrefix + key.String())
+	touchKey(bdb.db, dbKey)
+	return bdb.db.Put(dbKey, data)
 }
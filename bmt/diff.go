@@ -0,0 +1,202 @@
+package bmt
+
+import (
+	"tinychain/common"
+	"tinychain/db/leveldb"
+	"sort"
+	"bytes"
+)
+
+// DiffFunc is called with each different slot of two trees.
+// oldValue is nil if the slot is added, and newValue is nil if the slot is removed.
+// Return false to stop diffing.
+type DiffFunc func(key, oldValue, newValue []byte) bool
+
+// treeSide is one of the trees in diffing
+type treeSide struct {
+	db *BmtDB
+	ht *HashTable // nil if the tree is empty
+}
+
+func openSide(db *leveldb.LDBDatabase, root common.Hash) (*treeSide, error) {
+	side := &treeSide{db: NewBmtDB(db)}
+	if root.Nil() {
+		return side, nil
+	}
+	ht, err := side.db.GetHashTable(root)
+	if err != nil {
+		return nil, err
+	}
+	side.ht = ht
+	return side, nil
+}
+
+func (side *treeSide) node(hash common.Hash) (*MerkleNode, error) {
+	if hash.Nil() {
+		return nil, nil
+	}
+	return side.db.GetNode(hash)
+}
+
+func (side *treeSide) bucket(hash common.Hash) (map[string][]byte, error) {
+	if hash.Nil() {
+		return nil, nil
+	}
+	bucket, err := side.db.GetBucket(hash)
+	if err != nil {
+		return nil, err
+	}
+	return bucket.Slots, nil
+}
+
+// Load all slots of the tree
+func (side *treeSide) slots() (map[string][]byte, error) {
+	slots := make(map[string][]byte)
+	if side.ht == nil {
+		return slots, nil
+	}
+	for _, hash := range side.ht.BucketHash {
+		bucket, err := side.bucket(hash)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range bucket {
+			slots[k] = v
+		}
+	}
+	return slots, nil
+}
+
+// DiffTrees walks tree of rootA in dbA and tree of rootB in dbB, and reports
+// different slots to fn in the order of bucket index and key.
+// Subtrees with the same merkle node hash are skipped. If the trees are in
+// different shapes, all slots are compared.
+func DiffTrees(dbA *leveldb.LDBDatabase, rootA common.Hash, dbB *leveldb.LDBDatabase, rootB common.Hash, fn DiffFunc) error {
+	if rootA == rootB {
+		return nil
+	}
+	a, err := openSide(dbA, rootA)
+	if err != nil {
+		return err
+	}
+	b, err := openSide(dbB, rootB)
+	if err != nil {
+		return err
+	}
+
+	// Walk merkle nodes of the trees in the same shape
+	if a.ht == nil || b.ht == nil || a.ht.Cap != b.ht.Cap {
+		return diffAll(a, b, fn)
+	}
+	nodeA, err := a.node(rootA)
+	if err != nil {
+		return err
+	}
+	nodeB, err := b.node(rootB)
+	if err != nil {
+		return err
+	}
+	if len(nodeA.Children) != len(nodeB.Children) {
+		return diffAll(a, b, fn)
+	}
+	d := &differ{
+		a:      a,
+		b:      b,
+		aggre:  len(nodeA.Children),
+		llevel: lowestLevel(a.ht.Cap, len(nodeA.Children)),
+		fn:     fn,
+	}
+	_, err = d.diffNode(0, 0, rootA, rootB)
+	return err
+}
+
+type differ struct {
+	a, b   *treeSide
+	aggre  int
+	llevel int
+	fn     DiffFunc
+}
+
+// diffNode diffs the subtrees of node at (level, index). Returns false if stopped.
+func (d *differ) diffNode(level, index int, hashA, hashB common.Hash) (bool, error) {
+	if hashA == hashB {
+		return true, nil
+	}
+	if level == d.llevel {
+		// Hash of leaf node is the hash of bucket
+		if index >= d.a.ht.Cap {
+			return true, nil
+		}
+		return d.diffBucket(d.a.ht.BucketHash[index], d.b.ht.BucketHash[index])
+	}
+	nodeA, err := d.a.node(hashA)
+	if err != nil {
+		return false, err
+	}
+	nodeB, err := d.b.node(hashB)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < d.aggre; i++ {
+		var childA, childB common.Hash
+		if nodeA != nil {
+			childA = nodeA.Children[i]
+		}
+		if nodeB != nil {
+			childB = nodeB.Children[i]
+		}
+		if ok, err := d.diffNode(level+1, index*d.aggre+i, childA, childB); !ok || err != nil {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+func (d *differ) diffBucket(hashA, hashB common.Hash) (bool, error) {
+	slotsA, err := d.a.bucket(hashA)
+	if err != nil {
+		return false, err
+	}
+	slotsB, err := d.b.bucket(hashB)
+	if err != nil {
+		return false, err
+	}
+	return diffSlots(slotsA, slotsB, d.fn), nil
+}
+
+func diffAll(a, b *treeSide, fn DiffFunc) error {
+	slotsA, err := a.slots()
+	if err != nil {
+		return err
+	}
+	slotsB, err := b.slots()
+	if err != nil {
+		return err
+	}
+	diffSlots(slotsA, slotsB, fn)
+	return nil
+}
+
+// diffSlots reports different slots sorted by key. Returns false if stopped.
+func diffSlots(slotsA, slotsB map[string][]byte, fn DiffFunc) bool {
+	var keys []string
+	for k := range slotsA {
+		keys = append(keys, k)
+	}
+	for k := range slotsB {
+		if _, ok := slotsA[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		va, vb := slotsA[k], slotsB[k]
+		if bytes.Equal(va, vb) {
+			continue
+		}
+		if !fn([]byte(k), va, vb) {
+			return false
+		}
+	}
+	return true
+}
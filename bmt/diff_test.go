@@ -0,0 +1,64 @@
+package bmt
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"tinychain/db/leveldb"
+	"tinychain/common"
+	"strconv"
+	"os"
+)
+
+type diffEntry struct {
+	key, oldValue, newValue string
+}
+
+func collectDiff(t *testing.T, dbA *leveldb.LDBDatabase, rootA common.Hash, dbB *leveldb.LDBDatabase, rootB common.Hash) []diffEntry {
+	var diffs []diffEntry
+	err := DiffTrees(dbA, rootA, dbB, rootB, func(key, oldValue, newValue []byte) bool {
+		diffs = append(diffs, diffEntry{string(key), string(oldValue), string(newValue)})
+		return true
+	})
+	assert.Nil(t, err)
+	return diffs
+}
+
+func TestDiffTrees(t *testing.T) {
+	ddb, _ := leveldb.NewLDBDataBase("bucket_tree_diff_test")
+	defer os.RemoveAll("bucket_tree_diff_test")
+	defer ddb.Close()
+
+	tree := NewBucketTree(ddb)
+	tree.Init(nil)
+	set := NewWriteSet()
+	for i := 0; i < 100; i++ {
+		set["key"+strconv.Itoa(i)] = []byte(strconv.Itoa(i))
+	}
+	tree.Prepare(set)
+	assert.Nil(t, tree.Commit())
+	oldRoot := tree.Hash()
+
+	set = NewWriteSet()
+	set["key1"] = []byte("changed")
+	set["key2"] = nil
+	set["added"] = []byte("new")
+	tree.Prepare(set)
+	assert.Nil(t, tree.Commit())
+	newRoot := tree.Hash()
+
+	expect := []diffEntry{
+		{"added", "", "new"},
+		{"key1", "1", "changed"},
+		{"key2", "2", ""},
+	}
+	diffs := collectDiff(t, ddb, oldRoot, ddb, newRoot)
+	assert.ElementsMatch(t, expect, diffs)
+	assert.Empty(t, collectDiff(t, ddb, newRoot, ddb, newRoot))
+	assert.Equal(t, 100, len(collectDiff(t, ddb, common.Hash{}, ddb, oldRoot)))
+
+	// Trees in different shapes
+	tree.Resize(64, 4)
+	assert.Nil(t, tree.Commit())
+	diffs = collectDiff(t, ddb, oldRoot, ddb, tree.Hash())
+	assert.Equal(t, expect, diffs)
+}
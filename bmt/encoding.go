@@ -0,0 +1,260 @@
+package bmt
+
+import (
+	"tinychain/common"
+	"encoding/binary"
+	"errors"
+	"sort"
+	json "github.com/json-iterator/go"
+)
+
+/*
+	Binary storage encoding of bucket, hash table and merkle node.
+
+	Every encoded object starts with a 2-byte header: magic byte + version.
+	Variable-length fields are prefixed with uvarint length.
+
+	Bucket:     header | hash | uvarint(n) | n * (uvarint(len(key)) key uvarint(len(value)) value), sorted by key
+	HashTable:  header | uvarint(cap) | uvarint(scheme) | uvarint(n) | n * (uvarint(index) hash), non-empty bucket hashes only
+	MerkleNode: header | hash | uvarint(level) | uvarint(index) | uvarint(n) | n * hash
+
+	Objects written by older nodes are encoded in JSON, which is detected by
+	the leading '{' and still readable. Hash tables in JSON use HashLegacy scheme.
+*/
+
+const (
+	encodingMagic   = 0xb7
+	encodingVersion = 1
+
+	// Maximum capacity of hash table, which bounds the allocation of a decoded table
+	maxHashTableCap = 1 << 24
+)
+
+var (
+	ErrUnknownEncoding = errors.New("unknown bmt encoding")
+	ErrShortData       = errors.New("bmt data too short")
+	ErrInvalidCapacity = errors.New("invalid hash table capacity")
+)
+
+type encoder struct {
+	buf []byte
+}
+
+func newEncoder() *encoder {
+	return &encoder{buf: []byte{encodingMagic, encodingVersion}}
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) hash(h common.Hash) {
+	e.buf = append(e.buf, h[:]...)
+}
+
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrShortData
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	l := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < l {
+		d.err = ErrShortData
+		return nil
+	}
+	b := make([]byte, l)
+	copy(b, d.buf)
+	d.buf = d.buf[l:]
+	return b
+}
+
+func (d *decoder) hash() common.Hash {
+	if d.err != nil {
+		return common.Hash{}
+	}
+	if len(d.buf) < common.HashLength {
+		d.err = ErrShortData
+		return common.Hash{}
+	}
+	h := common.BytesToHash(d.buf[:common.HashLength])
+	d.buf = d.buf[common.HashLength:]
+	return h
+}
+
+// newDecoder checks the header of data.
+// Returns nil decoder if data is legacy json encoded.
+func newDecoder(data []byte) (*decoder, error) {
+	if len(data) > 0 && data[0] == '{' {
+		return nil, nil
+	}
+	if len(data) < 2 {
+		return nil, ErrShortData
+	}
+	if data[0] != encodingMagic || data[1] != encodingVersion {
+		return nil, ErrUnknownEncoding
+	}
+	return &decoder{buf: data[2:]}, nil
+}
+
+func encodeBucket(bk *Bucket) []byte {
+	keys := make([]string, 0, len(bk.Slots))
+	for k := range bk.Slots {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	e := newEncoder()
+	e.hash(bk.H)
+	e.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		e.bytes([]byte(k))
+		e.bytes(bk.Slots[k])
+	}
+	return e.buf
+}
+
+func decodeBucket(data []byte, bk *Bucket) error {
+	d, err := newDecoder(data)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return json.Unmarshal(data, bk)
+	}
+	bk.H = d.hash()
+	n := d.uvarint()
+	bk.Slots = make(map[string][]byte)
+	bk.Keys = nil
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		key := string(d.bytes())
+		val := d.bytes()
+		bk.Slots[key] = val
+		bk.Keys = append(bk.Keys, key)
+	}
+	return d.err
+}
+
+func encodeHashTable(ht *HashTable) []byte {
+	var indexes []int
+	for i, h := range ht.BucketHash {
+		if !h.Nil() {
+			indexes = append(indexes, i)
+		}
+	}
+
+	e := newEncoder()
+	e.uvarint(uint64(ht.Cap))
+	e.uvarint(uint64(ht.Scheme))
+	e.uvarint(uint64(len(indexes)))
+	for _, i := range indexes {
+		e.uvarint(uint64(i))
+		e.hash(ht.BucketHash[i])
+	}
+	return e.buf
+}
+
+func decodeHashTable(data []byte, ht *HashTable) error {
+	d, err := newDecoder(data)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		ht.Scheme = HashLegacy
+		if err := json.Unmarshal(data, ht); err != nil {
+			return err
+		}
+		if ht.Cap <= 0 || ht.Cap > maxHashTableCap || len(ht.BucketHash) != ht.Cap {
+			return ErrInvalidCapacity
+		}
+		return nil
+	}
+	capacity := d.uvarint()
+	if d.err != nil {
+		return d.err
+	}
+	if capacity == 0 || capacity > maxHashTableCap {
+		return ErrInvalidCapacity
+	}
+	ht.Cap = int(capacity)
+	scheme := d.uvarint()
+	if d.err == nil && scheme > uint64(HashLegacy) {
+		return ErrUnknownEncoding
+	}
+	ht.Scheme = HashScheme(scheme)
+	n := d.uvarint()
+	if d.err != nil {
+		return d.err
+	}
+	ht.BucketHash = make([]common.Hash, ht.Cap)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		index := d.uvarint()
+		hash := d.hash()
+		if index >= uint64(ht.Cap) {
+			return ErrUnknownEncoding
+		}
+		ht.BucketHash[index] = hash
+	}
+	return d.err
+}
+
+func encodeNode(node *MerkleNode) []byte {
+	e := newEncoder()
+	e.hash(node.H)
+	e.uvarint(uint64(node.Pos.Level))
+	e.uvarint(uint64(node.Pos.Index))
+	e.uvarint(uint64(len(node.Children)))
+	for _, h := range node.Children {
+		e.hash(h)
+	}
+	return e.buf
+}
+
+func decodeNode(data []byte, node *MerkleNode) error {
+	d, err := newDecoder(data)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return json.Unmarshal(data, node)
+	}
+	node.H = d.hash()
+	level := d.uvarint()
+	index := d.uvarint()
+	node.Pos = newPos(int(level), int(index))
+	n := d.uvarint()
+	if d.err != nil {
+		return d.err
+	}
+	if n > uint64(len(d.buf)/common.HashLength) {
+		return ErrShortData
+	}
+	node.Children = make([]common.Hash, 0, n)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		node.Children = append(node.Children, d.hash())
+	}
+	return d.err
+}
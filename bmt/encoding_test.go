@@ -0,0 +1,94 @@
+package bmt
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	json "github.com/json-iterator/go"
+	"tinychain/common"
+)
+
+func TestBucket_Encoding(t *testing.T) {
+	bucket := NewBucket()
+	bucket.Slots["b"] = []byte("value_b")
+	bucket.Slots["a"] = []byte("value_a")
+	bucket.Keys = []string{"b", "a"}
+	bucket.computHash(HashKeyed)
+
+	data, err := bucket.serialize()
+	assert.Nil(t, err)
+	decoded := &Bucket{}
+	err = decoded.deserialize(data)
+	assert.Nil(t, err)
+	assert.Equal(t, bucket.Hash(), decoded.Hash())
+	assert.Equal(t, []string{"a", "b"}, decoded.Keys)
+	assert.Equal(t, bucket.Slots, decoded.Slots)
+
+	// Read legacy json encoding
+	legacy, _ := json.Marshal(bucket)
+	decoded = &Bucket{}
+	err = decoded.deserialize(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, bucket.Slots, decoded.Slots)
+
+	err = decoded.deserialize(data[:len(data)-1])
+	assert.Equal(t, ErrShortData, err)
+	err = decoded.deserialize([]byte{encodingMagic, encodingVersion + 1})
+	assert.Equal(t, ErrUnknownEncoding, err)
+}
+
+func TestHashTable_Encoding(t *testing.T) {
+	ht := NewHashTable(nil, 16, HashLegacy)
+	ht.BucketHash[3] = common.Sha256([]byte("3"))
+	ht.BucketHash[15] = common.Sha256([]byte("15"))
+
+	data, err := ht.serialize()
+	assert.Nil(t, err)
+	decoded := &HashTable{}
+	err = decoded.deserialize(data)
+	assert.Nil(t, err)
+	assert.Equal(t, ht.Cap, decoded.Cap)
+	assert.Equal(t, ht.BucketHash, decoded.BucketHash)
+	assert.Equal(t, HashLegacy, decoded.Scheme)
+
+	// Unknown scheme
+	unknown := append([]byte{}, data...)
+	unknown[3] = byte(HashLegacy) + 1
+	err = (&HashTable{}).deserialize(unknown)
+	assert.Equal(t, ErrUnknownEncoding, err)
+
+	legacy, _ := json.Marshal(ht)
+	decoded = &HashTable{}
+	err = decoded.deserialize(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, ht.BucketHash, decoded.BucketHash)
+	assert.Equal(t, HashLegacy, decoded.Scheme)
+
+	// Capacity is checked before allocating
+	err = decoded.deserialize([]byte{encodingMagic, encodingVersion, 0, 0, 0})
+	assert.Equal(t, ErrInvalidCapacity, err)
+	err = decoded.deserialize([]byte{encodingMagic, encodingVersion, 0xff, 0xff, 0xff, 0xff, 0x0f, 0, 0})
+	assert.Equal(t, ErrInvalidCapacity, err)
+	err = decoded.deserialize([]byte(`{"cap":0,"bucket_hash":[]}`))
+	assert.Equal(t, ErrInvalidCapacity, err)
+}
+
+func TestMerkleNode_Encoding(t *testing.T) {
+	node := NewMerkleNode(nil, newPos(2, 3), 4)
+	node.Children[1] = common.Sha256([]byte("child"))
+	node.H = common.Sha256([]byte("node"))
+
+	data, err := node.serialize()
+	assert.Nil(t, err)
+	decoded := &MerkleNode{}
+	err = decoded.deserialize(data)
+	assert.Nil(t, err)
+	assert.Equal(t, node.H, decoded.H)
+	assert.Equal(t, *node.Pos, *decoded.Pos)
+	assert.Equal(t, node.Children, decoded.Children)
+
+	legacy, _ := json.Marshal(node)
+	decoded = &MerkleNode{}
+	err = decoded.deserialize(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, node.Children, decoded.Children)
+}
@@ -2,7 +2,6 @@ package bmt
 
 import (
 	"tinychain/common"
-	json "github.com/json-iterator/go"
 	"sync"
 )
 
@@ -61,18 +60,21 @@ func (node *MerkleNode) setHash(hash common.Hash) {
 }
 
 // When node is not leaf
-func (node *MerkleNode) computeHash() (common.Hash, error) {
+func (node *MerkleNode) computeHash(scheme HashScheme) (common.Hash, error) {
 	node.lock.Lock()
 	defer node.lock.Unlock()
 	if node.leaf {
 		return node.Hash(), nil
 	}
-	var bytes []byte
+	var (
+		bytes []byte
+		empty = true
+	)
 	for i, childHash := range node.Children {
 		var hash []byte
 		if node.dirty[i] {
 			child := node.childNodes[i]
-			h, err := child.computeHash()
+			h, err := child.computeHash(scheme)
 			if err != nil {
 				return common.Hash{}, err
 			}
@@ -81,8 +83,17 @@ func (node *MerkleNode) computeHash() (common.Hash, error) {
 		} else {
 			hash = childHash.Bytes()
 		}
+		if !common.BytesToHash(hash).Nil() {
+			empty = false
+		}
 		bytes = append(bytes, hash...)
 	}
+	// Node without any non-empty child is regarded as never created, except
+	// the root of HashLegacy scheme which is always hashed as older nodes did
+	if empty && (scheme != HashLegacy || node.Pos.Level > 0) {
+		node.H = common.Hash{}
+		return node.H, nil
+	}
 	node.H = common.Sha256(bytes)
 	return node.H, nil
 }
@@ -95,9 +106,9 @@ func (node *MerkleNode) store() error {
 }
 
 func (node *MerkleNode) serialize() ([]byte, error) {
-	return json.Marshal(node)
+	return encodeNode(node), nil
 }
 
 func (node *MerkleNode) deserialize(b []byte) error {
-	return json.Unmarshal(b, node)
+	return decodeNode(b, node)
 }
@@ -0,0 +1,128 @@
+package bmt
+
+import (
+	"tinychain/common"
+	"errors"
+	"bytes"
+	"sort"
+)
+
+var (
+	ErrInvalidProof = errors.New("invalid merkle proof")
+	ErrLegacyScheme = errors.New("proof is not supported by legacy hash scheme")
+)
+
+// Proof is the merkle inclusion proof of a key in bucket tree.
+// It contains the whole bucket the key located in, and sibling hashes
+// of each level from the lowest level up to the root.
+type Proof struct {
+	Capacity   int               `json:"capacity"`   // capacity of hash table
+	Aggreation int               `json:"aggreation"` // aggreation of merkle node
+	Index      int               `json:"index"`      // index of bucket in hash table
+	Slots      map[string][]byte `json:"slots"`      // bucket contents
+	Siblings   [][]common.Hash   `json:"siblings"`   // sibling hashes from leaf level to root
+}
+
+// GetProof generates the merkle proof of key.
+// The tree should be processed before, otherwise the node hashes are stale.
+func (bt *BucketTree) GetProof(key []byte) (*Proof, error) {
+	if bt.Scheme == HashLegacy {
+		return nil, ErrLegacyScheme
+	}
+	ht := bt.hashTable
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+
+	index := ht.getIndex(string(key))
+	bucket, err := ht.getBucket(index)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := bucket.Slots[string(key)]; !ok {
+		return nil, ErrKeyNotFound
+	}
+	proof := &Proof{
+		Capacity:   bt.Capacity,
+		Aggreation: bt.Aggreation,
+		Index:      int(index),
+		Slots:      make(map[string][]byte, len(bucket.Slots)),
+	}
+	for k, v := range bucket.Slots {
+		proof.Slots[k] = v
+	}
+
+	// Collect sibling hashes from lowest level to root
+	pos := newPos(bt.llevel, int(index))
+	for pos.Level > 0 {
+		parent, err := bt.getNode(pos.getParent(bt.Aggreation))
+		if err != nil {
+			return nil, err
+		}
+		offset := pos.Index % bt.Aggreation
+		var siblings []common.Hash
+		for i, hash := range parent.Children {
+			if i != offset {
+				siblings = append(siblings, hash)
+			}
+		}
+		proof.Siblings = append(proof.Siblings, siblings)
+		pos = pos.getParent(bt.Aggreation)
+	}
+	return proof, nil
+}
+
+// VerifyProof checks that key with value is included in the tree with given root.
+// It requires no db access.
+func VerifyProof(root common.Hash, key, value []byte, proof *Proof) error {
+	if proof == nil || proof.Capacity <= 0 || proof.Aggreation <= 1 {
+		return ErrInvalidProof
+	}
+	if int(bucketIndex(string(key), proof.Capacity)) != proof.Index {
+		return ErrInvalidProof
+	}
+	if val, ok := proof.Slots[string(key)]; !ok || !bytes.Equal(val, value) {
+		return ErrInvalidProof
+	}
+	if len(proof.Siblings) != lowestLevel(proof.Capacity, proof.Aggreation) {
+		return ErrInvalidProof
+	}
+
+	var keys []string
+	for k := range proof.Slots {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hash := hashSlots(keys, proof.Slots)
+
+	index := proof.Index
+	for _, siblings := range proof.Siblings {
+		if len(siblings) != proof.Aggreation-1 {
+			return ErrInvalidProof
+		}
+		offset := index % proof.Aggreation
+		var data []byte
+		for i, j := 0, 0; i < proof.Aggreation; i++ {
+			if i == offset {
+				data = append(data, hash.Bytes()...)
+			} else {
+				data = append(data, siblings[j].Bytes()...)
+				j++
+			}
+		}
+		hash = common.Sha256(data)
+		index /= proof.Aggreation
+	}
+	if hash != root {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// lowestLevel returns the level of leaf nodes with given capacity and aggreation
+func lowestLevel(capacity, aggre int) int {
+	level := 0
+	for pow(aggre, level) < capacity {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,67 @@
+package bmt
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+)
+
+func TestBucketTree_Proof(t *testing.T) {
+	tree := NewBucketTree(nil)
+	writeSet := NewWriteSet()
+	writeSet["test1"] = []byte("asdffsdf")
+	writeSet["abcd"] = []byte("test2asd")
+	writeSet["lslsl"] = []byte("test3f")
+	writeSet["werw"] = []byte("test12as")
+	writeSet["0"] = []byte("short key")
+	err := tree.Init(nil)
+	assert.Nil(t, err)
+	err = tree.Prepare(writeSet)
+	assert.Nil(t, err)
+	root, err := tree.Process()
+	assert.Nil(t, err)
+
+	for k, v := range writeSet {
+		proof, err := tree.GetProof([]byte(k))
+		assert.Nil(t, err)
+		assert.Nil(t, VerifyProof(root, []byte(k), v, proof))
+		assert.Equal(t, ErrInvalidProof, VerifyProof(root, []byte(k), []byte("fake"), proof))
+	}
+
+	_, err = tree.GetProof([]byte("notexist"))
+	assert.NotNil(t, err)
+}
+
+func TestVerifyProof_SwapKey(t *testing.T) {
+	tree := NewBucketTree(nil)
+	writeSet := NewWriteSet()
+	// Both keys locate in the same bucket
+	writeSet["aaaa1"] = []byte("value1")
+	writeSet["aaaa2"] = []byte("value2")
+	tree.Init(nil)
+	tree.Prepare(writeSet)
+	root, _ := tree.Process()
+
+	proof, err := tree.GetProof([]byte("aaaa1"))
+	assert.Nil(t, err)
+	proof.Slots["aaaa1"], proof.Slots["aaaa2"] = proof.Slots["aaaa2"], proof.Slots["aaaa1"]
+	assert.Equal(t, ErrInvalidProof, VerifyProof(root, []byte("aaaa1"), []byte("value2"), proof))
+}
+
+func TestHash_NoProof(t *testing.T) {
+	set := NewWriteSet()
+	for i := 0; i < 10; i++ {
+		set[strconv.Itoa(i)] = []byte(strconv.Itoa(i * i))
+	}
+	root, err := Hash(set)
+	assert.Nil(t, err)
+
+	// Roots of Hash are of legacy scheme, which proofs of new trees don't match
+	tree := NewBucketTree(nil)
+	tree.Init(nil)
+	tree.Prepare(set)
+	tree.Process()
+	proof, err := tree.GetProof([]byte("7"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInvalidProof, VerifyProof(root, []byte("7"), []byte("49"), proof))
+}
@@ -0,0 +1,212 @@
+package bmt
+
+import (
+	"tinychain/common"
+	"tinychain/db/leveldb"
+	"sync"
+	"errors"
+)
+
+var (
+	ErrPruning = errors.New("pruning is in progress")
+
+	// Registered pruners, *leveldb.LDBDatabase => *Pruner
+	pruners sync.Map
+)
+
+// Resolver returns the roots of sub trees referenced by a slot value,
+// e.g. storage root of an account in world state.
+type Resolver func(value []byte) []common.Hash
+
+// Pruner removes buckets, nodes and hash tables unreachable from retained roots
+// with mark-and-sweep.
+// Note that every bucket tree stored in the db is swept, so retained roots should
+// cover all trees in use.
+type Pruner struct {
+	db *leveldb.LDBDatabase
+
+	lock    sync.Mutex
+	running bool
+	touched map[string]struct{} // keys written since pruning prepared
+}
+
+func NewPruner(db *leveldb.LDBDatabase) *Pruner {
+	p := &Pruner{db: db}
+	pruners.Store(db, p)
+	return p
+}
+
+// Close unregisters the pruner
+func (p *Pruner) Close() {
+	pruners.Delete(p.db)
+}
+
+// Prepare starts tracking the writes of bucket trees, which are protected from sweeping.
+// It should be called before any commit that is not covered by the retained roots,
+// and Prune calls it if not prepared.
+func (p *Pruner) Prepare() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.running {
+		return ErrPruning
+	}
+	p.running = true
+	p.touched = make(map[string]struct{})
+	return nil
+}
+
+// touch marks key as written during pruning
+func (p *Pruner) touch(key []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.running {
+		p.touched[string(key)] = struct{}{}
+	}
+}
+
+func (p *Pruner) finish() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.running = false
+	p.touched = nil
+}
+
+// Prune keeps trees of given roots and removes the others from db.
+// Sub trees referenced by slot values are kept if resolve is not nil.
+// Returns the amount of deleted keys.
+func (p *Pruner) Prune(roots []common.Hash, resolve Resolver) (int, error) {
+	p.lock.Lock()
+	prepared := p.running
+	p.lock.Unlock()
+	if !prepared {
+		if err := p.Prepare(); err != nil {
+			return 0, err
+		}
+	}
+	defer p.finish()
+
+	// Iterators are created before marking, so keys written afterwards are invisible
+	prefixes := []string{NodeKeyPrefix, HashTableKeyPrefix, BucketKeyPrefix}
+	var iters []interface {
+		Next() bool
+		Key() []byte
+		Release()
+	}
+	for _, prefix := range prefixes {
+		iters = append(iters, p.db.NewIterator([]byte(prefix)))
+	}
+	defer func() {
+		for _, it := range iters {
+			it.Release()
+		}
+	}()
+
+	marked := make(map[string]struct{})
+	bdb := NewBmtDB(p.db)
+	for _, root := range roots {
+		if err := p.mark(bdb, root, resolve, marked); err != nil {
+			log.Errorf("Failed to mark tree %x, %s", root, err)
+			return 0, err
+		}
+	}
+
+	// Sweep unmarked keys
+	deleted := 0
+	for _, it := range iters {
+		for it.Next() {
+			key := it.Key()
+			if len(key) != 1+common.HashLength {
+				continue
+			}
+			if _, ok := marked[string(key)]; ok {
+				continue
+			}
+			p.lock.Lock()
+			_, ok := p.touched[string(key)]
+			if !ok {
+				if err := p.db.Delete(key); err != nil {
+					p.lock.Unlock()
+					return deleted, err
+				}
+				deleted++
+			}
+			p.lock.Unlock()
+		}
+	}
+	log.Infof("Pruned %d bucket tree objects, %d objects retained", deleted, len(marked))
+	return deleted, nil
+}
+
+// Mark all objects reachable from root
+func (p *Pruner) mark(bdb *BmtDB, root common.Hash, resolve Resolver, marked map[string]struct{}) error {
+	if root.Nil() {
+		return nil
+	}
+	htKey := HashTableKeyPrefix + root.String()
+	if _, ok := marked[htKey]; ok {
+		return nil
+	}
+	ht, err := bdb.GetHashTable(root)
+	if err != nil {
+		return err
+	}
+	marked[htKey] = struct{}{}
+
+	if err := p.markNode(bdb, root, marked); err != nil {
+		return err
+	}
+
+	for _, hash := range ht.BucketHash {
+		if hash.Nil() {
+			continue
+		}
+		bkKey := BucketKeyPrefix + hash.String()
+		if _, ok := marked[bkKey]; ok {
+			continue
+		}
+		marked[bkKey] = struct{}{}
+		if resolve == nil {
+			continue
+		}
+		bucket, err := bdb.GetBucket(hash)
+		if err != nil {
+			return err
+		}
+		for _, value := range bucket.Slots {
+			for _, sub := range resolve(value) {
+				if err := p.mark(bdb, sub, nil, marked); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Pruner) markNode(bdb *BmtDB, hash common.Hash, marked map[string]struct{}) error {
+	key := NodeKeyPrefix + hash.String()
+	if _, ok := marked[key]; ok {
+		return nil
+	}
+	node, err := bdb.GetNode(hash)
+	if err != nil {
+		return err
+	}
+	marked[key] = struct{}{}
+	for _, child := range node.Children {
+		if child.Nil() {
+			continue
+		}
+		if err := p.markNode(bdb, child, marked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchKey notifies the pruner of db that key is written
+func touchKey(db *leveldb.LDBDatabase, key []byte) {
+	if p, ok := pruners.Load(db); ok {
+		p.(*Pruner).touch(key)
+	}
+}
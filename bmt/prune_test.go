@@ -0,0 +1,88 @@
+package bmt
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"tinychain/db/leveldb"
+	"tinychain/common"
+	"os"
+)
+
+func TestPruner_Prune(t *testing.T) {
+	pdb, _ := leveldb.NewLDBDataBase("bucket_tree_prune_test")
+	defer os.RemoveAll("bucket_tree_prune_test")
+	defer pdb.Close()
+
+	// Sub tree referenced by value of main tree
+	sub := NewBucketTree(pdb)
+	sub.Init(nil)
+	subSet := NewWriteSet()
+	subSet["subkey"] = []byte("subvalue")
+	sub.Prepare(subSet)
+	assert.Nil(t, sub.Commit())
+	subRoot := sub.Hash()
+
+	tree := NewBucketTree(pdb)
+	tree.Init(nil)
+	set := NewWriteSet()
+	set["test1"] = []byte("asdffsdf")
+	set["root"] = subRoot.Bytes()
+	tree.Prepare(set)
+	assert.Nil(t, tree.Commit())
+	oldRoot := tree.Hash()
+
+	set = NewWriteSet()
+	set["test1"] = []byte("updated")
+	tree.Prepare(set)
+	assert.Nil(t, tree.Commit())
+	newRoot := tree.Hash()
+
+	pruner := NewPruner(pdb)
+	defer pruner.Close()
+	resolve := func(value []byte) []common.Hash {
+		if len(value) == common.HashLength {
+			return []common.Hash{common.BytesToHash(value)}
+		}
+		return nil
+	}
+	deleted, err := pruner.Prune([]common.Hash{newRoot}, resolve)
+	assert.Nil(t, err)
+	assert.NotZero(t, deleted)
+
+	loaded := NewBucketTree(pdb)
+	assert.Nil(t, loaded.Init(newRoot.Bytes()))
+	val, err := loaded.Get([]byte("test1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("updated"), val)
+
+	loadedSub := NewBucketTree(pdb)
+	assert.Nil(t, loadedSub.Init(subRoot.Bytes()))
+	val, err = loadedSub.Get([]byte("subkey"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("subvalue"), val)
+
+	assert.NotNil(t, NewBucketTree(pdb).Init(oldRoot.Bytes()))
+}
+
+func TestPruner_KeepTouched(t *testing.T) {
+	pdb, _ := leveldb.NewLDBDataBase("bucket_tree_touch_test")
+	defer os.RemoveAll("bucket_tree_touch_test")
+	defer pdb.Close()
+
+	pruner := NewPruner(pdb)
+	defer pruner.Close()
+	assert.Nil(t, pruner.Prepare())
+	assert.Equal(t, ErrPruning, pruner.Prepare())
+
+	// Commit during pruning should be protected
+	tree := NewBucketTree(pdb)
+	tree.Init(nil)
+	set := NewWriteSet()
+	set["test1"] = []byte("asdffsdf")
+	tree.Prepare(set)
+	assert.Nil(t, tree.Commit())
+
+	_, err := pruner.Prune(nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, NewBucketTree(pdb).Init(tree.Hash().Bytes()))
+}
@@ -9,8 +9,9 @@ import (
 )
 
 var (
-	log          = common.GetLogger("bucket_tree")
-	ErrDbNotOpen = errors.New("db not open")
+	log            = common.GetLogger("bucket_tree")
+	ErrDbNotOpen   = errors.New("db not open")
+	ErrKeyNotFound = errors.New("value not found")
 )
 
 const (
@@ -19,16 +20,23 @@ const (
 )
 
 // Write set for tree prepare
+// A key with empty value means deleting the key from tree
 type WriteSet map[string][]byte
 
 func NewWriteSet() WriteSet {
 	return make(WriteSet)
 }
 
+// Delete marks key to be removed from tree
+func (ws WriteSet) Delete(key string) {
+	ws[key] = nil
+}
+
 type BucketTree struct {
 	db         *BmtDB
 	Capacity   int
 	Aggreation int
+	Scheme     HashScheme
 	llevel     int        // the loweset level of tree
 	node       sync.Map   // map[Position]*MerkleNode
 	hashTable  *HashTable // dirty data hash table
@@ -37,24 +45,80 @@ type BucketTree struct {
 
 func NewBucketTree(db *leveldb.LDBDatabase) *BucketTree {
 	// v1.0
+	return NewBucketTreeWithConfig(db, DefaultConfig())
+}
+
+// NewBucketTreeWithConfig creates a bucket tree with specific capacity, aggreation
+// and hash scheme. The config only takes effect for a new tree, a tree loaded
+// from db by Init keeps the shape and hash scheme it was committed with.
+func NewBucketTreeWithConfig(db *leveldb.LDBDatabase, config *Config) *BucketTree {
+	if config.validate() != nil {
+		config = DefaultConfig()
+	}
 	return &BucketTree{
-		Capacity:   defaultHashTableCap,
-		Aggreation: defaultAggreation,
+		Capacity:   config.Capacity,
+		Aggreation: config.Aggreation,
+		Scheme:     config.Scheme,
 		db:         NewBmtDB(db),
 	}
 }
 
+// Config returns the shape and hash scheme of tree
+func (bt *BucketTree) Config() *Config {
+	return &Config{
+		Capacity:   bt.Capacity,
+		Aggreation: bt.Aggreation,
+		Scheme:     bt.Scheme,
+	}
+}
+
 func (bt *BucketTree) Hash() common.Hash {
 	node, _ := bt.getNode(newPos(0, 0))
 	return node.Hash()
 }
 
+// getNode returns the node at pos. Nodes on the path from root are loaded
+// from db or created on demand, so that a large tree is cheap to open and copy.
 func (bt *BucketTree) getNode(pos *Position) (*MerkleNode, error) {
-	node, ok := bt.node.Load(*pos)
-	if !ok {
+	if node, ok := bt.node.Load(*pos); ok {
+		return node.(*MerkleNode), nil
+	}
+	if pos.Level == 0 || pos.Level > bt.llevel || pos.Index >= pow(bt.Aggreation, pos.Level) {
 		return nil, errors.New("node not found")
 	}
-	return node.(*MerkleNode), nil
+	parent, err := bt.getNode(pos.getParent(bt.Aggreation))
+	if err != nil {
+		return nil, err
+	}
+	return bt.loadChild(parent, pos.Index%bt.Aggreation), nil
+}
+
+// loadChild returns the child of node at offset, and loads it from db if not cached
+func (bt *BucketTree) loadChild(node *MerkleNode, offset int) *MerkleNode {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if child := node.childNodes[offset]; child != nil {
+		return child
+	}
+	var (
+		level = node.Pos.Level + 1
+		pos   = newPos(level, node.Pos.Index*bt.Aggreation+offset)
+		child *MerkleNode
+		err   error
+	)
+	if hash := node.Children[offset]; !hash.Nil() {
+		child, err = bt.db.GetNode(hash)
+		if err != nil {
+			log.Errorf("cannot find node by hash, fatal error")
+		}
+	}
+	if child == nil {
+		child = NewMerkleNode(bt.db, pos, bt.Aggreation)
+	}
+	child.leaf = level == bt.llevel
+	node.childNodes[offset] = child
+	bt.putNode(pos, child)
+	return child
 }
 
 func (bt *BucketTree) putNode(pos *Position, node *MerkleNode) {
@@ -76,7 +140,7 @@ func (bt *BucketTree) Init(rootHash []byte) error {
 		root = NewMerkleNode(bt.db, newPos(0, 0), bt.Aggreation)
 
 		// Create a new hash table
-		bt.hashTable = NewHashTable(bt.db, bt.Capacity)
+		bt.hashTable = NewHashTable(bt.db, bt.Capacity, bt.Scheme)
 	} else {
 		if bt.db == nil {
 			return ErrDbNotOpen
@@ -93,46 +157,59 @@ func (bt *BucketTree) Init(rootHash []byte) error {
 			log.Errorf("Failed to get hash table from db:%s", err)
 			return err
 		}
+		// Tree shape is decided by the committed tree
+		bt.Capacity = bt.hashTable.Cap
+		bt.Aggreation = len(root.Children)
+		bt.Scheme = bt.hashTable.Scheme
 	}
-	bt.walkCreateNode(root, 0)
+	bt.setRoot(root)
 	return nil
 }
 
-// Recursive create children node
-// {num} the amount of nodes at current level
-func (bt *BucketTree) walkCreateNode(curr *MerkleNode, level int) *MerkleNode {
-	// Put node to global map of tree
-	bt.putNode(curr.Pos, curr)
-
-	num := pow(bt.Aggreation, level)
-	if num >= bt.Capacity {
-		// leaf node
-		if bt.llevel == 0 {
-			bt.llevel = level
-		}
-		curr.leaf = true
-	} else {
-		var err error
-		for i := 0; i < bt.Aggreation; i++ {
-			ind := curr.Pos.Index*bt.Aggreation + i
-			if hash := curr.Children[i]; !hash.Nil() {
-				curr.childNodes[i], err = bt.db.GetNode(hash)
-				if err != nil {
-					log.Errorf("cannot find node by hash, fatal error")
-					curr.childNodes[i] = NewMerkleNode(bt.db, newPos(level+1, ind), bt.Aggreation)
-				}
-			} else {
-				curr.childNodes[i] = NewMerkleNode(bt.db, newPos(level+1, ind), bt.Aggreation)
-			}
-			bt.walkCreateNode(curr.childNodes[i], level+1)
-		}
+// Resize migrates all slots to a new hash table with given capacity and aggreation,
+// and rebuilds merkle nodes upon it. The root after resizing is equal to that of
+// a new tree created with the same config and data, so it does not depend on
+// the resizing history. The hash scheme is kept.
+func (bt *BucketTree) Resize(capacity, aggre int) error {
+	config := &Config{capacity, aggre, bt.Scheme}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if capacity == bt.Capacity && aggre == bt.Aggreation {
+		return nil
+	}
+	slots, err := bt.hashTable.slots()
+	if err != nil {
+		log.Errorf("Failed to load slots when resizing: %s", err)
+		return err
 	}
-	return curr
+
+	bt.Capacity = capacity
+	bt.Aggreation = aggre
+	bt.node.Range(func(key, value interface{}) bool {
+		bt.node.Delete(key)
+		return true
+	})
+	bt.hashTable = NewHashTable(bt.db, capacity, bt.Scheme)
+	bt.setRoot(NewMerkleNode(bt.db, newPos(0, 0), aggre))
+	return bt.Prepare(slots)
+}
+
+// setRoot sets the root node, other nodes are loaded by getNode
+func (bt *BucketTree) setRoot(root *MerkleNode) {
+	bt.llevel = lowestLevel(bt.Capacity, bt.Aggreation)
+	root.leaf = bt.llevel == 0
+	bt.putNode(root.Pos, root)
 }
 
 func (bt *BucketTree) Prepare(dirty WriteSet) error {
 	for k, v := range dirty {
-		err := bt.hashTable.put(k, v)
+		var err error
+		if len(v) == 0 {
+			err = bt.hashTable.delete(k)
+		} else {
+			err = bt.hashTable.put(k, v)
+		}
 		if err != nil {
 			return err
 		}
@@ -153,7 +230,7 @@ func (bt *BucketTree) Process() (common.Hash, error) {
 	if err != nil {
 		return common.Hash{}, err
 	}
-	return root.computeHash()
+	return root.computeHash(bt.Scheme)
 }
 
 // Process dirty nodes
@@ -170,7 +247,7 @@ func (bt *BucketTree) processNodes() error {
 			return err
 		}
 		bucket := bt.hashTable.buckets[i]
-		bucket.computHash()
+		bucket.computHash(bt.Scheme)
 		leaf.setHash(bucket.Hash())
 
 		// Collect dirty node
@@ -241,15 +318,47 @@ func (bt *BucketTree) commitNode(node *MerkleNode) error {
 	return nil
 }
 
+// Copy returns a deep copy of tree, which can be modified independently
 func (bt *BucketTree) Copy() *BucketTree {
-	newTree := *bt
-	newTree.hashTable = bt.hashTable.copy()
-	return &newTree
+	newTree := &BucketTree{
+		db:         bt.db,
+		Capacity:   bt.Capacity,
+		Aggreation: bt.Aggreation,
+		Scheme:     bt.Scheme,
+		llevel:     bt.llevel,
+		dirty:      bt.dirty,
+	}
+	if bt.hashTable != nil {
+		newTree.hashTable = bt.hashTable.copy()
+	}
+	if root, err := bt.getNode(newPos(0, 0)); err == nil {
+		newTree.copyNode(root)
+	}
+	return newTree
 }
 
-func (bt *BucketTree) Verify(data []byte) {
-	// TODO verify data
-
+// Recursive copy node and its children
+func (bt *BucketTree) copyNode(node *MerkleNode) *MerkleNode {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	newNode := &MerkleNode{
+		db:         node.db,
+		H:          node.H,
+		Pos:        node.Pos.copy(),
+		Children:   make([]common.Hash, len(node.Children)),
+		childNodes: make([]*MerkleNode, len(node.childNodes)),
+		leaf:       node.leaf,
+		dirty:      make([]bool, len(node.dirty)),
+	}
+	copy(newNode.Children, node.Children)
+	copy(newNode.dirty, node.dirty)
+	for i, child := range node.childNodes {
+		if child != nil {
+			newNode.childNodes[i] = bt.copyNode(child)
+		}
+	}
+	bt.putNode(newNode.Pos, newNode)
+	return newNode
 }
 
 // Get data from hash table by key
@@ -257,6 +366,12 @@ func (bt *BucketTree) Get(key []byte) ([]byte, error) {
 	return bt.hashTable.get(string(key))
 }
 
+// ForEach iterates all slots in the order of bucket index and key,
+// until fn returns false. Buckets are loaded from db if not cached.
+func (bt *BucketTree) ForEach(fn func(key, value []byte) bool) error {
+	return bt.hashTable.forEach(fn)
+}
+
 func pow(a, m int) int {
 	if m == 0 {
 		return 1
@@ -264,8 +379,16 @@ func pow(a, m int) int {
 	return a * pow(a, m-1)
 }
 
+// legacyConfig is the config of trees built by Hash and Commit. Transaction
+// and receipt roots are in block headers, so they keep the HashLegacy scheme.
+func legacyConfig() *Config {
+	config := DefaultConfig()
+	config.Scheme = HashLegacy
+	return config
+}
+
 func Hash(set WriteSet) (common.Hash, error) {
-	tree := new(BucketTree)
+	tree := NewBucketTreeWithConfig(nil, legacyConfig())
 	tree.Init(nil)
 	tree.Prepare(set)
 	root, err := tree.Process()
@@ -276,7 +399,7 @@ func Hash(set WriteSet) (common.Hash, error) {
 }
 
 func Commit(set WriteSet, db *leveldb.LDBDatabase) error {
-	tree := NewBucketTree(db)
+	tree := NewBucketTreeWithConfig(db, legacyConfig())
 	tree.Init(nil)
 	tree.Prepare(set)
 	return tree.Commit()
@@ -81,3 +81,175 @@ func TestBucketTree_LoadFromDB(t *testing.T) {
 	assert.Equal(t, btree.llevel, newTree.llevel)
 	assert.Equal(t, len(btree.hashTable.BucketHash), len(newTree.hashTable.BucketHash))
 }
+
+func TestBucketTree_Resize(t *testing.T) {
+	writeSet := NewWriteSet()
+	writeSet["test1"] = []byte("asdffsdf")
+	writeSet["abcd"] = []byte("test2asd")
+	writeSet["lslsl"] = []byte("test3f")
+	writeSet["werw"] = []byte("test12as")
+
+	tree := NewBucketTree(nil)
+	tree.Init(nil)
+	tree.Prepare(writeSet)
+	tree.Process()
+	err := tree.Resize(64, 4)
+	assert.Nil(t, err)
+	resized, err := tree.Process()
+	assert.Nil(t, err)
+
+	newTree := NewBucketTreeWithConfig(nil, &Config{Capacity: 64, Aggreation: 4})
+	newTree.Init(nil)
+	newTree.Prepare(writeSet)
+	expect, err := newTree.Process()
+	assert.Nil(t, err)
+	assert.Equal(t, expect, resized)
+	assert.Equal(t, 3, tree.LowestLevel())
+
+	for k, v := range writeSet {
+		val, err := tree.Get([]byte(k))
+		assert.Nil(t, err)
+		assert.Equal(t, v, val)
+	}
+}
+
+func TestBucketTree_LoadConfigFromDB(t *testing.T) {
+	tree := NewBucketTreeWithConfig(db, &Config{Capacity: 16, Aggreation: 4})
+	tree.Init(nil)
+	set := NewWriteSet()
+	set["lowesyang"] = []byte("lowesyang")
+	tree.Prepare(set)
+	err := tree.Commit()
+	assert.Nil(t, err)
+
+	newTree := CreateBucketTree()
+	err = newTree.Init(tree.Hash().Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, 16, newTree.Capacity)
+	assert.Equal(t, 4, newTree.Aggreation)
+	val, err := newTree.Get([]byte("lowesyang"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("lowesyang"), val)
+}
+
+func TestBucketTree_Delete(t *testing.T) {
+	writeSet := NewWriteSet()
+	writeSet["test1"] = []byte("asdffsdf")
+	writeSet["abcd"] = []byte("test2asd")
+	writeSet["lslsl"] = []byte("test3f")
+
+	tree := NewBucketTree(nil)
+	tree.Init(nil)
+	tree.Prepare(writeSet)
+	tree.Process()
+
+	delSet := NewWriteSet()
+	delSet.Delete("lslsl")
+	delSet.Delete("notexist")
+	err := tree.Prepare(delSet)
+	assert.Nil(t, err)
+	root, err := tree.Process()
+	assert.Nil(t, err)
+	val, err := tree.Get([]byte("lslsl"))
+	assert.Nil(t, val)
+
+	delete(writeSet, "lslsl")
+	expectTree := NewBucketTree(nil)
+	expectTree.Init(nil)
+	expectTree.Prepare(writeSet)
+	expect, err := expectTree.Process()
+	assert.Nil(t, err)
+	assert.Equal(t, expect, root)
+
+	// Delete all keys
+	delSet = NewWriteSet()
+	delSet.Delete("test1")
+	delSet.Delete("abcd")
+	tree.Prepare(delSet)
+	root, err = tree.Process()
+	assert.Nil(t, err)
+	assert.True(t, root.Nil())
+}
+
+func TestBucketTree_LegacyScheme(t *testing.T) {
+	tree := NewBucketTreeWithConfig(db, &Config{Capacity: 4, Aggreation: 2, Scheme: HashLegacy})
+	tree.Init(nil)
+	set := NewWriteSet()
+	set["abcd"] = []byte("legacy")
+	tree.Prepare(set)
+	err := tree.Commit()
+	assert.Nil(t, err)
+
+	// Root computed as older nodes do, "abcd" is in bucket 0
+	var zero common.Hash
+	leaf := common.Sha256([]byte("legacy"))
+	left := common.Sha256(append(leaf.Bytes(), zero.Bytes()...))
+	assert.Equal(t, common.Sha256(append(left.Bytes(), zero.Bytes()...)), tree.Hash())
+
+	// Scheme is kept by committed tree
+	newTree := CreateBucketTree()
+	err = newTree.Init(tree.Hash().Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, HashLegacy, newTree.Scheme)
+	_, err = newTree.GetProof([]byte("abcd"))
+	assert.Equal(t, ErrLegacyScheme, err)
+}
+
+func TestHash_Legacy(t *testing.T) {
+	// Roots of transactions and receipts in block headers are not changed
+	empty := common.Sha256(make([]byte, 64))
+	root, err := Hash(NewWriteSet())
+	assert.Nil(t, err)
+	assert.Equal(t, empty, root)
+	assert.Equal(t, "0xf5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a92759fb4b", string(common.Hex(root[:])))
+
+	set := NewWriteSet()
+	set["key1"] = []byte("value1")
+	set["key2"] = []byte("value2")
+	root, err = Hash(set)
+	assert.Nil(t, err)
+	assert.Equal(t, "0x7b967cfc1610778dfc832f85599804d1b9f6aaf6061675109098e483e3c20586", string(common.Hex(root[:])))
+
+	// Deleting all keys restores the empty root
+	tree := NewBucketTreeWithConfig(nil, legacyConfig())
+	tree.Init(nil)
+	tree.Prepare(set)
+	_, err = tree.Process()
+	assert.Nil(t, err)
+	deletion := NewWriteSet()
+	deletion.Delete("key1")
+	deletion.Delete("key2")
+	tree.Prepare(deletion)
+	root, err = tree.Process()
+	assert.Nil(t, err)
+	assert.Equal(t, empty, root)
+}
+
+func TestBucketTree_LazyNodes(t *testing.T) {
+	tree := NewBucketTreeWithConfig(db, &Config{Capacity: 4096, Aggreation: 16})
+	tree.Init(nil)
+	set := NewWriteSet()
+	set["lowesyang"] = []byte("lowesyang")
+	tree.Prepare(set)
+	assert.Nil(t, tree.Commit())
+
+	count := func(tree *BucketTree) int {
+		n := 0
+		tree.node.Range(func(key, value interface{}) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	// Only nodes on the path of dirty bucket are created
+	assert.Equal(t, 4, count(tree))
+	newTree := NewBucketTree(db)
+	assert.Nil(t, newTree.Init(tree.Hash().Bytes()))
+	assert.Equal(t, 1, count(newTree))
+	val, err := newTree.Get([]byte("lowesyang"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("lowesyang"), val)
+	proof, err := newTree.GetProof([]byte("lowesyang"))
+	assert.Nil(t, err)
+	assert.Nil(t, VerifyProof(tree.Hash(), []byte("lowesyang"), val, proof))
+}
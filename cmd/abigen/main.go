@@ -0,0 +1,73 @@
+// Abigen generates Go bindings of a solidity contract from its json ABI and
+// bytecode, e.g.
+//
+//	abigen -abi token.abi -bin token.bin -type Token -pkg token -out token.go
+package main
+
+import (
+	"tinychain/abi/bind"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("abigen", flag.ExitOnError)
+	abiPath := fs.String("abi", "", "path of the contract json ABI, - for stdin")
+	binPath := fs.String("bin", "", "path of the contract hex bytecode, optional for bindings without deployment")
+	typ := fs.String("type", "", "Go type name of the contract, default is the file name of ABI")
+	pkg := fs.String("pkg", "", "Go package name of the bindings")
+	out := fs.String("out", "", "output file, default is stdout")
+	fs.Parse(args)
+
+	if *abiPath == "" || *pkg == "" {
+		fs.Usage()
+		return errors.New("abi and pkg are required")
+	}
+	var (
+		abiJSON []byte
+		err     error
+	)
+	if *abiPath == "-" {
+		abiJSON, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		abiJSON, err = ioutil.ReadFile(*abiPath)
+	}
+	if err != nil {
+		return err
+	}
+	var bytecode []byte
+	if *binPath != "" {
+		if bytecode, err = ioutil.ReadFile(*binPath); err != nil {
+			return err
+		}
+	}
+	name := *typ
+	if name == "" {
+		if *abiPath == "-" {
+			return errors.New("type is required for ABI from stdin")
+		}
+		name = strings.TrimSuffix(filepath.Base(*abiPath), filepath.Ext(*abiPath))
+	}
+
+	code, err := bind.Bind([]string{name}, []string{string(abiJSON)}, []string{string(bytecode)}, *pkg)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = fmt.Print(code)
+		return err
+	}
+	return ioutil.WriteFile(*out, []byte(code), 0644)
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "abigen: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/db/leveldb"
+)
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	path := fs.String("db", "tinychain", "path of node database")
+	path2 := fs.String("db2", "", "path of another node database holding state b, default is the same as -db")
+	rootA := fs.String("a", "", "state root a in 0x format")
+	rootB := fs.String("b", "", "state root b in 0x format")
+	fs.Parse(args)
+
+	if *rootA == "" || *rootB == "" {
+		return fmt.Errorf("state roots a and b are required")
+	}
+	dbA, err := leveldb.NewLDBDataBase(*path)
+	if err != nil {
+		return err
+	}
+	defer dbA.Close()
+	dbB := dbA
+	if *path2 != "" && *path2 != *path {
+		if dbB, err = leveldb.NewLDBDataBase(*path2); err != nil {
+			return err
+		}
+		defer dbB.Close()
+	}
+
+	diffs, err := state.DiffState(dbA, common.DecodeHash([]byte(*rootA)), dbB, common.DecodeHash([]byte(*rootB)))
+	if err != nil {
+		return err
+	}
+	for _, diff := range diffs {
+		fmt.Printf("%s %s\n", diff.Kind(), common.Hex(diff.Address[:]))
+		if diff.Old != nil && diff.New != nil {
+			if diff.Old.Balance.Cmp(diff.New.Balance) != 0 {
+				fmt.Printf("  balance: %s -> %s\n", diff.Old.Balance, diff.New.Balance)
+			}
+			if diff.Old.Nonce != diff.New.Nonce {
+				fmt.Printf("  nonce: %d -> %d\n", diff.Old.Nonce, diff.New.Nonce)
+			}
+			if diff.Old.CodeHash != diff.New.CodeHash {
+				fmt.Printf("  code hash: %s -> %s\n", common.Hex(diff.Old.CodeHash[:]), common.Hex(diff.New.CodeHash[:]))
+			}
+		}
+		for _, slot := range diff.Storage {
+			fmt.Printf("  storage %s: %s -> %s\n", common.Hex(slot.Key[:]), common.Hex(slot.Old[:]), common.Hex(slot.New[:]))
+		}
+	}
+	fmt.Printf("%d accounts differ\n", len(diffs))
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"tinychain/common"
+	"tinychain/core/asm"
+	"tinychain/core/state"
+	"tinychain/db"
+	"tinychain/db/leveldb"
+)
+
+func runDisasm(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	path := fs.String("db", "tinychain", "path of node database")
+	root := fs.String("root", "", "state root in 0x format, default is the state of last block")
+	addr := fs.String("addr", "", "contract address in 0x format")
+	codePath := fs.String("code", "", "file of hex bytecode to disassemble instead of a deployed contract")
+	summary := fs.Bool("summary", false, "print selectors and findings only")
+	fs.Parse(args)
+
+	var (
+		code []byte
+		err  error
+	)
+	switch {
+	case *codePath != "":
+		data, err := ioutil.ReadFile(*codePath)
+		if err != nil {
+			return err
+		}
+		if code, err = hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x")); err != nil {
+			return err
+		}
+	case *addr != "":
+		if code, err = deployedCode(*path, *root, common.DecodeAddr([]byte(*addr))); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("contract address or code file is required")
+	}
+
+	res := asm.Analyze(code)
+	if !*summary {
+		for _, b := range res.Blocks {
+			fmt.Printf("block %05x", b.Start)
+			for _, succ := range b.Succs {
+				fmt.Printf(" -> %05x", succ)
+			}
+			if b.Dynamic {
+				fmt.Print(" -> dynamic")
+			}
+			if !b.Reachable {
+				fmt.Print(" unreachable")
+			}
+			fmt.Println()
+			for _, ins := range b.Instructions {
+				fmt.Printf("  %s\n", ins)
+			}
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d bytes, %d instructions, %d blocks, %d jump destinations\n", len(code), len(res.Instructions), len(res.Blocks), len(res.JumpDests))
+	fmt.Printf("%d selectors\n", len(res.Selectors))
+	for _, sel := range res.Selectors {
+		fmt.Printf("  %s -> %05x\n", sel, sel.Entry)
+	}
+	fmt.Printf("%d findings\n", len(res.Findings))
+	for _, finding := range res.Findings {
+		fmt.Printf("  %s\n", finding)
+	}
+	return nil
+}
+
+// deployedCode returns the code of contract at the state root, or the state of
+// last block if root is empty
+func deployedCode(path, root string, addr common.Address) ([]byte, error) {
+	ldb, err := leveldb.NewLDBDataBase(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ldb.Close()
+
+	var stateRoot common.Hash
+	if root != "" {
+		stateRoot = common.DecodeHash([]byte(root))
+	} else {
+		block, err := db.NewTinyDB(ldb).GetLastBlock()
+		if err != nil {
+			return nil, err
+		}
+		stateRoot = block.Header.StateRoot
+	}
+	statedb, err := state.NewReadOnlyState(ldb, stateRoot)
+	if err != nil {
+		return nil, err
+	}
+	code := statedb.GetCode(addr)
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no code at %s", common.Hex(addr[:]))
+	}
+	return code, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/db"
+	"tinychain/db/leveldb"
+)
+
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	path := fs.String("db", "tinychain", "path of node database")
+	root := fs.String("root", "", "state root in 0x format, default is the state of last block")
+	format := fs.String("format", "json", "dump format, json or binary")
+	out := fs.String("out", "", "output file, default is stdout")
+	fs.Parse(args)
+
+	ldb, err := leveldb.NewLDBDataBase(*path)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+
+	var stateRoot common.Hash
+	if *root != "" {
+		stateRoot = common.DecodeHash([]byte(*root))
+	} else {
+		block, err := db.NewTinyDB(ldb).GetLastBlock()
+		if err != nil {
+			return err
+		}
+		stateRoot = block.Header.StateRoot
+	}
+	dump, err := state.DumpState(ldb, stateRoot)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch *format {
+	case "json":
+		if data, err = dump.EncodeJSON(); err != nil {
+			return err
+		}
+	case "binary":
+		data = dump.EncodeBinary()
+	default:
+		return fmt.Errorf("unknown format %s", *format)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Dumped %d accounts of state %s\n", len(dump.Accounts), common.Hex(stateRoot[:]))
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	path := fs.String("db", "tinychain", "path of node database")
+	format := fs.String("format", "json", "dump format, json or binary")
+	in := fs.String("in", "", "input dump file")
+	fs.Parse(args)
+
+	if *in == "" {
+		return fmt.Errorf("input file is required")
+	}
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+	var dump *state.Dump
+	switch *format {
+	case "json":
+		dump, err = state.DecodeDumpJSON(data)
+	case "binary":
+		dump, err = state.DecodeDumpBinary(data)
+	default:
+		return fmt.Errorf("unknown format %s", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	ldb, err := leveldb.NewLDBDataBase(*path)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+
+	root, err := state.ImportDump(ldb, dump)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d accounts, state root %s\n", len(dump.Accounts), common.Hex(root[:]))
+	return nil
+}
@@ -0,0 +1,51 @@
+// Statetool is the offline maintenance tool of tinychain state database.
+// The node should be stopped before running it.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]*command{
+	"prune":  {"prune historical state, keep recent and checkpoint roots", runPrune},
+	"dump":   {"dump accounts and storage of a state root in json or binary", runDump},
+	"import": {"rebuild state from a dump and verify the state root", runImport},
+	"diff":   {"report different accounts and storage slots of two state roots", runDiff},
+	"disasm": {"disassemble and analyze contract code for audit", runDisasm},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: statetool <command> [options]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/db"
+	"tinychain/db/leveldb"
+)
+
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	path := fs.String("db", "tinychain", "path of node database")
+	keep := fs.Int("keep", state.DefaultPrunerConfig().KeepRecent, "amount of recent state roots to keep")
+	checkpoints := fs.String("checkpoints", "", "comma separated state roots in 0x format to keep, besides those retained by node")
+	fs.Parse(args)
+
+	ldb, err := leveldb.NewLDBDataBase(*path)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+
+	roots, err := recentRoots(db.NewTinyDB(ldb), *keep)
+	if err != nil {
+		return err
+	}
+	roots = append(roots, state.Checkpoints(ldb)...)
+	for _, cp := range strings.Split(*checkpoints, ",") {
+		if cp = strings.TrimSpace(cp); cp != "" {
+			roots = append(roots, common.DecodeHash([]byte(cp)))
+		}
+	}
+	deleted, err := state.PruneState(ldb, roots)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %d objects, kept %d state roots\n", deleted, len(roots))
+	return nil
+}
+
+// recentRoots collects state roots of the latest {n} blocks
+func recentRoots(tdb *db.TinyDB, n int) ([]common.Hash, error) {
+	var roots []common.Hash
+	block, err := tdb.GetLastBlock()
+	if err != nil {
+		return nil, err
+	}
+	header := block.Header
+	for i := 0; i < n && header != nil; i++ {
+		roots = append(roots, header.StateRoot)
+		if header.Height.Sign() == 0 {
+			break
+		}
+		height, err := tdb.GetHeight(header.ParentHash)
+		if err != nil {
+			return nil, err
+		}
+		header, err = tdb.GetHeader(height, header.ParentHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return roots, nil
+}
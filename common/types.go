@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"crypto/sha256"
 	"encoding/binary"
+	"math/big"
 )
 
 const (
@@ -49,6 +50,17 @@ func (h Hash) Nil() bool {
 	return h == Hash{}
 }
 
+// BigToHash converts b to a 32 bytes big-endian word, which is right aligned
+func BigToHash(b *big.Int) Hash {
+	var h Hash
+	d := b.Bytes()
+	if len(d) > HashLength {
+		d = d[len(d)-HashLength:]
+	}
+	copy(h[HashLength-len(d):], d)
+	return h
+}
+
 func Sha256(d []byte) Hash {
 	return sha256.Sum256(d)
 }
@@ -31,3 +31,23 @@ func GenAddrByPrivkey(key crypto.PrivKey) (Address, error) {
 	pubkey := key.GetPublic()
 	return GenAddrByPubkey(pubkey)
 }
+
+// LeftPadBytes zero-pads slice to the left up to length l
+func LeftPadBytes(slice []byte, l int) []byte {
+	if l <= len(slice) {
+		return slice
+	}
+	padded := make([]byte, l)
+	copy(padded[l-len(slice):], slice)
+	return padded
+}
+
+// RightPadBytes zero-pads slice to the right up to length l
+func RightPadBytes(slice []byte, l int) []byte {
+	if l <= len(slice) {
+		return slice
+	}
+	padded := make([]byte, l)
+	copy(padded, slice)
+	return padded
+}
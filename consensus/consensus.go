@@ -4,12 +4,19 @@ import (
 	"tinychain/consensus/dpos"
 	"tinychain/core/types"
 	"tinychain/common"
+	"tinychain/core/vm"
 )
 
 type Engine interface {
 	Name() string
 	Start() error
 	Stop() error
+
+	// Producers returns the block producers elected at the state
+	Producers(statedb vm.StateDB) []common.Address
+
+	// IsProducer reports whether addr is a block producer at the state
+	IsProducer(statedb vm.StateDB, addr common.Address) bool
 }
 
 type TxPool interface {
@@ -20,6 +27,10 @@ type TxPool interface {
 	Pending() map[common.Address]types.Transactions
 }
 
-func New() Engine {
-	return dpos.NewDpos()
+// New creates the consensus engine, with the default config if config is nil
+func New(config *dpos.Config) Engine {
+	if config == nil {
+		config = dpos.DefaultConfig()
+	}
+	return dpos.NewDpos(config)
 }
@@ -1,10 +1,43 @@
 package dpos
 
+import (
+	"tinychain/common"
+	"tinychain/core/native"
+	"tinychain/core/vm"
+	"bytes"
+	"math/big"
+	"sort"
+)
+
+// Governance keys of config parameters, see native.Param
+var (
+	ParamBlockPeriod  = common.BytesToHash([]byte("blockPeriod"))
+	ParamMaxProducers = common.BytesToHash([]byte("maxProducers"))
+	ParamMinStake     = common.BytesToHash([]byte("minStake"))
+)
+
+// Config is the default consensus config, whose parameters are overridden
+// once they are set by the governance contract
+type Config struct {
+	BlockPeriod  uint64   // Seconds between blocks
+	MaxProducers int      // Maximum number of block producers
+	MinStake     *big.Int // Minimum votes of block producers
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		BlockPeriod:  3,
+		MaxProducers: 21,
+		MinStake:     big.NewInt(1),
+	}
+}
+
 type DposEngine struct {
+	config *Config
 }
 
-func NewDpos() *DposEngine {
-	return &DposEngine{}
+func NewDpos(config *Config) *DposEngine {
+	return &DposEngine{config: config}
 }
 
 func (dpos *DposEngine) Name() string {
@@ -18,3 +51,62 @@ func (dpos *DposEngine) Start() error {
 func (dpos *DposEngine) Stop() error {
 	return nil
 }
+
+// Config returns the config at the state, with parameters set by governance
+func (dpos *DposEngine) Config(statedb vm.StateDB) *Config {
+	config := *dpos.config
+	if period := native.Param(statedb, ParamBlockPeriod); period.Sign() > 0 {
+		config.BlockPeriod = period.Uint64()
+	}
+	if max := native.Param(statedb, ParamMaxProducers); max.Sign() > 0 && max.IsInt64() {
+		config.MaxProducers = int(max.Int64())
+	}
+	if stake := native.Param(statedb, ParamMinStake); stake.Sign() > 0 {
+		config.MinStake = stake
+	}
+	return &config
+}
+
+// Producers returns the block producers at the state, which are candidates
+// with the most votes of at least MinStake. Candidates of the same votes are
+// ordered by address.
+func (dpos *DposEngine) Producers(statedb vm.StateDB) []common.Address {
+	config := dpos.Config(statedb)
+	type candidate struct {
+		addr  common.Address
+		votes *big.Int
+	}
+	var candidates []candidate
+	for _, addr := range native.Candidates(statedb) {
+		if !native.IsCandidate(statedb, addr) {
+			continue
+		}
+		if votes := native.Votes(statedb, addr); votes.Cmp(config.MinStake) >= 0 {
+			candidates = append(candidates, candidate{addr, votes})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if c := candidates[i].votes.Cmp(candidates[j].votes); c != 0 {
+			return c > 0
+		}
+		return bytes.Compare(candidates[i].addr[:], candidates[j].addr[:]) < 0
+	})
+	if len(candidates) > config.MaxProducers {
+		candidates = candidates[:config.MaxProducers]
+	}
+	producers := make([]common.Address, len(candidates))
+	for i, c := range candidates {
+		producers[i] = c.addr
+	}
+	return producers
+}
+
+// IsProducer reports whether addr is a block producer at the state
+func (dpos *DposEngine) IsProducer(statedb vm.StateDB, addr common.Address) bool {
+	for _, producer := range dpos.Producers(statedb) {
+		if producer == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package dpos
+
+import (
+	"tinychain/common"
+	"tinychain/core/native"
+	"tinychain/core/state"
+	"tinychain/core/vm"
+	"tinychain/db/leveldb"
+	"math/big"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// call calls native contract at addr, transferring value before running
+func call(t *testing.T, statedb vm.StateDB, caller, addr common.Address, value int64, input []byte) {
+	ctx := &vm.NativeContext{
+		Context: vm.Context{
+			Transfer: func(db vm.StateDB, sender, recipient common.Address, amount *big.Int) {
+				db.SubBalance(sender, amount)
+				db.AddBalance(recipient, amount)
+			},
+		},
+		StateDB: statedb,
+		Caller:  caller,
+		Address: addr,
+		Value:   big.NewInt(value),
+		Gas:     1000000,
+	}
+	ctx.Transfer(statedb, caller, addr, ctx.Value)
+	_, err := vm.NativeContracts[addr].Run(ctx, input)
+	assert.Nil(t, err)
+}
+
+func newTestState(t *testing.T) *state.StateDB {
+	db, err := leveldb.NewMemDatabase()
+	assert.Nil(t, err)
+	return state.New(db, nil)
+}
+
+func TestDposEngine_Producers(t *testing.T) {
+	statedb := newTestState(t)
+	candidates := []common.Address{{1}, {2}, {3}}
+	for i, candidate := range candidates {
+		statedb.AddBalance(candidate, big.NewInt(1000))
+		call(t, statedb, candidate, native.StakingAddress, 0, native.PackInput("register()"))
+		call(t, statedb, candidate, native.StakingAddress, int64(100*(i+1)), native.PackInput("stake(address)", candidate))
+	}
+
+	config := DefaultConfig()
+	config.MaxProducers = 2
+	engine := NewDpos(config)
+	assert.Equal(t, []common.Address{{3}, {2}}, engine.Producers(statedb))
+	assert.True(t, engine.IsProducer(statedb, common.Address{3}))
+	assert.False(t, engine.IsProducer(statedb, common.Address{1}))
+
+	// Parameters set by governance override the config
+	call(t, statedb, candidates[1], native.GovernanceAddress, 0, native.PackInput("propose(bytes32,uint256)", ParamMinStake, uint64(300)))
+	for _, candidate := range candidates[1:] {
+		call(t, statedb, candidate, native.GovernanceAddress, 0, native.PackInput("approve(uint256)", uint64(0)))
+	}
+	assert.Equal(t, big.NewInt(300), engine.Config(statedb).MinStake)
+	assert.Equal(t, config.BlockPeriod, engine.Config(statedb).BlockPeriod)
+	assert.Equal(t, []common.Address{{3}}, engine.Producers(statedb))
+}
+
+func TestDposEngine_ProducersOrder(t *testing.T) {
+	statedb := newTestState(t)
+	// Candidate 4 is registered without votes
+	for _, candidate := range []common.Address{{2}, {1}, {3}, {4}} {
+		statedb.AddBalance(candidate, big.NewInt(1000))
+		call(t, statedb, candidate, native.StakingAddress, 0, native.PackInput("register()"))
+	}
+	call(t, statedb, common.Address{2}, native.StakingAddress, 100, native.PackInput("stake(address)", common.Address{2}))
+	call(t, statedb, common.Address{1}, native.StakingAddress, 100, native.PackInput("stake(address)", common.Address{1}))
+	call(t, statedb, common.Address{3}, native.StakingAddress, 200, native.PackInput("stake(address)", common.Address{3}))
+
+	// Candidates of the same votes are ordered by address, and candidates
+	// with less than MinStake are not elected
+	engine := NewDpos(DefaultConfig())
+	assert.Equal(t, []common.Address{{3}, {1}, {2}}, engine.Producers(statedb))
+	assert.False(t, engine.IsProducer(statedb, common.Address{4}))
+}
@@ -0,0 +1,426 @@
+package asm
+
+import (
+	"tinychain/core/vm"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Block is a basic block of code, which is entered only at its first
+// instruction and left only at its last one
+type Block struct {
+	Start        uint64 // PC of the first instruction
+	Instructions []*Instruction
+	Succs        []uint64 // Start of successor blocks
+	Dynamic      bool     // Ends with a jump whose destination can't be resolved statically
+	Reachable    bool
+}
+
+// End returns the PC of the last instruction
+func (b *Block) End() uint64 {
+	return b.Instructions[len(b.Instructions)-1].PC
+}
+
+func (b *Block) last() *Instruction {
+	return b.Instructions[len(b.Instructions)-1]
+}
+
+// Selector is a function selector matched by the dispatcher of a solidity contract
+type Selector struct {
+	ID    [4]byte
+	Entry uint64 // Jump destination of the function
+}
+
+func (s *Selector) String() string {
+	return fmt.Sprintf("0x%x", s.ID)
+}
+
+// FindingKind is the kind of dangerous pattern found in code
+type FindingKind int
+
+const (
+	FindingSelfdestruct      FindingKind = iota // Contract can be destroyed
+	FindingDelegateCallInput                    // DELEGATECALL or CALLCODE to address from call data
+	FindingInvalidJump                          // Static jump to a destination which is not JUMPDEST
+)
+
+func (k FindingKind) String() string {
+	switch k {
+	case FindingSelfdestruct:
+		return "selfdestruct"
+	case FindingDelegateCallInput:
+		return "delegatecall-input"
+	case FindingInvalidJump:
+		return "invalid-jump"
+	}
+	return fmt.Sprintf("unknown finding %d", int(k))
+}
+
+// Finding is a dangerous pattern at an instruction of reachable code
+type Finding struct {
+	Kind    FindingKind
+	PC      uint64
+	Op      vm.OpCode
+	Message string
+}
+
+func (f *Finding) String() string {
+	return fmt.Sprintf("%05x: %s %s: %s", f.PC, opName(f.Op), f.Kind, f.Message)
+}
+
+// Analysis is the result of static analysis of code
+type Analysis struct {
+	Instructions []*Instruction
+	Blocks       []*Block // Sorted by start
+	JumpDests    []uint64
+	Selectors    []*Selector
+	Findings     []*Finding // Sorted by PC
+}
+
+// Analyze disassembles code, splits it into basic blocks and resolves jump
+// targets, then extracts function selectors from the dispatcher and finds
+// dangerous patterns in reachable code.
+//
+// Jump targets and the origin of call addresses are tracked by abstract
+// interpretation of the stack along the control flow. Destinations of dynamic
+// jumps, e.g. returns of internal functions with several callers, can't be
+// resolved, in which case every JUMPDEST is assumed to be reachable.
+func Analyze(code []byte) *Analysis {
+	a := newAnalyzer(Disassemble(code))
+	a.flow(0, nil)
+	for {
+		a.propagate()
+		if !a.dynamic {
+			break
+		}
+		// Enter all jump destinations not reached yet with unknown stack
+		seeded := false
+		for _, dest := range a.jumpDests {
+			if _, ok := a.entries[dest]; !ok {
+				a.flow(dest, nil)
+				seeded = true
+			}
+		}
+		if !seeded {
+			break
+		}
+	}
+
+	res := &Analysis{
+		Instructions: a.instructions,
+		Blocks:       a.blocks,
+		JumpDests:    a.jumpDests,
+		Selectors:    a.selectors(),
+	}
+	for _, b := range a.blocks {
+		if entry, ok := a.entries[b.Start]; ok {
+			b.Reachable = true
+			a.exec(b, entry, &res.Findings)
+		}
+	}
+	sort.SliceStable(res.Findings, func(i, j int) bool {
+		return res.Findings[i].PC < res.Findings[j].PC
+	})
+	return res
+}
+
+// value is the abstract value of a stack item
+type value struct {
+	calldata bool   // Derived from call data
+	known    bool   // Constant pushed by code
+	num      uint64 // Constant if known
+}
+
+// stack is the abstract stack, whose items below the bottom are unknown
+type stack []value
+
+func (s *stack) push(v value) {
+	*s = append(*s, v)
+}
+
+func (s *stack) pop() value {
+	n := len(*s)
+	if n == 0 {
+		return value{}
+	}
+	v := (*s)[n-1]
+	*s = (*s)[:n-1]
+	return v
+}
+
+func (s stack) peek(n int) value {
+	if n >= len(s) {
+		return value{}
+	}
+	return s[len(s)-n-1]
+}
+
+// merge returns the stack of common top items of s and t. Items are derived
+// from call data if either is, and are constant only if both are the same.
+func merge(s, t stack) stack {
+	n := len(s)
+	if len(t) < n {
+		n = len(t)
+	}
+	merged := make(stack, n)
+	for i := 1; i <= n; i++ {
+		v, w := s[len(s)-i], t[len(t)-i]
+		merged[n-i] = value{calldata: v.calldata || w.calldata}
+		if v.known && w.known && v.num == w.num {
+			merged[n-i].known, merged[n-i].num = true, v.num
+		}
+	}
+	return merged
+}
+
+func equal(s, t stack) bool {
+	if len(s) != len(t) {
+		return false
+	}
+	for i := range s {
+		if s[i] != t[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type analyzer struct {
+	instructions []*Instruction
+	blocks       []*Block
+	byStart      map[uint64]*Block
+	jumpDests    []uint64
+	isJumpDest   map[uint64]bool
+
+	entries map[uint64]stack // Merged stack at entry of reached blocks
+	work    []uint64
+	dynamic bool // Any reached jump can't be resolved
+}
+
+func newAnalyzer(instructions []*Instruction) *analyzer {
+	a := &analyzer{
+		instructions: instructions,
+		byStart:      make(map[uint64]*Block),
+		isJumpDest:   make(map[uint64]bool),
+		entries:      make(map[uint64]stack),
+	}
+	var cur *Block
+	for _, ins := range instructions {
+		if ins.Op == vm.JUMPDEST {
+			a.jumpDests = append(a.jumpDests, ins.PC)
+			a.isJumpDest[ins.PC] = true
+			cur = nil
+		}
+		if cur == nil {
+			cur = &Block{Start: ins.PC}
+			a.blocks = append(a.blocks, cur)
+			a.byStart[ins.PC] = cur
+		}
+		cur.Instructions = append(cur.Instructions, ins)
+		if ins.Op == vm.JUMP || ins.Op == vm.JUMPI || halts(ins.Op) {
+			cur = nil
+		}
+	}
+	return a
+}
+
+// halts reports whether execution stops at op
+func halts(op vm.OpCode) bool {
+	switch op {
+	case vm.STOP, vm.RETURN, vm.REVERT, vm.SELFDESTRUCT:
+		return true
+	}
+	return !op.IsValid()
+}
+
+// flow merges the stack into the entry of block at start, and queues the
+// block if its entry changes
+func (a *analyzer) flow(start uint64, s stack) {
+	if a.byStart[start] == nil {
+		return
+	}
+	old, ok := a.entries[start]
+	if ok {
+		if s = merge(old, s); equal(old, s) {
+			return
+		}
+	}
+	a.entries[start] = s
+	a.work = append(a.work, start)
+}
+
+func (a *analyzer) propagate() {
+	for len(a.work) > 0 {
+		b := a.byStart[a.work[len(a.work)-1]]
+		a.work = a.work[:len(a.work)-1]
+		exit := a.exec(b, a.entries[b.Start], nil)
+		for _, succ := range b.Succs {
+			a.flow(succ, exit)
+		}
+	}
+}
+
+// exec interprets the block on the abstract stack, records its successors and
+// returns the stack at exit. Findings are reported if findings is not nil.
+func (a *analyzer) exec(b *Block, entry stack, findings *[]*Finding) stack {
+	s := append(stack{}, entry...)
+	report := func(ins *Instruction, kind FindingKind, format string, args ...interface{}) {
+		if findings != nil {
+			*findings = append(*findings, &Finding{Kind: kind, PC: ins.PC, Op: ins.Op, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+	for _, ins := range b.Instructions {
+		op := ins.Op
+		switch {
+		case op.IsPush():
+			v := value{}
+			if n := new(big.Int).SetBytes(ins.Arg); n.IsUint64() {
+				v = value{known: true, num: n.Uint64()}
+			}
+			s.push(v)
+		case op >= vm.DUP1 && op <= vm.DUP16:
+			s.push(s.peek(int(op - vm.DUP1)))
+		case op >= vm.SWAP1 && op <= vm.SWAP16:
+			n := int(op - vm.SWAP1 + 1)
+			for len(s) <= n {
+				s = append(stack{{}}, s...)
+			}
+			top := len(s) - 1
+			s[top], s[top-n] = s[top-n], s[top]
+		case op == vm.CALLDATALOAD:
+			s.pop()
+			s.push(value{calldata: true})
+		case op == vm.JUMP || op == vm.JUMPI:
+			dest := s.pop()
+			if op == vm.JUMPI {
+				s.pop()
+				a.addSucc(b, ins.PC+1)
+			}
+			switch {
+			case !dest.known:
+				b.Dynamic = true
+				a.dynamic = true
+			case !a.isJumpDest[dest.num]:
+				report(ins, FindingInvalidJump, "jump to invalid destination 0x%x", dest.num)
+			default:
+				a.addSucc(b, dest.num)
+			}
+		default:
+			if op == vm.DELEGATECALL || op == vm.CALLCODE {
+				if s.peek(1).calldata {
+					report(ins, FindingDelegateCallInput, "%s to address from call data runs arbitrary code in the contract context", opName(op))
+				}
+			} else if op == vm.SELFDESTRUCT {
+				if s.peek(0).calldata {
+					report(ins, FindingSelfdestruct, "contract can be destroyed, sending its balance to address from call data")
+				} else {
+					report(ins, FindingSelfdestruct, "contract can be destroyed")
+				}
+			}
+			pops, pushes := stackEffect(op)
+			fromCalldata := false
+			for i := 0; i < pops; i++ {
+				fromCalldata = s.pop().calldata || fromCalldata
+			}
+			// Only arithmetic, comparison and bitwise results keep the origin
+			v := value{calldata: fromCalldata && op > vm.STOP && op < vm.SHA3}
+			for i := 0; i < pushes; i++ {
+				s.push(v)
+			}
+		}
+	}
+	if last := b.last(); last.Op != vm.JUMP && last.Op != vm.JUMPI && !halts(last.Op) {
+		a.addSucc(b, last.PC+1+uint64(len(last.Arg)))
+	}
+	return s
+}
+
+func (a *analyzer) addSucc(b *Block, start uint64) {
+	if a.byStart[start] == nil {
+		return
+	}
+	for _, succ := range b.Succs {
+		if succ == start {
+			return
+		}
+	}
+	b.Succs = append(b.Succs, start)
+}
+
+// selectors matches the dispatcher pattern of solidity
+//
+//	PUSH4 selector (DUPn) EQ PUSHn dest JUMPI
+//
+// which jumps to the function if the selector equals to the one in call data
+func (a *analyzer) selectors() []*Selector {
+	var (
+		selectors []*Selector
+		seen      = make(map[[4]byte]bool)
+		code      = a.instructions
+	)
+	for i, ins := range code {
+		if ins.Op != vm.PUSH4 || len(ins.Arg) != 4 {
+			continue
+		}
+		j := i + 1
+		if j < len(code) && code[j].Op >= vm.DUP1 && code[j].Op <= vm.DUP16 {
+			j++
+		}
+		if j+2 >= len(code) || code[j].Op != vm.EQ || !code[j+1].Op.IsPush() || code[j+2].Op != vm.JUMPI {
+			continue
+		}
+		dest := new(big.Int).SetBytes(code[j+1].Arg)
+		if !dest.IsUint64() || !a.isJumpDest[dest.Uint64()] {
+			continue
+		}
+		var id [4]byte
+		copy(id[:], ins.Arg)
+		if !seen[id] {
+			seen[id] = true
+			selectors = append(selectors, &Selector{ID: id, Entry: dest.Uint64()})
+		}
+	}
+	return selectors
+}
+
+// stackEffect returns the number of items popped and pushed by op. DUP, SWAP
+// and jumps are interpreted separately.
+func stackEffect(op vm.OpCode) (int, int) {
+	switch {
+	case op.IsPush():
+		return 0, 1
+	case op >= vm.LOG0 && op <= vm.LOG4:
+		return int(op-vm.LOG0) + 2, 0
+	}
+	switch op {
+	case vm.ADDMOD, vm.MULMOD:
+		return 3, 1
+	case vm.ISZERO, vm.NOT, vm.BALANCE, vm.CALLDATALOAD, vm.EXTCODESIZE, vm.BLOCKHASH, vm.MLOAD, vm.SLOAD:
+		return 1, 1
+	case vm.ADDRESS, vm.ORIGIN, vm.CALLER, vm.CALLVALUE, vm.CALLDATASIZE, vm.CODESIZE, vm.GASPRICE, vm.RETURNDATASIZE,
+		vm.COINBASE, vm.TIMESTAMP, vm.NUMBER, vm.DIFFICULTY, vm.GASLIMIT, vm.PC, vm.MSIZE, vm.GAS:
+		return 0, 1
+	case vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY:
+		return 3, 0
+	case vm.EXTCODECOPY:
+		return 4, 0
+	case vm.POP, vm.JUMP, vm.SELFDESTRUCT:
+		return 1, 0
+	case vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMPI, vm.RETURN, vm.REVERT:
+		return 2, 0
+	case vm.CREATE:
+		return 3, 1
+	case vm.CALL, vm.CALLCODE:
+		return 7, 1
+	case vm.DELEGATECALL, vm.STATICCALL:
+		return 6, 1
+	case vm.STOP, vm.JUMPDEST:
+		return 0, 0
+	}
+	if op > vm.STOP && op <= vm.SHA3 {
+		// Binary arithmetic, comparison and bitwise operations
+		return 2, 1
+	}
+	return 0, 0
+}
@@ -0,0 +1,48 @@
+// Package asm disassembles and statically analyzes EVM bytecode, which is
+// used to audit contracts deployed on chain.
+package asm
+
+import (
+	"tinychain/core/vm"
+	"fmt"
+)
+
+// Instruction is an opcode of code with its immediate argument
+type Instruction struct {
+	PC  uint64
+	Op  vm.OpCode
+	Arg []byte // Immediate of PUSH, shorter than the push size if code ends early
+}
+
+func (ins *Instruction) String() string {
+	if ins.Arg != nil {
+		return fmt.Sprintf("%05x: %s 0x%x", ins.PC, opName(ins.Op), ins.Arg)
+	}
+	return fmt.Sprintf("%05x: %s", ins.PC, opName(ins.Op))
+}
+
+// Disassemble splits code into instructions. Undefined opcodes are kept and
+// printed as INVALID, e.g. the metadata appended to code by solc.
+func Disassemble(code []byte) []*Instruction {
+	var instructions []*Instruction
+	for pc := uint64(0); pc < uint64(len(code)); pc++ {
+		ins := &Instruction{PC: pc, Op: vm.OpCode(code[pc])}
+		if ins.Op.IsPush() {
+			end := pc + uint64(ins.Op-vm.PUSH1) + 2
+			if end > uint64(len(code)) {
+				end = uint64(len(code))
+			}
+			ins.Arg = code[pc+1 : end]
+			pc = end - 1
+		}
+		instructions = append(instructions, ins)
+	}
+	return instructions
+}
+
+func opName(op vm.OpCode) string {
+	if !op.IsValid() {
+		return fmt.Sprintf("INVALID(0x%02x)", byte(op))
+	}
+	return op.String()
+}
@@ -0,0 +1,91 @@
+package asm
+
+import (
+	"tinychain/core/vm"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// dispatcher calls functions a9059cbb and 12345678, the first delegates call
+// to address in call data and the second destroys the contract
+var dispatcher = []byte{
+	0x60, 0x00, 0x35, 0x60, 0xe0, 0x1c, // PUSH1 0 CALLDATALOAD PUSH1 0xe0 SHR
+	0x80, 0x63, 0xa9, 0x05, 0x9c, 0xbb, 0x14, 0x60, 0x1b, 0x57, // DUP1 PUSH4 0xa9059cbb EQ PUSH1 0x1b JUMPI
+	0x63, 0x12, 0x34, 0x56, 0x78, 0x81, 0x14, 0x60, 0x27, 0x57, // PUSH4 0x12345678 DUP2 EQ PUSH1 0x27 JUMPI
+	0x00,                               // STOP
+	0x5b, 0x60, 0x00, 0x80, 0x80, 0x80, // 1b: JUMPDEST PUSH1 0 DUP1 DUP1 DUP1
+	0x60, 0x04, 0x35, 0x5a, 0xf4, 0x00, // PUSH1 4 CALLDATALOAD GAS DELEGATECALL STOP
+	0x5b, 0x33, 0xff, // 27: JUMPDEST CALLER SELFDESTRUCT
+	0x60, 0x00, 0x56, // PUSH1 0 JUMP, unreachable
+	0xfe, 0xff, 0x61, 0x01, // INVALID and metadata
+}
+
+func TestDisassemble(t *testing.T) {
+	instructions := Disassemble(dispatcher)
+	assert.Equal(t, "00000: PUSH1 0x00", instructions[0].String())
+	assert.Equal(t, "00007: PUSH4 0xa9059cbb", instructions[5].String())
+	n := len(instructions)
+	assert.Equal(t, "0002d: INVALID(0xfe)", instructions[n-3].String())
+	// Push is truncated at the end of code
+	assert.Equal(t, &Instruction{PC: 0x2f, Op: vm.PUSH2, Arg: []byte{0x01}}, instructions[n-1])
+	assert.Equal(t, 0, len(Disassemble(nil)))
+}
+
+func TestAnalyze(t *testing.T) {
+	res := Analyze(dispatcher)
+	assert.Equal(t, []uint64{0x1b, 0x27}, res.JumpDests)
+
+	var starts []uint64
+	for _, b := range res.Blocks {
+		starts = append(starts, b.Start)
+	}
+	assert.Equal(t, []uint64{0, 0x10, 0x1a, 0x1b, 0x27, 0x2a, 0x2d, 0x2e, 0x2f}, starts)
+	assert.Equal(t, []uint64{0x10, 0x1b}, res.Blocks[0].Succs)
+	assert.Equal(t, []uint64{0x1a, 0x27}, res.Blocks[1].Succs)
+	assert.Equal(t, uint64(0x26), res.Blocks[3].End())
+	assert.True(t, res.Blocks[4].Reachable)
+	assert.False(t, res.Blocks[5].Reachable)
+	assert.False(t, res.Blocks[7].Reachable)
+
+	assert.Equal(t, 2, len(res.Selectors))
+	assert.Equal(t, "0xa9059cbb", res.Selectors[0].String())
+	assert.Equal(t, uint64(0x1b), res.Selectors[0].Entry)
+	assert.Equal(t, [4]byte{0x12, 0x34, 0x56, 0x78}, res.Selectors[1].ID)
+	assert.Equal(t, uint64(0x27), res.Selectors[1].Entry)
+
+	// Unreachable jump and metadata are not reported
+	assert.Equal(t, 2, len(res.Findings))
+	assert.Equal(t, FindingDelegateCallInput, res.Findings[0].Kind)
+	assert.Equal(t, uint64(0x25), res.Findings[0].PC)
+	assert.Equal(t, FindingSelfdestruct, res.Findings[1].Kind)
+	assert.Equal(t, "contract can be destroyed", res.Findings[1].Message)
+}
+
+func TestAnalyzeInternalCall(t *testing.T) {
+	code := []byte{
+		0x60, 0x08, 0x60, 0x04, 0x35, 0x60, 0x0a, 0x56, // PUSH1 ret PUSH1 4 CALLDATALOAD PUSH1 fn JUMP
+		0x5b, 0x00, // 08: JUMPDEST STOP
+		0x5b, 0x60, 0x00, 0x80, 0x80, 0x80, 0x84, // 0a: JUMPDEST PUSH1 0 DUP1 DUP1 DUP1 DUP5
+		0x5a, 0xf4, 0x50, 0x50, 0x56, // GAS DELEGATECALL POP POP JUMP
+	}
+	res := Analyze(code)
+	assert.Equal(t, []uint64{0x0a}, res.Blocks[0].Succs)
+	// Return address is resolved through the stack
+	assert.Equal(t, []uint64{0x08}, res.Blocks[2].Succs)
+	assert.False(t, res.Blocks[2].Dynamic)
+	assert.True(t, res.Blocks[1].Reachable)
+	assert.Equal(t, 1, len(res.Findings))
+	assert.Equal(t, FindingDelegateCallInput, res.Findings[0].Kind)
+	assert.Equal(t, uint64(0x12), res.Findings[0].PC)
+
+	// Dynamic jump makes all jump destinations reachable
+	res = Analyze([]byte{0x60, 0x00, 0x35, 0x56, 0x5b, 0x33, 0xff})
+	assert.True(t, res.Blocks[0].Dynamic)
+	assert.True(t, res.Blocks[1].Reachable)
+	assert.Equal(t, FindingSelfdestruct, res.Findings[0].Kind)
+
+	// Static jump to non JUMPDEST
+	res = Analyze([]byte{0x60, 0x03, 0x56, 0x00})
+	assert.Equal(t, FindingInvalidJump, res.Findings[0].Kind)
+	assert.Equal(t, "jump to invalid destination 0x3", res.Findings[0].Message)
+}
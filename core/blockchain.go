@@ -2,6 +2,8 @@ package core
 
 import (
 	"tinychain/db"
+	"tinychain/core/state"
+	"math/big"
 	"github.com/hashicorp/golang-lru"
 	"tinychain/core/types"
 	"tinychain/consensus"
@@ -20,6 +22,7 @@ type Blockchain struct {
 	db        *db.TinyDB       // chain db
 	lastBlock atomic.Value     // last block of chain
 	engine    consensus.Engine // consensus engine
+	config    *ChainConfig     // rules of blocks execution
 	// TODO more fields
 
 	dirtyBlk    sync.Map   // dirty block map
@@ -28,12 +31,16 @@ type Blockchain struct {
 	headerCache *lru.Cache // headers lru cache
 }
 
-func NewBlockchain(db *db.TinyDB, engine consensus.Engine) (*Blockchain, error) {
+func NewBlockchain(db *db.TinyDB, config *ChainConfig, engine consensus.Engine) (*Blockchain, error) {
+	if config == nil {
+		config = DefaultChainConfig()
+	}
 	blocksCache, _ := lru.New(cacheSize)
 	headerCache, _ := lru.New(cacheSize)
 	bc := &Blockchain{
 		db:          db,
 		engine:      engine,
+		config:      config,
 		blocksCache: blocksCache,
 		headerCache: headerCache,
 	}
@@ -81,6 +88,15 @@ func (bc *Blockchain) GetBlock(hash common.Hash) (*types.Block, error) {
 	return block, nil
 }
 
+// GetBlockByHeight returns the canonical block at height
+func (bc *Blockchain) GetBlockByHeight(height *big.Int) (*types.Block, error) {
+	hash, err := bc.db.GetHash(height)
+	if err != nil {
+		return nil, err
+	}
+	return bc.GetBlock(hash)
+}
+
 func (bc *Blockchain) GetHeader(hash common.Hash) (*types.Header, error) {
 	if header, ok := bc.headerCache.Get(hash); ok {
 		return header.(*types.Header), nil
@@ -97,6 +113,48 @@ func (bc *Blockchain) GetHeader(hash common.Hash) (*types.Header, error) {
 	return header, nil
 }
 
+// GetTxMeta returns the block hash, height and index of a committed transaction
+func (bc *Blockchain) GetTxMeta(txHash common.Hash) (*types.TxMeta, error) {
+	return bc.db.GetTxMeta(txHash)
+}
+
+// StateAt returns a new mutable state based on the state root of block.
+// Historical state is available only in archive mode, or within the
+// recent blocks retained by state pruner.
+func (bc *Blockchain) StateAt(hash common.Hash) (*state.StateDB, error) {
+	header, err := bc.GetHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	var root []byte
+	if !header.StateRoot.Nil() {
+		root = header.StateRoot.Bytes()
+	}
+	statedb := state.New(bc.db.LDB(), root)
+	if statedb == nil {
+		return nil, state.ErrStateNotFound
+	}
+	return statedb, nil
+}
+
+// ReadOnlyStateAt returns the read-only state of block for historical queries
+func (bc *Blockchain) ReadOnlyStateAt(hash common.Hash) (*state.ReadOnlyState, error) {
+	header, err := bc.GetHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	return state.NewReadOnlyState(bc.db.LDB(), header.StateRoot)
+}
+
+// ReadOnlyStateAtHeight returns the read-only state of the canonical block at height
+func (bc *Blockchain) ReadOnlyStateAtHeight(height *big.Int) (*state.ReadOnlyState, error) {
+	hash, err := bc.db.GetHash(height)
+	if err != nil {
+		return nil, err
+	}
+	return bc.ReadOnlyStateAt(hash)
+}
+
 func (bc *Blockchain) AddBlock(block *types.Block) error {
 
 }
@@ -109,3 +167,7 @@ func (bc *Blockchain) Commit(db *db.TinyDB) error {
 func (bc *Blockchain) Engine() consensus.Engine {
 	return bc.engine
 }
+
+func (bc *Blockchain) Config() *ChainConfig {
+	return bc.config
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/core/types"
+	"tinychain/core/vm"
+	"tinychain/core/wasm"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrStaticCall = errors.New("static call is only supported by evm contracts")
+)
+
+// CallMsg is a message call executed without creating a transaction
+type CallMsg struct {
+	From   common.Address
+	To     common.Address // nil means contract creation
+	Gas    uint64         // 0 means the block gas limit
+	Value  *big.Int
+	Data   []byte
+	Static bool // execute with StaticCall, which disallows state modification
+}
+
+// Call executes msg on a copy of the state at block blockRef, and returns
+// the output and gas used. Zero blockRef means the latest block.
+// Nothing is committed, so it is safe to call with any message.
+func (bc *Blockchain) Call(msg *CallMsg, blockRef common.Hash) ([]byte, uint64, error) {
+	header, statedb, err := bc.callState(blockRef)
+	if err != nil {
+		return nil, 0, err
+	}
+	gas := msg.Gas
+	if gas == 0 || gas > header.GasLimit {
+		gas = header.GasLimit
+	}
+	return bc.doCall(statedb, header, msg, gas)
+}
+
+// EstimateGas binary searches the minimal gas limit with which msg is
+// executed successfully at block blockRef. The upper bound is msg.Gas if set,
+// or the block gas limit. If msg fails with the upper bound, the error of
+// the execution is returned.
+func (bc *Blockchain) EstimateGas(msg *CallMsg, blockRef common.Hash) (uint64, error) {
+	header, statedb, err := bc.callState(blockRef)
+	if err != nil {
+		return 0, err
+	}
+	hi := header.GasLimit
+	if msg.Gas != 0 && msg.Gas < hi {
+		hi = msg.Gas
+	}
+	// Execute on a fresh copy of the state each time
+	executable := func(gas uint64) bool {
+		_, _, err := bc.doCall(statedb.Copy(), header, msg, gas)
+		return err == nil
+	}
+	_, gasUsed, err := bc.doCall(statedb.Copy(), header, msg, hi)
+	if err != nil {
+		return 0, err
+	}
+	// Gas used is needed at least, search above it
+	lo := uint64(0)
+	if gasUsed > 0 {
+		lo = gasUsed - 1
+	}
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		if executable(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, nil
+}
+
+// callState returns the header and state of block blockRef
+func (bc *Blockchain) callState(blockRef common.Hash) (*types.Header, *state.StateDB, error) {
+	var header *types.Header
+	if blockRef.Nil() {
+		header = bc.GetLastBlock().Header
+	} else {
+		var err error
+		if header, err = bc.GetHeader(blockRef); err != nil {
+			return nil, nil, err
+		}
+	}
+	statedb, err := bc.StateAt(header.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, statedb, nil
+}
+
+func (bc *Blockchain) doCall(statedb *state.StateDB, header *types.Header, msg *CallMsg, gas uint64) ([]byte, uint64, error) {
+	value := msg.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	tx := types.NewTransaction(statedb.GetNonce(msg.From), 0, gas, value, msg.Data, msg.From, msg.To)
+	context := NewEVMContext(tx, header, bc, nil)
+	evm := vm.NewEVM(context, statedb, bc.Config().EVMRules(), bc.Config().VMConfig(vm.Config{}))
+
+	if !statedb.Exist(msg.From) {
+		statedb.CreateAccount(msg.From)
+	}
+	caller := vm.AccountRef(msg.From)
+	var (
+		ret     []byte
+		leftGas uint64
+		err     error
+	)
+	// Contracts are routed to their runtime as in StateTransition.Process
+	if msg.To.Nil() {
+		ret, _, leftGas, err = runtimeOf(bc.Config(), evm, msg.Data).Create(caller, msg.Data, gas, value)
+		return ret, gas - leftGas, err
+	}
+	rt := runtimeOf(bc.Config(), evm, statedb.GetCode(msg.To))
+	if msg.Static {
+		if rt != wasm.Runtime(evm) {
+			return nil, 0, ErrStaticCall
+		}
+		ret, leftGas, err = evm.StaticCall(caller, msg.To, msg.Data, gas)
+	} else {
+		ret, leftGas, err = rt.Call(caller, msg.To, msg.Data, gas, value)
+	}
+	return ret, gas - leftGas, err
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"tinychain/common"
+	"tinychain/consensus"
+	"tinychain/core/jsvm"
+	"tinychain/core/state"
+	"tinychain/core/types"
+	"tinychain/core/vm"
+	"tinychain/core/wasm"
+	"tinychain/db"
+	"tinychain/db/leveldb"
+	"math/big"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	sender  = common.BytesToAddress([]byte("sender"))
+	evmAddr = common.BytesToAddress([]byte("evm"))
+	jsAddr  = common.BytesToAddress([]byte("js"))
+	// PUSH1 1 PUSH1 0 SSTORE STOP
+	storeCode = []byte{0x60, 0x01, 0x60, 0x00, 0x55, 0x00}
+	jsCode    = append(append([]byte{}, jsvm.CodePrefix...), "function get() { return 7; }"...)
+)
+
+// newTestChain creates a chain of genesis block, whose state holds an evm
+// contract and a js contract
+func newTestChain(t *testing.T) *Blockchain {
+	ldb, err := leveldb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb := state.New(ldb, nil)
+	statedb.SetBalance(sender, big.NewInt(1000000))
+	statedb.SetCode(evmAddr, storeCode)
+	statedb.SetCode(jsAddr, jsCode)
+	if err := statedb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	root, err := statedb.IntermediateRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := types.NewBlock(&types.Header{
+		Height:    new(big.Int),
+		StateRoot: root,
+		Time:      new(big.Int),
+		GasLimit:  1000000,
+	}, nil)
+
+	tdb := db.NewTinyDB(ldb)
+	assert.Nil(t, tdb.PutBlock(genesis))
+	assert.Nil(t, tdb.PutHeader(genesis.Header))
+	assert.Nil(t, tdb.PutHash(genesis.Height(), genesis.Hash()))
+	assert.Nil(t, tdb.PutHeight(genesis.Hash(), genesis.Height()))
+	assert.Nil(t, tdb.PutLastBlock(genesis))
+	bc, err := NewBlockchain(tdb, nil, consensus.New(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+func TestBlockchain_Call(t *testing.T) {
+	bc := newTestChain(t)
+
+	_, gasUsed, err := bc.Call(&CallMsg{From: sender, To: evmAddr}, common.Hash{})
+	assert.Nil(t, err)
+	assert.True(t, gasUsed > 20000)
+
+	// Nothing is committed
+	_, statedb, err := bc.callState(common.Hash{})
+	assert.Nil(t, err)
+	assert.Equal(t, common.Hash{}, statedb.GetState(evmAddr, common.Hash{}))
+
+	// Static call disallows state modification
+	_, _, err = bc.Call(&CallMsg{From: sender, To: evmAddr, Static: true}, common.Hash{})
+	assert.NotNil(t, err)
+
+	// Js contract is routed to jsvm
+	input, err := jsvm.PackInput("get")
+	assert.Nil(t, err)
+	ret, _, err := bc.Call(&CallMsg{From: sender, To: jsAddr, Data: input}, common.Hash{})
+	assert.Nil(t, err)
+	assert.Equal(t, "7", string(ret))
+	_, _, err = bc.Call(&CallMsg{From: sender, To: jsAddr, Data: input, Static: true}, common.Hash{})
+	assert.Equal(t, ErrStaticCall, err)
+}
+
+func TestBlockchain_EstimateGas(t *testing.T) {
+	bc := newTestChain(t)
+	msg := &CallMsg{From: sender, To: evmAddr}
+
+	gas, err := bc.EstimateGas(msg, common.Hash{})
+	assert.Nil(t, err)
+	_, gasUsed, err := bc.Call(msg, common.Hash{})
+	assert.Nil(t, err)
+	assert.True(t, gas >= gasUsed)
+
+	_, _, err = bc.Call(&CallMsg{From: sender, To: evmAddr, Gas: gas}, common.Hash{})
+	assert.Nil(t, err)
+	_, _, err = bc.Call(&CallMsg{From: sender, To: evmAddr, Gas: gas - 1}, common.Hash{})
+	assert.NotNil(t, err)
+
+	// Error of execution with the upper bound is returned
+	_, err = bc.EstimateGas(&CallMsg{From: sender, To: evmAddr, Gas: 100}, common.Hash{})
+	assert.Equal(t, vm.ErrOutOfGas, err)
+	input, err := jsvm.PackInput("set")
+	assert.Nil(t, err)
+	_, err = bc.EstimateGas(&CallMsg{From: sender, To: jsAddr, Data: input}, common.Hash{})
+	assert.Equal(t, jsvm.ErrMethodNotFound, err)
+}
+
+func TestBlockchain_CallMaxCodeSize(t *testing.T) {
+	bc := newTestChain(t)
+	bc.config.MaxCodeSize = len(jsCode) - 1
+
+	// Max code size of chain config applies to all runtimes
+	_, _, err := bc.Call(&CallMsg{From: sender, Data: jsCode}, common.Hash{})
+	assert.Equal(t, jsvm.ErrCodeSize, err)
+	wasmCode := append(append([]byte{}, wasm.Header...), make([]byte, len(jsCode))...)
+	_, _, err = bc.Call(&CallMsg{From: sender, Data: wasmCode}, common.Hash{})
+	assert.Equal(t, wasm.ErrCodeSize, err)
+}
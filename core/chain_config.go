@@ -0,0 +1,83 @@
+package core
+
+import (
+	"tinychain/core/jsvm"
+	"tinychain/core/vm"
+	"tinychain/core/wasm"
+	"math/big"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ChainConfig is the rules of blocks execution, which must be the same on all
+// nodes of the chain
+type ChainConfig struct {
+	EVM         *params.ChainConfig // EVM rules of forks, all of which are enabled from genesis if nil
+	MaxCodeSize int                 // Maximum size of contract code of all runtimes, enforced when created
+
+	// Block height from which state trees committed with another shape, e.g.
+	// by older nodes, are resized to the shape of state.StateTreeConfig and
+	// state.StorageTreeConfig. Nil means never, trees of a new chain already
+	// have the configured shape.
+	ResizeBlock *big.Int
+}
+
+func DefaultChainConfig() *ChainConfig {
+	return &ChainConfig{
+		EVM:         defaultEVMConfig(),
+		MaxCodeSize: params.MaxCodeSize,
+	}
+}
+
+// defaultEVMConfig enables EVM rules of all forks from genesis
+func defaultEVMConfig() *params.ChainConfig {
+	return &params.ChainConfig{
+		ChainId:             big.NewInt(1),
+		HomesteadBlock:      new(big.Int),
+		EIP150Block:         new(big.Int),
+		EIP155Block:         new(big.Int),
+		EIP158Block:         new(big.Int),
+		ByzantiumBlock:      new(big.Int),
+		ConstantinopleBlock: new(big.Int),
+	}
+}
+
+// EVMRules returns EVM rules of forks
+func (c *ChainConfig) EVMRules() *params.ChainConfig {
+	if c.EVM == nil {
+		return defaultEVMConfig()
+	}
+	return c.EVM
+}
+
+// IsResize returns whether state trees are resized at block height
+func (c *ChainConfig) IsResize(height *big.Int) bool {
+	return c.ResizeBlock != nil && height != nil && c.ResizeBlock.Cmp(height) <= 0
+}
+
+// JSVMConfig returns the jsvm config with chain rules applied
+func (c *ChainConfig) JSVMConfig() *jsvm.Config {
+	cfg := jsvm.DefaultConfig()
+	if c.MaxCodeSize > 0 {
+		cfg.MaxCodeSize = c.MaxCodeSize
+	}
+	return cfg
+}
+
+// WASMConfig returns the wasm config with chain rules applied, which are
+// applied to js contracts called by wasm contracts as well
+func (c *ChainConfig) WASMConfig() *wasm.Config {
+	cfg := wasm.DefaultConfig()
+	if c.MaxCodeSize > 0 {
+		cfg.MaxCodeSize = c.MaxCodeSize
+	}
+	cfg.JSVM = c.JSVMConfig()
+	return cfg
+}
+
+// VMConfig applies chain rules to the EVM config. EVM contracts can not create
+// code of jsvm and wasm, which must be validated by their runtimes.
+func (c *ChainConfig) VMConfig(cfg vm.Config) vm.Config {
+	cfg.MaxCodeSize = c.MaxCodeSize
+	cfg.ReservedCodePrefixes = [][]byte{jsvm.CodePrefix, wasm.Header}
+	return cfg
+}
@@ -0,0 +1,535 @@
+package jsvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"github.com/robertkrimen/otto"
+)
+
+/*
+	The prelude runs before the contract, and bounds the work of builtins:
+
+	- Builtins processing strings or arrays charge GasDataByte on every byte
+	  and element of their data, and throw RangeError if strings exceed
+	  MaxStringSize or arrays exceed MaxArraySize.
+	- Array join, String replace and JSON.stringify, whose output may be far
+	  larger than their input, are reimplemented on bounded buffers.
+	- eval and Function are disabled, since the code they run is not compiled.
+	- The helpers called by compiled contract code are defined.
+
+	Builtins it relies on are captured before the contract runs, so contracts
+	can not affect the checks by replacing them.
+*/
+
+// prelude is called with the global object, native helpers and limits
+const prelude = `(function(global, charge, buffer, encode, sortedKeys, substitute, maxString, maxArray) {
+	var FunctionPrototype = Function.prototype,
+		ArrayPrototype = Array.prototype,
+		StringPrototype = String.prototype,
+		uncurry = FunctionPrototype.bind.bind(FunctionPrototype.call),
+		apply = uncurry(FunctionPrototype.apply),
+		classOf = uncurry(Object.prototype.toString),
+		booleanValueOf = uncurry(Boolean.prototype.valueOf),
+		replace = StringPrototype.replace,
+		defineProperty = Object.defineProperty,
+		isArray = Array.isArray,
+		toObject = Object,
+		toString = String,
+		toNumber = Number,
+		RangeError = global.RangeError,
+		TypeError = global.TypeError,
+		EvalError = global.EvalError,
+		installed = [];
+
+	// install sets the builtin implemented by prelude
+	function install(object, name, fn) {
+		object[name] = fn;
+		installed[installed.length] = fn;
+	}
+
+	function sizeError() {
+		return new RangeError("data size exceeds limit");
+	}
+
+	// check charges the size of self and args, which must be within limits
+	function check(self, args) {
+		var strings = 0, elements = 0, i, value, length;
+		for (i = -1; i < args.length; i++) {
+			value = i < 0 ? self : args[i];
+			if (typeof value === "string") {
+				strings += value.length;
+			} else if (value !== null && typeof value === "object") {
+				length = value.length;
+				if (typeof length === "number" && length > 0) {
+					if (classOf(value) === "[object String]") {
+						strings += length;
+					} else {
+						elements += length;
+					}
+				}
+			}
+		}
+		if (strings > maxString || elements > maxArray) {
+			throw sizeError();
+		}
+		charge(strings + elements);
+	}
+
+	function meter(object, names) {
+		for (var i = 0; i < names.length; i++) {
+			(function(method) {
+				install(object, names[i], function() {
+					check(this, arguments);
+					var result = apply(method, this, arguments);
+					check(result, []);
+					return result;
+				});
+			})(object[names[i]]);
+		}
+	}
+
+	meter(ArrayPrototype, ["concat", "every", "filter", "forEach", "indexOf", "lastIndexOf", "map", "pop", "push",
+		"reduce", "reduceRight", "reverse", "shift", "slice", "some", "sort", "splice", "unshift"]);
+	meter(StringPrototype, ["concat", "indexOf", "lastIndexOf", "localeCompare", "match", "search", "slice", "split",
+		"substr", "substring", "toLocaleLowerCase", "toLocaleUpperCase", "toLowerCase", "toUpperCase", "trim"]);
+	meter(String, ["fromCharCode"]);
+	meter(RegExp.prototype, ["exec", "test"]);
+	meter(Object, ["defineProperties", "defineProperty", "getOwnPropertyNames", "keys"]);
+	meter(JSON, ["parse"]);
+	meter(FunctionPrototype, ["apply"]);
+
+	function join(separator) {
+		var object = toObject(this), length = object.length >>> 0, out = buffer(), i, value;
+		if (length > maxArray) {
+			throw sizeError();
+		}
+		separator = separator === undefined ? "," : toString(separator);
+		for (i = 0; i < length; i++) {
+			if (i > 0) {
+				out(separator);
+			}
+			value = object[i];
+			if (value !== undefined && value !== null) {
+				out(toString(value));
+			}
+		}
+		return out();
+	}
+	install(ArrayPrototype, "join", join);
+	install(ArrayPrototype, "toLocaleString", function() {
+		return apply(join, this, []);
+	});
+
+	install(StringPrototype, "replace", function(pattern, replacement) {
+		var string = toString(this), total = string.length, template;
+		if (total > maxString) {
+			throw sizeError();
+		}
+		charge(total);
+		if (typeof replacement !== "function") {
+			template = toString(replacement);
+		}
+		return apply(replace, string, [pattern, function() {
+			var part = template === undefined ? toString(apply(replacement, undefined, arguments)) :
+				substitute(template, arguments, maxString - total);
+			total += part.length;
+			if (total > maxString) {
+				throw sizeError();
+			}
+			charge(part.length);
+			return part;
+		}]);
+	});
+
+	// stringify writes json in the format of otto, whose object keys are sorted
+	install(JSON, "stringify", function(value, replacer, space) {
+		var out = buffer(), stack = [], indent = "", gap = "", properties, holder = {}, i, seen;
+		if (isArray(replacer)) {
+			if (replacer.length > maxArray) {
+				throw sizeError();
+			}
+			seen = {};
+			for (i = 0; i < replacer.length; i++) {
+				var item = replacer[i], kind = classOf(item);
+				if (typeof item === "string" || typeof item === "number" ||
+					kind === "[object String]" || kind === "[object Number]") {
+					seen[toString(item)] = true;
+				}
+			}
+			properties = sortedKeys(seen);
+			replacer = undefined;
+		} else if (typeof replacer !== "function") {
+			replacer = undefined;
+		}
+		if (space !== null && typeof space === "object") {
+			if (classOf(space) === "[object Number]") {
+				space = toNumber(space);
+			} else if (classOf(space) === "[object String]") {
+				space = toString(space);
+			}
+		}
+		if (typeof space === "number") {
+			for (i = 0; i < 10 && i + 1 <= space; i++) {
+				gap += " ";
+			}
+		} else if (typeof space === "string") {
+			gap = space.length > 10 ? space.substring(0, 10) : space;
+		}
+
+		function prepare(key, holder) {
+			var value = holder[key];
+			if (value !== null && typeof value === "object" && typeof value.toJSON === "function") {
+				value = apply(value.toJSON, value, [key]);
+			}
+			if (replacer) {
+				value = apply(replacer, holder, [key, value]);
+			}
+			if (value !== null && typeof value === "object") {
+				switch (classOf(value)) {
+				case "[object Boolean]":
+					value = booleanValueOf(value);
+					break;
+				case "[object String]":
+					value = toString(value);
+					break;
+				case "[object Number]":
+					value = toNumber(value);
+					break;
+				}
+			}
+			return value;
+		}
+
+		function newline() {
+			if (gap) {
+				out("\n");
+				out(indent);
+			}
+		}
+
+		function write(value) {
+			if (typeof value !== "object" || value === null) {
+				out(encode(value));
+				return;
+			}
+			for (var i = 0; i < stack.length; i++) {
+				if (stack[i] === value) {
+					throw new TypeError("Converting circular structure to JSON");
+				}
+			}
+			var outer = indent, keys, length, first = true, item;
+			stack[stack.length] = value;
+			indent = outer + gap;
+			if (isArray(value)) {
+				length = value.length >>> 0;
+				if (length > maxArray) {
+					throw sizeError();
+				}
+				out("[");
+				for (i = 0; i < length; i++) {
+					if (i > 0) {
+						out(",");
+					}
+					newline();
+					item = prepare(toString(i), value);
+					if (item === undefined || typeof item === "function") {
+						out("null");
+					} else {
+						write(item);
+					}
+				}
+				first = length === 0;
+				indent = outer;
+				if (!first) {
+					newline();
+				}
+				out("]");
+			} else {
+				keys = properties || sortedKeys(value);
+				out("{");
+				for (i = 0; i < keys.length; i++) {
+					item = prepare(keys[i], value);
+					if (item === undefined || typeof item === "function") {
+						continue;
+					}
+					if (!first) {
+						out(",");
+					}
+					first = false;
+					newline();
+					out(encode(keys[i]));
+					out(gap ? ": " : ":");
+					write(item);
+				}
+				indent = outer;
+				if (!first) {
+					newline();
+				}
+				out("}");
+			}
+			stack.length = stack.length - 1;
+		}
+
+		defineProperty(holder, "", {value: value, writable: true, enumerable: true, configurable: true});
+		value = prepare("", holder);
+		if (value === undefined || typeof value === "function") {
+			return undefined;
+		}
+		write(value);
+		return out();
+	});
+
+	function disabled() {
+		throw new EvalError("code generation from strings is disabled");
+	}
+	disabled.prototype = FunctionPrototype;
+	install(global, "eval", disabled);
+	install(global, "Function", disabled);
+	install(FunctionPrototype, "constructor", disabled);
+
+	function string(value) {
+		if (typeof value === "string") {
+			if (value.length > maxString) {
+				throw sizeError();
+			}
+			charge(value.length);
+		}
+		return value;
+	}
+
+	function operate(operator, left, right) {
+		switch (operator) {
+		case "+": return string(left + right);
+		case "-": return left - right;
+		case "*": return left * right;
+		case "/": return left / right;
+		case "%": return left % right;
+		case "<<": return left << right;
+		case ">>": return left >> right;
+		case ">>>": return left >>> right;
+		case "&": return left & right;
+		case "|": return left | right;
+		case "^": return left ^ right;
+		}
+		throw new TypeError("unknown operator " + operator);
+	}
+
+	// set assigns the property, and keeps arrays within limit
+	function set(object, key, operator, value) {
+		if (operator !== "=") {
+			value = operate(operator, object[key], value);
+		}
+		if (isArray(object)) {
+			key = toString(key);
+			if (object.length > maxArray) {
+				throw sizeError();
+			}
+			if (key === "length" ? toNumber(value) > maxArray : toNumber(key) >= maxArray) {
+				throw sizeError();
+			}
+		}
+		object[key] = value;
+		return value;
+	}
+
+	function update(object, key, delta, postfix) {
+		var old = toNumber(object[key]);
+		set(object, key, "=", old + delta);
+		return postfix ? old : old + delta;
+	}
+
+	defineProperty(global, "` + helperString + `", {value: string});
+	defineProperty(global, "` + helperSet + `", {value: set});
+	defineProperty(global, "` + helperUpdate + `", {value: update});
+	installed[installed.length] = string;
+	installed[installed.length] = set;
+	installed[installed.length] = update;
+
+	// Returns whether fn is installed by prelude
+	return function(fn) {
+		for (var i = 0; i < installed.length; i++) {
+			if (installed[i] === fn) {
+				return true;
+			}
+		}
+		return false;
+	};
+})`
+
+const errDataSize = "data size exceeds limit"
+
+// setupBuiltins runs the prelude, and captures the builtins used by sandbox
+func (sb *sandbox) setupBuiltins() error {
+	var err error
+	if sb.apply, err = sb.otto.Run("Function.prototype.apply"); err != nil {
+		return err
+	}
+	if sb.functionSource, err = sb.otto.Run("Function.prototype.toString"); err != nil {
+		return err
+	}
+	global, err := sb.otto.Run("this")
+	if err != nil {
+		return err
+	}
+	fn, err := sb.otto.Run(prelude)
+	if err != nil {
+		return err
+	}
+	config := sb.jvm.config
+	sb.installed, err = fn.Call(otto.UndefinedValue(), global, sb.chargeData, sb.buffer, sb.encode, sb.sortedKeys, sb.substitute,
+		config.MaxStringSize, config.MaxArraySize)
+	if err != nil {
+		return err
+	}
+	if sb.stringify, err = sb.otto.Run("JSON.stringify"); err != nil {
+		return err
+	}
+	sb.parse, err = sb.otto.Run("JSON.parse")
+	return err
+}
+
+// chargeData charges GasDataByte on every byte or element of data
+func (sb *sandbox) chargeData(call otto.FunctionCall) otto.Value {
+	if n, _ := call.Argument(0).ToInteger(); n > 0 {
+		sb.charge(uint64(n) * GasDataByte)
+	}
+	return otto.UndefinedValue()
+}
+
+// buffer returns a function appending its argument to a string within
+// MaxStringSize, which returns the string if called without argument
+func (sb *sandbox) buffer(call otto.FunctionCall) otto.Value {
+	var buf bytes.Buffer
+	return sb.toValue(func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) == 0 {
+			return sb.toValue(buf.String())
+		}
+		s := call.Argument(0).String()
+		if buf.Len()+len(s) > sb.jvm.config.MaxStringSize {
+			panic(call.Otto.MakeRangeError(errDataSize))
+		}
+		sb.charge(uint64(len(s)) * GasDataByte)
+		buf.WriteString(s)
+		return otto.UndefinedValue()
+	})
+}
+
+// encode returns the json of primitive value, in the format of otto
+func (sb *sandbox) encode(call otto.FunctionCall) otto.Value {
+	value := call.Argument(0)
+	var data []byte
+	switch {
+	case value.IsString():
+		data, _ = json.Marshal(value.String())
+	case value.IsNumber():
+		f, _ := value.ToFloat()
+		switch {
+		case math.IsNaN(f) || math.IsInf(f, 0):
+			data = []byte("null")
+		case f > math.MinInt64 && f < math.MaxInt64 && f == math.Trunc(f):
+			data = []byte(strconv.FormatInt(int64(f), 10))
+		default:
+			data, _ = json.Marshal(f)
+		}
+	case value.IsBoolean():
+		b, _ := value.ToBoolean()
+		data = []byte(strconv.FormatBool(b))
+	default:
+		data = []byte("null")
+	}
+	return sb.toValue(string(data))
+}
+
+// sortedKeys returns the sorted enumerable keys of object
+func (sb *sandbox) sortedKeys(call otto.FunctionCall) otto.Value {
+	if !call.Argument(0).IsObject() {
+		return sb.toValue([]string{})
+	}
+	keys := call.Argument(0).Object().Keys()
+	sort.Strings(keys)
+	sb.charge(uint64(len(keys)) * GasDataByte)
+	return sb.toValue(keys)
+}
+
+// Patterns of replacement string, the same as otto
+var substitution = regexp.MustCompile("\\$(?:[\\$\\&\\'\\`1-9]|0[1-9]|[1-9][0-9])")
+
+// substitute expands the replacement template for the arguments of a match,
+// which are the match, captures, offset and string. The expansion must not
+// exceed limit.
+func (sb *sandbox) substitute(call otto.FunctionCall) otto.Value {
+	template := call.Argument(0).String()
+	args := call.Argument(1).Object()
+	limit, _ := call.Argument(2).ToInteger()
+
+	length, _ := args.Get("length")
+	n, _ := length.ToInteger()
+	if n < 3 {
+		return sb.toValue(template)
+	}
+	arg := func(i int64) string {
+		v, _ := args.Get(strconv.FormatInt(i, 10))
+		if !v.IsString() {
+			return ""
+		}
+		return v.String()
+	}
+	match, target := arg(0), arg(n-1)
+	offsetValue, _ := args.Get(strconv.FormatInt(n-2, 10))
+	offset, _ := offsetValue.ToInteger()
+	if offset < 0 || int(offset)+len(match) > len(target) {
+		offset = 0
+	}
+
+	part := func(p string) string {
+		switch p[1] {
+		case '$':
+			return "$"
+		case '&':
+			return match
+		case '`':
+			return target[:offset]
+		case '\'':
+			return target[int(offset)+len(match):]
+		}
+		i, err := strconv.ParseInt(p[1:], 10, 64)
+		if err != nil || i >= n-2 {
+			return ""
+		}
+		return arg(i)
+	}
+	// Check the size before expanding
+	size := int64(len(template))
+	for _, p := range substitution.FindAllString(template, -1) {
+		size += int64(len(part(p)) - len(p))
+		if size > limit {
+			panic(call.Otto.MakeRangeError(errDataSize))
+		}
+	}
+	sb.charge(uint64(size) * GasDataByte)
+	return sb.toValue(substitution.ReplaceAllStringFunc(template, part))
+}
+
+func (sb *sandbox) toValue(v interface{}) otto.Value {
+	value, err := sb.otto.ToValue(v)
+	if err != nil {
+		panic(sb.otto.MakeTypeError(err.Error()))
+	}
+	return value
+}
+
+// isBuiltin reports whether fn is a builtin, a bound function or installed by
+// prelude, rather than defined by contract code
+func (sb *sandbox) isBuiltin(fn otto.Value) bool {
+	installed, err := sb.installed.Call(otto.UndefinedValue(), fn)
+	if b, _ := installed.ToBoolean(); err != nil || b {
+		return true
+	}
+	src, err := sb.functionSource.Call(fn)
+	return err != nil || strings.HasSuffix(src.String(), "[native code] }")
+}
@@ -0,0 +1,180 @@
+package jsvm
+
+import (
+	"reflect"
+	"github.com/robertkrimen/otto/ast"
+	"github.com/robertkrimen/otto/file"
+	"github.com/robertkrimen/otto/parser"
+	"github.com/robertkrimen/otto/token"
+)
+
+/*
+	Contract source is compiled before execution. Expressions creating strings
+	or growing arrays without calling builtins are rewritten to call helpers of
+	the prelude, which check and charge the size of data:
+
+		a + b          @string(a + b)
+		x += b         x = @string(x + b)
+		o[k] op= v     @set(o, k, "op", v)
+		o[k]++         @update(o, k, 1, true)
+
+	Helper names are not valid identifiers, so contracts can not shadow them.
+	The with statement is rejected, since it could shadow them by properties.
+*/
+
+const (
+	helperString = "@string"
+	helperSet    = "@set"
+	helperUpdate = "@update"
+)
+
+var (
+	astPackage     = reflect.TypeOf(ast.Program{}).PkgPath()
+	expressionType = reflect.TypeOf((*ast.Expression)(nil)).Elem()
+)
+
+// program is the compiled source of js contract
+type program struct {
+	ast       *ast.Program
+	functions map[string]bool // functions declared at top level, which can be called
+}
+
+func compile(src []byte) (*program, error) {
+	prog, err := parser.ParseFile(nil, "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{visited: make(map[visitKey]bool)}
+	c.walk(reflect.ValueOf(prog))
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	functions := make(map[string]bool)
+	for _, decl := range prog.DeclarationList {
+		if fn, ok := decl.(*ast.FunctionDeclaration); ok && fn.Function.Name != nil {
+			functions[fn.Function.Name.Name] = true
+		}
+	}
+	return &program{ast: prog, functions: functions}, nil
+}
+
+type visitKey struct {
+	typ reflect.Type
+	ptr uintptr
+}
+
+// compiler rewrites the syntax tree in place
+type compiler struct {
+	visited map[visitKey]bool // nodes referred by both statements and declarations are rewritten once
+	err     error
+}
+
+func (c *compiler) walk(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || v.Elem().Type().PkgPath() != astPackage {
+			return
+		}
+		key := visitKey{v.Type(), v.Pointer()}
+		if c.visited[key] {
+			return
+		}
+		c.visited[key] = true
+		c.check(v.Interface())
+		c.walk(v.Elem())
+	case reflect.Interface:
+		if !v.IsNil() {
+			c.walk(v.Elem())
+		}
+	case reflect.Struct:
+		if v.Type().PkgPath() != astPackage {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			c.walk(v.Field(i))
+			c.replace(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			c.walk(v.Index(i))
+			c.replace(v.Index(i))
+		}
+	}
+}
+
+// check rejects the syntax which could escape the helpers
+func (c *compiler) check(node interface{}) {
+	switch node := node.(type) {
+	case *ast.WithStatement:
+		c.err = ErrUnsupportedSyntax
+	case *ast.ForInStatement:
+		if object, _ := member(node.Into); object != nil {
+			c.err = ErrUnsupportedSyntax
+		}
+	}
+}
+
+// replace rewrites the expression held by v, whose children are rewritten
+func (c *compiler) replace(v reflect.Value) {
+	if v.Type() != expressionType || v.IsNil() || !v.CanSet() {
+		return
+	}
+	v.Set(reflect.ValueOf(rewrite(v.Interface().(ast.Expression))))
+}
+
+func rewrite(expr ast.Expression) ast.Expression {
+	switch e := expr.(type) {
+	case *ast.BinaryExpression:
+		if e.Operator == token.PLUS {
+			return call(e.Idx0(), helperString, e)
+		}
+	case *ast.AssignExpression:
+		idx := e.Idx0()
+		if object, key := member(e.Left); object != nil {
+			return call(idx, helperSet, object, key, str(idx, e.Operator.String()), e.Right)
+		}
+		if e.Operator == token.PLUS {
+			// Identifier is evaluated twice, which has no side effect
+			concat := &ast.BinaryExpression{Operator: token.PLUS, Left: e.Left, Right: e.Right}
+			return &ast.AssignExpression{Operator: token.ASSIGN, Left: e.Left, Right: call(idx, helperString, concat)}
+		}
+	case *ast.UnaryExpression:
+		if e.Operator != token.INCREMENT && e.Operator != token.DECREMENT {
+			break
+		}
+		idx := e.Idx
+		if object, key := member(e.Operand); object != nil {
+			delta := &ast.NumberLiteral{Idx: idx, Literal: "1", Value: int64(1)}
+			if e.Operator == token.DECREMENT {
+				delta = &ast.NumberLiteral{Idx: idx, Literal: "-1", Value: int64(-1)}
+			}
+			return call(idx, helperUpdate, object, key, delta, &ast.BooleanLiteral{Idx: idx, Value: e.Postfix})
+		}
+	}
+	return expr
+}
+
+// member returns the object and key of property access expression
+func member(expr ast.Expression) (object, key ast.Expression) {
+	switch e := expr.(type) {
+	case *ast.DotExpression:
+		return e.Left, str(e.Identifier.Idx, e.Identifier.Name)
+	case *ast.BracketExpression:
+		return e.Left, e.Member
+	}
+	return nil, nil
+}
+
+func call(idx file.Idx, helper string, args ...ast.Expression) ast.Expression {
+	return &ast.CallExpression{
+		Callee:           &ast.Identifier{Name: helper, Idx: idx},
+		LeftParenthesis:  idx,
+		ArgumentList:     args,
+		RightParenthesis: idx,
+	}
+}
+
+func str(idx file.Idx, value string) ast.Expression {
+	return &ast.StringLiteral{Idx: idx, Literal: value, Value: value}
+}
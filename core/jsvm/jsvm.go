@@ -0,0 +1,169 @@
+// Package jsvm implements a contract runtime executing sandboxed javascript
+// contracts, alongside the EVM.
+//
+// A js contract is deployed by a contract creation transaction whose payload
+// is CodePrefix followed by the source. The top-level code defines functions,
+// and the optional init function is called at deployment. A call transaction
+// to a js contract carries a json encoded Input, which calls a top-level
+// function with arguments, and the return value is json encoded as output.
+//
+// Contracts access the environment by the globals below:
+//
+//	ctx.sender, ctx.origin, ctx.address  hex addresses
+//	ctx.value                            decimal string of transferred value
+//	ctx.height, ctx.time                 block height and timestamp
+//	storage.get(key)                     json value of key, undefined if not set
+//	storage.set(key, value)              store json serializable value
+//	storage.remove(key)                  remove key
+//
+// Only functions declared at the top level of the source can be called, and
+// eval and Function are disabled. The with statement is not supported.
+//
+// Execution is deterministic: Date and Math.random are removed, and gas is
+// charged for every evaluated statement, storage access and code byte. Work
+// of builtins and string concatenation is charged by the size of data, and
+// strings and arrays are bounded by Config.MaxStringSize and MaxArraySize.
+package jsvm
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"bytes"
+	"errors"
+	"math/big"
+	json "github.com/json-iterator/go"
+)
+
+var (
+	// CodePrefix marks the code of js contracts. It starts with STOP,
+	// so the code halts immediately if it is executed by EVM.
+	CodePrefix = []byte("\x00jsvm")
+
+	ErrInvalidInput   = errors.New("invalid js contract input")
+	ErrMethodNotFound = errors.New("js contract method not found")
+	ErrCodeSize       = errors.New("js contract code size exceeds limit")
+	ErrNotJSContract  = errors.New("not a js contract")
+
+	ErrUnsupportedSyntax = errors.New("unsupported js contract syntax")
+)
+
+const (
+	GasStep         = 1    // Gas of every evaluated statement
+	GasDataByte     = 1    // Gas of every byte of strings and element of arrays processed by builtins
+	GasStorageRead  = 200  // Gas of reading a storage slot
+	GasStorageWrite = 5000 // Gas of writing a storage slot
+	GasCodeByte     = 200  // Gas of storing a byte of code at deployment
+	initMethod      = "init"
+)
+
+type Config struct {
+	MaxCodeSize   int // Maximum size of contract code
+	StackDepth    int // Maximum depth of js call stack
+	MaxStringSize int // Maximum length of strings created by builtins and concatenation
+	MaxArraySize  int // Maximum length of arrays processed by builtins and assignment
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		MaxCodeSize:   64 * 1024,
+		StackDepth:    128,
+		MaxStringSize: 1 << 20,
+		MaxArraySize:  1 << 16,
+	}
+}
+
+// IsJSCode reports whether code is a js contract, which should be executed by JSVM
+func IsJSCode(code []byte) bool {
+	return bytes.HasPrefix(code, CodePrefix)
+}
+
+// Input is the call data of js contract
+type Input struct {
+	Method string        `json:"method"`
+	Args   []interface{} `json:"args,omitempty"`
+}
+
+// PackInput encodes the call data of calling method with args
+func PackInput(method string, args ...interface{}) ([]byte, error) {
+	return json.Marshal(&Input{Method: method, Args: args})
+}
+
+// JSVM executes js contracts on the state. It shares the context with EVM,
+// and like EVM, should be used in a single transaction.
+type JSVM struct {
+	vm.Context
+	StateDB vm.StateDB
+
+	config *Config
+}
+
+func New(ctx vm.Context, statedb vm.StateDB, config *Config) *JSVM {
+	return &JSVM{
+		Context: ctx,
+		StateDB: statedb,
+		config:  config,
+	}
+}
+
+// Create deploys the js contract code, and calls its init function.
+// Code must start with CodePrefix.
+func (jvm *JSVM) Create(caller vm.ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
+	if !IsJSCode(code) {
+		return nil, common.Address{}, gas, ErrNotJSContract
+	}
+	if len(code) > jvm.config.MaxCodeSize {
+		return nil, common.Address{}, gas, ErrCodeSize
+	}
+	if !jvm.CanTransfer(jvm.StateDB, caller.Address(), value) {
+		return nil, common.Address{}, gas, vm.ErrInsufficientBalance
+	}
+	nonce := jvm.StateDB.GetNonce(caller.Address())
+	jvm.StateDB.SetNonce(caller.Address(), nonce+1)
+
+	contractAddr = common.CreateAddress(caller.Address(), nonce)
+	if jvm.StateDB.GetNonce(contractAddr) != 0 || jvm.StateDB.GetCodeSize(contractAddr) != 0 {
+		return nil, common.Address{}, 0, vm.ErrContractAddressCollision
+	}
+	snapshot := jvm.StateDB.Snapshot()
+	jvm.StateDB.CreateAccount(contractAddr)
+	jvm.Transfer(jvm.StateDB, caller.Address(), contractAddr, value)
+
+	sb := newSandbox(jvm, caller.Address(), contractAddr, value, gas)
+	err = sb.useGas(uint64(len(code)) * GasCodeByte)
+	if err == nil {
+		ret, err = sb.run(code[len(CodePrefix):], initMethod, nil, true)
+	}
+	if err != nil {
+		jvm.StateDB.RevertToSnapshot(snapshot)
+		return nil, contractAddr, sb.gas, err
+	}
+	jvm.StateDB.SetCode(contractAddr, code)
+	return ret, contractAddr, sb.gas, nil
+}
+
+// Call calls the js contract at addr with the json encoded Input
+func (jvm *JSVM) Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	code := jvm.StateDB.GetCode(addr)
+	if !IsJSCode(code) {
+		return nil, gas, ErrNotJSContract
+	}
+	in := &Input{}
+	if err := json.Unmarshal(input, in); err != nil || in.Method == "" {
+		return nil, gas, ErrInvalidInput
+	}
+	if in.Method == initMethod {
+		return nil, gas, ErrMethodNotFound
+	}
+	if !jvm.CanTransfer(jvm.StateDB, caller.Address(), value) {
+		return nil, gas, vm.ErrInsufficientBalance
+	}
+	snapshot := jvm.StateDB.Snapshot()
+	jvm.Transfer(jvm.StateDB, caller.Address(), addr, value)
+
+	sb := newSandbox(jvm, caller.Address(), addr, value, gas)
+	ret, err = sb.run(code[len(CodePrefix):], in.Method, in.Args, false)
+	if err != nil {
+		jvm.StateDB.RevertToSnapshot(snapshot)
+	}
+	return ret, sb.gas, err
+}
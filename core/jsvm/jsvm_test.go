@@ -0,0 +1,236 @@
+package jsvm
+
+import (
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/core/vm"
+	"tinychain/db/leveldb"
+	"math/big"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+const testContract = `
+function init() {
+	storage.set("owner", ctx.sender);
+	storage.set("count", 0);
+}
+
+function add(n) {
+	var count = storage.get("count") + n;
+	storage.set("count", count);
+	return count;
+}
+
+function setNote(note) {
+	storage.set("note", note);
+}
+
+function note() {
+	return storage.get("note");
+}
+
+function fail() {
+	storage.set("count", 100);
+	throw new Error("failed");
+}
+
+function spin() {
+	while (true) {
+		try {
+			for (;;) {}
+		} catch (e) {}
+	}
+}
+
+function now() {
+	return Date.now();
+}
+`
+
+func newTestJSVM(t *testing.T) (*JSVM, *state.StateDB) {
+	db, err := leveldb.NewMemDatabase()
+	assert.Nil(t, err)
+	statedb := state.New(db, nil)
+	ctx := vm.Context{
+		CanTransfer: func(db vm.StateDB, addr common.Address, amount *big.Int) bool {
+			return db.GetBalance(addr).Cmp(amount) >= 0
+		},
+		Transfer: func(db vm.StateDB, sender, recipient common.Address, amount *big.Int) {
+			db.SubBalance(sender, amount)
+			db.AddBalance(recipient, amount)
+		},
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(1000),
+	}
+	return New(ctx, statedb, DefaultConfig()), statedb
+}
+
+func TestJSVM_Contract(t *testing.T) {
+	jvm, statedb := newTestJSVM(t)
+	caller := vm.AccountRef(common.BytesToAddress([]byte{1}))
+	code := append(append([]byte{}, CodePrefix...), testContract...)
+
+	_, addr, left, err := jvm.Create(caller, code, 10000000, new(big.Int))
+	assert.Nil(t, err)
+	assert.True(t, left < 10000000)
+	assert.True(t, IsJSCode(statedb.GetCode(addr)))
+	assert.Equal(t, uint64(1), statedb.GetNonce(caller.Address()))
+
+	call := func(method string, args ...interface{}) ([]byte, uint64, error) {
+		input, err := PackInput(method, args...)
+		assert.Nil(t, err)
+		return jvm.Call(caller, addr, input, 1000000, new(big.Int))
+	}
+	ret, _, err := call("add", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(ret))
+	ret, _, err = call("add", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "5", string(ret))
+
+	// Values longer than a slot, and shrinking values
+	long := `{"text": "a note spanning several storage slots of the account"}`
+	_, _, err = call("setNote", long)
+	assert.Nil(t, err)
+	ret, _, err = call("note")
+	assert.Nil(t, err)
+	assert.Equal(t, `"{\"text\": \"a note spanning several storage slots of the account\"}"`, string(ret))
+	_, _, err = call("setNote", "short")
+	assert.Nil(t, err)
+	ret, _, err = call("note")
+	assert.Nil(t, err)
+	assert.Equal(t, `"short"`, string(ret))
+
+	// Exception reverts state
+	_, _, err = call("fail")
+	assert.NotNil(t, err)
+	ret, _, err = call("add", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "5", string(ret))
+
+	// Catching the halt does not escape gas metering
+	_, left, err = call("spin")
+	assert.Equal(t, vm.ErrOutOfGas, err)
+	assert.Equal(t, uint64(0), left)
+
+	_, _, err = call("now")
+	assert.NotNil(t, err)
+	_, _, err = call("init")
+	assert.Equal(t, ErrMethodNotFound, err)
+	_, _, err = call("missing")
+	assert.Equal(t, ErrMethodNotFound, err)
+}
+
+func TestJSVM_Deterministic(t *testing.T) {
+	var gasUsed []uint64
+	for i := 0; i < 2; i++ {
+		jvm, _ := newTestJSVM(t)
+		caller := vm.AccountRef(common.BytesToAddress([]byte{1}))
+		code := append(append([]byte{}, CodePrefix...), testContract...)
+		_, addr, _, err := jvm.Create(caller, code, 10000000, new(big.Int))
+		assert.Nil(t, err)
+		input, _ := PackInput("add", 1)
+		_, left, err := jvm.Call(caller, addr, input, 1000000, new(big.Int))
+		assert.Nil(t, err)
+		gasUsed = append(gasUsed, 1000000-left)
+	}
+	assert.Equal(t, gasUsed[0], gasUsed[1])
+}
+
+const limitContract = `
+var alias = eval;
+function replaced() {}
+replaced = JSON.stringify;
+
+function join(n) {
+	return new Array(n).join("x").length;
+}
+
+function double() {
+	var s = "x";
+	while (true) {
+		s += s;
+	}
+}
+
+function amplify() {
+	var a = [], s = "";
+	for (var i = 0; i < 100; i++) {
+		s = s + "xxxxxxxxxx";
+	}
+	for (i = 0; i < 1000; i++) {
+		a.push(s);
+	}
+	return JSON.stringify([a, a, a, a, a, a, a, a, a, a]).length;
+}
+
+function run(src) {
+	return eval(src);
+}
+
+function grow() {
+	var a = [];
+	a.length = 1e9;
+}
+
+function replace() {
+	return "abc".replace(/b/, "$&$&$'$$").replace("a", function(m) { return m + m; });
+}
+
+function encode() {
+	return {b: [1, "x<"], a: true, c: null, d: undefined};
+}
+`
+
+func TestJSVM_Limits(t *testing.T) {
+	jvm, _ := newTestJSVM(t)
+	caller := vm.AccountRef(common.BytesToAddress([]byte{1}))
+	code := append(append([]byte{}, CodePrefix...), limitContract...)
+	_, addr, _, err := jvm.Create(caller, code, 100000000, new(big.Int))
+	assert.Nil(t, err)
+
+	call := func(method string, args ...interface{}) ([]byte, uint64, error) {
+		input, err := PackInput(method, args...)
+		assert.Nil(t, err)
+		return jvm.Call(caller, addr, input, 10000000, new(big.Int))
+	}
+
+	// Only functions declared by the contract can be called
+	for _, method := range []string{"eval", "alias", "replaced", "JSON", "toString", "Function"} {
+		_, _, err = call(method, "1")
+		assert.Equal(t, ErrMethodNotFound, err, method)
+	}
+
+	// Builtins are charged by the size of data
+	ret, left1, err := call("join", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "9", string(ret))
+	_, left2, err := call("join", 1000)
+	assert.Nil(t, err)
+	assert.True(t, left2+900 < left1)
+	_, _, err = call("join", 1e8)
+	assert.NotNil(t, err)
+
+	_, _, err = call("double")
+	assert.NotNil(t, err)
+	_, _, err = call("amplify")
+	assert.NotNil(t, err)
+	_, _, err = call("run", "1")
+	assert.NotNil(t, err)
+	_, _, err = call("grow")
+	assert.NotNil(t, err)
+
+	ret, _, err = call("replace")
+	assert.Nil(t, err)
+	assert.Equal(t, `"aabbc$c"`, string(ret))
+	// Json keeps the format of otto
+	ret, _, err = call("encode")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":true,"b":[1,"x\u003c"],"c":null}`, string(ret))
+
+	// Syntax escaping the checks is rejected
+	code = append(append([]byte{}, CodePrefix...), "function f(o) { with (o) { x = 1; } }"...)
+	_, _, _, err = jvm.Create(caller, code, 100000000, new(big.Int))
+	assert.Equal(t, ErrUnsupportedSyntax, err)
+}
@@ -0,0 +1,258 @@
+package jsvm
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"math/big"
+	json "github.com/json-iterator/go"
+	"github.com/robertkrimen/otto"
+)
+
+// halt is panicked to stop the script when gas is exhausted
+type halt struct{}
+
+// sandbox is the js runtime of a single contract execution
+type sandbox struct {
+	jvm     *JSVM
+	caller  common.Address
+	address common.Address
+	value   *big.Int
+
+	gas       uint64
+	exhausted bool
+
+	otto *otto.Otto
+	// builtins captured before the contract runs
+	apply, functionSource, stringify, parse, installed otto.Value
+}
+
+func newSandbox(jvm *JSVM, caller, address common.Address, value *big.Int, gas uint64) *sandbox {
+	return &sandbox{
+		jvm:     jvm,
+		caller:  caller,
+		address: address,
+		value:   value,
+		gas:     gas,
+	}
+}
+
+func (sb *sandbox) useGas(amount uint64) error {
+	if sb.gas < amount {
+		sb.gas = 0
+		sb.exhausted = true
+		return vm.ErrOutOfGas
+	}
+	sb.gas -= amount
+	return nil
+}
+
+// meter charges GasStep on every statement evaluated by otto. Expressions are
+// not metered by otto, so the work of builtins and concatenation is charged by
+// the size of data instead (see builtins.go). The interrupt is queued again
+// before halting, so that the script keeps halting even if it catches the
+// panic with try statement.
+func (sb *sandbox) meter() {
+	sb.otto.Interrupt <- func() {
+		sb.meter()
+		if sb.useGas(GasStep) != nil {
+			panic(halt{})
+		}
+	}
+}
+
+func (sb *sandbox) setup() error {
+	sb.otto = otto.New()
+	sb.otto.SetStackDepthLimit(sb.jvm.config.StackDepth)
+	// Remove sources of non-determinism
+	if _, err := sb.otto.Run("delete Date; delete Math.random;"); err != nil {
+		return err
+	}
+	if err := sb.setupBuiltins(); err != nil {
+		return err
+	}
+
+	ctx, _ := sb.otto.Object("({})")
+	ctx.Set("sender", string(common.Hex(sb.caller[:])))
+	ctx.Set("origin", string(common.Hex(sb.jvm.Origin[:])))
+	ctx.Set("address", string(common.Hex(sb.address[:])))
+	ctx.Set("value", sb.value.String())
+	ctx.Set("height", bigToInt64(sb.jvm.BlockNumber))
+	ctx.Set("time", bigToInt64(sb.jvm.Time))
+	if err := sb.otto.Set("ctx", ctx); err != nil {
+		return err
+	}
+
+	storage, _ := sb.otto.Object("({})")
+	storage.Set("get", sb.storageGet)
+	storage.Set("set", sb.storageSet)
+	storage.Set("remove", sb.storageRemove)
+	if err := sb.otto.Set("storage", storage); err != nil {
+		return err
+	}
+
+	sb.otto.Interrupt = make(chan func(), 1)
+	sb.meter()
+	return nil
+}
+
+// run evaluates the source, and calls method with args. Returns the json
+// encoded return value. If optional is set, missing method is ignored.
+func (sb *sandbox) run(src []byte, method string, args []interface{}, optional bool) (ret []byte, err error) {
+	defer func() {
+		if caught := recover(); caught != nil {
+			if _, ok := caught.(halt); !ok {
+				panic(caught)
+			}
+		}
+		if sb.exhausted {
+			ret, err = nil, vm.ErrOutOfGas
+		}
+	}()
+	prog, err := compile(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := sb.setup(); err != nil {
+		return nil, err
+	}
+	if _, err := sb.otto.Run(prog.ast); err != nil {
+		return nil, err
+	}
+	// Only functions declared by the contract can be called, even if the
+	// global is replaced by a builtin
+	fn, err := sb.otto.Get(method)
+	if err != nil {
+		return nil, err
+	}
+	if !prog.functions[method] || !fn.IsFunction() || sb.isBuiltin(fn) {
+		if optional {
+			return nil, nil
+		}
+		return nil, ErrMethodNotFound
+	}
+
+	// Pass arguments as plain js values
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, ErrInvalidInput
+	}
+	arguments, err := sb.parse.Call(otto.UndefinedValue(), string(data))
+	if err != nil {
+		return nil, ErrInvalidInput
+	}
+	result, err := sb.apply.Call(fn, otto.UndefinedValue(), arguments)
+	if err != nil {
+		return nil, err
+	}
+	if result.IsUndefined() {
+		return nil, nil
+	}
+	output, err := sb.stringify.Call(otto.UndefinedValue(), result)
+	if err != nil {
+		return nil, err
+	}
+	if output.IsUndefined() {
+		return nil, nil
+	}
+	return []byte(output.String()), nil
+}
+
+func (sb *sandbox) storageGet(call otto.FunctionCall) otto.Value {
+	data := sb.load(call.Argument(0).String())
+	if data == nil {
+		return otto.UndefinedValue()
+	}
+	value, err := sb.parse.Call(otto.UndefinedValue(), string(data))
+	if err != nil {
+		panic(call.Otto.MakeCustomError("StorageError", err.Error()))
+	}
+	return value
+}
+
+func (sb *sandbox) storageSet(call otto.FunctionCall) otto.Value {
+	data, err := sb.stringify.Call(otto.UndefinedValue(), call.Argument(1))
+	if err != nil || data.IsUndefined() {
+		panic(call.Otto.MakeTypeError("storage value is not json serializable"))
+	}
+	sb.store(call.Argument(0).String(), []byte(data.String()))
+	return otto.UndefinedValue()
+}
+
+func (sb *sandbox) storageRemove(call otto.FunctionCall) otto.Value {
+	sb.store(call.Argument(0).String(), nil)
+	return otto.UndefinedValue()
+}
+
+/*
+	Storage of js contract is mapped onto the storage slots of the account.
+	The slot sha256(key) holds len(value)+1, and value is split into 32 bytes
+	chunks in the following slots.
+*/
+
+func storageSlot(base common.Hash, i int) common.Hash {
+	n := new(big.Int).SetBytes(base[:])
+	return common.BigToHash(n.Add(n, big.NewInt(int64(i))))
+}
+
+// charge uses gas in native functions, and halts the script if gas is exhausted
+func (sb *sandbox) charge(amount uint64) {
+	if sb.useGas(amount) != nil {
+		panic(halt{})
+	}
+}
+
+// length returns the value length of key, or -1 if key does not exist
+func (sb *sandbox) length(base common.Hash) int {
+	sb.charge(GasStorageRead)
+	head := sb.jvm.StateDB.GetState(sb.address, base)
+	return int(new(big.Int).SetBytes(head[:]).Int64()) - 1
+}
+
+func (sb *sandbox) load(key string) []byte {
+	base := common.Sha256([]byte(key))
+	n := sb.length(base)
+	if n < 0 {
+		return nil
+	}
+	data := make([]byte, 0, n+common.HashLength)
+	for i := 1; len(data) < n; i++ {
+		sb.charge(GasStorageRead)
+		chunk := sb.jvm.StateDB.GetState(sb.address, storageSlot(base, i))
+		data = append(data, chunk[:]...)
+	}
+	return data[:n]
+}
+
+// store sets the value of key, or removes key if data is nil
+func (sb *sandbox) store(key string, data []byte) {
+	base := common.Sha256([]byte(key))
+	oldChunks := (sb.length(base) + common.HashLength - 1) / common.HashLength
+
+	var head common.Hash
+	if data != nil {
+		head = common.BigToHash(big.NewInt(int64(len(data) + 1)))
+	}
+	sb.charge(GasStorageWrite)
+	sb.jvm.StateDB.SetState(sb.address, base, head)
+
+	i := 1
+	for ; len(data) > 0; i++ {
+		var chunk common.Hash
+		n := copy(chunk[:], data)
+		data = data[n:]
+		sb.charge(GasStorageWrite)
+		sb.jvm.StateDB.SetState(sb.address, storageSlot(base, i), chunk)
+	}
+	// Clear chunks of the old value
+	for ; i <= oldChunks; i++ {
+		sb.charge(GasStorageWrite)
+		sb.jvm.StateDB.SetState(sb.address, storageSlot(base, i), common.Hash{})
+	}
+}
+
+func bigToInt64(b *big.Int) int64 {
+	if b == nil {
+		return 0
+	}
+	return b.Int64()
+}
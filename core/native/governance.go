@@ -0,0 +1,194 @@
+package native
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"errors"
+	"math/big"
+)
+
+// Governance slots
+const (
+	paramPrefix         byte = iota // key -> value
+	proposalCountPrefix             // number of proposals
+	proposalPrefix                  // id, field -> value
+	approvedPrefix                  // id, candidate -> approved
+	approverPrefix                  // id, index -> candidate
+)
+
+// Proposal fields
+const (
+	proposalKey byte = iota
+	proposalValue
+	proposalApprovals
+	proposalExecuted
+	proposalApprovers // number of approvers
+)
+
+var (
+	ErrNotCandidate     = errors.New("caller is not candidate")
+	ErrProposalNotFound = errors.New("proposal not found")
+	ErrProposalExecuted = errors.New("proposal already executed")
+	ErrAlreadyApproved  = errors.New("proposal already approved")
+)
+
+// Governance is the chain parameter governance contract. Candidates propose
+// to set a parameter, and approve proposals with weight of their votes.
+// Approvals are recounted by the current votes of all approvers at every
+// approval, so stake moved between approvers is counted once. A proposal is
+// executed when the approvals exceed half of the total stake. Parameters are
+// read by consensus, see Param.
+//
+//	propose(bytes32 key, uint256 value) returns (uint256 id)
+//	approve(uint256 id)
+//	param(bytes32 key) view returns (uint256)
+//	proposal(uint256 id) view returns (bytes32 key, uint256 value, uint256 approvals, bool executed)
+func newGovernance() *contract {
+	return newContract(
+		&method{sig: "propose(bytes32,uint256)", args: 2, write: true, fn: propose},
+		&method{sig: "approve(uint256)", args: 1, write: true, fn: approve},
+		&method{sig: "param(bytes32)", args: 1, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			value, err := load(ctx, slot(paramPrefix, args[0].Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			return output(value)
+		}},
+		&method{sig: "proposal(uint256)", args: 1, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			if err := checkProposal(ctx, args[0]); err != nil {
+				return nil, err
+			}
+			var ret []byte
+			for _, field := range []byte{proposalKey, proposalValue, proposalApprovals, proposalExecuted} {
+				value, err := load(ctx, slot(proposalPrefix, args[0].Bytes(), []byte{field}))
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, value.Bytes()...)
+			}
+			return ret, nil
+		}},
+	)
+}
+
+func propose(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+	if err := ctx.UseGas(GasRead); err != nil {
+		return nil, err
+	}
+	if !IsCandidate(ctx.StateDB, ctx.Caller) {
+		return nil, ErrNotCandidate
+	}
+	id, err := loadBig(ctx, slot(proposalCountPrefix))
+	if err != nil {
+		return nil, err
+	}
+	idWord := common.BigToHash(id)
+	if err := store(ctx, slot(proposalPrefix, idWord.Bytes(), []byte{proposalKey}), args[0]); err != nil {
+		return nil, err
+	}
+	if err := store(ctx, slot(proposalPrefix, idWord.Bytes(), []byte{proposalValue}), args[1]); err != nil {
+		return nil, err
+	}
+	if err := storeBig(ctx, slot(proposalCountPrefix), new(big.Int).Add(id, big.NewInt(1))); err != nil {
+		return nil, err
+	}
+	return output(idWord)
+}
+
+func approve(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+	id := args[0]
+	if err := checkProposal(ctx, id); err != nil {
+		return nil, err
+	}
+	if err := ctx.UseGas(GasRead); err != nil {
+		return nil, err
+	}
+	if !IsCandidate(ctx.StateDB, ctx.Caller) {
+		return nil, ErrNotCandidate
+	}
+	executed, err := load(ctx, slot(proposalPrefix, id.Bytes(), []byte{proposalExecuted}))
+	if err != nil {
+		return nil, err
+	}
+	if executed != (common.Hash{}) {
+		return nil, ErrProposalExecuted
+	}
+	approvedKey := slot(approvedPrefix, id.Bytes(), ctx.Caller.Bytes())
+	approved, err := load(ctx, approvedKey)
+	if err != nil {
+		return nil, err
+	}
+	if approved != (common.Hash{}) {
+		return nil, ErrAlreadyApproved
+	}
+	if err := store(ctx, approvedKey, boolWord(true)); err != nil {
+		return nil, err
+	}
+	count, err := addBig(ctx, slot(proposalPrefix, id.Bytes(), []byte{proposalApprovers}), big.NewInt(1))
+	if err != nil {
+		return nil, err
+	}
+	index := common.BigToHash(new(big.Int).Sub(count, big.NewInt(1)))
+	if err := store(ctx, slot(approverPrefix, id.Bytes(), index.Bytes()), addressWord(ctx.Caller)); err != nil {
+		return nil, err
+	}
+	approvals, err := countApprovals(ctx, id, count.Uint64())
+	if err != nil {
+		return nil, err
+	}
+	if err := storeBig(ctx, slot(proposalPrefix, id.Bytes(), []byte{proposalApprovals}), approvals); err != nil {
+		return nil, err
+	}
+	// Execute if approvals > total stake / 2
+	if new(big.Int).Lsh(approvals, 1).Cmp(TotalStake(ctx.StateDB)) <= 0 {
+		return nil, nil
+	}
+	if err := store(ctx, slot(proposalPrefix, id.Bytes(), []byte{proposalExecuted}), boolWord(true)); err != nil {
+		return nil, err
+	}
+	key, err := load(ctx, slot(proposalPrefix, id.Bytes(), []byte{proposalKey}))
+	if err != nil {
+		return nil, err
+	}
+	value, err := load(ctx, slot(proposalPrefix, id.Bytes(), []byte{proposalValue}))
+	if err != nil {
+		return nil, err
+	}
+	return nil, store(ctx, slot(paramPrefix, key.Bytes()), value)
+}
+
+// countApprovals sums the current votes of the approvers of proposal
+func countApprovals(ctx *vm.NativeContext, id common.Hash, approvers uint64) (*big.Int, error) {
+	approvals := new(big.Int)
+	for i := uint64(0); i < approvers; i++ {
+		index := common.BigToHash(new(big.Int).SetUint64(i))
+		approver, err := load(ctx, slot(approverPrefix, id.Bytes(), index.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.UseGas(GasRead); err != nil {
+			return nil, err
+		}
+		approvals.Add(approvals, Votes(ctx.StateDB, wordAddress(approver)))
+	}
+	return approvals, nil
+}
+
+// checkProposal returns ErrProposalNotFound if proposal of id is not proposed
+func checkProposal(ctx *vm.NativeContext, id common.Hash) error {
+	count, err := loadBig(ctx, slot(proposalCountPrefix))
+	if err != nil {
+		return err
+	}
+	if new(big.Int).SetBytes(id[:]).Cmp(count) >= 0 {
+		return ErrProposalNotFound
+	}
+	return nil
+}
+
+// Param returns the chain parameter of key set by governance, zero if not set.
+// It is used by consensus, e.g. dpos.Config.
+func Param(statedb vm.StateDB, key common.Hash) *big.Int {
+	value := statedb.GetState(GovernanceAddress, slot(paramPrefix, key.Bytes()))
+	return new(big.Int).SetBytes(value[:])
+}
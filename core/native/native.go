@@ -0,0 +1,199 @@
+// Package native implements the system contracts of tinychain in Go, at
+// reserved addresses: DPoS staking and voting, chain parameter governance
+// and a name registry.
+//
+// Native contracts are registered to the EVM at init, so they are called like
+// other contracts, by transactions and by solidity contracts. The call data
+// follows the solidity ABI: the 4 bytes selector of method signature followed
+// by 32 bytes words of static arguments. State is persisted in the storage of
+// the contract account, and gas is charged for every call, storage access and
+// transfer. Methods fail with all gas consumed, like precompiled contracts.
+package native
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"errors"
+	"math/big"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	StakingAddress    = reservedAddress(0x01, 0x00)
+	GovernanceAddress = reservedAddress(0x01, 0x01)
+	RegistryAddress   = reservedAddress(0x01, 0x02)
+
+	ErrInvalidInput    = errors.New("invalid native contract input")
+	ErrMethodNotFound  = errors.New("native contract method not found")
+	ErrWriteProtection = errors.New("native contract write protection")
+	ErrNotPayable      = errors.New("native contract method is not payable")
+)
+
+const (
+	GasCall     = 100  // Gas of every call
+	GasRead     = 200  // Gas of reading a storage slot
+	GasWrite    = 5000 // Gas of writing a storage slot
+	GasTransfer = 9000 // Gas of transferring value out of the contract
+	wordSize    = 32
+)
+
+func init() {
+	vm.RegisterNativeContract(StakingAddress, newStaking())
+	vm.RegisterNativeContract(GovernanceAddress, newGovernance())
+	vm.RegisterNativeContract(RegistryAddress, newRegistry())
+}
+
+// reservedAddress returns the address with low bytes b, e.g. 0x00...0100
+func reservedAddress(b ...byte) common.Address {
+	var addr common.Address
+	copy(addr[common.AddressLength-len(b):], b)
+	return addr
+}
+
+// method is a method of native contract
+type method struct {
+	sig     string // solidity signature, e.g. "stake(address)"
+	args    int    // number of arguments
+	write   bool   // whether the method modifies state
+	payable bool   // whether the method accepts value
+	fn      func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error)
+}
+
+// contract dispatches calls to methods by selector
+type contract struct {
+	methods map[string]*method
+}
+
+func newContract(methods ...*method) *contract {
+	c := &contract{methods: make(map[string]*method)}
+	for _, m := range methods {
+		c.methods[string(Selector(m.sig))] = m
+	}
+	return c
+}
+
+func (c *contract) Run(ctx *vm.NativeContext, input []byte) ([]byte, error) {
+	if err := ctx.UseGas(GasCall); err != nil {
+		return nil, err
+	}
+	if len(input) < 4 {
+		return nil, ErrInvalidInput
+	}
+	m, ok := c.methods[string(input[:4])]
+	if !ok {
+		return nil, ErrMethodNotFound
+	}
+	if m.write && ctx.ReadOnly {
+		return nil, ErrWriteProtection
+	}
+	if !m.payable && ctx.Value.Sign() > 0 {
+		return nil, ErrNotPayable
+	}
+	input = input[4:]
+	if len(input) != m.args*wordSize {
+		return nil, ErrInvalidInput
+	}
+	args := make([]common.Hash, m.args)
+	for i := range args {
+		args[i] = common.BytesToHash(input[i*wordSize : (i+1)*wordSize])
+	}
+	return m.fn(ctx, args)
+}
+
+// Selector returns the 4 bytes selector of method signature
+func Selector(sig string) []byte {
+	return crypto.Keccak256([]byte(sig))[:4]
+}
+
+// PackInput encodes the call data of method sig with static arguments,
+// which are common.Address, common.Hash, *big.Int, uint64 or bool.
+func PackInput(sig string, args ...interface{}) []byte {
+	input := Selector(sig)
+	for _, arg := range args {
+		var word common.Hash
+		switch arg := arg.(type) {
+		case common.Address:
+			word = addressWord(arg)
+		case common.Hash:
+			word = arg
+		case *big.Int:
+			word = common.BigToHash(arg)
+		case uint64:
+			word = common.BigToHash(new(big.Int).SetUint64(arg))
+		case bool:
+			word = boolWord(arg)
+		default:
+			panic("unsupported argument type")
+		}
+		input = append(input, word[:]...)
+	}
+	return input
+}
+
+func addressWord(addr common.Address) common.Hash {
+	var word common.Hash
+	copy(word[wordSize-common.AddressLength:], addr[:])
+	return word
+}
+
+func wordAddress(word common.Hash) common.Address {
+	return common.BytesToAddress(word[wordSize-common.AddressLength:])
+}
+
+func boolWord(b bool) common.Hash {
+	if b {
+		return common.BigToHash(big.NewInt(1))
+	}
+	return common.Hash{}
+}
+
+// slot returns the storage key of the value keyed by keys in the mapping of prefix
+func slot(prefix byte, keys ...[]byte) common.Hash {
+	data := []byte{prefix}
+	for _, key := range keys {
+		data = append(data, key...)
+	}
+	return common.BytesToHash(crypto.Keccak256(data))
+}
+
+func load(ctx *vm.NativeContext, key common.Hash) (common.Hash, error) {
+	if err := ctx.UseGas(GasRead); err != nil {
+		return common.Hash{}, err
+	}
+	return ctx.StateDB.GetState(ctx.Address, key), nil
+}
+
+func store(ctx *vm.NativeContext, key, value common.Hash) error {
+	if err := ctx.UseGas(GasWrite); err != nil {
+		return err
+	}
+	ctx.StateDB.SetState(ctx.Address, key, value)
+	return nil
+}
+
+func loadBig(ctx *vm.NativeContext, key common.Hash) (*big.Int, error) {
+	value, err := load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(value[:]), nil
+}
+
+func storeBig(ctx *vm.NativeContext, key common.Hash, value *big.Int) error {
+	return store(ctx, key, common.BigToHash(value))
+}
+
+// addBig adds delta to the value at key, and returns the new value
+func addBig(ctx *vm.NativeContext, key common.Hash, delta *big.Int) (*big.Int, error) {
+	value, err := loadBig(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	value.Add(value, delta)
+	return value, storeBig(ctx, key, value)
+}
+
+// output encodes a word as return data
+func output(word common.Hash) ([]byte, error) {
+	return word.Bytes(), nil
+}
@@ -0,0 +1,197 @@
+package native
+
+import (
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/core/vm"
+	"tinychain/db/leveldb"
+	"math/big"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	alice = common.Address{1}
+	bob   = common.Address{2}
+)
+
+func newTestState(t *testing.T) *state.StateDB {
+	db, err := leveldb.NewMemDatabase()
+	assert.Nil(t, err)
+	statedb := state.New(db, nil)
+	statedb.AddBalance(alice, big.NewInt(1000))
+	statedb.AddBalance(bob, big.NewInt(1000))
+	return statedb
+}
+
+// call calls native contract at addr like EVM, transferring value before running,
+// and reverting state on error
+func call(statedb vm.StateDB, caller, addr common.Address, value int64, input []byte) ([]byte, error) {
+	ctx := &vm.NativeContext{
+		Context: vm.Context{
+			Transfer: func(db vm.StateDB, sender, recipient common.Address, amount *big.Int) {
+				db.SubBalance(sender, amount)
+				db.AddBalance(recipient, amount)
+			},
+		},
+		StateDB: statedb,
+		Caller:  caller,
+		Address: addr,
+		Value:   big.NewInt(value),
+		Gas:     1000000,
+	}
+	snapshot := statedb.Snapshot()
+	ctx.Transfer(statedb, caller, addr, ctx.Value)
+	ret, err := vm.NativeContracts[addr].Run(ctx, input)
+	if err != nil {
+		statedb.RevertToSnapshot(snapshot)
+	}
+	return ret, err
+}
+
+func TestStaking(t *testing.T) {
+	statedb := newTestState(t)
+
+	_, err := call(statedb, bob, StakingAddress, 100, PackInput("stake(address)", alice))
+	assert.Equal(t, ErrCandidateNotRegistered, err)
+
+	_, err = call(statedb, alice, StakingAddress, 0, PackInput("register()"))
+	assert.Nil(t, err)
+	_, err = call(statedb, alice, StakingAddress, 0, PackInput("register()"))
+	assert.Equal(t, ErrCandidateRegistered, err)
+	_, err = call(statedb, alice, StakingAddress, 1, PackInput("register()"))
+	assert.Equal(t, ErrNotPayable, err)
+	assert.True(t, IsCandidate(statedb, alice))
+
+	_, err = call(statedb, bob, StakingAddress, 100, PackInput("stake(address)", alice))
+	assert.Nil(t, err)
+	_, err = call(statedb, alice, StakingAddress, 50, PackInput("stake(address)", alice))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(150), Votes(statedb, alice))
+	assert.Equal(t, big.NewInt(150), TotalStake(statedb))
+
+	ret, err := call(statedb, bob, StakingAddress, 0, PackInput("stakeOf(address,address)", bob, alice))
+	assert.Nil(t, err)
+	assert.Equal(t, common.BigToHash(big.NewInt(100)).Bytes(), ret)
+
+	_, err = call(statedb, bob, StakingAddress, 0, PackInput("unstake(address,uint256)", alice, big.NewInt(101)))
+	assert.Equal(t, ErrInsufficientStake, err)
+	_, err = call(statedb, bob, StakingAddress, 0, PackInput("unstake(address,uint256)", alice, big.NewInt(40)))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(110), Votes(statedb, alice))
+	assert.Equal(t, big.NewInt(940), statedb.GetBalance(bob))
+	assert.Equal(t, big.NewInt(110), statedb.GetBalance(StakingAddress))
+}
+
+func TestGovernance(t *testing.T) {
+	statedb := newTestState(t)
+	key := common.BytesToHash([]byte("blockPeriod"))
+
+	_, err := call(statedb, alice, GovernanceAddress, 0, PackInput("propose(bytes32,uint256)", key, uint64(5)))
+	assert.Equal(t, ErrNotCandidate, err)
+
+	for _, candidate := range []common.Address{alice, bob} {
+		_, err = call(statedb, candidate, StakingAddress, 0, PackInput("register()"))
+		assert.Nil(t, err)
+		_, err = call(statedb, candidate, StakingAddress, 100, PackInput("stake(address)", candidate))
+		assert.Nil(t, err)
+	}
+
+	ret, err := call(statedb, alice, GovernanceAddress, 0, PackInput("propose(bytes32,uint256)", key, uint64(5)))
+	assert.Nil(t, err)
+	id := common.BytesToHash(ret)
+	assert.Equal(t, common.Hash{}, id)
+
+	// Half of the total stake is not enough
+	_, err = call(statedb, alice, GovernanceAddress, 0, PackInput("approve(uint256)", id))
+	assert.Nil(t, err)
+	_, err = call(statedb, alice, GovernanceAddress, 0, PackInput("approve(uint256)", id))
+	assert.Equal(t, ErrAlreadyApproved, err)
+	assert.Equal(t, 0, Param(statedb, key).Sign())
+
+	_, err = call(statedb, bob, GovernanceAddress, 0, PackInput("approve(uint256)", id))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(5), Param(statedb, key))
+
+	_, err = call(statedb, bob, GovernanceAddress, 0, PackInput("approve(uint256)", uint64(1)))
+	assert.Equal(t, ErrProposalNotFound, err)
+}
+
+func TestGovernance_MovedStake(t *testing.T) {
+	statedb := newTestState(t)
+	carol := common.Address{3}
+	statedb.AddBalance(carol, big.NewInt(1000))
+	key := common.BytesToHash([]byte("blockPeriod"))
+
+	for _, candidate := range []common.Address{alice, bob, carol} {
+		_, err := call(statedb, candidate, StakingAddress, 0, PackInput("register()"))
+		assert.Nil(t, err)
+	}
+	_, err := call(statedb, carol, StakingAddress, 100, PackInput("stake(address)", alice))
+	assert.Nil(t, err)
+	_, err = call(statedb, carol, StakingAddress, 100, PackInput("stake(address)", carol))
+	assert.Nil(t, err)
+	_, err = call(statedb, alice, GovernanceAddress, 0, PackInput("propose(bytes32,uint256)", key, uint64(5)))
+	assert.Nil(t, err)
+	_, err = call(statedb, alice, GovernanceAddress, 0, PackInput("approve(uint256)", uint64(0)))
+	assert.Nil(t, err)
+
+	// Stake moved from alice to bob is counted once
+	_, err = call(statedb, carol, StakingAddress, 0, PackInput("unstake(address,uint256)", alice, big.NewInt(100)))
+	assert.Nil(t, err)
+	_, err = call(statedb, carol, StakingAddress, 100, PackInput("stake(address)", bob))
+	assert.Nil(t, err)
+	_, err = call(statedb, bob, GovernanceAddress, 0, PackInput("approve(uint256)", uint64(0)))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, Param(statedb, key).Sign())
+	ret, err := call(statedb, bob, GovernanceAddress, 0, PackInput("proposal(uint256)", uint64(0)))
+	assert.Nil(t, err)
+	assert.Equal(t, common.BigToHash(big.NewInt(100)).Bytes(), ret[2*wordSize:3*wordSize])
+
+	_, err = call(statedb, carol, GovernanceAddress, 0, PackInput("approve(uint256)", uint64(0)))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(5), Param(statedb, key))
+	assert.Equal(t, []common.Address{alice, bob, carol}, Candidates(statedb))
+}
+
+func TestRegistry(t *testing.T) {
+	statedb := newTestState(t)
+	name := common.BytesToHash([]byte("tiny"))
+
+	_, ok := Resolve(statedb, name)
+	assert.False(t, ok)
+
+	_, err := call(statedb, alice, RegistryAddress, 0, PackInput("register(bytes32,address)", name, alice))
+	assert.Nil(t, err)
+	_, err = call(statedb, bob, RegistryAddress, 0, PackInput("register(bytes32,address)", name, bob))
+	assert.Equal(t, ErrNameRegistered, err)
+	_, err = call(statedb, bob, RegistryAddress, 0, PackInput("setAddress(bytes32,address)", name, bob))
+	assert.Equal(t, ErrNotNameOwner, err)
+
+	_, err = call(statedb, alice, RegistryAddress, 0, PackInput("transfer(bytes32,address)", name, bob))
+	assert.Nil(t, err)
+	_, err = call(statedb, bob, RegistryAddress, 0, PackInput("setAddress(bytes32,address)", name, bob))
+	assert.Nil(t, err)
+
+	addr, ok := Resolve(statedb, name)
+	assert.True(t, ok)
+	assert.Equal(t, bob, addr)
+	ret, err := call(statedb, alice, RegistryAddress, 0, PackInput("ownerOf(bytes32)", name))
+	assert.Nil(t, err)
+	assert.Equal(t, addressWord(bob).Bytes(), ret)
+}
+
+func TestContract_Run(t *testing.T) {
+	statedb := newTestState(t)
+	ctx := &vm.NativeContext{StateDB: statedb, Address: RegistryAddress, Value: new(big.Int), Gas: GasCall + GasRead - 1}
+	_, err := vm.NativeContracts[RegistryAddress].Run(ctx, PackInput("resolve(bytes32)", common.Hash{}))
+	assert.Equal(t, vm.ErrOutOfGas, err)
+
+	ctx.Gas, ctx.ReadOnly = 1000000, true
+	_, err = vm.NativeContracts[RegistryAddress].Run(ctx, PackInput("register(bytes32,address)", common.Hash{}, alice))
+	assert.Equal(t, ErrWriteProtection, err)
+	_, err = vm.NativeContracts[RegistryAddress].Run(ctx, []byte{1, 2, 3, 4})
+	assert.Equal(t, ErrMethodNotFound, err)
+	_, err = vm.NativeContracts[RegistryAddress].Run(ctx, Selector("resolve(bytes32)"))
+	assert.Equal(t, ErrInvalidInput, err)
+}
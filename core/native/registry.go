@@ -0,0 +1,93 @@
+package native
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"errors"
+)
+
+// Registry slots
+const (
+	ownerPrefix   byte = iota // name -> owner
+	addressPrefix             // name -> address
+)
+
+var (
+	ErrNameRegistered = errors.New("name already registered")
+	ErrNotNameOwner   = errors.New("caller is not owner of name")
+)
+
+// Registry is the name registry contract. Names are registered first come
+// first served, and resolved to addresses set by their owners.
+//
+//	register(bytes32 name, address addr)
+//	setAddress(bytes32 name, address addr)
+//	transfer(bytes32 name, address owner)
+//	resolve(bytes32 name) view returns (address)
+//	ownerOf(bytes32 name) view returns (address)
+func newRegistry() *contract {
+	return newContract(
+		&method{sig: "register(bytes32,address)", args: 2, write: true, fn: registerName},
+		&method{sig: "setAddress(bytes32,address)", args: 2, write: true, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			if err := checkOwner(ctx, args[0]); err != nil {
+				return nil, err
+			}
+			return nil, store(ctx, slot(addressPrefix, args[0].Bytes()), args[1])
+		}},
+		&method{sig: "transfer(bytes32,address)", args: 2, write: true, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			if err := checkOwner(ctx, args[0]); err != nil {
+				return nil, err
+			}
+			return nil, store(ctx, slot(ownerPrefix, args[0].Bytes()), args[1])
+		}},
+		&method{sig: "resolve(bytes32)", args: 1, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			addr, err := load(ctx, slot(addressPrefix, args[0].Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			return output(addr)
+		}},
+		&method{sig: "ownerOf(bytes32)", args: 1, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			owner, err := load(ctx, slot(ownerPrefix, args[0].Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			return output(owner)
+		}},
+	)
+}
+
+func registerName(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+	ownerKey := slot(ownerPrefix, args[0].Bytes())
+	owner, err := load(ctx, ownerKey)
+	if err != nil {
+		return nil, err
+	}
+	if owner != (common.Hash{}) {
+		return nil, ErrNameRegistered
+	}
+	if err := store(ctx, ownerKey, addressWord(ctx.Caller)); err != nil {
+		return nil, err
+	}
+	return nil, store(ctx, slot(addressPrefix, args[0].Bytes()), args[1])
+}
+
+// checkOwner returns ErrNotNameOwner if caller is not the owner of name
+func checkOwner(ctx *vm.NativeContext, name common.Hash) error {
+	owner, err := load(ctx, slot(ownerPrefix, name.Bytes()))
+	if err != nil {
+		return err
+	}
+	if owner != addressWord(ctx.Caller) {
+		return ErrNotNameOwner
+	}
+	return nil
+}
+
+// Resolve returns the address of name, and false if name is not registered
+func Resolve(statedb vm.StateDB, name common.Hash) (common.Address, bool) {
+	if statedb.GetState(RegistryAddress, slot(ownerPrefix, name.Bytes())) == (common.Hash{}) {
+		return common.Address{}, false
+	}
+	return wordAddress(statedb.GetState(RegistryAddress, slot(addressPrefix, name.Bytes()))), true
+}
@@ -0,0 +1,175 @@
+package native
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"errors"
+	"math/big"
+)
+
+// Staking slots
+const (
+	candidatePrefix      byte = iota // candidate -> registered
+	votesPrefix                      // candidate -> total stake voted
+	stakePrefix                      // voter, candidate -> stake
+	totalStakePrefix                 // total stake of all candidates
+	candidateCountPrefix             // number of candidates
+	candidateListPrefix              // index -> candidate
+)
+
+var (
+	ErrCandidateRegistered    = errors.New("candidate already registered")
+	ErrCandidateNotRegistered = errors.New("candidate not registered")
+	ErrInsufficientStake      = errors.New("insufficient stake")
+	ErrZeroValue              = errors.New("zero value")
+)
+
+// Staking is the DPoS staking and voting contract. Accounts register as
+// block producer candidates, and voters stake value to candidates. Stake is
+// held by the contract until unstaked.
+//
+//	register()
+//	stake(address candidate) payable
+//	unstake(address candidate, uint256 amount)
+//	isCandidate(address candidate) view returns (bool)
+//	votes(address candidate) view returns (uint256)
+//	stakeOf(address voter, address candidate) view returns (uint256)
+//	totalStake() view returns (uint256)
+func newStaking() *contract {
+	return newContract(
+		&method{sig: "register()", write: true, fn: register},
+		&method{sig: "stake(address)", args: 1, write: true, payable: true, fn: stake},
+		&method{sig: "unstake(address,uint256)", args: 2, write: true, fn: unstake},
+		&method{sig: "isCandidate(address)", args: 1, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			registered, err := load(ctx, slot(candidatePrefix, wordAddress(args[0]).Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			return output(registered)
+		}},
+		&method{sig: "votes(address)", args: 1, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			votes, err := load(ctx, slot(votesPrefix, wordAddress(args[0]).Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			return output(votes)
+		}},
+		&method{sig: "stakeOf(address,address)", args: 2, fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			stake, err := load(ctx, slot(stakePrefix, wordAddress(args[0]).Bytes(), wordAddress(args[1]).Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			return output(stake)
+		}},
+		&method{sig: "totalStake()", fn: func(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+			total, err := load(ctx, slot(totalStakePrefix))
+			if err != nil {
+				return nil, err
+			}
+			return output(total)
+		}},
+	)
+}
+
+func register(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+	key := slot(candidatePrefix, ctx.Caller.Bytes())
+	registered, err := load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if registered != (common.Hash{}) {
+		return nil, ErrCandidateRegistered
+	}
+	if err := store(ctx, key, boolWord(true)); err != nil {
+		return nil, err
+	}
+	// Append to the candidate list, which is enumerated by consensus
+	count, err := addBig(ctx, slot(candidateCountPrefix), big.NewInt(1))
+	if err != nil {
+		return nil, err
+	}
+	index := common.BigToHash(count.Sub(count, big.NewInt(1)))
+	return nil, store(ctx, slot(candidateListPrefix, index.Bytes()), addressWord(ctx.Caller))
+}
+
+func stake(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+	candidate := wordAddress(args[0])
+	if ctx.Value.Sign() == 0 {
+		return nil, ErrZeroValue
+	}
+	registered, err := load(ctx, slot(candidatePrefix, candidate.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	if registered == (common.Hash{}) {
+		return nil, ErrCandidateNotRegistered
+	}
+	return nil, updateStake(ctx, ctx.Caller, candidate, ctx.Value)
+}
+
+func unstake(ctx *vm.NativeContext, args []common.Hash) ([]byte, error) {
+	candidate := wordAddress(args[0])
+	amount := new(big.Int).SetBytes(args[1][:])
+	if amount.Sign() == 0 {
+		return nil, ErrZeroValue
+	}
+	staked, err := loadBig(ctx, slot(stakePrefix, ctx.Caller.Bytes(), candidate.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	if staked.Cmp(amount) < 0 {
+		return nil, ErrInsufficientStake
+	}
+	if err := updateStake(ctx, ctx.Caller, candidate, new(big.Int).Neg(amount)); err != nil {
+		return nil, err
+	}
+	if err := ctx.UseGas(GasTransfer); err != nil {
+		return nil, err
+	}
+	ctx.Transfer(ctx.StateDB, ctx.Address, ctx.Caller, amount)
+	return nil, nil
+}
+
+// updateStake adds delta to the stake of voter to candidate, and the votes
+func updateStake(ctx *vm.NativeContext, voter, candidate common.Address, delta *big.Int) error {
+	for _, key := range []common.Hash{
+		slot(stakePrefix, voter.Bytes(), candidate.Bytes()),
+		slot(votesPrefix, candidate.Bytes()),
+		slot(totalStakePrefix),
+	} {
+		if _, err := addBig(ctx, key, delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsCandidate reports whether addr is registered as candidate, used by consensus
+func IsCandidate(statedb vm.StateDB, addr common.Address) bool {
+	return statedb.GetState(StakingAddress, slot(candidatePrefix, addr.Bytes())) != common.Hash{}
+}
+
+// Votes returns the stake voted to candidate, used by consensus
+func Votes(statedb vm.StateDB, candidate common.Address) *big.Int {
+	votes := statedb.GetState(StakingAddress, slot(votesPrefix, candidate.Bytes()))
+	return new(big.Int).SetBytes(votes[:])
+}
+
+// Candidates returns all registered candidates in the order of registration,
+// used by consensus
+func Candidates(statedb vm.StateDB) []common.Address {
+	count := statedb.GetState(StakingAddress, slot(candidateCountPrefix))
+	n := new(big.Int).SetBytes(count[:]).Uint64()
+	candidates := make([]common.Address, 0, n)
+	for i := uint64(0); i < n; i++ {
+		index := common.BigToHash(new(big.Int).SetUint64(i))
+		candidates = append(candidates, wordAddress(statedb.GetState(StakingAddress, slot(candidateListPrefix, index.Bytes()))))
+	}
+	return candidates
+}
+
+// TotalStake returns the stake voted to all candidates
+func TotalStake(statedb vm.StateDB) *big.Int {
+	total := statedb.GetState(StakingAddress, slot(totalStakePrefix))
+	return new(big.Int).SetBytes(total[:])
+}
@@ -4,35 +4,74 @@ import (
 	"tinychain/db/leveldb"
 	"github.com/hashicorp/golang-lru"
 	"tinychain/common"
+	"encoding/binary"
+	"errors"
+	"sync"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
 )
 
 const (
 	cacheSize       = 128
+	sizeCacheSize   = 4096
 	KeyContractCode = "c"
+	KeyCodeMeta     = "m"
+	KeyOrphanCode   = "d"
 )
 
-// CacheDB is used to store contract code
+var (
+	ErrInvalidCodeMeta = errors.New("invalid code metadata")
+
+	// Code references of databases, *leveldb.LDBDatabase => *codeRefs
+	codeRefsOfDB sync.Map
+)
+
+// codeRefs guards code references of a database, which are shared by state
+// dbs of the database and the pruner
+type codeRefs struct {
+	lock sync.Mutex
+	// Code referenced since pruning started, which must not be removed.
+	// It's nil if no pruning is running.
+	touched map[common.Hash]struct{}
+}
+
+func getCodeRefs(db *leveldb.LDBDatabase) *codeRefs {
+	refs, _ := codeRefsOfDB.LoadOrStore(db, &codeRefs{})
+	return refs.(*codeRefs)
+}
+
+// CacheDB is used to store contract code, which is deduplicated by code hash
+// and counted by references of accounts in committed states
 // "c" + contract_code_hash => code
+// "m" + contract_code_hash => uvarint(code size) | uvarint(references)
+// "d" + contract_code_hash => nil, code without references waiting for pruning
+//
+// References may be overcounted, e.g. by reverted blocks, which only delays the
+// removal. Pruning never removes code held by retained states, so code stored
+// before metadata was introduced is safe though its references are not counted.
 
 type cacheDB struct {
 	db        *leveldb.LDBDatabase
+	refs      *codeRefs
 	codeCache *lru.Cache
+	sizeCache *lru.Cache
 }
 
 func newCacheDB(db *leveldb.LDBDatabase) *cacheDB {
 	l, _ := lru.New(cacheSize)
+	sizes, _ := lru.New(sizeCacheSize)
 	return &cacheDB{
 		db:        db,
+		refs:      getCodeRefs(db),
 		codeCache: l,
+		sizeCache: sizes,
 	}
 }
 
 func (db *cacheDB) GetCode(codeHash common.Hash) ([]byte, error) {
-	if code, ok := db.codeCache.Get(codeHash.Bytes()); ok {
+	if code, ok := db.codeCache.Get(codeHash); ok {
 		return code.([]byte), nil
 	}
-	key := append([]byte(KeyContractCode), codeHash.Bytes()...)
-	code, err := db.db.Get(key)
+	code, err := db.db.Get(codeKey(codeHash))
 	if err != nil {
 		log.Errorf("Failed to get code with hash %s,%s", codeHash, err)
 		return nil, err
@@ -41,13 +80,198 @@ func (db *cacheDB) GetCode(codeHash common.Hash) ([]byte, error) {
 	return code, nil
 }
 
+// GetCodeSize returns the code size in metadata without loading the code
+func (db *cacheDB) GetCodeSize(codeHash common.Hash) (int, error) {
+	if size, ok := db.sizeCache.Get(codeHash); ok {
+		return size.(int), nil
+	}
+	if code, ok := db.codeCache.Get(codeHash); ok {
+		return len(code.([]byte)), nil
+	}
+	meta, err := readCodeMeta(db.db, codeHash)
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	if meta != nil {
+		size = meta.size
+	} else {
+		// Code without metadata
+		code, err := db.GetCode(codeHash)
+		if err != nil {
+			return 0, err
+		}
+		size = len(code)
+	}
+	db.sizeCache.Add(codeHash, size)
+	return size, nil
+}
+
+// PutCode adds a reference to code, and stores the code if it's new
 func (db *cacheDB) PutCode(codeHash common.Hash, code []byte) error {
-	key := append([]byte(KeyContractCode), codeHash.Bytes()...)
-	err := db.db.Put(key, code)
+	db.refs.lock.Lock()
+	defer db.refs.lock.Unlock()
+	meta, err := readCodeMeta(db.db, codeHash)
 	if err != nil {
+		return err
+	}
+	batch := db.db.NewBatch()
+	if meta == nil {
+		meta = &codeMeta{size: len(code)}
+		batch.Put(codeKey(codeHash), code)
+	} else if meta.refs == 0 {
+		batch.Delete(orphanCodeKey(codeHash))
+	}
+	meta.refs++
+	batch.Put(codeMetaKey(codeHash), meta.encode())
+	if err := batch.Write(); err != nil {
 		log.Errorf("Failed to put code with hash %s, %s", codeHash, err)
 		return err
 	}
+	if db.refs.touched != nil {
+		db.refs.touched[codeHash] = struct{}{}
+	}
+	db.sizeCache.Add(codeHash, meta.size)
+	return nil
+}
+
+// ReleaseCode removes a reference to code. Code without references is
+// removed by pruning, unless it's held by retained states.
+func (db *cacheDB) ReleaseCode(codeHash common.Hash) error {
+	db.refs.lock.Lock()
+	defer db.refs.lock.Unlock()
+	meta, err := readCodeMeta(db.db, codeHash)
+	if err != nil || meta == nil || meta.refs == 0 {
+		return err
+	}
+	meta.refs--
+	batch := db.db.NewBatch()
+	batch.Put(codeMetaKey(codeHash), meta.encode())
+	if meta.refs == 0 {
+		batch.Put(orphanCodeKey(codeHash), nil)
+	}
+	if err := batch.Write(); err != nil {
+		log.Errorf("Failed to release code with hash %s, %s", codeHash, err)
+		return err
+	}
 	return nil
 }
 
+// CodeRefs returns the references of code, and false if code has no metadata
+func CodeRefs(db *leveldb.LDBDatabase, codeHash common.Hash) (uint64, bool, error) {
+	refs := getCodeRefs(db)
+	refs.lock.Lock()
+	defer refs.lock.Unlock()
+	meta, err := readCodeMeta(db, codeHash)
+	if err != nil || meta == nil {
+		return 0, false, err
+	}
+	return meta.refs, true, nil
+}
+
+// startCodePruning starts tracking code of db referenced during pruning
+func startCodePruning(db *leveldb.LDBDatabase) {
+	refs := getCodeRefs(db)
+	refs.lock.Lock()
+	defer refs.lock.Unlock()
+	if refs.touched == nil {
+		refs.touched = make(map[common.Hash]struct{})
+	}
+}
+
+// pruneCode removes code without references, except the live code held by
+// retained states and the code referenced since pruning started. It returns
+// the amount of removed code.
+func pruneCode(db *leveldb.LDBDatabase, live map[common.Hash]struct{}) (int, error) {
+	var orphans []common.Hash
+	it := db.NewIterator([]byte(KeyOrphanCode))
+	for it.Next() {
+		if key := it.Key(); len(key) == len(KeyOrphanCode)+common.HashLength {
+			orphans = append(orphans, common.BytesToHash(key[len(KeyOrphanCode):]))
+		}
+	}
+	it.Release()
+
+	refs := getCodeRefs(db)
+	refs.lock.Lock()
+	defer refs.lock.Unlock()
+	defer func() { refs.touched = nil }()
+	batch := db.NewBatch()
+	deleted := 0
+	for _, codeHash := range orphans {
+		if _, ok := live[codeHash]; ok {
+			continue
+		}
+		if _, ok := refs.touched[codeHash]; ok {
+			continue
+		}
+		meta, err := readCodeMeta(db, codeHash)
+		if err != nil {
+			return 0, err
+		}
+		if meta != nil && meta.refs > 0 {
+			continue
+		}
+		batch.Delete(codeKey(codeHash))
+		batch.Delete(codeMetaKey(codeHash))
+		batch.Delete(orphanCodeKey(codeHash))
+		deleted++
+	}
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	if deleted > 0 {
+		log.Infof("Pruned %d contract code without references", deleted)
+	}
+	return deleted, nil
+}
+
+// codeMeta is the metadata of contract code
+type codeMeta struct {
+	size int
+	refs uint64 // References of accounts in committed states
+}
+
+func (m *codeMeta) encode() []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(m.size))
+	n += binary.PutUvarint(buf[n:], m.refs)
+	return buf[:n]
+}
+
+// readCodeMeta returns nil if code has no metadata
+func readCodeMeta(db *leveldb.LDBDatabase, codeHash common.Hash) (*codeMeta, error) {
+	data, err := db.LDB().Get(codeMetaKey(codeHash), nil)
+	if err == goleveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	size, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, ErrInvalidCodeMeta
+	}
+	refs, m := binary.Uvarint(data[n:])
+	if m <= 0 {
+		return nil, ErrInvalidCodeMeta
+	}
+	return &codeMeta{size: int(size), refs: refs}, nil
+}
+
+func codeKey(codeHash common.Hash) []byte {
+	return append([]byte(KeyContractCode), codeHash.Bytes()...)
+}
+
+func codeMetaKey(codeHash common.Hash) []byte {
+	return append([]byte(KeyCodeMeta), codeHash.Bytes()...)
+}
+
+func orphanCodeKey(codeHash common.Hash) []byte {
+	return append([]byte(KeyOrphanCode), codeHash.Bytes()...)
+}
+
+// hasCode reports whether code hash is of non-empty code
+func hasCode(codeHash common.Hash) bool {
+	return !codeHash.Nil() && codeHash != emptyCodeHash
+}
@@ -0,0 +1,79 @@
+package state
+
+import (
+	"tinychain/common"
+	"tinychain/bmt"
+	"tinychain/db/leveldb"
+)
+
+type StorageDiff struct {
+	Key common.Hash
+	Old common.Hash // zero if the slot is added
+	New common.Hash // zero if the slot is removed
+}
+
+type AccountDiff struct {
+	Address common.Address
+	Old     *Account // nil if the account is added
+	New     *Account // nil if the account is removed
+	Storage []*StorageDiff
+}
+
+func (diff *AccountDiff) Kind() string {
+	switch {
+	case diff.Old == nil:
+		return "added"
+	case diff.New == nil:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// DiffState compares the state of rootA in dbA with the state of rootB in dbB,
+// and returns added, removed and changed accounts with their storage diffs.
+// dbA and dbB could be the same database.
+func DiffState(dbA *leveldb.LDBDatabase, rootA common.Hash, dbB *leveldb.LDBDatabase, rootB common.Hash) ([]*AccountDiff, error) {
+	var (
+		diffs   []*AccountDiff
+		diffErr error
+	)
+	err := bmt.DiffTrees(dbA, rootA, dbB, rootB, func(key, oldValue, newValue []byte) bool {
+		diff := &AccountDiff{Address: common.BytesToAddress(key)}
+		var storageA, storageB common.Hash
+		if oldValue != nil {
+			diff.Old = &Account{}
+			if diffErr = diff.Old.Deserialize(oldValue); diffErr != nil {
+				return false
+			}
+			storageA = diff.Old.Root
+		}
+		if newValue != nil {
+			diff.New = &Account{}
+			if diffErr = diff.New.Deserialize(newValue); diffErr != nil {
+				return false
+			}
+			storageB = diff.New.Root
+		}
+		diffErr = bmt.DiffTrees(dbA, storageA, dbB, storageB, func(key, oldValue, newValue []byte) bool {
+			diff.Storage = append(diff.Storage, &StorageDiff{
+				Key: common.BytesToHash(key),
+				Old: common.BytesToHash(oldValue),
+				New: common.BytesToHash(newValue),
+			})
+			return true
+		})
+		if diffErr != nil {
+			return false
+		}
+		diffs = append(diffs, diff)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if diffErr != nil {
+		return nil, diffErr
+	}
+	return diffs, nil
+}
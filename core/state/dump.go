@@ -0,0 +1,465 @@
+package state
+
+import (
+	"tinychain/common"
+	"tinychain/bmt"
+	"tinychain/db/leveldb"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"sort"
+	"bytes"
+	"strings"
+	json "github.com/json-iterator/go"
+)
+
+/*
+	State dump exports all accounts and storage slots of a state root.
+
+	JSON:   {"root": "0x..", "tree": {"capacity": 4096, "aggreation": 16, "scheme": 0},
+	        "accounts": [{"address": "0x..", "balance": "100", "nonce": 1, "root": "0x..",
+	        "code_hash": "0x..", "code": "0x..", "storage": {"0x..": "0x.."}, "storage_tree": {..}}]}
+	Binary: magic | version | root | tree | uvarint(n) | n * account
+	        account: address | uvarint(len(balance)) balance | uvarint(nonce) | root | code_hash |
+	                 uvarint(len(code)) code | uvarint(m) | m * (key value), sorted by key | tree if m > 0
+	        tree: uvarint(capacity) | uvarint(aggreation) | uvarint(scheme)
+
+	Accounts are sorted by address in both formats, so the same state always
+	produces the same dump. The shape of bucket trees is kept, so that the roots
+	of trees committed with another shape or hash scheme can be rebuilt. Binary
+	dumps of version 1 have no tree shape, which is the configured one.
+*/
+
+const (
+	dumpMagic   = 0xd5
+	dumpVersion = 2
+)
+
+var (
+	ErrInvalidDump  = errors.New("invalid state dump")
+	ErrRootMismatch = errors.New("state root mismatch")
+	ErrCodeMismatch = errors.New("code hash mismatch")
+
+	// Keep map keys sorted in json
+	dumpJSON = json.ConfigCompatibleWithStandardLibrary
+)
+
+type DumpAccount struct {
+	Address  common.Address
+	Balance  *big.Int
+	Nonce    uint64
+	Root     common.Hash // storage root
+	CodeHash common.Hash
+	Code     []byte
+	Storage  Storage
+	Tree     *bmt.Config // shape of storage tree, nil if storage is empty or unknown
+}
+
+type Dump struct {
+	Root     common.Hash
+	Tree     *bmt.Config // shape of state tree, nil if unknown
+	Accounts []*DumpAccount
+}
+
+// DumpState dumps all accounts of state root in db
+func DumpState(db *leveldb.LDBDatabase, root common.Hash) (*Dump, error) {
+	rs, err := NewReadOnlyState(db, root)
+	if err != nil {
+		return nil, err
+	}
+	dump := &Dump{Root: root, Tree: rs.sdb.bmt.Config()}
+	rs.ForEachAccount(func(addr common.Address, account *Account) bool {
+		da := &DumpAccount{
+			Address:  addr,
+			Balance:  new(big.Int).Set(account.Balance),
+			Nonce:    account.Nonce,
+			Root:     account.Root,
+			CodeHash: account.CodeHash,
+			Code:     rs.GetCode(addr),
+			Storage:  make(Storage),
+		}
+		rs.ForEachStorage(addr, func(key, value common.Hash) bool {
+			da.Storage[key] = value
+			return true
+		})
+		if len(da.Storage) > 0 {
+			da.Tree = rs.sdb.StateBmt(addr).Config()
+		}
+		dump.Accounts = append(dump.Accounts, da)
+		return true
+	})
+	sort.Slice(dump.Accounts, func(i, j int) bool {
+		return bytes.Compare(dump.Accounts[i].Address[:], dump.Accounts[j].Address[:]) < 0
+	})
+	return dump, nil
+}
+
+// ImportDump rebuilds the state of dump into db, and verifies the state root.
+// Bucket trees are built in memory and written after all roots are verified,
+// so an invalid dump leaves nothing in db. Accounts considered empty are kept
+// as they are.
+func ImportDump(db *leveldb.LDBDatabase, dump *Dump) (common.Hash, error) {
+	stateConfig := dump.Tree
+	if stateConfig == nil {
+		stateConfig = StateTreeConfig
+	}
+	var (
+		accounts = bmt.NewWriteSet()
+		storages []*bmt.BucketTree
+		codes    = make(map[common.Hash][]byte)
+	)
+	for _, da := range dump.Accounts {
+		if da.Balance == nil {
+			return common.Hash{}, ErrInvalidDump
+		}
+		// Rebuild storage tree
+		var storageRoot common.Hash
+		if len(da.Storage) > 0 {
+			config := da.Tree
+			if config == nil {
+				config = &bmt.Config{
+					Capacity:   StorageTreeConfig.Capacity,
+					Aggreation: StorageTreeConfig.Aggreation,
+					Scheme:     stateConfig.Scheme,
+				}
+			}
+			tree := bmt.NewBucketTreeWithConfig(db, config)
+			if err := tree.Init(nil); err != nil {
+				return common.Hash{}, err
+			}
+			slots := bmt.NewWriteSet()
+			for key, value := range da.Storage {
+				slots[key.String()] = value.Bytes()
+			}
+			if err := tree.Prepare(slots); err != nil {
+				return common.Hash{}, err
+			}
+			root, err := tree.Process()
+			if err != nil {
+				return common.Hash{}, err
+			}
+			storageRoot = root
+			storages = append(storages, tree)
+		}
+		if storageRoot != da.Root {
+			log.Errorf("Storage root of %s mismatch, expect %x, got %x", common.Hex(da.Address[:]), da.Root, storageRoot)
+			return common.Hash{}, ErrRootMismatch
+		}
+		// Check contract code
+		if len(da.Code) > 0 {
+			if common.Sha256(da.Code) != da.CodeHash {
+				return common.Hash{}, ErrCodeMismatch
+			}
+			codes[da.CodeHash] = da.Code
+		}
+		account := &Account{
+			Nonce:    da.Nonce,
+			Balance:  da.Balance,
+			Root:     storageRoot,
+			CodeHash: da.CodeHash,
+		}
+		data, err := account.Serialize()
+		if err != nil {
+			return common.Hash{}, err
+		}
+		accounts[da.Address.String()] = data
+	}
+
+	tree := bmt.NewBucketTreeWithConfig(db, stateConfig)
+	if err := tree.Init(nil); err != nil {
+		return common.Hash{}, err
+	}
+	if err := tree.Prepare(accounts); err != nil {
+		return common.Hash{}, err
+	}
+	root, err := tree.Process()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if root != dump.Root {
+		log.Errorf("State root mismatch, expect %x, got %x", dump.Root, root)
+		return root, ErrRootMismatch
+	}
+
+	// Write storage and code before the state tree, so that the state root
+	// is available only if it's complete
+	for _, storage := range storages {
+		if err := storage.Commit(); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	cdb := newCacheDB(db)
+	for _, da := range dump.Accounts {
+		if code, ok := codes[da.CodeHash]; ok {
+			if err := cdb.PutCode(da.CodeHash, code); err != nil {
+				return common.Hash{}, err
+			}
+		}
+	}
+	if err := tree.Commit(); err != nil {
+		return common.Hash{}, err
+	}
+	return root, nil
+}
+
+type jsonTreeConfig struct {
+	Capacity   int            `json:"capacity"`
+	Aggreation int            `json:"aggreation"`
+	Scheme     bmt.HashScheme `json:"scheme"`
+}
+
+func newJSONTreeConfig(config *bmt.Config) *jsonTreeConfig {
+	if config == nil {
+		return nil
+	}
+	return &jsonTreeConfig{config.Capacity, config.Aggreation, config.Scheme}
+}
+
+func (c *jsonTreeConfig) config() *bmt.Config {
+	if c == nil {
+		return nil
+	}
+	return &bmt.Config{Capacity: c.Capacity, Aggreation: c.Aggreation, Scheme: c.Scheme}
+}
+
+type jsonDumpAccount struct {
+	Address  string            `json:"address"`
+	Balance  string            `json:"balance"`
+	Nonce    uint64            `json:"nonce"`
+	Root     string            `json:"root"`
+	CodeHash string            `json:"code_hash"`
+	Code     string            `json:"code,omitempty"`
+	Storage  map[string]string `json:"storage,omitempty"`
+	Tree     *jsonTreeConfig   `json:"storage_tree,omitempty"`
+}
+
+type jsonDump struct {
+	Root     string             `json:"root"`
+	Tree     *jsonTreeConfig    `json:"tree,omitempty"`
+	Accounts []*jsonDumpAccount `json:"accounts"`
+}
+
+func (d *Dump) EncodeJSON() ([]byte, error) {
+	jd := &jsonDump{Root: string(common.Hex(d.Root[:])), Tree: newJSONTreeConfig(d.Tree)}
+	for _, da := range d.Accounts {
+		ja := &jsonDumpAccount{
+			Address:  string(common.Hex(da.Address[:])),
+			Balance:  da.Balance.String(),
+			Nonce:    da.Nonce,
+			Root:     string(common.Hex(da.Root[:])),
+			CodeHash: string(common.Hex(da.CodeHash[:])),
+			Tree:     newJSONTreeConfig(da.Tree),
+		}
+		if len(da.Code) > 0 {
+			ja.Code = string(common.Hex(da.Code))
+		}
+		if len(da.Storage) > 0 {
+			ja.Storage = make(map[string]string)
+			for key, value := range da.Storage {
+				ja.Storage[string(common.Hex(key[:]))] = string(common.Hex(value[:]))
+			}
+		}
+		jd.Accounts = append(jd.Accounts, ja)
+	}
+	return dumpJSON.MarshalIndent(jd, "", "  ")
+}
+
+func DecodeDumpJSON(data []byte) (*Dump, error) {
+	jd := &jsonDump{}
+	if err := dumpJSON.Unmarshal(data, jd); err != nil {
+		return nil, err
+	}
+	var err error
+	dump := &Dump{Tree: jd.Tree.config()}
+	if dump.Root, err = decodeHexHash(jd.Root); err != nil {
+		return nil, err
+	}
+	for _, ja := range jd.Accounts {
+		da := &DumpAccount{Nonce: ja.Nonce, Storage: make(Storage), Tree: ja.Tree.config()}
+		addr, err := decodeHex(ja.Address)
+		if err != nil || len(addr) != common.AddressLength {
+			return nil, ErrInvalidDump
+		}
+		da.Address = common.BytesToAddress(addr)
+		balance, ok := new(big.Int).SetString(ja.Balance, 10)
+		if !ok {
+			return nil, ErrInvalidDump
+		}
+		da.Balance = balance
+		if da.Root, err = decodeHexHash(ja.Root); err != nil {
+			return nil, err
+		}
+		if da.CodeHash, err = decodeHexHash(ja.CodeHash); err != nil {
+			return nil, err
+		}
+		if ja.Code != "" {
+			if da.Code, err = decodeHex(ja.Code); err != nil {
+				return nil, err
+			}
+		}
+		for k, v := range ja.Storage {
+			key, err := decodeHexHash(k)
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeHexHash(v)
+			if err != nil {
+				return nil, err
+			}
+			da.Storage[key] = value
+		}
+		dump.Accounts = append(dump.Accounts, da)
+	}
+	return dump, nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, ErrInvalidDump
+	}
+	return b, nil
+}
+
+func decodeHexHash(s string) (common.Hash, error) {
+	b, err := decodeHex(s)
+	if err != nil || len(b) != common.HashLength {
+		return common.Hash{}, ErrInvalidDump
+	}
+	return common.BytesToHash(b), nil
+}
+
+func (d *Dump) EncodeBinary() []byte {
+	buf := []byte{dumpMagic, dumpVersion}
+	putUvarint := func(v uint64) {
+		var b [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(b[:], v)
+		buf = append(buf, b[:n]...)
+	}
+	putBytes := func(b []byte) {
+		putUvarint(uint64(len(b)))
+		buf = append(buf, b...)
+	}
+
+	putTree := func(config *bmt.Config) {
+		if config == nil {
+			config = &bmt.Config{}
+		}
+		putUvarint(uint64(config.Capacity))
+		putUvarint(uint64(config.Aggreation))
+		putUvarint(uint64(config.Scheme))
+	}
+
+	buf = append(buf, d.Root[:]...)
+	putTree(d.Tree)
+	putUvarint(uint64(len(d.Accounts)))
+	for _, da := range d.Accounts {
+		buf = append(buf, da.Address[:]...)
+		putBytes(da.Balance.Bytes())
+		putUvarint(da.Nonce)
+		buf = append(buf, da.Root[:]...)
+		buf = append(buf, da.CodeHash[:]...)
+		putBytes(da.Code)
+
+		keys := make([]common.Hash, 0, len(da.Storage))
+		for key := range da.Storage {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i][:], keys[j][:]) < 0
+		})
+		putUvarint(uint64(len(keys)))
+		for _, key := range keys {
+			value := da.Storage[key]
+			buf = append(buf, key[:]...)
+			buf = append(buf, value[:]...)
+		}
+		if len(keys) > 0 {
+			putTree(da.Tree)
+		}
+	}
+	return buf
+}
+
+func DecodeDumpBinary(data []byte) (*Dump, error) {
+	if len(data) < 2 || data[0] != dumpMagic || data[1] == 0 || data[1] > dumpVersion {
+		return nil, ErrInvalidDump
+	}
+	version := data[1]
+	buf := data[2:]
+	var err error
+	uvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			err = ErrInvalidDump
+			return 0
+		}
+		buf = buf[n:]
+		return v
+	}
+	fixed := func(l uint64) []byte {
+		if err != nil {
+			return nil
+		}
+		if uint64(len(buf)) < l {
+			err = ErrInvalidDump
+			return nil
+		}
+		b := buf[:l]
+		buf = buf[l:]
+		return b
+	}
+	varBytes := func() []byte {
+		return fixed(uvarint())
+	}
+	// Zero shape is unknown
+	tree := func() *bmt.Config {
+		if version < 2 {
+			return nil
+		}
+		config := &bmt.Config{
+			Capacity:   int(uvarint()),
+			Aggreation: int(uvarint()),
+			Scheme:     bmt.HashScheme(uvarint()),
+		}
+		if config.Capacity == 0 {
+			return nil
+		}
+		return config
+	}
+
+	dump := &Dump{Root: common.BytesToHash(fixed(common.HashLength))}
+	dump.Tree = tree()
+	n := uvarint()
+	for i := uint64(0); i < n && err == nil; i++ {
+		da := &DumpAccount{Storage: make(Storage)}
+		da.Address = common.BytesToAddress(fixed(common.AddressLength))
+		da.Balance = new(big.Int).SetBytes(varBytes())
+		da.Nonce = uvarint()
+		da.Root = common.BytesToHash(fixed(common.HashLength))
+		da.CodeHash = common.BytesToHash(fixed(common.HashLength))
+		if code := varBytes(); len(code) > 0 {
+			da.Code = append([]byte{}, code...)
+		}
+		m := uvarint()
+		for j := uint64(0); j < m && err == nil; j++ {
+			key := common.BytesToHash(fixed(common.HashLength))
+			da.Storage[key] = common.BytesToHash(fixed(common.HashLength))
+		}
+		if m > 0 {
+			da.Tree = tree()
+		}
+		dump.Accounts = append(dump.Accounts, da)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > 0 {
+		return nil, ErrInvalidDump
+	}
+	return dump, nil
+}
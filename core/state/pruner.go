@@ -0,0 +1,190 @@
+package state
+
+import (
+	"tinychain/common"
+	"tinychain/bmt"
+	"tinychain/db/leveldb"
+	"math/big"
+	"sync"
+)
+
+// "k" + state root => nil, finalized state retained by pruner
+const KeyCheckpoint = "k"
+
+type PrunerConfig struct {
+	KeepRecent         int    // Amount of recent state roots to retain
+	Interval           int    // Prune once every {Interval} commits
+	CheckpointInterval uint64 // Retain state of finalized blocks every {CheckpointInterval} heights, 0 means never
+}
+
+func DefaultPrunerConfig() *PrunerConfig {
+	return &PrunerConfig{
+		KeepRecent:         128,
+		Interval:           64,
+		CheckpointInterval: 4096,
+	}
+}
+
+// Pruner removes historical state which is neither one of the recent
+// state roots nor a finalized checkpoint, and contract code which is not
+// referenced any more.
+type Pruner struct {
+	config *PrunerConfig
+	db     *leveldb.LDBDatabase
+	pruner *bmt.Pruner
+
+	mu          sync.Mutex
+	recent      []common.Hash            // recent committed state roots, in commit order
+	checkpoints map[common.Hash]struct{} // finalized state roots
+	commits     int                      // commits since last pruning
+	wg          sync.WaitGroup
+}
+
+// NewPruner creates a pruner, which retains the checkpoints stored in db
+func NewPruner(db *leveldb.LDBDatabase, config *PrunerConfig) *Pruner {
+	p := &Pruner{
+		config:      config,
+		db:          db,
+		pruner:      bmt.NewPruner(db),
+		checkpoints: make(map[common.Hash]struct{}),
+	}
+	for _, root := range Checkpoints(db) {
+		p.checkpoints[root] = struct{}{}
+	}
+	return p
+}
+
+// AddCheckpoint retains the state of root permanently
+func (p *Pruner) AddCheckpoint(root common.Hash) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.checkpoints[root]; ok {
+		return nil
+	}
+	if err := p.db.Put(checkpointKey(root), nil); err != nil {
+		log.Errorf("Failed to put checkpoint %s, %s", root, err)
+		return err
+	}
+	p.checkpoints[root] = struct{}{}
+	return nil
+}
+
+// Finalize is called with the state root of a finalized block, which is
+// retained as checkpoint every {CheckpointInterval} heights
+func (p *Pruner) Finalize(height *big.Int, root common.Hash) error {
+	interval := p.config.CheckpointInterval
+	if interval == 0 || new(big.Int).Mod(height, new(big.Int).SetUint64(interval)).Sign() != 0 {
+		return nil
+	}
+	return p.AddCheckpoint(root)
+}
+
+// Commit records a committed state root, and launches pruning in background
+// every {Interval} commits. It never blocks, and skips if last pruning
+// is still running.
+func (p *Pruner) Commit(root common.Hash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.recent = append(p.recent, root)
+	if len(p.recent) > p.config.KeepRecent {
+		p.recent = p.recent[len(p.recent)-p.config.KeepRecent:]
+	}
+	p.commits++
+	if p.commits < p.config.Interval {
+		return
+	}
+	// Start tracking writes before next commit
+	if err := p.pruner.Prepare(); err != nil {
+		return
+	}
+	startCodePruning(p.db)
+	p.commits = 0
+	roots := p.roots()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if _, err := pruneState(p.db, p.pruner, roots); err != nil {
+			log.Errorf("Failed to prune state, %s", err)
+		}
+	}()
+}
+
+// Prune removes historical state synchronously.
+func (p *Pruner) Prune() (int, error) {
+	p.mu.Lock()
+	roots := p.roots()
+	p.mu.Unlock()
+	return pruneState(p.db, p.pruner, roots)
+}
+
+// Wait waits for the background pruning to finish
+func (p *Pruner) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Pruner) Close() {
+	p.Wait()
+	p.pruner.Close()
+}
+
+// Collect retained roots
+func (p *Pruner) roots() []common.Hash {
+	var roots []common.Hash
+	roots = append(roots, p.recent...)
+	for root := range p.checkpoints {
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// pruneState removes state trees and code which are not held by roots
+func pruneState(db *leveldb.LDBDatabase, pruner *bmt.Pruner, roots []common.Hash) (int, error) {
+	startCodePruning(db)
+	live := make(map[common.Hash]struct{})
+	deleted, err := pruner.Prune(roots, stateResolver(live))
+	if err != nil {
+		return deleted, err
+	}
+	codes, err := pruneCode(db, live)
+	return deleted + codes, err
+}
+
+// stateResolver resolves storage root of account, and collects the code
+// hashes of accounts into live
+func stateResolver(live map[common.Hash]struct{}) bmt.Resolver {
+	return func(value []byte) []common.Hash {
+		account := &Account{}
+		if err := account.Deserialize(value); err != nil {
+			return nil
+		}
+		if hasCode(account.CodeHash) {
+			live[account.CodeHash] = struct{}{}
+		}
+		return []common.Hash{account.Root}
+	}
+}
+
+// Checkpoints returns the checkpoints stored in db
+func Checkpoints(db *leveldb.LDBDatabase) []common.Hash {
+	var roots []common.Hash
+	it := db.NewIterator([]byte(KeyCheckpoint))
+	defer it.Release()
+	for it.Next() {
+		if key := it.Key(); len(key) == len(KeyCheckpoint)+common.HashLength {
+			roots = append(roots, common.BytesToHash(key[len(KeyCheckpoint):]))
+		}
+	}
+	return roots
+}
+
+func checkpointKey(root common.Hash) []byte {
+	return append([]byte(KeyCheckpoint), root.Bytes()...)
+}
+
+// PruneState removes all state except trees of given roots.
+// It is used to prune an offline database.
+func PruneState(db *leveldb.LDBDatabase, roots []common.Hash) (int, error) {
+	pruner := bmt.NewPruner(db)
+	defer pruner.Close()
+	return pruneState(db, pruner, roots)
+}
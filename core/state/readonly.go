@@ -0,0 +1,78 @@
+package state
+
+import (
+	"tinychain/common"
+	"tinychain/db/leveldb"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrStateNotFound = errors.New("state not found, it may be pruned")
+)
+
+// ReadOnlyState is a read-only view of the state at a certain root,
+// which is used for historical queries.
+type ReadOnlyState struct {
+	root common.Hash
+	sdb  *StateDB
+}
+
+func NewReadOnlyState(db *leveldb.LDBDatabase, root common.Hash) (*ReadOnlyState, error) {
+	var rootBytes []byte
+	if !root.Nil() {
+		rootBytes = root.Bytes()
+	}
+	sdb := New(db, rootBytes)
+	if sdb == nil {
+		return nil, ErrStateNotFound
+	}
+	return &ReadOnlyState{
+		root: root,
+		sdb:  sdb,
+	}, nil
+}
+
+func (rs *ReadOnlyState) Root() common.Hash {
+	return rs.root
+}
+
+func (rs *ReadOnlyState) Exist(addr common.Address) bool {
+	return rs.sdb.Exist(addr)
+}
+
+func (rs *ReadOnlyState) GetBalance(addr common.Address) *big.Int {
+	return new(big.Int).Set(rs.sdb.GetBalance(addr))
+}
+
+func (rs *ReadOnlyState) GetNonce(addr common.Address) uint64 {
+	return rs.sdb.GetNonce(addr)
+}
+
+func (rs *ReadOnlyState) GetCode(addr common.Address) []byte {
+	code := rs.sdb.GetCode(addr)
+	if code == nil {
+		return nil
+	}
+	return append([]byte{}, code...)
+}
+
+func (rs *ReadOnlyState) GetCodeSize(addr common.Address) int {
+	return rs.sdb.GetCodeSize(addr)
+}
+
+func (rs *ReadOnlyState) GetCodeHash(addr common.Address) common.Hash {
+	return rs.sdb.GetCodeHash(addr)
+}
+
+func (rs *ReadOnlyState) GetState(addr common.Address, key common.Hash) common.Hash {
+	return rs.sdb.GetState(addr, key)
+}
+
+func (rs *ReadOnlyState) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	rs.sdb.ForEachStorage(addr, cb)
+}
+
+func (rs *ReadOnlyState) ForEachAccount(cb func(addr common.Address, account *Account) bool) {
+	rs.sdb.ForEachAccount(cb)
+}
@@ -0,0 +1,386 @@
+package state
+
+import (
+	"tinychain/common"
+	"tinychain/db/leveldb"
+	"errors"
+	"sync"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+	Snapshot is a flat view of accounts and storage slots, which serves reads of
+	state with a single db lookup instead of walking bucket trees.
+
+	It consists of a disk layer persisted in db, and a tree of diff layers in
+	memory upon it, one for each recent commit keyed by its state root. Forks
+	are kept as branches of the tree. When a branch is deeper than the limit,
+	its bottom diff layer is flattened into disk layer, and the branches which
+	are not built upon the flattened layer are discarded.
+
+	"a" + address => serialized account
+	"o" + address + slot key => slot value
+	"SnapshotRoot" => state root of disk layer
+*/
+
+const (
+	KeySnapshotAccount = "a"
+	KeySnapshotStorage = "o"
+	KeySnapshotRoot    = "SnapshotRoot"
+)
+
+var (
+	ErrSnapshotStale   = errors.New("snapshot layer is stale")
+	ErrSnapshotMissing = errors.New("snapshot layer of parent root not found")
+)
+
+type SnapshotConfig struct {
+	Layers int // Amount of diff layers kept in memory for each branch
+}
+
+func DefaultSnapshotConfig() *SnapshotConfig {
+	return &SnapshotConfig{
+		Layers: 128,
+	}
+}
+
+func snapshotAccountKey(addr common.Address) []byte {
+	return append([]byte(KeySnapshotAccount), addr.Bytes()...)
+}
+
+func snapshotStorageKey(addr common.Address, key common.Hash) []byte {
+	buf := make([]byte, 0, 1+common.AddressLength+common.HashLength)
+	buf = append(buf, KeySnapshotStorage...)
+	buf = append(buf, addr.Bytes()...)
+	return append(buf, key.Bytes()...)
+}
+
+// snapshotLayer is a read-only view of state at root
+type snapshotLayer interface {
+	Root() common.Hash
+
+	// Account returns serialized account, or nil if account does not exist
+	Account(addr common.Address) ([]byte, error)
+
+	// Storage returns slot value, or zero if slot does not exist
+	Storage(addr common.Address, key common.Hash) (common.Hash, error)
+}
+
+type diskLayer struct {
+	snaps *Snapshots
+	root  common.Hash
+	stale bool
+}
+
+func (dl *diskLayer) Root() common.Hash {
+	return dl.root
+}
+
+func (dl *diskLayer) Account(addr common.Address) ([]byte, error) {
+	dl.snaps.lock.RLock()
+	defer dl.snaps.lock.RUnlock()
+	return dl.account(addr)
+}
+
+func (dl *diskLayer) account(addr common.Address) ([]byte, error) {
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	data, err := dl.snaps.db.LDB().Get(snapshotAccountKey(addr), nil)
+	if err == goleveldb.ErrNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (dl *diskLayer) Storage(addr common.Address, key common.Hash) (common.Hash, error) {
+	dl.snaps.lock.RLock()
+	defer dl.snaps.lock.RUnlock()
+	return dl.storage(addr, key)
+}
+
+func (dl *diskLayer) storage(addr common.Address, key common.Hash) (common.Hash, error) {
+	if dl.stale {
+		return common.Hash{}, ErrSnapshotStale
+	}
+	data, err := dl.snaps.db.LDB().Get(snapshotStorageKey(addr, key), nil)
+	if err == goleveldb.ErrNotFound {
+		return common.Hash{}, nil
+	}
+	return common.BytesToHash(data), err
+}
+
+// diffLayer holds the state changes of a commit upon parent layer
+type diffLayer struct {
+	snaps  *Snapshots
+	parent snapshotLayer
+	root   common.Hash
+	stale  bool
+
+	destructs map[common.Address]struct{} // accounts deleted or recreated, whose storage are wiped
+	accounts  map[common.Address][]byte   // updated accounts, nil if deleted
+	storage   map[common.Address]Storage  // updated slots, zero if deleted
+}
+
+func (dl *diffLayer) Root() common.Hash {
+	return dl.root
+}
+
+func (dl *diffLayer) Account(addr common.Address) ([]byte, error) {
+	dl.snaps.lock.RLock()
+	defer dl.snaps.lock.RUnlock()
+	return dl.account(addr)
+}
+
+func (dl *diffLayer) account(addr common.Address) ([]byte, error) {
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	if data, ok := dl.accounts[addr]; ok {
+		return data, nil
+	}
+	if _, ok := dl.destructs[addr]; ok {
+		return nil, nil
+	}
+	switch parent := dl.parent.(type) {
+	case *diffLayer:
+		return parent.account(addr)
+	default:
+		return parent.(*diskLayer).account(addr)
+	}
+}
+
+func (dl *diffLayer) Storage(addr common.Address, key common.Hash) (common.Hash, error) {
+	dl.snaps.lock.RLock()
+	defer dl.snaps.lock.RUnlock()
+	return dl.storageAt(addr, key)
+}
+
+func (dl *diffLayer) storageAt(addr common.Address, key common.Hash) (common.Hash, error) {
+	if dl.stale {
+		return common.Hash{}, ErrSnapshotStale
+	}
+	if slots, ok := dl.storage[addr]; ok {
+		if value, ok := slots[key]; ok {
+			return value, nil
+		}
+	}
+	if _, ok := dl.destructs[addr]; ok {
+		return common.Hash{}, nil
+	}
+	switch parent := dl.parent.(type) {
+	case *diffLayer:
+		return parent.storageAt(addr, key)
+	default:
+		return parent.(*diskLayer).storage(addr, key)
+	}
+}
+
+// Snapshots manages the disk layer and diff layer tree of state snapshot
+type Snapshots struct {
+	config *SnapshotConfig
+	db     *leveldb.LDBDatabase
+
+	lock   sync.RWMutex
+	disk   *diskLayer
+	layers map[common.Hash]*diffLayer // diff layers keyed by state root
+	latest *diffLayer                 // the last updated diff layer
+}
+
+// NewSnapshots loads the snapshot of state root, and regenerates disk layer
+// from bucket trees if it does not match root.
+func NewSnapshots(db *leveldb.LDBDatabase, root common.Hash, config *SnapshotConfig) (*Snapshots, error) {
+	snaps := &Snapshots{
+		config: config,
+		db:     db,
+		layers: make(map[common.Hash]*diffLayer),
+	}
+	data, err := db.LDB().Get([]byte(KeySnapshotRoot), nil)
+	if err != nil || common.BytesToHash(data) != root {
+		log.Infof("Regenerating state snapshot of %x", root)
+		if err := snaps.generate(root); err != nil {
+			return nil, err
+		}
+	}
+	snaps.disk = &diskLayer{snaps: snaps, root: root}
+	return snaps, nil
+}
+
+// generate rebuilds disk layer of state root from bucket trees
+func (s *Snapshots) generate(root common.Hash) error {
+	batch := s.db.NewBatch()
+	for _, prefix := range []string{KeySnapshotAccount, KeySnapshotStorage} {
+		it := s.db.NewIterator([]byte(prefix))
+		for it.Next() {
+			if isSnapshotKey(it.Key()) {
+				batch.Delete(append([]byte{}, it.Key()...))
+			}
+		}
+		it.Release()
+	}
+
+	rs, err := NewReadOnlyState(s.db, root)
+	if err != nil {
+		return err
+	}
+	rs.ForEachAccount(func(addr common.Address, account *Account) bool {
+		data, _ := account.Serialize()
+		batch.Put(snapshotAccountKey(addr), data)
+		rs.ForEachStorage(addr, func(key, value common.Hash) bool {
+			batch.Put(snapshotStorageKey(addr, key), value.Bytes())
+			return true
+		})
+		return true
+	})
+	batch.Put([]byte(KeySnapshotRoot), root.Bytes())
+	return batch.Write()
+}
+
+func isSnapshotKey(key []byte) bool {
+	switch string(key[:1]) {
+	case KeySnapshotAccount:
+		return len(key) == 1+common.AddressLength
+	case KeySnapshotStorage:
+		return len(key) == 1+common.AddressLength+common.HashLength
+	}
+	return false
+}
+
+// layer returns the snapshot layer of root, or nil if not found
+func (s *Snapshots) layer(root common.Hash) snapshotLayer {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if dl, ok := s.layers[root]; ok {
+		return dl
+	}
+	if s.disk.root == root {
+		return s.disk
+	}
+	return nil
+}
+
+// Update adds a diff layer of root upon the layer of parent. Other children
+// of parent are kept, as they may be on the canonical chain.
+func (s *Snapshots) Update(parent, root common.Hash, destructs map[common.Address]struct{},
+	accounts map[common.Address][]byte, storage map[common.Address]Storage) error {
+	if parent == root {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Same state is reached by another branch
+	if _, ok := s.layers[root]; ok {
+		return nil
+	}
+	var base snapshotLayer
+	if dl, ok := s.layers[parent]; ok {
+		base = dl
+	} else if s.disk.root == parent {
+		base = s.disk
+	} else {
+		return ErrSnapshotMissing
+	}
+	dl := &diffLayer{
+		snaps:     s,
+		parent:    base,
+		root:      root,
+		destructs: destructs,
+		accounts:  accounts,
+		storage:   storage,
+	}
+	s.layers[root] = dl
+	s.latest = dl
+	for depth(dl) > s.config.Layers {
+		if err := s.flatten(bottomOf(dl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// depth returns the amount of diff layers from dl down to disk layer
+func depth(dl *diffLayer) int {
+	n := 1
+	for parent, ok := dl.parent.(*diffLayer); ok; parent, ok = parent.parent.(*diffLayer) {
+		n++
+	}
+	return n
+}
+
+// bottomOf returns the diff layer right upon disk layer in the branch of dl
+func bottomOf(dl *diffLayer) *diffLayer {
+	for {
+		parent, ok := dl.parent.(*diffLayer)
+		if !ok {
+			return dl
+		}
+		dl = parent
+	}
+}
+
+// flatten merges the bottom diff layer into disk layer, and discards the
+// branches which are not built upon it.
+func (s *Snapshots) flatten(bottom *diffLayer) error {
+	batch := s.db.NewBatch()
+	for addr := range bottom.destructs {
+		batch.Delete(snapshotAccountKey(addr))
+		it := s.db.NewIterator(append([]byte(KeySnapshotStorage), addr.Bytes()...))
+		for it.Next() {
+			batch.Delete(append([]byte{}, it.Key()...))
+		}
+		it.Release()
+	}
+	for addr, data := range bottom.accounts {
+		if data == nil {
+			batch.Delete(snapshotAccountKey(addr))
+		} else {
+			batch.Put(snapshotAccountKey(addr), data)
+		}
+	}
+	for addr, slots := range bottom.storage {
+		for key, value := range slots {
+			if value.Nil() {
+				batch.Delete(snapshotStorageKey(addr, key))
+			} else {
+				batch.Put(snapshotStorageKey(addr, key), value.Bytes())
+			}
+		}
+	}
+	batch.Put([]byte(KeySnapshotRoot), bottom.root.Bytes())
+	if err := batch.Write(); err != nil {
+		log.Errorf("Failed to flatten snapshot layer %x, %s", bottom.root, err)
+		return err
+	}
+
+	s.disk.stale = true
+	bottom.stale = true
+	delete(s.layers, bottom.root)
+	s.disk = &diskLayer{snaps: s, root: bottom.root}
+	for _, dl := range s.layers {
+		if dl.parent == snapshotLayer(bottom) {
+			dl.parent = s.disk
+		}
+	}
+	// Branches upon the stale disk layer are forks of the flattened layer
+	for root, dl := range s.layers {
+		if bottomOf(dl).parent != snapshotLayer(s.disk) {
+			dl.stale = true
+			delete(s.layers, root)
+		}
+	}
+	return nil
+}
+
+// Close flattens the branch of the last updated diff layer into disk layer,
+// so that snapshot of the latest root need not be regenerated at next start.
+func (s *Snapshots) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.latest != nil && !s.latest.stale {
+		if err := s.flatten(bottomOf(s.latest)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,16 +11,31 @@ import (
 // Value is not actually hash, but just a 32 bytes array
 type Storage map[common.Hash]common.Hash
 
+var (
+	emptyCodeHash = common.Sha256(nil)
+)
+
 type stateObject struct {
 	address common.Address
 	data    *Account
-	code    []byte     // contract code bytes
+	sdb     *StateDB
+	db      *leveldb.LDBDatabase
+	code    []byte     // contract code bytes, loaded on demand
 	bmt     BucketTree // bucket tree of this account
 
-	cacheStorage Storage // storage cache
-	dirtyStorage Storage // dirty storage
+	// Storage tree is shared with copies of this object, and must be
+	// copied before modification
+	bmtShared bool
+
+	cacheStorage   Storage // storage cache
+	dirtyStorage   Storage // dirty storage
+	pendingStorage Storage // storage applied to bucket tree since last commit
 
-	dirtyCode bool // code is updated or not
+	// Code hash in committed state, whose reference is counted
+	originCodeHash common.Hash
+
+	suicided bool // account is suicided, and will be deleted when finalized
+	deleted  bool // account is deleted from state
 }
 
 type Account struct {
@@ -38,12 +53,15 @@ func (s *Account) Deserialize(data []byte) error {
 	return json.Unmarshal(data, s)
 }
 
-func newStateObject(address common.Address, data *Account) *stateObject {
+func newStateObject(sdb *StateDB, address common.Address, data *Account) *stateObject {
 	return &stateObject{
-		address:      address,
-		data:         data,
-		cacheStorage: make(Storage),
-		dirtyStorage: make(Storage),
+		address:        address,
+		data:           data,
+		sdb:            sdb,
+		db:             sdb.ldb,
+		cacheStorage:   make(Storage),
+		dirtyStorage:   make(Storage),
+		pendingStorage: make(Storage),
 	}
 }
 
@@ -52,7 +70,28 @@ func (s *stateObject) Address() common.Address {
 }
 
 func (s *stateObject) Code() []byte {
-	return s.code
+	if s.code != nil || !hasCode(s.data.CodeHash) {
+		return s.code
+	}
+	code, err := s.sdb.db.GetCode(s.data.CodeHash)
+	if err != nil {
+		return nil
+	}
+	s.code = code
+	return code
+}
+
+// CodeSize returns the size of code without loading it
+func (s *stateObject) CodeSize() int {
+	if s.code != nil || !hasCode(s.data.CodeHash) {
+		return len(s.code)
+	}
+	size, err := s.sdb.db.GetCodeSize(s.data.CodeHash)
+	if err != nil {
+		log.Errorf("Failed to get code size of %s, %s", s.address.Hex(), err)
+		return 0
+	}
+	return size
 }
 
 func (s *stateObject) Balance() *big.Int {
@@ -68,9 +107,17 @@ func (s *stateObject) Root() common.Hash {
 }
 
 func (s *stateObject) SetCode(code []byte) {
+	s.sdb.journal.append(codeChange{
+		account:  &s.address,
+		prevcode: s.code,
+		prevhash: s.data.CodeHash,
+	})
+	s.setCode(common.Sha256(code), code)
+}
+
+func (s *stateObject) setCode(codeHash common.Hash, code []byte) {
 	s.code = code
-	s.data.CodeHash = common.Sha256(code)
-	s.dirtyCode = true
+	s.data.CodeHash = codeHash
 }
 
 func (s *stateObject) AddBalance(amount *big.Int) {
@@ -82,6 +129,14 @@ func (s *stateObject) SubBalance(amount *big.Int) {
 }
 
 func (s *stateObject) SetBalance(amount *big.Int) {
+	s.sdb.journal.append(balanceChange{
+		account: &s.address,
+		prev:    new(big.Int).Set(s.data.Balance),
+	})
+	s.setBalance(amount)
+}
+
+func (s *stateObject) setBalance(amount *big.Int) {
 	s.data.Balance = amount
 }
 
@@ -90,15 +145,41 @@ func (s *stateObject) Nonce() uint64 {
 }
 
 func (s *stateObject) SetNonce(nonce uint64) {
+	s.sdb.journal.append(nonceChange{
+		account: &s.address,
+		prev:    s.data.Nonce,
+	})
+	s.setNonce(nonce)
+}
+
+func (s *stateObject) setNonce(nonce uint64) {
 	s.data.Nonce = nonce
 }
 
-func (s *stateObject) Bmt(db *leveldb.LDBDatabase) BucketTree {
+func (s *stateObject) markSuicided() {
+	s.suicided = true
+}
+
+// Empty returns whether the account is considered empty according to EIP161
+func (s *stateObject) empty() bool {
+	return s.data.Nonce == 0 && s.data.Balance.Sign() == 0 &&
+		(s.data.CodeHash.Nil() || s.data.CodeHash == emptyCodeHash)
+}
+
+// Bmt returns the storage bucket tree, and loads it from db if not cached
+func (s *stateObject) Bmt() BucketTree {
 	if tree := s.bmt; tree != nil {
 		return tree
 	}
-	tree := bmt.NewBucketTree(db)
-	tree.Init(s.data.Root.Bytes())
+	tree := bmt.NewBucketTreeWithConfig(s.db, s.sdb.storageTreeConfig())
+	var root []byte
+	if !s.data.Root.Nil() {
+		root = s.data.Root.Bytes()
+	}
+	if err := tree.Init(root); err != nil {
+		log.Errorf("Failed to init storage tree of %s, %s", s.address.Hex(), err)
+		return nil
+	}
 	s.bmt = tree
 	return tree
 }
@@ -107,53 +188,124 @@ func (s *stateObject) GetState(key common.Hash) common.Hash {
 	if val, exist := s.cacheStorage[key]; exist {
 		return val
 	}
+	// Storage of recreated account is not in snapshot
+	if snap := s.sdb.snap; snap != nil {
+		if _, destructed := s.sdb.stateObjectsDestruct[s.address]; !destructed {
+			if slot, err := snap.Storage(s.address, key); err == nil {
+				s.cacheStorage[key] = slot
+				return slot
+			}
+		}
+	}
+	tree := s.Bmt()
+	if tree == nil {
+		return common.Hash{}
+	}
 	// Load slot from bucket merkel tree
-	val, err := s.bmt.Get(key.Bytes())
+	val, err := tree.Get(key.Bytes())
 	if err != nil {
 		return common.Hash{}
 	}
 	slot := common.BytesToHash(val)
-	s.SetState(key, slot)
+	s.cacheStorage[key] = slot
 	return slot
 
 }
 
 func (s *stateObject) SetState(key, value common.Hash) {
+	s.sdb.journal.append(storageChange{
+		account:  &s.address,
+		key:      key,
+		prevalue: s.GetState(key),
+	})
+	s.setState(key, value)
+}
+
+func (s *stateObject) setState(key, value common.Hash) {
 	s.cacheStorage[key] = value
 	s.dirtyStorage[key] = value
 }
 
+// writableBmt returns the storage tree which is not shared with others
+func (s *stateObject) writableBmt() BucketTree {
+	tree := s.Bmt()
+	if tree != nil && s.bmtShared {
+		tree = tree.Copy()
+		s.bmt = tree
+		s.bmtShared = false
+	}
+	return tree
+}
+
+// Apply dirty storage to bucket tree and update the storage root
 func (s *stateObject) updateRoot() (common.Hash, error) {
+	tree := s.writableBmt()
+	if tree == nil {
+		return common.Hash{}, bmt.ErrDbNotOpen
+	}
+	if err := s.sdb.resizeTree(tree, StorageTreeConfig); err != nil {
+		return common.Hash{}, err
+	}
 	dirtySet := bmt.NewWriteSet()
 	for key, value := range s.dirtyStorage {
 		delete(s.dirtyStorage, key)
-		dirtySet[key.String()] = value.Bytes()
-		// TODO if value.Nil() ?
+		s.pendingStorage[key] = value
+		if value.Nil() {
+			// Zero-value slot is removed from storage
+			dirtySet.Delete(key.String())
+		} else {
+			dirtySet[key.String()] = value.Bytes()
+		}
 	}
 
-	if err := s.bmt.Prepare(dirtySet); err != nil {
+	if err := tree.Prepare(dirtySet); err != nil {
 		return common.Hash{}, err
 	}
 
-	return s.bmt.Process()
+	root, err := tree.Process()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	s.data.Root = root
+	return root, nil
 }
 
 func (s *stateObject) Commit() error {
-	err := s.bmt.Commit()
+	tree := s.writableBmt()
+	if tree == nil {
+		return bmt.ErrDbNotOpen
+	}
+	err := tree.Commit()
 	if err != nil {
 		return err
 	}
-	s.data.Root = s.bmt.Hash()
+	s.data.Root = tree.Hash()
 	return nil
 }
 
-func (s *stateObject) deepCopy() *stateObject {
+// deepCopy copies the state object for sdb. Storage tree is shared
+// by both objects until one of them modifies it.
+func (s *stateObject) deepCopy(sdb *StateDB) *stateObject {
 	newAcc := *s.data
-	sobj := newStateObject(s.address, &newAcc)
+	newAcc.Balance = new(big.Int).Set(s.data.Balance)
+	sobj := newStateObject(sdb, s.address, &newAcc)
 	sobj.code = s.code
-	sobj.dirtyCode = s.dirtyCode
-	if tree := s.bmt; tree != nil {
-		sobj.bmt = tree.Copy()
+	sobj.originCodeHash = s.originCodeHash
+	sobj.suicided = s.suicided
+	sobj.deleted = s.deleted
+	for key, value := range s.cacheStorage {
+		sobj.cacheStorage[key] = value
+	}
+	for key, value := range s.dirtyStorage {
+		sobj.dirtyStorage[key] = value
+	}
+	for key, value := range s.pendingStorage {
+		sobj.pendingStorage[key] = value
+	}
+	if s.bmt != nil {
+		sobj.bmt = s.bmt
+		sobj.bmtShared = true
+		s.bmtShared = true
 	}
-	return s
+	return sobj
 }
@@ -1,14 +1,25 @@
 package state
 
 import (
+	"bytes"
+	"fmt"
+	"sort"
 	"tinychain/common"
 	"tinychain/bmt"
 	"tinychain/db/leveldb"
+	"tinychain/core/types"
 	"math/big"
 )
 
 var (
 	log = common.GetLogger("state")
+
+	// Bucket tree config of world state and contract storage.
+	// World state holds all accounts, so it is much larger than a single contract storage.
+	// New storage trees use the hash scheme of world state, so that a chain
+	// started by older nodes keeps the legacy scheme.
+	StateTreeConfig   = &bmt.Config{Capacity: 4096, Aggreation: 16}
+	StorageTreeConfig = &bmt.Config{Capacity: 64, Aggreation: 4}
 )
 
 // Bucket tree
@@ -19,61 +30,237 @@ type BucketTree interface {
 	Process() (common.Hash, error)
 	Commit() error
 	Get(key []byte) ([]byte, error)
+	ForEach(fn func(key, value []byte) bool) error
 	Copy() *bmt.BucketTree
+	Config() *bmt.Config
+	Resize(capacity, aggre int) error
 }
 
 type StateDB struct {
+	ldb               *leveldb.LDBDatabase
 	db                *cacheDB
 	bmt               BucketTree                      // bucket merkle tree of global state
+	bmtShared         bool                            // state tree is shared with copies
 	stateObjects      map[common.Address]*stateObject // live state objects
-	stateObjectsDirty map[common.Address]struct{}     // dirty state objects
+	stateObjectsDirty map[common.Address]struct{}     // state objects finalised since last commit
+	pruner            *Pruner                         // historical state pruner, nil if disabled
+	resize            bool                            // resize trees to the configured shape
+	deleteEmpty       bool                            // delete empty touched accounts, see EIP-161
+
+	// Flat snapshot of state serving reads, nil if disabled or unavailable
+	snaps                *Snapshots
+	snap                 snapshotLayer
+	stateObjectsPending  map[common.Address]struct{} // finalised objects not written into the state tree yet
+	stateObjectsDestruct map[common.Address]struct{} // accounts deleted or recreated since last commit
+
+	// Journal of state modifications. This is the backbone of
+	// Snapshot and RevertToSnapshot.
+	journal        *journal
+	validRevisions []revision
+	nextRevisionId int
+
+	refund    uint64                 // gas refund counter of current transaction
+	preimages map[common.Hash][]byte // sha3 preimages seen by EVM
+
+	thash common.Hash                  // hash of current transaction
+	logs  map[common.Hash][]*types.Log // logs emitted by transactions
+}
+
+type revision struct {
+	id           int
+	journalIndex int
 }
 
 func New(db *leveldb.LDBDatabase, root []byte) *StateDB {
-	tree := bmt.NewBucketTree(db)
+	tree := bmt.NewBucketTreeWithConfig(db, StateTreeConfig)
 	if err := tree.Init(root); err != nil {
 		log.Errorf("Failed to init bucket tree when new state db, %s", err)
 		return nil
 	}
 	return &StateDB{
+		ldb:               db,
 		db:                newCacheDB(db),
 		bmt:               tree,
 		stateObjects:      make(map[common.Address]*stateObject),
 		stateObjectsDirty: make(map[common.Address]struct{}),
+		journal:           newJournal(),
+		preimages:         make(map[common.Hash][]byte),
+		logs:              make(map[common.Hash][]*types.Log),
+
+		stateObjectsPending:  make(map[common.Address]struct{}),
+		stateObjectsDestruct: make(map[common.Address]struct{}),
+	}
+}
+
+// storageTreeConfig returns the config of contract storage tree
+func (sdb *StateDB) storageTreeConfig() *bmt.Config {
+	config := *StorageTreeConfig
+	config.Scheme = sdb.bmt.Config().Scheme
+	return &config
+}
+
+// Copy creates an isolated copy of state, which could be used for speculative
+// execution in another goroutine. The copy is layered on the same base state,
+// bucket trees are shared and copied on write.
+// Copy must not be called concurrently with modification of sdb.
+func (sdb *StateDB) Copy() *StateDB {
+	state := &StateDB{
+		ldb:               sdb.ldb,
+		db:                sdb.db,
+		bmt:               sdb.bmt,
+		bmtShared:         true,
+		resize:            sdb.resize,
+		deleteEmpty:       sdb.deleteEmpty,
+		stateObjects:      make(map[common.Address]*stateObject, len(sdb.stateObjects)),
+		stateObjectsDirty: make(map[common.Address]struct{}, len(sdb.stateObjectsDirty)),
+		journal:           newJournal(),
+		refund:            sdb.refund,
+		preimages:         make(map[common.Hash][]byte, len(sdb.preimages)),
+		thash:             sdb.thash,
+		logs:              make(map[common.Hash][]*types.Log, len(sdb.logs)),
+
+		snaps:                sdb.snaps,
+		snap:                 sdb.snap,
+		stateObjectsPending:  make(map[common.Address]struct{}, len(sdb.stateObjectsPending)),
+		stateObjectsDestruct: make(map[common.Address]struct{}, len(sdb.stateObjectsDestruct)),
+	}
+	sdb.bmtShared = true
+	for addr, obj := range sdb.stateObjects {
+		state.stateObjects[addr] = obj.deepCopy(state)
+	}
+	for addr := range sdb.stateObjectsDirty {
+		state.stateObjectsDirty[addr] = struct{}{}
+	}
+	for addr := range sdb.stateObjectsPending {
+		state.stateObjectsPending[addr] = struct{}{}
+	}
+	// Changes not finalised yet are finalised by the copy, which can not
+	// revert them since snapshots are not copied
+	for addr, n := range sdb.journal.dirties {
+		state.journal.dirties[addr] = n
+	}
+	for hash, preimage := range sdb.preimages {
+		state.preimages[hash] = preimage
 	}
+	for addr := range sdb.stateObjectsDestruct {
+		state.stateObjectsDestruct[addr] = struct{}{}
+	}
+	for hash, logs := range sdb.logs {
+		cpy := make([]*types.Log, len(logs))
+		for i, l := range logs {
+			cpy[i] = new(types.Log)
+			*cpy[i] = *l
+		}
+		state.logs[hash] = cpy
+	}
+	return state
+}
+
+// writableBmt returns the state tree which is not shared with copies
+func (sdb *StateDB) writableBmt() BucketTree {
+	if sdb.bmtShared {
+		sdb.bmt = sdb.bmt.Copy()
+		sdb.bmtShared = false
+	}
+	return sdb.bmt
+}
+
+// SetResize enables migrating trees to the shape of StateTreeConfig and
+// StorageTreeConfig. State tree is resized with the next root, and a storage
+// tree is resized when its account is updated. Roots depend on it, so it must be
+// enabled from the same block on all nodes.
+func (sdb *StateDB) SetResize(resize bool) {
+	sdb.resize = resize
+}
+
+// SetDeleteEmpty enables deleting empty accounts touched by transactions as
+// EIP-161 does. Roots depend on it, so it must be enabled from the same block
+// on all nodes.
+func (sdb *StateDB) SetDeleteEmpty(deleteEmpty bool) {
+	sdb.deleteEmpty = deleteEmpty
+}
+
+// resizeTree resizes tree to the shape of config if resizing is enabled
+func (sdb *StateDB) resizeTree(tree BucketTree, config *bmt.Config) error {
+	if !sdb.resize {
+		return nil
+	}
+	if curr := tree.Config(); curr.Capacity == config.Capacity && curr.Aggreation == config.Aggreation {
+		return nil
+	}
+	return tree.Resize(config.Capacity, config.Aggreation)
+}
+
+// SetPruner enables pruning historical state after commit
+func (sdb *StateDB) SetPruner(pruner *Pruner) {
+	sdb.pruner = pruner
+}
+
+// SetSnapshots enables reading state from snapshot, if the snapshot
+// of current state root is available.
+func (sdb *StateDB) SetSnapshots(snaps *Snapshots) {
+	sdb.snaps = snaps
+	sdb.snap = snaps.layer(sdb.bmt.Hash())
 }
 
-// Get state object from cache and bucket tree
+// Get state object from cache, snapshot and bucket tree
 // If error, return nil
 func (sdb *StateDB) GetStateObj(addr common.Address) *stateObject {
 	if stateObj, exist := sdb.stateObjects[addr]; exist {
+		if stateObj.deleted {
+			return nil
+		}
 		return stateObj
 	}
-	data, err := sdb.bmt.Get(addr.Bytes())
-	if err != nil {
-		return nil
+	var (
+		data []byte
+		err  error
+	)
+	if sdb.snap != nil {
+		data, err = sdb.snap.Account(addr)
+		if err == nil && data == nil {
+			return nil
+		}
+	}
+	if sdb.snap == nil || err != nil {
+		data, err = sdb.bmt.Get(addr.Bytes())
+		if err != nil {
+			return nil
+		}
 	}
 	account := &Account{}
 	err = account.Deserialize(data)
 	if err != nil {
 		return nil
 	}
-	stateObj := newStateObject(addr, account)
-	code, _ := sdb.db.GetCode(account.CodeHash)
-	if code != nil {
-		stateObj.SetCode(code)
-	}
+	// Code is loaded on demand
+	stateObj := newStateObject(sdb, addr, account)
+	stateObj.originCodeHash = account.CodeHash
 	sdb.setStateObj(stateObj)
 	return stateObj
 }
 
-// Create a new state object
+// Create a new state object, the existing one will be overwritten
 func (sdb *StateDB) CreateStateObj(addr common.Address) *stateObject {
+	prev := sdb.stateObjects[addr]
+	if prev == nil {
+		prev = sdb.GetStateObj(addr)
+	}
 	account := &Account{
 		Nonce:   uint64(0),
 		Balance: new(big.Int),
 	}
-	newObj := newStateObject(addr, account)
+	newObj := newStateObject(sdb, addr, account)
+	if prev == nil {
+		sdb.journal.append(createObjectChange{account: &newObj.address})
+	} else {
+		_, prevdestruct := sdb.stateObjectsDestruct[addr]
+		sdb.journal.append(resetObjectChange{prev: prev, prevdestruct: prevdestruct})
+		// Code of previous account is released when committed
+		newObj.originCodeHash = prev.originCodeHash
+		// Storage of previous account is wiped
+		sdb.stateObjectsDestruct[addr] = struct{}{}
+	}
 	sdb.setStateObj(newObj)
 	return newObj
 }
@@ -81,7 +268,182 @@ func (sdb *StateDB) CreateStateObj(addr common.Address) *stateObject {
 // Set "live" state object
 func (sdb *StateDB) setStateObj(object *stateObject) {
 	sdb.stateObjects[object.Address()] = object
-	sdb.stateObjectsDirty[object.Address()] = struct{}{}
+}
+
+// CreateAccount explicitly creates a state object. If a state object with the address
+// already exists the balance is carried over to the new account.
+//
+// CreateAccount is called during the EVM CREATE operation. The situation might arise that
+// a contract does the following:
+//
+//  1. sends funds to sha(account ++ (nonce + 1))
+//  2. tx_create(sha(account ++ nonce)) (note that this gets the address of 1)
+//
+// Carrying over the balance ensures that Ether doesn't disappear.
+func (sdb *StateDB) CreateAccount(addr common.Address) {
+	prev := sdb.GetStateObj(addr)
+	newObj := sdb.CreateStateObj(addr)
+	if prev != nil {
+		newObj.setBalance(new(big.Int).Set(prev.Balance()))
+	}
+}
+
+// Retrieve the balance from the given address or 0 if object not found
+func (sdb *StateDB) GetBalance(addr common.Address) *big.Int {
+	stateObj := sdb.GetStateObj(addr)
+	if stateObj != nil {
+		return stateObj.Balance()
+	}
+	return new(big.Int)
+}
+
+func (sdb *StateDB) GetNonce(addr common.Address) uint64 {
+	stateObj := sdb.GetStateObj(addr)
+	if stateObj != nil {
+		return stateObj.Nonce()
+	}
+	return 0
+}
+
+func (sdb *StateDB) GetCode(addr common.Address) []byte {
+	stateObj := sdb.GetStateObj(addr)
+	if stateObj != nil {
+		return stateObj.Code()
+	}
+	return nil
+}
+
+func (sdb *StateDB) GetCodeSize(addr common.Address) int {
+	stateObj := sdb.GetStateObj(addr)
+	if stateObj != nil {
+		return stateObj.CodeSize()
+	}
+	return 0
+}
+
+// AddPreimage records a SHA3 preimage seen by the VM.
+func (sdb *StateDB) AddPreimage(hash common.Hash, preimage []byte) {
+	if _, ok := sdb.preimages[hash]; !ok {
+		sdb.journal.append(addPreimageChange{hash: hash})
+		pi := make([]byte, len(preimage))
+		copy(pi, preimage)
+		sdb.preimages[hash] = pi
+	}
+}
+
+// Prepare sets the hash of transaction to be executed, which is recorded
+// in the logs emitted afterwards.
+func (sdb *StateDB) Prepare(thash common.Hash) {
+	sdb.thash = thash
+}
+
+// AddLog records a log emitted by the current transaction
+func (sdb *StateDB) AddLog(l *types.Log) {
+	sdb.journal.append(addLogChange{txhash: sdb.thash})
+	l.TxHash = sdb.thash
+	l.Index = uint(len(sdb.logs[sdb.thash]))
+	sdb.logs[sdb.thash] = append(sdb.logs[sdb.thash], l)
+}
+
+// GetLogs returns the logs emitted by transaction of hash
+func (sdb *StateDB) GetLogs(hash common.Hash) []*types.Log {
+	return sdb.logs[hash]
+}
+
+// Preimages returns a list of SHA3 preimages that have been submitted.
+func (sdb *StateDB) Preimages() map[common.Hash][]byte {
+	return sdb.preimages
+}
+
+// ForEachAccount iterates accounts in state tree order, including the ones
+// modified but not committed yet. Accounts which are not in state tree are
+// visited at last, sorted by address. The account passed to cb must not be modified.
+func (sdb *StateDB) ForEachAccount(cb func(addr common.Address, account *Account) bool) {
+	visited := make(map[common.Address]struct{})
+	stop := false
+	err := sdb.bmt.ForEach(func(k, v []byte) bool {
+		addr := common.BytesToAddress(k)
+		visited[addr] = struct{}{}
+		var account *Account
+		if stateObj, exist := sdb.stateObjects[addr]; exist {
+			if stateObj.deleted || stateObj.suicided {
+				return true
+			}
+			account = stateObj.data
+		} else {
+			account = &Account{}
+			if err := account.Deserialize(v); err != nil {
+				log.Errorf("Failed to decode account %s, %s", common.Hex(k), err)
+				return true
+			}
+		}
+		stop = !cb(addr, account)
+		return !stop
+	})
+	if err != nil {
+		log.Errorf("Failed to iterate state tree, %s", err)
+	}
+	// Accounts which are not committed to bucket tree yet, sorted by address
+	var dirtyAddrs []common.Address
+	for addr, stateObj := range sdb.stateObjects {
+		if _, ok := visited[addr]; !ok && !stateObj.deleted && !stateObj.suicided {
+			dirtyAddrs = append(dirtyAddrs, addr)
+		}
+	}
+	sort.Slice(dirtyAddrs, func(i, j int) bool {
+		return bytes.Compare(dirtyAddrs[i][:], dirtyAddrs[j][:]) < 0
+	})
+	for _, addr := range dirtyAddrs {
+		if stop {
+			return
+		}
+		stop = !cb(addr, sdb.stateObjects[addr].data)
+	}
+}
+
+// ForEachStorage iterates non-empty storage slots of an account, including
+// slots in the dirty cache and those in the bucket tree, until cb returns false.
+func (sdb *StateDB) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	stateObj := sdb.GetStateObj(addr)
+	if stateObj == nil {
+		return
+	}
+	visited := make(map[common.Hash]struct{})
+	stop := false
+	if tree := stateObj.Bmt(); tree != nil {
+		err := tree.ForEach(func(k, v []byte) bool {
+			key := common.BytesToHash(k)
+			visited[key] = struct{}{}
+			value := common.BytesToHash(v)
+			if cached, ok := stateObj.cacheStorage[key]; ok {
+				value = cached
+			}
+			if value.Nil() {
+				return true
+			}
+			stop = !cb(key, value)
+			return !stop
+		})
+		if err != nil {
+			log.Errorf("Failed to iterate storage of %s, %s", addr.Hex(), err)
+		}
+	}
+	// Slots which are not committed to bucket tree yet, sorted by key
+	var dirtyKeys []common.Hash
+	for key, value := range stateObj.cacheStorage {
+		if _, ok := visited[key]; !ok && !value.Nil() {
+			dirtyKeys = append(dirtyKeys, key)
+		}
+	}
+	sort.Slice(dirtyKeys, func(i, j int) bool {
+		return bytes.Compare(dirtyKeys[i][:], dirtyKeys[j][:]) < 0
+	})
+	for _, key := range dirtyKeys {
+		if stop {
+			return
+		}
+		stop = !cb(key, stateObj.cacheStorage[key])
+	}
 }
 
 // Get state of an account with address
@@ -113,7 +475,9 @@ func (sdb *StateDB) GetCodeHash(addr common.Address) common.Hash {
 func (sdb *StateDB) StateBmt(addr common.Address) BucketTree {
 	stateObj := sdb.GetStateObj(addr)
 	if stateObj != nil {
-		return stateObj.bmt.Copy()
+		if tree := stateObj.Bmt(); tree != nil {
+			return tree.Copy()
+		}
 	}
 	return nil
 }
@@ -121,7 +485,7 @@ func (sdb *StateDB) StateBmt(addr common.Address) BucketTree {
 // Get or create a state object
 func (sdb *StateDB) GetOrNewStateObj(addr common.Address) *stateObject {
 	stateObj := sdb.GetStateObj(addr)
-	if stateObj != nil {
+	if stateObj == nil {
 		return sdb.CreateStateObj(addr)
 	}
 	return stateObj
@@ -162,48 +526,220 @@ func (sdb *StateDB) SetCode(addr common.Address, code []byte) {
 	}
 }
 
+// Exist reports whether the given account exists in state.
+// Suicided account still exists until state is finalized.
 func (sdb *StateDB) Exist(addr common.Address) bool {
 	s := sdb.GetStateObj(addr)
 	return s != nil
 }
 
-// Process dirty state object to state tree and get intermediate root
+// Empty returns whether the account is non-existent or empty according to EIP161
+func (sdb *StateDB) Empty(addr common.Address) bool {
+	s := sdb.GetStateObj(addr)
+	return s == nil || s.empty()
+}
+
+// Suicide marks the given account as suicided and clears its balance.
+// The account is removed from state when finalized.
+func (sdb *StateDB) Suicide(addr common.Address) bool {
+	stateObj := sdb.GetStateObj(addr)
+	if stateObj == nil {
+		return false
+	}
+	sdb.journal.append(suicideChange{
+		account:     &stateObj.address,
+		prev:        stateObj.suicided,
+		prevbalance: new(big.Int).Set(stateObj.Balance()),
+	})
+	stateObj.markSuicided()
+	stateObj.setBalance(new(big.Int))
+	return true
+}
+
+func (sdb *StateDB) HasSuicided(addr common.Address) bool {
+	stateObj := sdb.GetStateObj(addr)
+	if stateObj != nil {
+		return stateObj.suicided
+	}
+	return false
+}
+
+// Snapshot returns an identifier for the current revision of the state.
+func (sdb *StateDB) Snapshot() int {
+	id := sdb.nextRevisionId
+	sdb.nextRevisionId++
+	sdb.validRevisions = append(sdb.validRevisions, revision{id, sdb.journal.length()})
+	return id
+}
+
+// RevertToSnapshot reverts all state changes made since the given revision.
+func (sdb *StateDB) RevertToSnapshot(revid int) {
+	// Find the snapshot in the stack of valid snapshots.
+	idx := sort.Search(len(sdb.validRevisions), func(i int) bool {
+		return sdb.validRevisions[i].id >= revid
+	})
+	if idx == len(sdb.validRevisions) || sdb.validRevisions[idx].id != revid {
+		panic(fmt.Errorf("revision id %v cannot be reverted", revid))
+	}
+	snapshot := sdb.validRevisions[idx].journalIndex
+
+	// Replay the journal to undo changes and remove invalidated snapshots
+	sdb.journal.revert(sdb, snapshot)
+	sdb.validRevisions = sdb.validRevisions[:idx]
+}
+
+// AddRefund adds gas to the refund counter
+func (sdb *StateDB) AddRefund(gas uint64) {
+	sdb.journal.append(refundChange{prev: sdb.refund})
+	sdb.refund += gas
+}
+
+// GetRefund returns the current value of the refund counter.
+func (sdb *StateDB) GetRefund() uint64 {
+	return sdb.refund
+}
+
+// finalise marks suicided accounts dirtied by the journal as deleted, and
+// empty ones too if enabled by SetDeleteEmpty. The journal is cleared as it
+// cannot be reverted any more.
+func (sdb *StateDB) finalise() {
+	for addr := range sdb.journal.dirties {
+		stateObj, exist := sdb.stateObjects[addr]
+		if !exist {
+			continue
+		}
+		if stateObj.suicided || (sdb.deleteEmpty && stateObj.empty()) {
+			stateObj.deleted = true
+		}
+		sdb.stateObjectsPending[addr] = struct{}{}
+		sdb.stateObjectsDirty[addr] = struct{}{}
+	}
+	sdb.journal = newJournal()
+	sdb.validRevisions = sdb.validRevisions[:0]
+	sdb.refund = 0
+}
+
+// Process dirty state object to state tree and get intermediate root. Only
+// objects changed since the last call are written, the rest are already in
+// the tree.
 func (sdb *StateDB) IntermediateRoot() (common.Hash, error) {
+	sdb.finalise()
 	dirtySet := bmt.NewWriteSet()
-	for addr := range sdb.stateObjectsDirty {
-		stateobj := sdb.stateObjects[addr]
+	for addr := range sdb.stateObjectsPending {
+		stateobj, exist := sdb.stateObjects[addr]
+		if !exist {
+			continue
+		}
+		if stateobj.deleted {
+			dirtySet.Delete(addr.String())
+			continue
+		}
+		if _, err := stateobj.updateRoot(); err != nil {
+			return common.Hash{}, err
+		}
 		data, _ := stateobj.data.Serialize()
 		dirtySet[addr.String()] = data
 	}
-	if err := sdb.bmt.Prepare(dirtySet); err != nil {
+	tree := sdb.writableBmt()
+	if err := sdb.resizeTree(tree, StateTreeConfig); err != nil {
 		return common.Hash{}, err
 	}
-	return sdb.bmt.Process()
+	if err := tree.Prepare(dirtySet); err != nil {
+		return common.Hash{}, err
+	}
+	sdb.stateObjectsPending = make(map[common.Address]struct{})
+	return tree.Process()
 }
 
 func (sdb *StateDB) Commit() error {
+	sdb.finalise()
 	dirtySet := bmt.NewWriteSet()
 
+	// State changes for snapshot
+	var (
+		snapAccounts = make(map[common.Address][]byte)
+		snapStorage  = make(map[common.Address]Storage)
+	)
+	sdb.stateObjectsPending = make(map[common.Address]struct{})
 	for addr := range sdb.stateObjectsDirty {
 		delete(sdb.stateObjectsDirty, addr)
-		stateobj := sdb.stateObjects[addr]
+		stateobj, exist := sdb.stateObjects[addr]
+		if !exist {
+			continue
+		}
+		if stateobj.deleted {
+			if hasCode(stateobj.originCodeHash) {
+				if err := sdb.db.ReleaseCode(stateobj.originCodeHash); err != nil {
+					return err
+				}
+			}
+			// Remove account from state tree
+			dirtySet.Delete(addr.String())
+			delete(sdb.stateObjects, addr)
+			sdb.stateObjectsDestruct[addr] = struct{}{}
+			snapAccounts[addr] = nil
+			continue
+		}
+		// Commit storage tree
+		if _, err := stateobj.updateRoot(); err != nil {
+			return err
+		}
+		if err := stateobj.Commit(); err != nil {
+			return err
+		}
+		if len(stateobj.pendingStorage) > 0 {
+			snapStorage[addr] = stateobj.pendingStorage
+			stateobj.pendingStorage = make(Storage)
+		}
 		// Put account data to dirtySet
 		data, _ := stateobj.data.Serialize()
 		dirtySet[addr.String()] = data
+		snapAccounts[addr] = data
 
-		// Put code bytes to codeSet
-		if stateobj.dirtyCode {
-			if err := sdb.db.PutCode(stateobj.CodeHash(), stateobj.Code()); err != nil {
-				stateobj.dirtyCode = false
+		// Put code bytes to codeSet, and move the reference from previous code
+		if codeHash := stateobj.CodeHash(); codeHash != stateobj.originCodeHash {
+			if hasCode(codeHash) {
+				if err := sdb.db.PutCode(codeHash, stateobj.Code()); err != nil {
+					return err
+				}
 			}
+			if hasCode(stateobj.originCodeHash) {
+				if err := sdb.db.ReleaseCode(stateobj.originCodeHash); err != nil {
+					return err
+				}
+			}
+			stateobj.originCodeHash = codeHash
 		}
 	}
 
-	if err := sdb.bmt.Prepare(dirtySet); err != nil {
+	tree := sdb.writableBmt()
+	if err := sdb.resizeTree(tree, StateTreeConfig); err != nil {
 		return err
 	}
-	if err := sdb.bmt.Commit(); err != nil {
+	if err := tree.Prepare(dirtySet); err != nil {
 		return err
 	}
+	if err := tree.Commit(); err != nil {
+		return err
+	}
+	root := tree.Hash()
+	if sdb.snap != nil {
+		err := sdb.snaps.Update(sdb.snap.Root(), root, sdb.stateObjectsDestruct, snapAccounts, snapStorage)
+		if err != nil {
+			log.Errorf("Failed to update state snapshot, %s", err)
+			sdb.snap = nil
+		} else {
+			sdb.snap = sdb.snaps.layer(root)
+		}
+	} else if sdb.snaps != nil {
+		// Root may be added to snapshot by another state db
+		sdb.snap = sdb.snaps.layer(root)
+	}
+	sdb.stateObjectsDestruct = make(map[common.Address]struct{})
+	// Logs are kept in receipts after commit
+	sdb.logs = make(map[common.Hash][]*types.Log)
+	if sdb.pruner != nil {
+		sdb.pruner.Commit(root)
+	}
 	return nil
 }
@@ -0,0 +1,658 @@
+package state
+
+import (
+	"testing"
+	"math/big"
+	"tinychain/common"
+	"tinychain/db/leveldb"
+	"tinychain/bmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+)
+
+var (
+	addr1 = common.BytesToAddress([]byte("addr1_______________"))
+	addr2 = common.BytesToAddress([]byte("addr2_______________"))
+	key1  = common.BytesToHash([]byte("key1"))
+	val1  = common.BytesToHash([]byte("val1"))
+	val2  = common.BytesToHash([]byte("val2"))
+)
+
+func newTestState(t *testing.T, name string) (*StateDB, *leveldb.LDBDatabase) {
+	os.RemoveAll(name)
+	db, err := leveldb.NewLDBDataBase(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(db, nil), db
+}
+
+func TestStateDB_RevertToSnapshot(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_revert")
+	defer os.RemoveAll("state_test_revert")
+	defer db.Close()
+
+	sdb.SetBalance(addr1, big.NewInt(100))
+	sdb.SetState(addr1, key1, val1)
+	snap := sdb.Snapshot()
+
+	// Nested call frame
+	sdb.AddBalance(addr1, big.NewInt(50))
+	sdb.SetNonce(addr1, 3)
+	sdb.SetState(addr1, key1, val2)
+	sdb.SetCode(addr1, []byte{0x60, 0x00})
+	sdb.AddRefund(10)
+	sdb.SetBalance(addr2, big.NewInt(7))
+	inner := sdb.Snapshot()
+	sdb.Suicide(addr1)
+	assert.True(t, sdb.HasSuicided(addr1))
+
+	sdb.RevertToSnapshot(inner)
+	assert.False(t, sdb.HasSuicided(addr1))
+	assert.Equal(t, big.NewInt(150), sdb.GetStateObj(addr1).Balance())
+
+	sdb.RevertToSnapshot(snap)
+	obj := sdb.GetStateObj(addr1)
+	assert.Equal(t, big.NewInt(100), obj.Balance())
+	assert.Equal(t, uint64(0), obj.Nonce())
+	assert.Nil(t, obj.Code())
+	assert.Equal(t, val1, sdb.GetState(addr1, key1))
+	assert.Equal(t, uint64(0), sdb.GetRefund())
+	assert.False(t, sdb.Exist(addr2))
+}
+
+func TestStateDB_RevertCreateAccount(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_create")
+	defer os.RemoveAll("state_test_create")
+	defer db.Close()
+
+	sdb.SetBalance(addr1, big.NewInt(100))
+	assert.Nil(t, sdb.Commit())
+	root := sdb.bmt.Hash()
+
+	sdb = New(db, root.Bytes())
+	snap := sdb.Snapshot()
+	sdb.CreateStateObj(addr1)
+	assert.Equal(t, 0, sdb.GetStateObj(addr1).Balance().Sign())
+	sdb.RevertToSnapshot(snap)
+	assert.Equal(t, big.NewInt(100), sdb.GetStateObj(addr1).Balance())
+
+	// Reverted changes should not affect state root
+	snap = sdb.Snapshot()
+	sdb.SetBalance(addr2, big.NewInt(1))
+	sdb.RevertToSnapshot(snap)
+	newRoot, err := sdb.IntermediateRoot()
+	assert.Nil(t, err)
+	assert.Equal(t, root, newRoot)
+}
+
+func TestStateDB_RevertTouch(t *testing.T) {
+	os.RemoveAll("state_test_touch")
+	defer os.RemoveAll("state_test_touch")
+	db, err := leveldb.NewLDBDataBase("state_test_touch")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// Empty account only exists in imported state
+	dump := &Dump{Accounts: []*DumpAccount{{Address: addr1, Balance: new(big.Int)}}}
+	dump.Root, _ = ImportDump(db, dump)
+	root, err := ImportDump(db, dump)
+	assert.Nil(t, err)
+
+	sdb := New(db, root.Bytes())
+	sdb.SetDeleteEmpty(true)
+	assert.True(t, sdb.Exist(addr1))
+	snap := sdb.Snapshot()
+	sdb.AddBalance(addr1, new(big.Int))
+	sdb.RevertToSnapshot(snap)
+	newRoot, err := sdb.IntermediateRoot()
+	assert.Nil(t, err)
+	assert.Equal(t, root, newRoot)
+	assert.True(t, sdb.Exist(addr1))
+
+	// Touched account is kept before EIP-161
+	sdb.SetDeleteEmpty(false)
+	sdb.AddBalance(addr1, new(big.Int))
+	newRoot, err = sdb.IntermediateRoot()
+	assert.Nil(t, err)
+	assert.Equal(t, root, newRoot)
+
+	// Touched account is deleted once
+	sdb.SetDeleteEmpty(true)
+	sdb.AddBalance(addr1, new(big.Int))
+	newRoot, err = sdb.IntermediateRoot()
+	assert.Nil(t, err)
+	assert.NotEqual(t, root, newRoot)
+	assert.False(t, sdb.Exist(addr1))
+	assert.Equal(t, 0, len(sdb.stateObjectsPending))
+	again, err := sdb.IntermediateRoot()
+	assert.Nil(t, err)
+	assert.Equal(t, newRoot, again)
+}
+
+func TestStateDB_Accessors(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_accessors")
+	defer os.RemoveAll("state_test_accessors")
+	defer db.Close()
+
+	code := []byte{0x60, 0x01, 0x60, 0x02}
+	assert.True(t, sdb.Empty(addr1))
+	assert.Equal(t, 0, sdb.GetBalance(addr1).Sign())
+	sdb.SetBalance(addr1, big.NewInt(42))
+	sdb.SetNonce(addr1, 5)
+	sdb.SetCode(addr1, code)
+	assert.False(t, sdb.Empty(addr1))
+	assert.Nil(t, sdb.Commit())
+
+	sdb = New(db, sdb.bmt.Hash().Bytes())
+	assert.Equal(t, big.NewInt(42), sdb.GetBalance(addr1))
+	assert.Equal(t, uint64(5), sdb.GetNonce(addr1))
+	assert.Equal(t, code, sdb.GetCode(addr1))
+	assert.Equal(t, len(code), sdb.GetCodeSize(addr1))
+	assert.Equal(t, common.Sha256(code), sdb.GetCodeHash(addr1))
+
+	// Balance is carried over to the re-created account
+	sdb.CreateAccount(addr1)
+	assert.Equal(t, big.NewInt(42), sdb.GetBalance(addr1))
+	assert.Equal(t, uint64(0), sdb.GetNonce(addr1))
+
+	sdb.AddPreimage(val1, []byte("preimage"))
+	assert.Equal(t, []byte("preimage"), sdb.Preimages()[val1])
+}
+
+func TestStateDB_ForEachStorage(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_storage")
+	defer os.RemoveAll("state_test_storage")
+	defer db.Close()
+
+	committed := map[common.Hash]common.Hash{
+		common.BytesToHash([]byte("a")): common.BytesToHash([]byte("1")),
+		common.BytesToHash([]byte("b")): common.BytesToHash([]byte("2")),
+		common.BytesToHash([]byte("c")): common.BytesToHash([]byte("3")),
+	}
+	sdb.SetBalance(addr1, big.NewInt(1))
+	for k, v := range committed {
+		sdb.SetState(addr1, k, v)
+	}
+	assert.Nil(t, sdb.Commit())
+
+	sdb = New(db, sdb.bmt.Hash().Bytes())
+	// Dirty changes: update, delete and insert
+	sdb.SetState(addr1, common.BytesToHash([]byte("a")), common.BytesToHash([]byte("10")))
+	sdb.SetState(addr1, common.BytesToHash([]byte("b")), common.Hash{})
+	sdb.SetState(addr1, common.BytesToHash([]byte("d")), common.BytesToHash([]byte("4")))
+
+	expect := map[common.Hash]common.Hash{
+		common.BytesToHash([]byte("a")): common.BytesToHash([]byte("10")),
+		common.BytesToHash([]byte("c")): common.BytesToHash([]byte("3")),
+		common.BytesToHash([]byte("d")): common.BytesToHash([]byte("4")),
+	}
+	result := make(map[common.Hash]common.Hash)
+	sdb.ForEachStorage(addr1, func(key, value common.Hash) bool {
+		result[key] = value
+		return true
+	})
+	assert.Equal(t, expect, result)
+
+	count := 0
+	sdb.ForEachStorage(addr1, func(key, value common.Hash) bool {
+		count++
+		return false
+	})
+	assert.Equal(t, 1, count)
+}
+
+func TestStateDB_Copy(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_copy")
+	defer os.RemoveAll("state_test_copy")
+	defer db.Close()
+
+	sdb.SetBalance(addr1, big.NewInt(100))
+	sdb.SetState(addr1, key1, val1)
+	assert.Nil(t, sdb.Commit())
+	root := sdb.bmt.Hash()
+
+	sdb.SetBalance(addr2, big.NewInt(1))
+	cpy := sdb.Copy()
+	cpy.AddBalance(addr1, big.NewInt(1))
+	cpy.SetState(addr1, key1, val2)
+	cpy.SetBalance(addr2, big.NewInt(2))
+	_, err := cpy.IntermediateRoot()
+	assert.Nil(t, err)
+
+	// Original state is not affected by the copy
+	assert.Equal(t, big.NewInt(100), sdb.GetBalance(addr1))
+	assert.Equal(t, val1, sdb.GetState(addr1, key1))
+	assert.Equal(t, big.NewInt(1), sdb.GetBalance(addr2))
+	assert.Equal(t, root, sdb.bmt.Hash())
+
+	// Copy is not affected by the original state
+	sdb.SetState(addr1, key1, common.BytesToHash([]byte("val3")))
+	assert.Nil(t, sdb.Commit())
+	assert.Equal(t, val2, cpy.GetState(addr1, key1))
+	assert.Equal(t, big.NewInt(2), cpy.GetBalance(addr2))
+}
+
+func TestStateDB_ConcurrentCopy(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_concurrent")
+	defer os.RemoveAll("state_test_concurrent")
+	defer db.Close()
+
+	sdb.SetBalance(addr1, big.NewInt(100))
+	sdb.SetState(addr1, key1, val1)
+	assert.Nil(t, sdb.Commit())
+	sdb = New(db, sdb.bmt.Hash().Bytes())
+
+	var copies []*StateDB
+	for i := 0; i < 8; i++ {
+		copies = append(copies, sdb.Copy())
+	}
+	roots := make([]common.Hash, len(copies))
+	done := make(chan struct{})
+	for i, cpy := range copies {
+		go func(i int, cpy *StateDB) {
+			defer func() { done <- struct{}{} }()
+			cpy.GetState(addr1, key1)
+			cpy.AddBalance(addr1, big.NewInt(1))
+			cpy.SetState(addr2, key1, val2)
+			roots[i], _ = cpy.IntermediateRoot()
+		}(i, cpy)
+	}
+	for range copies {
+		<-done
+	}
+	for _, root := range roots {
+		assert.Equal(t, roots[0], root)
+	}
+	assert.Equal(t, big.NewInt(100), sdb.GetBalance(addr1))
+}
+
+func TestReadOnlyState_History(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_history")
+	defer os.RemoveAll("state_test_history")
+	defer db.Close()
+
+	sdb.SetBalance(addr1, big.NewInt(100))
+	sdb.SetState(addr1, key1, val1)
+	assert.Nil(t, sdb.Commit())
+	root1 := sdb.bmt.Hash()
+
+	sdb.SetBalance(addr1, big.NewInt(200))
+	sdb.SetNonce(addr1, 1)
+	sdb.SetState(addr1, key1, val2)
+	sdb.SetCode(addr2, []byte("code"))
+	assert.Nil(t, sdb.Commit())
+	root2 := sdb.bmt.Hash()
+
+	rs, err := NewReadOnlyState(db, root1)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(100), rs.GetBalance(addr1))
+	assert.Equal(t, uint64(0), rs.GetNonce(addr1))
+	assert.Equal(t, val1, rs.GetState(addr1, key1))
+	assert.False(t, rs.Exist(addr2))
+
+	rs, err = NewReadOnlyState(db, root2)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(200), rs.GetBalance(addr1))
+	assert.Equal(t, uint64(1), rs.GetNonce(addr1))
+	assert.Equal(t, val2, rs.GetState(addr1, key1))
+	assert.Equal(t, []byte("code"), rs.GetCode(addr2))
+
+	_, err = NewReadOnlyState(db, common.BytesToHash([]byte("unknown root")))
+	assert.Equal(t, ErrStateNotFound, err)
+}
+
+func TestDumpState_Import(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_dump")
+	defer os.RemoveAll("state_test_dump")
+	defer db.Close()
+
+	sdb.SetBalance(addr1, big.NewInt(100))
+	sdb.SetNonce(addr1, 2)
+	sdb.SetState(addr1, key1, val1)
+	sdb.SetState(addr1, val1, val2)
+	sdb.SetCode(addr2, []byte("code"))
+	sdb.SetNonce(addr2, 1)
+	assert.Nil(t, sdb.Commit())
+	root := sdb.bmt.Hash()
+
+	dump, err := DumpState(db, root)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dump.Accounts))
+	assert.Equal(t, root, dump.Root)
+
+	var accounts []common.Address
+	sdb.ForEachAccount(func(addr common.Address, account *Account) bool {
+		accounts = append(accounts, addr)
+		return true
+	})
+	assert.Equal(t, 2, len(accounts))
+
+	data, err := dump.EncodeJSON()
+	assert.Nil(t, err)
+	jsonDump, err := DecodeDumpJSON(data)
+	assert.Nil(t, err)
+	assert.Equal(t, dump, jsonDump)
+
+	binDump, err := DecodeDumpBinary(dump.EncodeBinary())
+	assert.Nil(t, err)
+	assert.Equal(t, dump, binDump)
+
+	// Rebuild state in another db
+	os.RemoveAll("state_test_import")
+	defer os.RemoveAll("state_test_import")
+	db2, err := leveldb.NewLDBDataBase("state_test_import")
+	assert.Nil(t, err)
+	defer db2.Close()
+	imported, err := ImportDump(db2, binDump)
+	assert.Nil(t, err)
+	assert.Equal(t, root, imported)
+
+	rs, err := NewReadOnlyState(db2, root)
+	assert.Nil(t, err)
+	assert.Equal(t, val2, rs.GetState(addr1, val1))
+	assert.Equal(t, []byte("code"), rs.GetCode(addr2))
+
+	binDump.Accounts[0].Nonce++
+	_, err = ImportDump(db2, binDump)
+	assert.Equal(t, ErrRootMismatch, err)
+}
+
+func TestDumpState_TreeShape(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_dump_shape")
+	defer os.RemoveAll("state_test_dump_shape")
+	defer db.Close()
+
+	// State committed with the shape and hash scheme of older nodes
+	legacy := &bmt.Config{Capacity: 4, Aggreation: 2, Scheme: bmt.HashLegacy}
+	stateConfig, storageConfig := *StateTreeConfig, *StorageTreeConfig
+	*StateTreeConfig, *StorageTreeConfig = *legacy, *legacy
+	sdb = New(db, nil)
+	sdb.SetBalance(addr1, big.NewInt(100))
+	sdb.SetState(addr1, key1, val1)
+	assert.Nil(t, sdb.Commit())
+	*StateTreeConfig, *StorageTreeConfig = stateConfig, storageConfig
+	root := sdb.bmt.Hash()
+
+	dump, err := DumpState(db, root)
+	assert.Nil(t, err)
+	assert.Equal(t, legacy, dump.Tree)
+	assert.Equal(t, legacy, dump.Accounts[0].Tree)
+	data, err := dump.EncodeJSON()
+	assert.Nil(t, err)
+	jsonDump, err := DecodeDumpJSON(data)
+	assert.Nil(t, err)
+	assert.Equal(t, dump, jsonDump)
+	binDump, err := DecodeDumpBinary(dump.EncodeBinary())
+	assert.Nil(t, err)
+	assert.Equal(t, dump, binDump)
+
+	os.RemoveAll("state_test_import_shape")
+	defer os.RemoveAll("state_test_import_shape")
+	db2, err := leveldb.NewLDBDataBase("state_test_import_shape")
+	assert.Nil(t, err)
+	defer db2.Close()
+
+	// Nothing is written if root mismatches
+	binDump.Accounts[0].Storage[key1] = val2
+	_, err = ImportDump(db2, binDump)
+	assert.Equal(t, ErrRootMismatch, err)
+	it := db2.NewIterator(nil)
+	assert.False(t, it.Next())
+	it.Release()
+
+	imported, err := ImportDump(db2, dump)
+	assert.Nil(t, err)
+	assert.Equal(t, root, imported)
+	assert.Equal(t, val1, New(db2, root.Bytes()).GetState(addr1, key1))
+}
+
+func TestDiffState(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_diff")
+	defer os.RemoveAll("state_test_diff")
+	defer db.Close()
+
+	sdb.SetBalance(addr1, big.NewInt(100))
+	sdb.SetState(addr1, key1, val1)
+	assert.Nil(t, sdb.Commit())
+	root1 := sdb.bmt.Hash()
+
+	sdb.SetState(addr1, key1, val2)
+	sdb.SetState(addr1, val1, val1)
+	sdb.SetNonce(addr2, 1)
+	assert.Nil(t, sdb.Commit())
+	root2 := sdb.bmt.Hash()
+
+	diffs, err := DiffState(db, root1, db, root2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(diffs))
+	for _, diff := range diffs {
+		switch diff.Address {
+		case addr1:
+			assert.Equal(t, "changed", diff.Kind())
+			assert.ElementsMatch(t, []*StorageDiff{
+				{Key: key1, Old: val1, New: val2},
+				{Key: val1, New: val1},
+			}, diff.Storage)
+		case addr2:
+			assert.Equal(t, "added", diff.Kind())
+			assert.Equal(t, uint64(1), diff.New.Nonce)
+		default:
+			t.Fatalf("unexpected account diff %x", diff.Address)
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_snapshot")
+	defer os.RemoveAll("state_test_snapshot")
+	defer db.Close()
+
+	snaps, err := NewSnapshots(db, common.Hash{}, &SnapshotConfig{Layers: 2})
+	assert.Nil(t, err)
+	sdb.SetSnapshots(snaps)
+
+	var roots []common.Hash
+	for i := 1; i <= 4; i++ {
+		sdb.SetBalance(addr1, big.NewInt(int64(i)))
+		sdb.SetState(addr1, common.BytesToHash([]byte{byte(i)}), val1)
+		assert.Nil(t, sdb.Commit())
+		roots = append(roots, sdb.bmt.Hash())
+		assert.NotNil(t, sdb.snap)
+	}
+	// Oldest layers are flattened into disk layer
+	assert.Nil(t, snaps.layer(roots[0]))
+	assert.NotNil(t, snaps.layer(roots[1]))
+
+	// Destruct and recreate account
+	sdb.Suicide(addr1)
+	sdb.SetNonce(addr2, 1)
+	assert.Nil(t, sdb.Commit())
+	sdb.CreateAccount(addr1)
+	sdb.SetState(addr1, key1, val2)
+	sdb.SetNonce(addr1, 5)
+	assert.Nil(t, sdb.Commit())
+	root := sdb.bmt.Hash()
+
+	check := func(sdb *StateDB) {
+		assert.NotNil(t, sdb.snap)
+		assert.Equal(t, uint64(5), sdb.GetNonce(addr1))
+		assert.Equal(t, big.NewInt(0), sdb.GetBalance(addr1))
+		assert.Equal(t, val2, sdb.GetState(addr1, key1))
+		assert.Equal(t, common.Hash{}, sdb.GetState(addr1, common.BytesToHash([]byte{1})))
+		assert.Equal(t, uint64(1), sdb.GetNonce(addr2))
+	}
+	fresh := New(db, root.Bytes())
+	fresh.SetSnapshots(snaps)
+	check(fresh)
+
+	// Fork from the disk layer keeps the canonical layers
+	fork := New(db, roots[3].Bytes())
+	fork.SetSnapshots(snaps)
+	assert.NotNil(t, fork.snap)
+	assert.Equal(t, big.NewInt(4), fork.GetBalance(addr1))
+	fork.SetNonce(addr2, 9)
+	assert.Nil(t, fork.Commit())
+	forkRoot := fork.bmt.Hash()
+	assert.NotNil(t, fork.snap)
+	assert.Equal(t, uint64(9), fork.GetNonce(addr2))
+	fresh = New(db, root.Bytes())
+	fresh.SetSnapshots(snaps)
+	check(fresh)
+
+	// Flattening the canonical branch discards the fork
+	sdb.SetNonce(addr2, 2)
+	assert.Nil(t, sdb.Commit())
+	head := sdb.bmt.Hash()
+	assert.NotNil(t, sdb.snap)
+	assert.NotNil(t, snaps.layer(root))
+	assert.Nil(t, snaps.layer(forkRoot))
+
+	// Flattened snapshot is reused
+	assert.Nil(t, snaps.Close())
+	snaps, err = NewSnapshots(db, head, DefaultSnapshotConfig())
+	assert.Nil(t, err)
+	fresh = New(db, head.Bytes())
+	fresh.SetSnapshots(snaps)
+	assert.NotNil(t, fresh.snap)
+	assert.Equal(t, uint64(2), fresh.GetNonce(addr2))
+	assert.Equal(t, val2, fresh.GetState(addr1, key1))
+
+	// Regenerated from bucket tree
+	snaps, err = NewSnapshots(db, root, DefaultSnapshotConfig())
+	assert.Nil(t, err)
+	fresh = New(db, root.Bytes())
+	fresh.SetSnapshots(snaps)
+	check(fresh)
+}
+
+func TestStateDB_CodeRefs(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_code")
+	defer os.RemoveAll("state_test_code")
+	defer db.Close()
+
+	code := []byte{0x60, 0x00, 0x60, 0x00, 0xf3}
+	codeHash := common.Sha256(code)
+	refs := func() uint64 {
+		n, ok, err := CodeRefs(db, codeHash)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		return n
+	}
+
+	// Identical code of two contracts is stored once
+	sdb.SetCode(addr1, code)
+	sdb.SetCode(addr2, code)
+	assert.Nil(t, sdb.Commit())
+	root1 := sdb.bmt.Hash()
+	assert.Equal(t, uint64(2), refs())
+
+	// Code size is read from metadata
+	fresh := New(db, root1.Bytes())
+	assert.Equal(t, len(code), fresh.GetCodeSize(addr1))
+	assert.Nil(t, fresh.GetStateObj(addr1).code)
+	assert.Equal(t, code, fresh.GetCode(addr1))
+
+	// Recreated account releases its code
+	sdb.CreateAccount(addr1)
+	sdb.SetBalance(addr1, big.NewInt(1))
+	assert.Nil(t, sdb.Commit())
+	assert.Equal(t, uint64(1), refs())
+	sdb.Suicide(addr2)
+	assert.Nil(t, sdb.Commit())
+	root3 := sdb.bmt.Hash()
+	assert.Equal(t, uint64(0), refs())
+
+	// Orphaned code is kept while historical state holds it
+	_, err := PruneState(db, []common.Hash{root1, root3})
+	assert.Nil(t, err)
+	assert.Equal(t, code, New(db, root1.Bytes()).GetCode(addr2))
+	_, err = PruneState(db, []common.Hash{root3})
+	assert.Nil(t, err)
+	_, ok, err := CodeRefs(db, codeHash)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = db.Get(append([]byte(KeyContractCode), codeHash.Bytes()...))
+	assert.NotNil(t, err)
+
+	// Referenced again after pruning
+	sdb.SetCode(addr2, code)
+	assert.Nil(t, sdb.Commit())
+	assert.Equal(t, uint64(1), refs())
+	assert.Equal(t, code, New(db, sdb.bmt.Hash().Bytes()).GetCode(addr2))
+
+	// Pruning of another database doesn't track code of db
+	other, otherDB := newTestState(t, "state_test_code_other")
+	defer os.RemoveAll("state_test_code_other")
+	defer otherDB.Close()
+	startCodePruning(otherDB)
+	sdb.SetCode(addr1, code)
+	assert.Nil(t, sdb.Commit())
+	assert.Nil(t, getCodeRefs(db).touched)
+	other.SetCode(addr1, code)
+	assert.Nil(t, other.Commit())
+	assert.Equal(t, 1, len(getCodeRefs(otherDB).touched))
+}
+
+func TestStateDB_Resize(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_resize")
+	defer os.RemoveAll("state_test_resize")
+	defer db.Close()
+
+	// State committed with the shape of older nodes
+	stateConfig, storageConfig := *StateTreeConfig, *StorageTreeConfig
+	*StateTreeConfig, *StorageTreeConfig = *bmt.DefaultConfig(), *bmt.DefaultConfig()
+	sdb = New(db, nil)
+	sdb.SetBalance(addr1, big.NewInt(100))
+	sdb.SetState(addr1, key1, val1)
+	sdb.SetBalance(addr2, big.NewInt(50))
+	assert.Nil(t, sdb.Commit())
+	*StateTreeConfig, *StorageTreeConfig = stateConfig, storageConfig
+
+	sdb = New(db, sdb.bmt.Hash().Bytes())
+	assert.Equal(t, 4, sdb.bmt.Config().Capacity)
+	sdb.SetResize(true)
+	sdb.AddBalance(addr1, big.NewInt(1))
+	assert.Nil(t, sdb.Commit())
+	assert.Equal(t, StateTreeConfig.Capacity, sdb.bmt.Config().Capacity)
+	assert.Equal(t, StorageTreeConfig.Capacity, sdb.StateBmt(addr1).Config().Capacity)
+
+	// Root is the same as state created with the configured shape
+	expect := New(db, nil)
+	expect.SetBalance(addr1, big.NewInt(101))
+	expect.SetState(addr1, key1, val1)
+	expect.SetBalance(addr2, big.NewInt(50))
+	root, err := expect.IntermediateRoot()
+	assert.Nil(t, err)
+	assert.Equal(t, root, sdb.bmt.Hash())
+	assert.Equal(t, val1, New(db, root.Bytes()).GetState(addr1, key1))
+}
+
+func TestPruner_Checkpoints(t *testing.T) {
+	sdb, db := newTestState(t, "state_test_checkpoint")
+	defer os.RemoveAll("state_test_checkpoint")
+	defer db.Close()
+
+	config := &PrunerConfig{KeepRecent: 1, Interval: 1, CheckpointInterval: 2}
+	pruner := NewPruner(db, config)
+	sdb.SetPruner(pruner)
+	sdb.SetBalance(addr1, big.NewInt(1))
+	assert.Nil(t, sdb.Commit())
+	root1 := sdb.bmt.Hash()
+	assert.Nil(t, pruner.Finalize(big.NewInt(1), root1))
+	assert.Equal(t, 0, len(Checkpoints(db)))
+	assert.Nil(t, pruner.Finalize(big.NewInt(2), root1))
+	assert.Equal(t, []common.Hash{root1}, Checkpoints(db))
+	pruner.Close()
+
+	// Checkpoints are loaded by a new pruner
+	pruner = NewPruner(db, config)
+	sdb.SetPruner(pruner)
+	for i := int64(2); i < 5; i++ {
+		sdb.SetBalance(addr1, big.NewInt(i))
+		assert.Nil(t, sdb.Commit())
+		pruner.Wait()
+	}
+	pruner.Close()
+	assert.Equal(t, big.NewInt(1), New(db, root1.Bytes()).GetBalance(addr1))
+}
@@ -7,6 +7,9 @@ import (
 	"tinychain/core/vm"
 )
 
+// StateDB must satisfy the state interface required by EVM
+var _ vm.StateDB = (*state.StateDB)(nil)
+
 type StateProcessor struct {
 	bc      *Blockchain
 	statedb *state.StateDB
@@ -25,9 +28,10 @@ func (sp *StateProcessor) Process(block *types.Block) (types.Receipts, error) {
 		receipts types.Receipts
 		header   = block.Header
 	)
+	sp.statedb.SetResize(sp.bc.Config().IsResize(header.Height))
 
 	for _, tx := range block.Transactions {
-		receipt, err := ApplyTransaction(sp.bc, nil, sp.statedb, header, tx)
+		receipt, err := ApplyTransaction(sp.bc, nil, sp.statedb, header, tx, vm.Config{})
 		if err != nil {
 			return nil, nil
 		}
@@ -37,14 +41,20 @@ func (sp *StateProcessor) Process(block *types.Block) (types.Receipts, error) {
 	return receipts, nil
 }
 
-func ApplyTransaction(bc *Blockchain, author *common.Address, statedb *state.StateDB, header *types.Header, tx *types.Transaction) (*types.Receipt, error) {
+// ApplyTransaction applies tx to statedb, and returns the receipt.
+// Set cfg.Debug and cfg.Tracer to trace the execution.
+func ApplyTransaction(bc *Blockchain, author *common.Address, statedb *state.StateDB, header *types.Header, tx *types.Transaction, cfg vm.Config) (*types.Receipt, error) {
 	// Create a new context to be used in the EVM environment
 	context := NewEVMContext(tx, header, bc, author)
 	// Create a new environment which holds all relevant information
 	// about the transaction and calling mechanisms
-	vmenv := vm.NewEVM(context, statedb, nil, nil)
+	vmenv := vm.NewEVM(context, statedb, bc.Config().EVMRules(), bc.Config().VMConfig(cfg))
+	// Record tx hash in logs
+	statedb.Prepare(tx.Hash())
+	statedb.SetResize(bc.Config().IsResize(header.Height))
+	statedb.SetDeleteEmpty(bc.Config().EVMRules().IsEIP158(header.Height))
 	// Apply the tx to current state
-	_, gasUsed, failed, err := ApplyTx(vmenv, tx)
+	_, gasUsed, failed, err := ApplyTx(bc.Config(), vmenv, tx)
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +64,7 @@ func ApplyTransaction(bc *Blockchain, author *common.Address, statedb *state.Sta
 		return nil, err
 	}
 	receipt := types.NewRecipet(root, failed, tx.Hash(), gasUsed)
+	receipt.SetLogs(statedb.GetLogs(tx.Hash()))
 	if tx.To.Nil() {
 		// Create contract call
 		receipt.SetContractAddress(common.CreateAddress(tx.From, tx.Nonce))
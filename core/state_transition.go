@@ -5,6 +5,9 @@ import (
 	"tinychain/core/vm"
 	"errors"
 	"tinychain/core/types"
+	"tinychain/core/jsvm"
+	"tinychain/core/wasm"
+	_ "tinychain/core/native" // register native contracts to evm
 )
 
 var (
@@ -15,12 +18,14 @@ var (
 
 type StateTransition struct {
 	tx      *types.Transaction // state transition event
+	config  *ChainConfig       // rules of jsvm and wasm contracts
 	evm     *vm.EVM
 	statedb vm.StateDB
 }
 
-func NewStateTransition(evm *vm.EVM, tx *types.Transaction) *StateTransition {
+func NewStateTransition(config *ChainConfig, evm *vm.EVM, tx *types.Transaction) *StateTransition {
 	return &StateTransition{
+		config:  config,
 		evm:     evm,
 		tx:      tx,
 		statedb: evm.StateDB,
@@ -28,8 +33,8 @@ func NewStateTransition(evm *vm.EVM, tx *types.Transaction) *StateTransition {
 }
 
 // Make state transition by applying a new event
-func ApplyTx(evm *vm.EVM, tx *types.Transaction) ([]byte, uint64, bool, error) {
-	return NewStateTransition(evm, tx).Process()
+func ApplyTx(config *ChainConfig, evm *vm.EVM, tx *types.Transaction) ([]byte, uint64, bool, error) {
+	return NewStateTransition(config, evm, tx).Process()
 }
 
 // Check nonce is correct or not
@@ -67,6 +72,19 @@ func (st *StateTransition) to() vm.AccountRef {
 	return vm.AccountRef(to)
 }
 
+// runtimeOf returns the runtime executing code, which shares context with evm.
+// Code is routed by its prefix to jsvm or wasm, and the rest is run by evm.
+// Runtimes apply the rules of config, as evm does.
+func runtimeOf(config *ChainConfig, evm *vm.EVM, code []byte) wasm.Runtime {
+	switch {
+	case jsvm.IsJSCode(code):
+		return jsvm.New(evm.Context, evm.StateDB, config.JSVMConfig())
+	case wasm.IsWasmCode(code):
+		return wasm.New(evm.Context, evm.StateDB, config.WASMConfig(), evm)
+	}
+	return evm
+}
+
 func (st *StateTransition) data() []byte {
 	return st.tx.Payload
 }
@@ -92,11 +110,12 @@ func (st *StateTransition) Process() ([]byte, uint64, bool, error) {
 	)
 	if (st.to() == vm.AccountRef{}) {
 		// Contract create
-		ret, _, leftGas, vmerr = st.evm.Create(st.to(), st.data(), st.gas(), st.value())
+		ret, _, leftGas, vmerr = runtimeOf(st.config, st.evm, st.data()).Create(st.from(), st.data(), st.gas(), st.value())
 	} else {
 		// Call contract
 		st.statedb.SetNonce(st.from().Address(), st.statedb.GetNonce(st.from().Address())+1)
-		ret, leftGas, vmerr = st.evm.Call(st.from(), st.to().Address(), st.data(), MaxGas, st.value())
+		code := st.statedb.GetCode(st.to().Address())
+		ret, leftGas, vmerr = runtimeOf(st.config, st.evm, code).Call(st.from(), st.to().Address(), st.data(), st.gas(), st.value())
 	}
 	if vmerr != nil {
 		log.Errorf("VM returned with error %s", vmerr)
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"tinychain/common"
+	"tinychain/core/types"
+	"tinychain/core/vm"
+	"math/big"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyTransaction_Call(t *testing.T) {
+	bc := newTestChain(t)
+	header, statedb, err := bc.callState(common.Hash{})
+	assert.Nil(t, err)
+
+	// Evm call is charged within the gas limit of tx. Status of receipt is
+	// set if tx failed.
+	tx := types.NewTransaction(0, 0, 100000, new(big.Int), nil, sender, evmAddr)
+	receipt, err := ApplyTransaction(bc, nil, statedb, header, tx, vm.Config{})
+	assert.Nil(t, err)
+	assert.False(t, receipt.Status)
+	assert.True(t, receipt.GasUsed > 20000)
+	assert.True(t, receipt.GasUsed <= tx.GasLimit)
+	assert.Equal(t, common.BytesToHash([]byte{1}), statedb.GetState(evmAddr, common.Hash{}))
+
+	// Evm call fails if gas limit of tx is not enough
+	tx = types.NewTransaction(1, 0, 1000, new(big.Int), nil, sender, evmAddr)
+	receipt, err = ApplyTransaction(bc, nil, statedb, header, tx, vm.Config{})
+	assert.Nil(t, err)
+	assert.True(t, receipt.Status)
+	assert.Equal(t, tx.GasLimit, receipt.GasUsed)
+}
+
+func TestApplyTransaction_Create(t *testing.T) {
+	bc := newTestChain(t)
+	header, statedb, err := bc.callState(common.Hash{})
+	assert.Nil(t, err)
+
+	// PUSH6 storeCode PUSH1 0 MSTORE PUSH1 6 PUSH1 26 RETURN
+	initCode := append(append([]byte{0x65}, storeCode...), 0x60, 0x00, 0x52, 0x60, 0x06, 0x60, 0x1a, 0xf3)
+	tx := types.NewTransaction(0, 0, 100000, new(big.Int), initCode, sender, common.Address{})
+	receipt, err := ApplyTransaction(bc, nil, statedb, header, tx, vm.Config{})
+	assert.Nil(t, err)
+	assert.False(t, receipt.Status)
+	assert.True(t, receipt.GasUsed <= tx.GasLimit)
+
+	// Evm contract is created by sender at the address of receipt
+	addr := common.CreateAddress(sender, 0)
+	assert.Equal(t, addr, receipt.ContractAddress)
+	assert.Equal(t, storeCode, statedb.GetCode(addr))
+	assert.Equal(t, uint64(1), statedb.GetNonce(sender))
+}
@@ -0,0 +1,167 @@
+package tracers
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"math/big"
+	"time"
+	json "github.com/json-iterator/go"
+)
+
+// CallFrame is a call or contract creation in the call tree
+type CallFrame struct {
+	Type    string
+	From    common.Address
+	To      common.Address
+	Value   *big.Int
+	Gas     uint64
+	GasUsed uint64
+	Input   []byte
+	Output  []byte
+	Error   string
+	Calls   []*CallFrame
+}
+
+type jsonCallFrame struct {
+	Type    string       `json:"type"`
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Value   string       `json:"value,omitempty"`
+	Gas     uint64       `json:"gas"`
+	GasUsed uint64       `json:"gasUsed"`
+	Input   string       `json:"input"`
+	Output  string       `json:"output,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Calls   []*CallFrame `json:"calls,omitempty"`
+}
+
+func (f *CallFrame) MarshalJSON() ([]byte, error) {
+	enc := &jsonCallFrame{
+		Type:    f.Type,
+		From:    string(common.Hex(f.From[:])),
+		To:      string(common.Hex(f.To[:])),
+		Gas:     f.Gas,
+		GasUsed: f.GasUsed,
+		Input:   string(common.Hex(f.Input)),
+		Error:   f.Error,
+		Calls:   f.Calls,
+	}
+	if f.Value != nil {
+		enc.Value = f.Value.String()
+	}
+	if len(f.Output) > 0 {
+		enc.Output = string(common.Hex(f.Output))
+	}
+	return json.Marshal(enc)
+}
+
+// CallTracer builds the nested call tree of a transaction, with gas, input,
+// output and error of every call frame.
+type CallTracer struct {
+	root  *CallFrame
+	stack []*CallFrame // frames being executed
+}
+
+func NewCallTracer() *CallTracer {
+	return &CallTracer{}
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func copyBig(v *big.Int) *big.Int {
+	if v == nil {
+		return nil
+	}
+	return new(big.Int).Set(v)
+}
+
+func (ct *CallTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	ct.root = &CallFrame{
+		Type:  typ.String(),
+		From:  from,
+		To:    to,
+		Value: copyBig(value),
+		Gas:   gas,
+		Input: copyBytes(input),
+	}
+	ct.stack = []*CallFrame{ct.root}
+	return nil
+}
+
+func (ct *CallTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	frame := &CallFrame{
+		Type:  typ.String(),
+		From:  from,
+		To:    to,
+		Value: copyBig(value),
+		Gas:   gas,
+		Input: copyBytes(input),
+	}
+	if n := len(ct.stack); n > 0 {
+		parent := ct.stack[n-1]
+		parent.Calls = append(parent.Calls, frame)
+	}
+	ct.stack = append(ct.stack, frame)
+}
+
+func (ct *CallTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	n := len(ct.stack)
+	if n <= 1 {
+		return
+	}
+	frame := ct.stack[n-1]
+	ct.stack = ct.stack[:n-1]
+	frame.finish(output, gasUsed, err)
+}
+
+func (ct *CallTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Self destruct transfers all balance to the beneficiary
+	if op == vm.SELFDESTRUCT && len(ct.stack) > 0 && len(stack.Data()) > 0 {
+		parent := ct.stack[len(ct.stack)-1]
+		parent.Calls = append(parent.Calls, &CallFrame{
+			Type:  op.String(),
+			From:  contract.Address(),
+			To:    wordToAddress(stack.Back(0)),
+			Value: copyBig(env.StateDB.GetBalance(contract.Address())),
+		})
+	}
+	return nil
+}
+
+func (ct *CallTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (ct *CallTracer) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	if ct.root != nil {
+		ct.root.finish(output, gasUsed, err)
+	}
+	ct.stack = nil
+	return nil
+}
+
+func (f *CallFrame) finish(output []byte, gasUsed uint64, err error) {
+	f.GasUsed = gasUsed
+	f.Output = copyBytes(output)
+	if err != nil {
+		f.Error = err.Error()
+	}
+}
+
+// Result returns the root call frame
+func (ct *CallTracer) Result() *CallFrame {
+	return ct.root
+}
+
+func (ct *CallTracer) GetResult() (interface{}, error) {
+	return ct.root, nil
+}
@@ -0,0 +1,149 @@
+package tracers
+
+import (
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/core/vm"
+	"math/big"
+	"time"
+	json "github.com/json-iterator/go"
+)
+
+type AccountState struct {
+	Balance *big.Int
+	Nonce   uint64
+	Code    []byte
+	Storage map[common.Hash]common.Hash
+}
+
+func (as *AccountState) MarshalJSON() ([]byte, error) {
+	enc := struct {
+		Balance string            `json:"balance"`
+		Nonce   uint64            `json:"nonce"`
+		Code    string            `json:"code,omitempty"`
+		Storage map[string]string `json:"storage,omitempty"`
+	}{
+		Balance: as.Balance.String(),
+		Nonce:   as.Nonce,
+	}
+	if len(as.Code) > 0 {
+		enc.Code = string(common.Hex(as.Code))
+	}
+	if len(as.Storage) > 0 {
+		enc.Storage = make(map[string]string, len(as.Storage))
+		for key, value := range as.Storage {
+			enc.Storage[string(common.Hex(key[:]))] = string(common.Hex(value[:]))
+		}
+	}
+	return json.Marshal(enc)
+}
+
+// PrestateResult holds the state of touched accounts before and after
+// the transaction. Accounts not existing are absent.
+type PrestateResult struct {
+	Pre  map[string]*AccountState `json:"pre"`
+	Post map[string]*AccountState `json:"post"`
+}
+
+// PrestateTracer collects every account and storage slot touched by the
+// transaction, and reports their state before and after execution.
+type PrestateTracer struct {
+	pre     *state.StateDB // copy of state before the transaction
+	post    *state.StateDB // state the transaction is applied on
+	touched map[common.Address]map[common.Hash]struct{}
+}
+
+// NewPrestateTracer creates a prestate tracer for the transaction applied on statedb.
+// It must be created before the transaction changes statedb.
+func NewPrestateTracer(statedb *state.StateDB) *PrestateTracer {
+	return &PrestateTracer{
+		pre:     statedb.Copy(),
+		post:    statedb,
+		touched: make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+// Touch adds an account to the result, e.g. coinbase which receives the fee
+func (pt *PrestateTracer) Touch(addr common.Address) {
+	if _, ok := pt.touched[addr]; !ok {
+		pt.touched[addr] = make(map[common.Hash]struct{})
+	}
+}
+
+func (pt *PrestateTracer) touchSlot(addr common.Address, key common.Hash) {
+	pt.Touch(addr)
+	pt.touched[addr][key] = struct{}{}
+}
+
+func (pt *PrestateTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	pt.Touch(from)
+	pt.Touch(to)
+	return nil
+}
+
+func (pt *PrestateTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	pt.Touch(to)
+}
+
+func (pt *PrestateTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+}
+
+func (pt *PrestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if len(stack.Data()) == 0 {
+		return nil
+	}
+	switch op {
+	case vm.SLOAD, vm.SSTORE:
+		pt.touchSlot(contract.Address(), common.BigToHash(stack.Back(0)))
+	case vm.BALANCE, vm.EXTCODESIZE, vm.EXTCODECOPY, vm.SELFDESTRUCT:
+		pt.Touch(wordToAddress(stack.Back(0)))
+	}
+	return nil
+}
+
+func (pt *PrestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (pt *PrestateTracer) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	return nil
+}
+
+// Result reads the touched accounts from the state before and after the transaction.
+// It should be called after the transaction is finalized.
+func (pt *PrestateTracer) Result() *PrestateResult {
+	result := &PrestateResult{
+		Pre:  make(map[string]*AccountState),
+		Post: make(map[string]*AccountState),
+	}
+	for addr, slots := range pt.touched {
+		key := string(common.Hex(addr[:]))
+		if as := accountState(pt.pre, addr, slots); as != nil {
+			result.Pre[key] = as
+		}
+		if as := accountState(pt.post, addr, slots); as != nil {
+			result.Post[key] = as
+		}
+	}
+	return result
+}
+
+func (pt *PrestateTracer) GetResult() (interface{}, error) {
+	return pt.Result(), nil
+}
+
+func accountState(statedb *state.StateDB, addr common.Address, slots map[common.Hash]struct{}) *AccountState {
+	if !statedb.Exist(addr) {
+		return nil
+	}
+	as := &AccountState{
+		Balance: new(big.Int).Set(statedb.GetBalance(addr)),
+		Nonce:   statedb.GetNonce(addr),
+		Code:    copyBytes(statedb.GetCode(addr)),
+		Storage: make(map[common.Hash]common.Hash, len(slots)),
+	}
+	for key := range slots {
+		as.Storage[key] = statedb.GetState(addr, key)
+	}
+	return as
+}
@@ -0,0 +1,283 @@
+package tracers
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProfileMetric is the value of folded stacks in flame graph
+type ProfileMetric int
+
+const (
+	ProfileGas  ProfileMetric = iota // Gas used
+	ProfileTime                      // Wall time in nanoseconds
+)
+
+// ProfileStats is the aggregated cost of an opcode, contract, code position or call stack
+type ProfileStats struct {
+	Count uint64        // Executed steps
+	Gas   uint64        // Gas used by the steps, excluding gas of nested calls
+	Time  time.Duration // Wall time of the steps, excluding time of nested calls
+}
+
+func (s *ProfileStats) add(gas uint64, t time.Duration) {
+	s.Count++
+	s.Gas += gas
+	s.Time += t
+}
+
+type codePosition struct {
+	addr common.Address
+	pc   uint64
+	op   vm.OpCode
+}
+
+// profileStep is the step being executed, whose gas and time are added when it finishes
+type profileStep struct {
+	pos   codePosition
+	stack string // folded call stack of contracts
+	gas   uint64
+	time  time.Duration
+	start time.Time
+}
+
+// Profiler is the tracer aggregating gas and wall time of EVM execution per
+// opcode, per contract code and per code position. It can be reused across
+// transactions of a block or benchmark runs to aggregate all of them.
+//
+// Gas and time of CALL family opcodes exclude the nested call, which is
+// accounted to the callee. Wall time of a step is measured until the next
+// step, so it includes the overhead of the interpreter loop and tracer.
+type Profiler struct {
+	ops       map[vm.OpCode]*ProfileStats
+	contracts map[common.Address]*ProfileStats
+	positions map[codePosition]*ProfileStats
+	stacks    map[string]*ProfileStats // folded call stacks with opcode, e.g. "0xaa;0xbb;SSTORE"
+
+	txs     uint64
+	gasUsed uint64
+	elapsed time.Duration
+
+	frames  []string       // folded call stacks of executing frames
+	pending *profileStep   // step waiting for its finish
+	calls   []*profileStep // call steps waiting for nested calls
+	now     func() time.Time
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		ops:       make(map[vm.OpCode]*ProfileStats),
+		contracts: make(map[common.Address]*ProfileStats),
+		positions: make(map[codePosition]*ProfileStats),
+		stacks:    make(map[string]*ProfileStats),
+		now:       time.Now,
+	}
+}
+
+func (p *Profiler) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	p.frames = []string{addressName(to)}
+	p.pending, p.calls = nil, nil
+	return nil
+}
+
+func (p *Profiler) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	now := p.now()
+	p.finish(now)
+	addr := contract.Address()
+	if contract.CodeAddr != nil {
+		addr = *contract.CodeAddr
+	}
+	// Failed step consumes all the gas left
+	if err != nil {
+		cost = gas
+	}
+	var frame string
+	if n := len(p.frames); n > 0 {
+		frame = p.frames[n-1]
+	}
+	p.pending = &profileStep{
+		pos:   codePosition{addr: addr, pc: pc, op: op},
+		stack: frame,
+		gas:   cost,
+		start: now,
+	}
+	return nil
+}
+
+func (p *Profiler) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (p *Profiler) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	now := p.now()
+	step := p.pending
+	p.pending = nil
+	if step != nil {
+		step.time += now.Sub(step.start)
+		// The cost of CALL family includes the gas passed to the callee
+		if isCallOp(step.pos.op) {
+			if gas > step.gas {
+				gas = step.gas
+			}
+			step.gas -= gas
+		}
+	}
+	p.calls = append(p.calls, step)
+	parent := ""
+	if n := len(p.frames); n > 0 {
+		parent = p.frames[n-1] + ";"
+	}
+	p.frames = append(p.frames, parent+addressName(to))
+}
+
+func (p *Profiler) CaptureExit(output []byte, gasUsed uint64, err error) {
+	now := p.now()
+	p.finish(now)
+	if n := len(p.frames); n > 1 {
+		p.frames = p.frames[:n-1]
+	}
+	// Resume the call step until the next step of caller
+	if n := len(p.calls); n > 0 {
+		p.pending = p.calls[n-1]
+		p.calls = p.calls[:n-1]
+		if p.pending != nil {
+			p.pending.start = now
+		}
+	}
+}
+
+func (p *Profiler) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	p.finish(p.now())
+	p.txs++
+	p.gasUsed += gasUsed
+	p.elapsed += t
+	p.frames, p.calls = nil, nil
+	return nil
+}
+
+// finish adds the pending step to stats
+func (p *Profiler) finish(now time.Time) {
+	step := p.pending
+	if step == nil {
+		return
+	}
+	p.pending = nil
+	t := step.time + now.Sub(step.start)
+	if p.ops[step.pos.op] == nil {
+		p.ops[step.pos.op] = new(ProfileStats)
+	}
+	p.ops[step.pos.op].add(step.gas, t)
+	addr := step.pos.addr
+	if p.contracts[addr] == nil {
+		p.contracts[addr] = new(ProfileStats)
+	}
+	p.contracts[addr].add(step.gas, t)
+	if p.positions[step.pos] == nil {
+		p.positions[step.pos] = new(ProfileStats)
+	}
+	p.positions[step.pos].add(step.gas, t)
+	key := step.pos.op.String()
+	if step.stack != "" {
+		key = step.stack + ";" + key
+	}
+	if p.stacks[key] == nil {
+		p.stacks[key] = new(ProfileStats)
+	}
+	p.stacks[key].add(step.gas, t)
+}
+
+func isCallOp(op vm.OpCode) bool {
+	return op == vm.CALL || op == vm.CALLCODE || op == vm.DELEGATECALL || op == vm.STATICCALL
+}
+
+func addressName(addr common.Address) string {
+	return string(common.Hex(addr[:]))
+}
+
+// ProfileEntry is the stats of an opcode, contract or code position in result
+type ProfileEntry struct {
+	Name  string        `json:"name"` // Opcode, contract address, or "address:pc"
+	Op    string        `json:"op,omitempty"`
+	Count uint64        `json:"count"`
+	Gas   uint64        `json:"gas"`
+	Time  time.Duration `json:"timeNs"`
+}
+
+// ProfileResult is the report of profiler, entries are sorted by gas descending
+type ProfileResult struct {
+	Transactions uint64          `json:"transactions"`
+	GasUsed      uint64          `json:"gasUsed"` // Gas used by transactions, including intrinsic gas
+	Time         time.Duration   `json:"timeNs"`
+	Ops          []*ProfileEntry `json:"ops"`
+	Contracts    []*ProfileEntry `json:"contracts"`
+	Positions    []*ProfileEntry `json:"positions"`
+}
+
+// Result returns the report of all captured executions
+func (p *Profiler) Result() *ProfileResult {
+	res := &ProfileResult{
+		Transactions: p.txs,
+		GasUsed:      p.gasUsed,
+		Time:         p.elapsed,
+	}
+	for op, s := range p.ops {
+		res.Ops = append(res.Ops, newProfileEntry(op.String(), "", s))
+	}
+	for addr, s := range p.contracts {
+		res.Contracts = append(res.Contracts, newProfileEntry(addressName(addr), "", s))
+	}
+	for pos, s := range p.positions {
+		res.Positions = append(res.Positions, newProfileEntry(fmt.Sprintf("%s:%d", addressName(pos.addr), pos.pc), pos.op.String(), s))
+	}
+	sortProfileEntries(res.Ops)
+	sortProfileEntries(res.Contracts)
+	sortProfileEntries(res.Positions)
+	return res
+}
+
+func (p *Profiler) GetResult() (interface{}, error) {
+	return p.Result(), nil
+}
+
+func newProfileEntry(name, op string, s *ProfileStats) *ProfileEntry {
+	return &ProfileEntry{Name: name, Op: op, Count: s.Count, Gas: s.Gas, Time: s.Time}
+}
+
+func sortProfileEntries(entries []*ProfileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Gas != entries[j].Gas {
+			return entries[i].Gas > entries[j].Gas
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
+
+// WriteFolded writes the folded call stacks of contracts and opcodes with
+// metric, one stack per line like "0xaa;0xbb;SSTORE 20000", which is the input
+// format of flame graph tools such as flamegraph.pl and speedscope.
+func (p *Profiler) WriteFolded(w io.Writer, metric ProfileMetric) error {
+	keys := make([]string, 0, len(p.stacks))
+	for key := range p.stacks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		value := p.stacks[key].Gas
+		if metric == ProfileTime {
+			value = uint64(p.stacks[key].Time.Nanoseconds())
+		}
+		if value == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d\n", key, value)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,73 @@
+// Package tracers implements the EVM tracers used when replaying transactions.
+package tracers
+
+import (
+	"tinychain/core/state"
+	"tinychain/core/vm"
+	"tinychain/common"
+	"errors"
+	"math/big"
+)
+
+const (
+	StructLoggerName   = "structLogger"
+	CallTracerName     = "callTracer"
+	PrestateTracerName = "prestateTracer"
+	ProfilerName       = "profiler"
+)
+
+var (
+	ErrUnknownTracer = errors.New("unknown tracer")
+)
+
+// Tracer is a vm.Tracer that provides the result of tracing
+type Tracer interface {
+	vm.Tracer
+
+	// GetResult returns the json encodable result after execution
+	GetResult() (interface{}, error)
+}
+
+// New creates tracer by name. statedb is the state on which the transaction
+// is going to be applied, and must not be modified before tracer is created.
+func New(name string, statedb *state.StateDB, logConfig *vm.LogConfig) (Tracer, error) {
+	switch name {
+	case StructLoggerName, "":
+		return NewStructTracer(logConfig), nil
+	case CallTracerName:
+		return NewCallTracer(), nil
+	case PrestateTracerName:
+		return NewPrestateTracer(statedb), nil
+	case ProfilerName:
+		return NewProfiler(), nil
+	}
+	return nil, ErrUnknownTracer
+}
+
+// StructTracer wraps the opcode struct logger
+type StructTracer struct {
+	*vm.StructLogger
+}
+
+type StructLogResult struct {
+	Failed      bool           `json:"failed"`
+	ReturnValue string         `json:"returnValue"`
+	StructLogs  []vm.StructLog `json:"structLogs"`
+}
+
+func NewStructTracer(cfg *vm.LogConfig) *StructTracer {
+	return &StructTracer{vm.NewStructLogger(cfg)}
+}
+
+func (st *StructTracer) GetResult() (interface{}, error) {
+	return &StructLogResult{
+		Failed:      st.Error() != nil,
+		ReturnValue: string(common.Hex(st.Output())),
+		StructLogs:  st.StructLogs(),
+	}, nil
+}
+
+// wordToAddress converts a stack word to address with the lowest 20 bytes
+func wordToAddress(word *big.Int) common.Address {
+	return common.BytesToAddress(common.BigToHash(word).Bytes()[common.HashLength-common.AddressLength:])
+}
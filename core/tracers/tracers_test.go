@@ -0,0 +1,145 @@
+package tracers
+
+import (
+	"testing"
+	"math/big"
+	"errors"
+	"os"
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/core/vm"
+	"tinychain/db/leveldb"
+	"github.com/stretchr/testify/assert"
+	json "github.com/json-iterator/go"
+	"strings"
+	"time"
+)
+
+var (
+	addr1 = common.BytesToAddress([]byte("addr1_______________"))
+	addr2 = common.BytesToAddress([]byte("addr2_______________"))
+	addr3 = common.BytesToAddress([]byte("addr3_______________"))
+)
+
+func TestCallTracer(t *testing.T) {
+	tracer := NewCallTracer()
+	tracer.CaptureStart(addr1, addr2, false, []byte{1}, 1000, big.NewInt(10))
+	tracer.CaptureEnter(vm.DELEGATECALL, addr2, addr3, []byte{2}, 500, nil)
+	tracer.CaptureEnter(vm.STATICCALL, addr3, addr1, []byte{3}, 200, nil)
+	tracer.CaptureExit([]byte{4}, 50, nil)
+	tracer.CaptureExit(nil, 500, errors.New("out of gas"))
+	tracer.CaptureEnter(vm.CALL, addr2, addr3, nil, 300, big.NewInt(1))
+	tracer.CaptureExit([]byte{5}, 100, nil)
+	tracer.CaptureEnd([]byte{6}, 900, 0, nil)
+
+	root := tracer.Result()
+	assert.Equal(t, "CALL", root.Type)
+	assert.Equal(t, uint64(900), root.GasUsed)
+	assert.Equal(t, []byte{6}, root.Output)
+	assert.Equal(t, 2, len(root.Calls))
+
+	delegate := root.Calls[0]
+	assert.Equal(t, "DELEGATECALL", delegate.Type)
+	assert.Equal(t, addr3, delegate.To)
+	assert.Equal(t, "out of gas", delegate.Error)
+	assert.Equal(t, 1, len(delegate.Calls))
+	assert.Equal(t, "STATICCALL", delegate.Calls[0].Type)
+	assert.Equal(t, []byte{4}, delegate.Calls[0].Output)
+
+	assert.Equal(t, big.NewInt(1), root.Calls[1].Value)
+	assert.Equal(t, uint64(100), root.Calls[1].GasUsed)
+
+	_, err := json.Marshal(root)
+	assert.Nil(t, err)
+}
+
+func TestPrestateTracer(t *testing.T) {
+	os.RemoveAll("tracers_test")
+	defer os.RemoveAll("tracers_test")
+	db, err := leveldb.NewLDBDataBase("tracers_test")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	statedb := state.New(db, nil)
+	statedb.SetBalance(addr1, big.NewInt(100))
+	assert.Nil(t, statedb.Commit())
+
+	tracer, err := New(PrestateTracerName, statedb, nil)
+	assert.Nil(t, err)
+	tracer.CaptureStart(addr1, addr2, false, nil, 1000, big.NewInt(10))
+	statedb.SubBalance(addr1, big.NewInt(10))
+	statedb.AddBalance(addr2, big.NewInt(10))
+	statedb.SetNonce(addr1, 1)
+	assert.Nil(t, statedb.Commit())
+
+	res, err := tracer.GetResult()
+	assert.Nil(t, err)
+	result := res.(*PrestateResult)
+	key1, key2 := string(common.Hex(addr1[:])), string(common.Hex(addr2[:]))
+	assert.Equal(t, big.NewInt(100), result.Pre[key1].Balance)
+	assert.Nil(t, result.Pre[key2])
+	assert.Equal(t, big.NewInt(90), result.Post[key1].Balance)
+	assert.Equal(t, uint64(1), result.Post[key1].Nonce)
+	assert.Equal(t, big.NewInt(10), result.Post[key2].Balance)
+
+	_, err = New("unknown", statedb, nil)
+	assert.Equal(t, ErrUnknownTracer, err)
+}
+
+func newTestContract(addr common.Address) *vm.Contract {
+	contract := vm.NewContract(vm.AccountRef(addr1), vm.AccountRef(addr), new(big.Int), 0)
+	contract.SetCallCode(&addr, common.Hash{}, nil)
+	return contract
+}
+
+func TestProfiler(t *testing.T) {
+	profiler, err := New(ProfilerName, nil, nil)
+	assert.Nil(t, err)
+	p := profiler.(*Profiler)
+	// Every event takes 1ms
+	clock := time.Unix(0, 0)
+	p.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+
+	c1, c2 := newTestContract(addr2), newTestContract(addr3)
+	for i := 0; i < 2; i++ {
+		p.CaptureStart(addr1, addr2, false, nil, 10000, nil)
+		p.CaptureState(nil, 0, vm.PUSH1, 10000, 3, nil, nil, c1, 1, nil)
+		p.CaptureState(nil, 2, vm.CALL, 9997, 1200, nil, nil, c1, 1, nil)
+		p.CaptureEnter(vm.CALL, addr2, addr3, nil, 500, nil)
+		p.CaptureState(nil, 0, vm.SSTORE, 500, 400, nil, nil, c2, 2, nil)
+		p.CaptureExit(nil, 400, nil)
+		p.CaptureState(nil, 3, vm.ADD, 8897, 3, nil, nil, c1, 1, nil)
+		p.CaptureEnd(nil, 22000, time.Second, nil)
+	}
+
+	res := p.Result()
+	assert.Equal(t, uint64(2), res.Transactions)
+	assert.Equal(t, uint64(44000), res.GasUsed)
+	assert.Equal(t, 4, len(res.Ops))
+	// CALL excludes the gas passed to callee, and time of the nested call
+	assert.Equal(t, &ProfileEntry{Name: "CALL", Count: 2, Gas: 1400, Time: 4 * time.Millisecond}, res.Ops[0])
+	assert.Equal(t, &ProfileEntry{Name: "SSTORE", Count: 2, Gas: 800, Time: 2 * time.Millisecond}, res.Ops[1])
+	key2, key3 := string(common.Hex(addr2[:])), string(common.Hex(addr3[:]))
+	assert.Equal(t, key2, res.Contracts[0].Name)
+	assert.Equal(t, uint64(1412), res.Contracts[0].Gas)
+	assert.Equal(t, key3, res.Contracts[1].Name)
+	assert.Equal(t, &ProfileEntry{Name: key2 + ":2", Op: "CALL", Count: 2, Gas: 1400, Time: 4 * time.Millisecond}, res.Positions[0])
+
+	var gas, wall strings.Builder
+	assert.Nil(t, p.WriteFolded(&gas, ProfileGas))
+	assert.Equal(t, key2+";"+key3+";SSTORE 800\n"+key2+";ADD 6\n"+key2+";CALL 1400\n"+key2+";PUSH1 6\n", gas.String())
+	assert.Nil(t, p.WriteFolded(&wall, ProfileTime))
+	assert.True(t, strings.HasPrefix(wall.String(), key2+";"+key3+";SSTORE 2000000\n"))
+
+	// Failed step consumes all the gas left
+	p.CaptureStart(addr1, addr3, false, nil, 10000, nil)
+	p.CaptureState(nil, 0, vm.SSTORE, 300, 5000, nil, nil, c2, 1, vm.ErrOutOfGas)
+	p.CaptureEnd(nil, 10000, time.Second, vm.ErrOutOfGas)
+	assert.Equal(t, uint64(1100), p.Result().Ops[1].Gas)
+
+	_, err = json.Marshal(res)
+	assert.Nil(t, err)
+}
@@ -1,4 +1,17 @@
 package types
 
+import (
+	"tinychain/common"
+)
+
+// Log is an event emitted by contract execution
 type Log struct {
-}
\ No newline at end of file
+	Address common.Address `json:"address"` // Address of the contract emitting the event
+	Topics  []common.Hash  `json:"topics"`  // Indexed topics of the event
+	Data    []byte         `json:"data"`    // Non-indexed data of the event
+
+	// Derived fields, filled in by state
+	BlockNumber uint64      `json:"block_number"`
+	TxHash      common.Hash `json:"tx_hash"`
+	Index       uint        `json:"index"` // Index of log in the transaction
+}
@@ -15,6 +15,7 @@ type Receipt struct {
 	TxHash          common.Hash    `json:"tx_hash"`          // Transaction hash
 	ContractAddress common.Address `json:"contract_address"` // Contract address
 	GasUsed         uint64         `json:"gas_used"`         // gas used of transaction
+	Logs            []*Log         `json:"logs"`             // Logs emitted by contracts
 }
 
 func NewRecipet(root common.Hash, status bool, txHash common.Hash, gasUsed uint64) *Receipt {
@@ -30,6 +31,10 @@ func (re *Receipt) SetContractAddress(addr common.Address) {
 	re.ContractAddress = addr
 }
 
+func (re *Receipt) SetLogs(logs []*Log) {
+	re.Logs = logs
+}
+
 func (re *Receipt) Serialize() ([]byte, error) {
 	return json.Marshal(re)
 }
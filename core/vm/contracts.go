@@ -57,6 +57,11 @@ var PrecompiledContractsByzantium = map[common.Address]PrecompiledContract{
 	common.BytesToAddress([]byte{6}): &bn256Add{},
 	common.BytesToAddress([]byte{7}): &bn256ScalarMul{},
 	common.BytesToAddress([]byte{8}): &bn256Pairing{},
+
+	// Precompiles of libp2p keys used by tinychain, see contracts_libp2p.go
+	common.BytesToAddress([]byte{0x02, 0x00}): &libp2pSecp256k1Verify{},
+	common.BytesToAddress([]byte{0x02, 0x01}): &libp2pEd25519Verify{},
+	common.BytesToAddress([]byte{0x02, 0x02}): &pubkeyToAddress{},
 }
 
 // RunPrecompiledContract runs and evaluates the output of a precompiled contract.
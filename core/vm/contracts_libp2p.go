@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"errors"
+	"math/big"
+
+	"tinychain/common"
+	"github.com/libp2p/go-libp2p-crypto"
+)
+
+// Gas of the precompiled contracts for tinychain keys
+const (
+	Secp256k1VerifyGas   uint64 = 3000 // Base gas of secp256k1 signature verification
+	Ed25519VerifyGas     uint64 = 2000 // Base gas of ed25519 signature verification
+	VerifyPerWordGas     uint64 = 12   // Gas of hashing every word of input at verification
+	PubkeyToAddressGas   uint64 = 600  // Base gas of deriving address from public key
+	PubkeyPerWordGas     uint64 = 12   // Gas of hashing every word of public key at deriving address
+	verifyHeaderLength          = 64   // Length of the words of public key and signature length
+	precompileWordLength        = 32
+
+	// Length of marshaled secp256k1 public key, which is the longest of the
+	// supported keys: 2 bytes of type, 2 bytes of length and 33 bytes of
+	// compressed key. Ed25519 key is 36 bytes.
+	maxPubkeyLength = 37
+)
+
+var errUnsupportedPubkey = errors.New("unsupported public key")
+
+// Precompiled contracts verifying signatures of libp2p keys, which sign
+// transactions and p2p messages. They are at 0x0200+, which is reserved for
+// tinychain precompiles as 0x0100+ for native contracts, so that precompiles
+// of later ethereum forks don't collide with them. Public keys are marshaled
+// by crypto.MarshalPublicKey, as transaction public keys, and secp256k1
+// signatures are DER encoded over sha256 of the message. Only secp256k1 and
+// ed25519 keys are supported.
+//
+// Input of verification is the length of public key and signature in 32
+// bytes words, followed by public key, signature and message:
+//
+//	[pubkey length][signature length][pubkey][signature][message]
+//
+// Output is 1 in a 32 bytes word if the signature is valid, or 0 if invalid
+// or the input is malformed.
+
+// libp2pSecp256k1Verify verifies secp256k1 signature
+type libp2pSecp256k1Verify struct{}
+
+func (c *libp2pSecp256k1Verify) RequiredGas(input []byte) uint64 {
+	return uint64(len(input)+31)/32*VerifyPerWordGas + Secp256k1VerifyGas
+}
+
+func (c *libp2pSecp256k1Verify) Run(input []byte) ([]byte, error) {
+	return verifyLibp2pSignature(input, func(key crypto.PubKey) bool {
+		_, ok := key.(*crypto.Secp256k1PublicKey)
+		return ok
+	}), nil
+}
+
+// libp2pEd25519Verify verifies ed25519 signature
+type libp2pEd25519Verify struct{}
+
+func (c *libp2pEd25519Verify) RequiredGas(input []byte) uint64 {
+	return uint64(len(input)+31)/32*VerifyPerWordGas + Ed25519VerifyGas
+}
+
+func (c *libp2pEd25519Verify) Run(input []byte) ([]byte, error) {
+	return verifyLibp2pSignature(input, func(key crypto.PubKey) bool {
+		_, ok := key.(*crypto.Ed25519PublicKey)
+		return ok
+	}), nil
+}
+
+// pubkeyToAddress derives the tinychain address from the marshaled public key,
+// and returns it left padded to 32 bytes, or nothing if the key is invalid.
+type pubkeyToAddress struct{}
+
+func (c *pubkeyToAddress) RequiredGas(input []byte) uint64 {
+	return uint64(len(input)+31)/32*PubkeyPerWordGas + PubkeyToAddressGas
+}
+
+func (c *pubkeyToAddress) Run(input []byte) ([]byte, error) {
+	key, err := unmarshalPubkey(input)
+	if err != nil {
+		return nil, nil
+	}
+	addr, err := common.GenAddrByPubkey(key)
+	if err != nil {
+		return nil, nil
+	}
+	ret := make([]byte, precompileWordLength)
+	copy(ret[precompileWordLength-common.AddressLength:], addr[:])
+	return ret, nil
+}
+
+// verifyLibp2pSignature verifies the signature in input, by public key of the expected type
+func verifyLibp2pSignature(input []byte, expectType func(crypto.PubKey) bool) []byte {
+	ret := make([]byte, precompileWordLength)
+	if len(input) < verifyHeaderLength {
+		return ret
+	}
+	var (
+		body      = input[verifyHeaderLength:]
+		keyLen    = new(big.Int).SetBytes(input[:32])
+		sigLen    = new(big.Int).SetBytes(input[32:64])
+		bodyLen   = big.NewInt(int64(len(body)))
+		headerLen = new(big.Int).Add(keyLen, sigLen)
+	)
+	if headerLen.Cmp(bodyLen) > 0 {
+		return ret
+	}
+	var (
+		pubkey = body[:keyLen.Uint64()]
+		sig    = body[keyLen.Uint64():headerLen.Uint64()]
+		msg    = body[headerLen.Uint64():]
+	)
+	key, err := unmarshalPubkey(pubkey)
+	if err != nil || !expectType(key) {
+		return ret
+	}
+	if ok, err := key.Verify(msg, sig); err == nil && ok {
+		ret[precompileWordLength-1] = 1
+	}
+	return ret
+}
+
+// unmarshalPubkey unmarshals secp256k1 or ed25519 public key. Longer data,
+// e.g. of RSA keys which are expensive to parse, is rejected before unmarshaling.
+func unmarshalPubkey(data []byte) (crypto.PubKey, error) {
+	if len(data) > maxPubkeyLength {
+		return nil, errUnsupportedPubkey
+	}
+	key, err := crypto.UnmarshalPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *crypto.Secp256k1PublicKey, *crypto.Ed25519PublicKey:
+		return key, nil
+	}
+	return nil, errUnsupportedPubkey
+}
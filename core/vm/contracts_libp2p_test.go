@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"tinychain/common"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/libp2p/go-libp2p-crypto"
+)
+
+var (
+	secp256k1VerifyAddr = ethcommon.BytesToAddress([]byte{0x02, 0x00})
+	ed25519VerifyAddr   = ethcommon.BytesToAddress([]byte{0x02, 0x01})
+	pubkeyToAddressAddr = ethcommon.BytesToAddress([]byte{0x02, 0x02})
+)
+
+func runLibp2pPrecompiled(t *testing.T, addr ethcommon.Address, input []byte) []byte {
+	p := PrecompiledContractsByzantium[addr]
+	contract := NewContract(AccountRef(common.Address{}), nil, new(big.Int), p.RequiredGas(input))
+	res, err := RunPrecompiledContract(p, input, contract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func packVerifyInput(t *testing.T, pub crypto.PubKey, sig, msg []byte) []byte {
+	pubkey, err := crypto.MarshalPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := common.BigToHash(big.NewInt(int64(len(pubkey)))).Bytes()
+	input = append(input, common.BigToHash(big.NewInt(int64(len(sig)))).Bytes()...)
+	input = append(input, pubkey...)
+	input = append(input, sig...)
+	return append(input, msg...)
+}
+
+func TestPrecompiledLibp2pVerify(t *testing.T) {
+	var (
+		valid   = common.BigToHash(big.NewInt(1)).Bytes()
+		invalid = make([]byte, 32)
+		msg     = []byte("tinychain")
+	)
+	secpPriv, secpPub, _ := crypto.GenerateSecp256k1Key(rand.Reader)
+	edPriv, edPub, _ := crypto.GenerateEd25519Key(rand.Reader)
+	secpSig, err := secpPriv.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig, err := edPriv.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		addr     ethcommon.Address
+		input    []byte
+		expected []byte
+	}{
+		{"secp256k1", secp256k1VerifyAddr, packVerifyInput(t, secpPub, secpSig, msg), valid},
+		{"secp256k1-wrong-msg", secp256k1VerifyAddr, packVerifyInput(t, secpPub, secpSig, []byte("tiny")), invalid},
+		{"secp256k1-ed25519-key", secp256k1VerifyAddr, packVerifyInput(t, edPub, edSig, msg), invalid},
+		{"ed25519", ed25519VerifyAddr, packVerifyInput(t, edPub, edSig, msg), valid},
+		{"ed25519-wrong-sig", ed25519VerifyAddr, packVerifyInput(t, edPub, secpSig, msg), invalid},
+		{"ed25519-secp256k1-key", ed25519VerifyAddr, packVerifyInput(t, secpPub, secpSig, msg), invalid},
+		{"short-input", secp256k1VerifyAddr, []byte{1, 2, 3}, invalid},
+		{"overflow-length", ed25519VerifyAddr, bytes.Repeat([]byte{0xff}, 80), invalid},
+	}
+	for _, test := range tests {
+		if res := runLibp2pPrecompiled(t, test.addr, test.input); !bytes.Equal(res, test.expected) {
+			t.Errorf("%s: expected %x, got %x", test.name, test.expected, res)
+		}
+	}
+}
+
+func TestPrecompiledPubkeyToAddress(t *testing.T) {
+	_, pub, _ := crypto.GenerateSecp256k1Key(rand.Reader)
+	pubkey, _ := crypto.MarshalPublicKey(pub)
+	addr, err := common.GenAddrByPubkey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(make([]byte, 12), addr[:]...)
+	if res := runLibp2pPrecompiled(t, pubkeyToAddressAddr, pubkey); !bytes.Equal(res, expected) {
+		t.Errorf("expected %x, got %x", expected, res)
+	}
+	if res := runLibp2pPrecompiled(t, pubkeyToAddressAddr, []byte{1, 2, 3}); len(res) != 0 {
+		t.Errorf("expected empty output of invalid key, got %x", res)
+	}
+
+	// Ed25519 key is supported, and longer keys are rejected before unmarshaling
+	_, edPub, _ := crypto.GenerateEd25519Key(rand.Reader)
+	edPubkey, _ := crypto.MarshalPublicKey(edPub)
+	if len(pubkey) != maxPubkeyLength || len(edPubkey) > maxPubkeyLength {
+		t.Errorf("unexpected marshaled key length %d and %d", len(pubkey), len(edPubkey))
+	}
+	if res := runLibp2pPrecompiled(t, pubkeyToAddressAddr, edPubkey); len(res) != precompileWordLength {
+		t.Errorf("expected address of ed25519 key, got %x", res)
+	}
+	if res := runLibp2pPrecompiled(t, pubkeyToAddressAddr, append(pubkey, 0)); len(res) != 0 {
+		t.Errorf("expected empty output of long key, got %x", res)
+	}
+
+	// Gas is charged by words of input
+	p := PrecompiledContractsByzantium[pubkeyToAddressAddr]
+	if gas := p.RequiredGas(pubkey); gas != PubkeyToAddressGas+2*PubkeyPerWordGas {
+		t.Errorf("unexpected gas %d", gas)
+	}
+	if gas := p.RequiredGas(make([]byte, 1024)); gas != PubkeyToAddressGas+32*PubkeyPerWordGas {
+		t.Errorf("unexpected gas %d", gas)
+	}
+}
@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"errors"
+	"math/big"
+
+	"tinychain/common"
+)
+
+var ErrNativeDelegation = errors.New("native contract can not be called by delegatecall or callcode")
+
+// NativeContract is a native Go contract at reserved address. Unlike precompiled
+// contracts, it has access to the state of its own account, and charges gas
+// deterministically by NativeContext.UseGas while running.
+type NativeContract interface {
+	Run(ctx *NativeContext, input []byte) ([]byte, error)
+}
+
+// NativeContext is the environment of a native contract call
+type NativeContext struct {
+	Context
+	StateDB StateDB
+
+	Caller   common.Address
+	Address  common.Address // Address of the native contract
+	Value    *big.Int
+	ReadOnly bool   // Whether called by staticcall, and state should not be modified
+	Gas      uint64 // Gas left
+}
+
+// UseGas charges gas, and returns ErrOutOfGas if gas left is not enough
+func (ctx *NativeContext) UseGas(gas uint64) error {
+	if ctx.Gas < gas {
+		ctx.Gas = 0
+		return ErrOutOfGas
+	}
+	ctx.Gas -= gas
+	return nil
+}
+
+// NativeContracts are the native contracts by reserved address.
+// All nodes of a chain must register the same native contracts.
+var NativeContracts = make(map[common.Address]NativeContract)
+
+// RegisterNativeContract registers the native contract at addr, it should be called at init
+func RegisterNativeContract(addr common.Address, c NativeContract) {
+	if _, ok := NativeContracts[addr]; ok {
+		panic("native contract already registered at " + addr.Hex())
+	}
+	NativeContracts[addr] = c
+}
+
+// RunNativeContract runs the native contract with state of its own account
+func RunNativeContract(evm *EVM, c NativeContract, input []byte, contract *Contract) ([]byte, error) {
+	if contract.Address() != *contract.CodeAddr {
+		return nil, ErrNativeDelegation
+	}
+	ctx := &NativeContext{
+		Context:  evm.Context,
+		StateDB:  evm.StateDB,
+		Caller:   contract.Caller(),
+		Address:  contract.Address(),
+		Value:    contract.Value(),
+		ReadOnly: evm.interpreter.readOnly,
+		Gas:      contract.Gas,
+	}
+	// Keep the account from being deleted as empty, like created contracts (EIP161)
+	if !ctx.ReadOnly && evm.StateDB.GetNonce(ctx.Address) == 0 {
+		evm.StateDB.SetNonce(ctx.Address, 1)
+	}
+	ret, err := c.Run(ctx, input)
+	contract.Gas = ctx.Gas
+	return ret, err
+}
@@ -17,6 +17,7 @@
 package vm
 
 import (
+	"bytes"
 	"math/big"
 	"sync/atomic"
 	"time"
@@ -48,6 +49,9 @@ func run(evm *EVM, contract *Contract, input []byte) ([]byte, error) {
 		if p := precompiles[*contract.CodeAddr]; p != nil {
 			return RunPrecompiledContract(p, input, contract)
 		}
+		if c := NativeContracts[*contract.CodeAddr]; c != nil {
+			return RunNativeContract(evm, c, input, contract)
+		}
 	}
 	return evm.interpreter.Run(contract, input)
 }
@@ -159,7 +163,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		if evm.ChainConfig().IsByzantium(evm.BlockNumber) {
 			precompiles = PrecompiledContractsByzantium
 		}
-		if precompiles[addr] == nil && evm.ChainConfig().IsEIP158(evm.BlockNumber) && value.Sign() == 0 {
+		if precompiles[addr] == nil && NativeContracts[addr] == nil && evm.ChainConfig().IsEIP158(evm.BlockNumber) && value.Sign() == 0 {
 			return nil, gas, nil
 		}
 		evm.StateDB.CreateAccount(addr)
@@ -171,6 +175,14 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	contract := NewContract(caller, to, value, gas)
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
+	// Capture the nested call frame in debug mode
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		if tracer, ok := evm.vmConfig.Tracer.(CallFrameTracer); ok {
+			tracer.CaptureEnter(CALL, caller.Address(), addr, input, gas, value)
+			defer func() { tracer.CaptureExit(ret, gas-contract.Gas, err) }()
+		}
+	}
+
 	start := time.Now()
 
 	// Capture the tracer start/end events in debug mode
@@ -226,6 +238,14 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	contract := NewContract(caller, to, value, gas)
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
+	// Capture the nested call frame in debug mode
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		if tracer, ok := evm.vmConfig.Tracer.(CallFrameTracer); ok {
+			tracer.CaptureEnter(CALLCODE, caller.Address(), addr, input, gas, value)
+			defer func() { tracer.CaptureExit(ret, gas-contract.Gas, err) }()
+		}
+	}
+
 	ret, err = run(evm, contract, input)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
@@ -259,6 +279,14 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 	contract := NewContract(caller, to, nil, gas).AsDelegate()
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
+	// Capture the nested call frame in debug mode
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		if tracer, ok := evm.vmConfig.Tracer.(CallFrameTracer); ok {
+			tracer.CaptureEnter(DELEGATECALL, caller.Address(), addr, input, gas, contract.value)
+			defer func() { tracer.CaptureExit(ret, gas-contract.Gas, err) }()
+		}
+	}
+
 	ret, err = run(evm, contract, input)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
@@ -299,6 +327,14 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	contract := NewContract(caller, to, new(big.Int), gas)
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
+	// Capture the nested call frame in debug mode
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		if tracer, ok := evm.vmConfig.Tracer.(CallFrameTracer); ok {
+			tracer.CaptureEnter(STATICCALL, caller.Address(), addr, input, gas, nil)
+			defer func() { tracer.CaptureExit(ret, gas-contract.Gas, err) }()
+		}
+	}
+
 	// When an error was returned by the EVM or when setting the creation code
 	// above we revert to the snapshot and consume any gas remaining. Additionally
 	// when we're in Homestead this also counts for code storage gas errors.
@@ -353,17 +389,34 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 	if evm.vmConfig.Debug && evm.depth == 0 {
 		evm.vmConfig.Tracer.CaptureStart(caller.Address(), contractAddr, true, code, gas, value)
 	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		if tracer, ok := evm.vmConfig.Tracer.(CallFrameTracer); ok {
+			tracer.CaptureEnter(CREATE, caller.Address(), contractAddr, code, gas, value)
+			defer func() { tracer.CaptureExit(ret, gas-contract.Gas, err) }()
+		}
+	}
 	start := time.Now()
 
 	ret, err = run(evm, contract, nil)
 
 	// check whether the max code size has been exceeded
-	maxCodeSizeExceeded := evm.ChainConfig().IsEIP158(evm.BlockNumber) && len(ret) > params.MaxCodeSize
+	maxCodeSize := params.MaxCodeSize
+	if evm.vmConfig.MaxCodeSize > 0 {
+		maxCodeSize = evm.vmConfig.MaxCodeSize
+	}
+	maxCodeSizeExceeded := evm.ChainConfig().IsEIP158(evm.BlockNumber) && len(ret) > maxCodeSize
+	// code carrying the prefix of another runtime would be executed by it
+	reservedCode := false
+	for _, prefix := range evm.vmConfig.ReservedCodePrefixes {
+		if bytes.HasPrefix(ret, prefix) {
+			reservedCode = true
+		}
+	}
 	// if the contract creation ran successfully and no errors were returned
 	// calculate the gas required to store the code. If the code could not
 	// be stored due to not enough gas set an error and let it be handled
 	// by the error checking condition below.
-	if err == nil && !maxCodeSizeExceeded {
+	if err == nil && !maxCodeSizeExceeded && !reservedCode {
 		createDataGas := uint64(len(ret)) * params.CreateDataGas
 		if contract.UseGas(createDataGas) {
 			evm.StateDB.SetCode(contractAddr, ret)
@@ -375,7 +428,7 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 	// When an error was returned by the EVM or when setting the creation code
 	// above we revert to the snapshot and consume any gas remaining. Additionally
 	// when we're in homestead this also counts for code storage gas errors.
-	if maxCodeSizeExceeded || (err != nil && (evm.ChainConfig().IsHomestead(evm.BlockNumber) || err != ErrCodeStoreOutOfGas)) {
+	if maxCodeSizeExceeded || reservedCode || (err != nil && (evm.ChainConfig().IsHomestead(evm.BlockNumber) || err != ErrCodeStoreOutOfGas)) {
 		evm.StateDB.RevertToSnapshot(snapshot)
 		if err != errExecutionReverted {
 			contract.UseGas(contract.Gas)
@@ -385,6 +438,9 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 	if maxCodeSizeExceeded && err == nil {
 		err = errMaxCodeSizeExceeded
 	}
+	if reservedCode && err == nil {
+		err = errReservedCodePrefix
+	}
 	if evm.vmConfig.Debug && evm.depth == 0 {
 		evm.vmConfig.Tracer.CaptureEnd(ret, gas-contract.Gas, time.Since(start), err)
 	}
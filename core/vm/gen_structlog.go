@@ -6,7 +6,7 @@ import (
 	json "github.com/json-iterator/go"
 	"math/big"
 
-	"github.com/ethereum/go-ethereum/common"
+	"tinychain/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/common/math"
 )
@@ -20,6 +20,7 @@ import (
 	"math/big"
 
 	"tinychain/common"
+	"tinychain/core/types"
 )
 
 // StateDB is an EVM database for full state querying.
@@ -57,7 +58,7 @@ type StateDB interface {
 	RevertToSnapshot(int)
 	Snapshot() int
 
-	//AddLog(*types.Log)
+	AddLog(*types.Log)
 	AddPreimage(common.Hash, []byte)
 
 	ForEachStorage(common.Address, func(common.Hash, common.Hash) bool)
@@ -35,6 +35,12 @@ type Config struct {
 	NoRecursion bool
 	// Enable recording of SHA3/keccak preimages
 	EnablePreimageRecording bool
+	// MaxCodeSize is the maximum size of contract code created,
+	// params.MaxCodeSize if zero
+	MaxCodeSize int
+	// ReservedCodePrefixes are the prefixes marking code of other
+	// runtimes, which can not be created by evm contracts
+	ReservedCodePrefixes [][]byte
 	// JumpTable contains the EVM instruction table. This
 	// may be left uninitialised and will be set to the default
 	// table.
@@ -23,10 +23,10 @@ import (
 	"math/big"
 	"time"
 
-	"github.com/ethereum/go-ethereum/common"
+	"tinychain/common"
+	"tinychain/core/types"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/common/math"
-	"github.com/ethereum/go-ethereum/core/types"
 )
 
 type Storage map[common.Hash]common.Hash
@@ -99,6 +99,14 @@ type Tracer interface {
 	CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error
 }
 
+// CallFrameTracer is an optional interface of Tracer, which is notified when
+// entering and exiting nested call frames. CaptureStart and CaptureEnd are only
+// called for the outermost frame.
+type CallFrameTracer interface {
+	CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int)
+	CaptureExit(output []byte, gasUsed uint64, err error)
+}
+
 // StructLogger is an EVM state logger and implements Tracer.
 //
 // StructLogger can capture state based on the given Log configuration and also keeps
@@ -235,7 +243,7 @@ func WriteTrace(writer io.Writer, logs []StructLog) {
 // WriteLogs writes vm logs in a readable format to the given writer
 func WriteLogs(writer io.Writer, logs []*types.Log) {
 	for _, log := range logs {
-		fmt.Fprintf(writer, "LOG%d: %x bn=%d txi=%x\n", len(log.Topics), log.Address, log.BlockNumber, log.TxIndex)
+		fmt.Fprintf(writer, "LOG%d: %x bn=%d tx=%x\n", len(log.Topics), log.Address, log.BlockNumber, log.TxHash)
 
 		for i, topic := range log.Topics {
 			fmt.Fprintf(writer, "%08d  %x\n", i, topic)
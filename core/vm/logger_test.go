@@ -20,7 +20,7 @@ import (
 	"math/big"
 	"testing"
 
-	"github.com/ethereum/go-ethereum/common"
+	"tinychain/common"
 	"github.com/ethereum/go-ethereum/params"
 )
 
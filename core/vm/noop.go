@@ -19,8 +19,8 @@ package vm
 import (
 	"math/big"
 
+	"tinychain/core/types"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
 )
 
 func NoopCanTransfer(db StateDB, from common.Address, balance *big.Int) bool {
@@ -35,6 +35,13 @@ func (op OpCode) IsStaticJump() bool {
 	return op == JUMP
 }
 
+// IsValid reports whether op is defined in the instruction set, excluding the
+// unofficial opcodes used for parsing
+func (op OpCode) IsValid() bool {
+	_, ok := opCodeToString[op]
+	return ok && (op < PUSH || op > SWAP)
+}
+
 const (
 	// 0x0 range - arithmetic ops
 	STOP OpCode = iota
@@ -17,14 +17,15 @@
 package runtime_test
 
 import (
+	"encoding/hex"
 	"fmt"
 
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"tinychain/core/vm/runtime"
 )
 
 func ExampleExecute() {
-	ret, _, err := runtime.Execute(common.Hex2Bytes("6060604052600a8060106000396000f360606040526008565b00"), nil, nil)
+	code, _ := hex.DecodeString("6060604052600a8060106000396000f360606040526008565b00")
+	ret, _, err := runtime.Execute(code, nil, nil)
 	if err != nil {
 		fmt.Println(err)
 	}
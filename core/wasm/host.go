@@ -0,0 +1,211 @@
+package wasm
+
+import (
+	"tinychain/common"
+	"tinychain/core/types"
+	"errors"
+	"math/big"
+)
+
+// hostModule is the module name of imported host functions
+const hostModule = "env"
+
+var ErrTooManyTopics = errors.New("wasm: too many log topics")
+
+// hostFunc is a function provided by the runtime to contracts
+type hostFunc struct {
+	typ *FuncType
+	gas uint64 // static gas charged at calling
+	fn  func(in *instance, args []uint64) []uint64
+}
+
+func sig(params []ValueType, results ...ValueType) *FuncType {
+	return &FuncType{Params: params, Results: results}
+}
+
+func i32s(n int) []ValueType {
+	types := make([]ValueType, n)
+	for i := range types {
+		types[i] = I32
+	}
+	return types
+}
+
+// hostFuncs are the host functions by name. Addresses take 20 bytes of memory,
+// and values and storage keys take 32 bytes in big endian.
+var hostFuncs map[string]*hostFunc
+
+func init() {
+	// Initialized in init, since call and create refer to hostFuncs indirectly
+	hostFuncs = map[string]*hostFunc{
+		// getCallDataSize() -> i32
+		"getCallDataSize": {sig(nil, I32), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			return []uint64{uint64(len(in.input))}
+		}},
+		// callDataCopy(resultOffset, dataOffset, length i32)
+		"callDataCopy": {sig(i32s(3)), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			in.copyData(uint32(args[0]), in.input, uint32(args[1]), uint32(args[2]))
+			return nil
+		}},
+		// getCaller(resultOffset i32)
+		"getCaller": {sig(i32s(1)), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			in.write(uint32(args[0]), in.caller[:])
+			return nil
+		}},
+		// getAddress(resultOffset i32)
+		"getAddress": {sig(i32s(1)), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			in.write(uint32(args[0]), in.address[:])
+			return nil
+		}},
+		// getCallValue(resultOffset i32)
+		"getCallValue": {sig(i32s(1)), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			value := common.BigToHash(in.value)
+			in.write(uint32(args[0]), value[:])
+			return nil
+		}},
+		// getBalance(addressOffset, resultOffset i32)
+		"getBalance": {sig(i32s(2)), GasHostCall + GasStorageLoad, func(in *instance, args []uint64) []uint64 {
+			addr := common.BytesToAddress(in.read(uint32(args[0]), common.AddressLength))
+			balance := common.BigToHash(in.wasm.StateDB.GetBalance(addr))
+			in.write(uint32(args[1]), balance[:])
+			return nil
+		}},
+		// getBlockNumber() -> i64
+		"getBlockNumber": {sig(nil, I64), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			return []uint64{bigToUint64(in.wasm.BlockNumber)}
+		}},
+		// getBlockTimestamp() -> i64
+		"getBlockTimestamp": {sig(nil, I64), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			return []uint64{bigToUint64(in.wasm.Time)}
+		}},
+		// getGasLeft() -> i64
+		"getGasLeft": {sig(nil, I64), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			return []uint64{in.gas}
+		}},
+		// storageStore(keyOffset, valueOffset i32)
+		"storageStore": {sig(i32s(2)), GasHostCall + GasStorageStore, func(in *instance, args []uint64) []uint64 {
+			key := common.BytesToHash(in.read(uint32(args[0]), common.HashLength))
+			value := common.BytesToHash(in.read(uint32(args[1]), common.HashLength))
+			in.wasm.StateDB.SetState(in.address, key, value)
+			return nil
+		}},
+		// storageLoad(keyOffset, resultOffset i32)
+		"storageLoad": {sig(i32s(2)), GasHostCall + GasStorageLoad, func(in *instance, args []uint64) []uint64 {
+			key := common.BytesToHash(in.read(uint32(args[0]), common.HashLength))
+			value := in.wasm.StateDB.GetState(in.address, key)
+			in.write(uint32(args[1]), value[:])
+			return nil
+		}},
+		// log(dataOffset, dataLength, numberOfTopics, topicsOffset i32)
+		"log": {sig(i32s(4)), GasHostCall + GasLog, func(in *instance, args []uint64) []uint64 {
+			n := uint32(args[2])
+			if n > 4 {
+				panic(trap{ErrTooManyTopics})
+			}
+			in.useGas(uint64(n)*GasLogTopic + uint64(uint32(args[1]))*GasLogByte)
+			data := in.read(uint32(args[0]), uint32(args[1]))
+			topics := make([]common.Hash, n)
+			for i := range topics {
+				topics[i] = common.BytesToHash(in.read(uint32(args[3])+uint32(i)*common.HashLength, common.HashLength))
+			}
+			in.wasm.StateDB.AddLog(&types.Log{
+				Address:     in.address,
+				Topics:      topics,
+				Data:        data,
+				BlockNumber: bigToUint64(in.wasm.BlockNumber),
+			})
+			return nil
+		}},
+		// call(gas i64, addressOffset, valueOffset, dataOffset, dataLength i32) -> i32
+		// returns 0 on success, 1 on failure
+		"call": {sig(append([]ValueType{I64}, i32s(4)...), I32), GasHostCall + GasCall, func(in *instance, args []uint64) []uint64 {
+			addr := common.BytesToAddress(in.read(uint32(args[1]), common.AddressLength))
+			value := new(big.Int).SetBytes(in.read(uint32(args[2]), common.HashLength))
+			in.useGas(uint64(uint32(args[4])) * GasDataByte)
+			data := in.read(uint32(args[3]), uint32(args[4]))
+
+			gas := args[0]
+			if gas > in.gas {
+				gas = in.gas
+			}
+			in.gas -= gas
+			ret, leftOverGas, err := in.wasm.call(in.address, addr, data, gas, value)
+			in.gas += leftOverGas
+			in.returnData = ret
+			return []uint64{failed(err)}
+		}},
+		// create(valueOffset, dataOffset, dataLength, resultOffset i32) -> i32
+		// writes the contract address at resultOffset, returns 0 on success, 1 on failure
+		"create": {sig(i32s(4), I32), GasHostCall + GasCreate, func(in *instance, args []uint64) []uint64 {
+			value := new(big.Int).SetBytes(in.read(uint32(args[0]), common.HashLength))
+			code := in.read(uint32(args[1]), uint32(args[2]))
+
+			gas := in.gas
+			in.gas = 0
+			ret, addr, leftOverGas, err := in.wasm.create(in.address, code, gas, value)
+			in.gas += leftOverGas
+			in.returnData = ret
+			if err == nil {
+				in.write(uint32(args[3]), addr[:])
+			}
+			return []uint64{failed(err)}
+		}},
+		// getReturnDataSize() -> i32
+		"getReturnDataSize": {sig(nil, I32), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			return []uint64{uint64(len(in.returnData))}
+		}},
+		// returnDataCopy(resultOffset, dataOffset, length i32)
+		"returnDataCopy": {sig(i32s(3)), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			in.copyData(uint32(args[0]), in.returnData, uint32(args[1]), uint32(args[2]))
+			return nil
+		}},
+		// finish(dataOffset, length i32) stops execution with output
+		"finish": {sig(i32s(2)), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			in.useGas(uint64(uint32(args[1])) * GasDataByte)
+			in.output = in.read(uint32(args[0]), uint32(args[1]))
+			panic(halt{})
+		}},
+		// revert(dataOffset, length i32) stops execution and reverts state changes
+		"revert": {sig(i32s(2)), GasHostCall, func(in *instance, args []uint64) []uint64 {
+			in.useGas(uint64(uint32(args[1])) * GasDataByte)
+			in.output = in.read(uint32(args[0]), uint32(args[1]))
+			panic(halt{revert: true})
+		}},
+	}
+}
+
+// read returns a copy of memory in [offset, offset+length), which is checked
+// before allocating the copy
+func (in *instance) read(offset, length uint32) []byte {
+	mem := in.effective(offset, 0, int(length))
+	data := make([]byte, len(mem))
+	copy(data, mem)
+	return data
+}
+
+func (in *instance) write(offset uint32, data []byte) {
+	copy(in.effective(offset, 0, len(data)), data)
+}
+
+// copyData copies data[dataOffset:dataOffset+length] into memory, charging gas per word
+func (in *instance) copyData(resultOffset uint32, data []byte, dataOffset, length uint32) {
+	in.useGas((uint64(length) + 31) / 32 * GasCopyWord)
+	if uint64(dataOffset)+uint64(length) > uint64(len(data)) {
+		panic(trap{ErrMemoryOutOfBounds})
+	}
+	in.write(resultOffset, data[dataOffset:dataOffset+length])
+}
+
+func failed(err error) uint64 {
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+func bigToUint64(n *big.Int) uint64 {
+	if n == nil {
+		return 0
+	}
+	return n.Uint64()
+}
@@ -0,0 +1,660 @@
+package wasm
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+var (
+	ErrUnreachable       = errors.New("wasm: unreachable executed")
+	ErrMemoryOutOfBounds = errors.New("wasm: memory access out of bounds")
+	ErrDivideByZero      = errors.New("wasm: integer divide by zero")
+	ErrIntegerOverflow   = errors.New("wasm: integer overflow")
+	ErrStackOverflow     = errors.New("wasm: operand stack overflow")
+	ErrStackUnderflow    = errors.New("wasm: operand stack underflow")
+	ErrIndirectCall      = errors.New("wasm: invalid indirect call")
+)
+
+// trap is panicked to abort execution with err
+type trap struct {
+	err error
+}
+
+// halt is panicked by host functions finish and revert to stop execution
+type halt struct {
+	revert bool
+}
+
+// label is the branch target of an entered block
+type label struct {
+	cont   int  // position to continue at branching
+	arity  int  // number of values carried by branching
+	height int  // height of operand stack at entering
+	loop   bool // branching to loop continues the loop
+}
+
+// instance is an instantiated module executing a contract call
+type instance struct {
+	wasm   *WASM
+	module *Module
+	funcs  []*function
+
+	memory   []byte
+	maxPages uint32
+	table    []int64 // function indexes, -1 if uninitialized
+	globals  []uint64
+
+	stack []uint64
+	base  int // stack base of current frame
+	depth int // depth of function calls
+	gas   uint64
+
+	// Contract call context
+	caller     common.Address
+	address    common.Address
+	value      *big.Int
+	input      []byte
+	output     []byte
+	returnData []byte
+}
+
+func newInstance(w *WASM, m *Module, funcs []*function, gas uint64) (*instance, error) {
+	in := &instance{
+		wasm:   w,
+		module: m,
+		funcs:  funcs,
+		gas:    gas,
+	}
+	if m.Memory != nil {
+		in.memory = make([]byte, int(m.Memory.Min)*pageSize)
+		in.maxPages = w.config.MaxPages
+		if m.Memory.HasMax && m.Memory.Max < in.maxPages {
+			in.maxPages = m.Memory.Max
+		}
+	}
+	if m.Table != nil {
+		in.table = make([]int64, m.Table.Min)
+		for i := range in.table {
+			in.table[i] = -1
+		}
+	}
+	for _, seg := range m.Elems {
+		if uint64(seg.Offset)+uint64(len(seg.Funcs)) > uint64(len(in.table)) {
+			return nil, ErrInvalidModule
+		}
+		for i, fn := range seg.Funcs {
+			in.table[int(seg.Offset)+i] = int64(fn)
+		}
+	}
+	for _, seg := range m.Data {
+		if uint64(seg.Offset)+uint64(len(seg.Data)) > uint64(len(in.memory)) {
+			return nil, ErrInvalidModule
+		}
+		copy(in.memory[seg.Offset:], seg.Data)
+	}
+	for _, g := range m.Globals {
+		in.globals = append(in.globals, g.Init)
+	}
+	return in, nil
+}
+
+// invoke calls the exported function, and returns the error of trap or revert
+func (in *instance) invoke(name string) (err error) {
+	exp, ok := in.module.Exports[name]
+	if !ok || exp.Kind != ExternalFunction || int(exp.Index) >= len(in.funcs) {
+		return fmt.Errorf("%s, %s not exported", ErrInvalidModule, name)
+	}
+	defer func() {
+		if caught := recover(); caught != nil {
+			switch caught := caught.(type) {
+			case trap:
+				err = caught.err
+			case halt:
+				if caught.revert {
+					err = ErrExecutionReverted
+				}
+			default:
+				panic(caught)
+			}
+		}
+	}()
+	in.call(exp.Index)
+	return nil
+}
+
+func (in *instance) useGas(amount uint64) {
+	if in.gas < amount {
+		in.gas = 0
+		panic(trap{vm.ErrOutOfGas})
+	}
+	in.gas -= amount
+}
+
+func (in *instance) push(v uint64) {
+	if len(in.stack) >= in.wasm.config.MaxStack {
+		panic(trap{ErrStackOverflow})
+	}
+	in.stack = append(in.stack, v)
+}
+
+func (in *instance) pop() uint64 {
+	if len(in.stack) <= in.base {
+		panic(trap{ErrStackUnderflow})
+	}
+	v := in.stack[len(in.stack)-1]
+	in.stack = in.stack[:len(in.stack)-1]
+	return v
+}
+
+// popN pops n values in the order they were pushed
+func (in *instance) popN(n int) []uint64 {
+	if len(in.stack)-in.base < n {
+		panic(trap{ErrStackUnderflow})
+	}
+	values := make([]uint64, n)
+	copy(values, in.stack[len(in.stack)-n:])
+	in.stack = in.stack[:len(in.stack)-n]
+	return values
+}
+
+func (in *instance) pushN(values []uint64) {
+	for _, v := range values {
+		in.push(v)
+	}
+}
+
+// call calls function of idx with arguments on the stack
+func (in *instance) call(idx uint32) {
+	fn := in.funcs[idx]
+	args := in.popN(len(fn.typ.Params))
+	if fn.host != nil {
+		in.useGas(fn.host.gas)
+		in.pushN(fn.host.fn(in, args))
+		return
+	}
+	if in.depth >= in.wasm.config.MaxCallDepth {
+		panic(trap{vm.ErrDepth})
+	}
+	locals := make([]uint64, len(args)+len(fn.locals))
+	copy(locals, args)
+
+	in.depth++
+	base := in.base
+	in.base = len(in.stack)
+	in.exec(fn, locals)
+	in.base = base
+	in.depth--
+}
+
+// exec executes the function body, and leaves results on the stack
+func (in *instance) exec(fn *function, locals []uint64) {
+	var (
+		body   = fn.body
+		pc     = 0
+		labels []label
+	)
+	u32 := func() uint32 {
+		v, n := readULEB(body[pc:], 32)
+		pc += n
+		return uint32(v)
+	}
+	// branch unwinds the stack to label of depth, and returns false if branching out of function
+	branch := func(depth uint32) bool {
+		if int(depth) == len(labels) {
+			return false
+		}
+		l := labels[len(labels)-1-int(depth)]
+		results := in.popN(l.arity)
+		if len(in.stack) < l.height {
+			panic(trap{ErrStackUnderflow})
+		}
+		in.stack = append(in.stack[:l.height], results...)
+		if l.loop {
+			labels = labels[:len(labels)-int(depth)]
+		} else {
+			labels = labels[:len(labels)-1-int(depth)]
+		}
+		pc = l.cont
+		return true
+	}
+	ret := func() {
+		results := in.popN(len(fn.typ.Results))
+		in.stack = append(in.stack[:in.base], results...)
+	}
+
+	for {
+		in.useGas(GasInstruction)
+		op := body[pc]
+		pc++
+		switch op {
+		case opUnreachable:
+			panic(trap{ErrUnreachable})
+		case opNop:
+		case opBlock:
+			b := fn.blocks[pc-1]
+			pc++
+			labels = append(labels, label{cont: b.end, arity: b.arity, height: len(in.stack)})
+		case opLoop:
+			pc++
+			labels = append(labels, label{cont: pc, height: len(in.stack), loop: true})
+		case opIf:
+			b := fn.blocks[pc-1]
+			pc++
+			l := label{cont: b.end, arity: b.arity}
+			if uint32(in.pop()) != 0 {
+				l.height = len(in.stack)
+				labels = append(labels, l)
+			} else if b.els != 0 {
+				l.height = len(in.stack)
+				labels = append(labels, l)
+				pc = b.els
+			} else {
+				pc = b.end
+			}
+		case opElse:
+			// End of the then branch
+			pc = labels[len(labels)-1].cont
+			labels = labels[:len(labels)-1]
+		case opEnd:
+			if len(labels) == 0 {
+				ret()
+				return
+			}
+			labels = labels[:len(labels)-1]
+		case opBr:
+			if !branch(u32()) {
+				ret()
+				return
+			}
+		case opBrIf:
+			depth := u32()
+			if uint32(in.pop()) != 0 && !branch(depth) {
+				ret()
+				return
+			}
+		case opBrTable:
+			n := u32()
+			targets := make([]uint32, n+1)
+			for i := range targets {
+				targets[i] = u32()
+			}
+			idx := uint32(in.pop())
+			if idx > n {
+				idx = n
+			}
+			if !branch(targets[idx]) {
+				ret()
+				return
+			}
+		case opReturn:
+			ret()
+			return
+		case opCall:
+			in.call(u32())
+		case opCallIndirect:
+			typ := &in.module.Types[u32()]
+			pc++
+			elem := uint32(in.pop())
+			if elem >= uint32(len(in.table)) || in.table[elem] < 0 {
+				panic(trap{ErrIndirectCall})
+			}
+			idx := uint32(in.table[elem])
+			if !in.funcs[idx].typ.Equal(typ) {
+				panic(trap{ErrIndirectCall})
+			}
+			in.call(idx)
+
+		case opDrop:
+			in.pop()
+		case opSelect:
+			c, b, a := in.pop(), in.pop(), in.pop()
+			if uint32(c) != 0 {
+				in.push(a)
+			} else {
+				in.push(b)
+			}
+
+		case opLocalGet:
+			in.push(locals[u32()])
+		case opLocalSet:
+			locals[u32()] = in.pop()
+		case opLocalTee:
+			v := in.pop()
+			locals[u32()] = v
+			in.push(v)
+		case opGlobalGet:
+			in.push(in.globals[u32()])
+		case opGlobalSet:
+			in.globals[u32()] = in.pop()
+
+		case opMemorySize:
+			pc++
+			in.push(uint64(len(in.memory) / pageSize))
+		case opMemoryGrow:
+			pc++
+			n := uint32(in.pop())
+			pages := uint32(len(in.memory) / pageSize)
+			if uint64(pages)+uint64(n) > uint64(in.maxPages) {
+				in.push(uint64(math.MaxUint32))
+				break
+			}
+			in.useGas(uint64(n) * GasMemoryPage)
+			in.memory = append(in.memory, make([]byte, int(n)*pageSize)...)
+			in.push(uint64(pages))
+
+		case opI32Const:
+			v, n := readSLEB(body[pc:], 32)
+			pc += n
+			in.push(uint64(uint32(v)))
+		case opI64Const:
+			v, n := readSLEB(body[pc:], 64)
+			pc += n
+			in.push(uint64(v))
+
+		default:
+			switch {
+			case isMemoryOp(op):
+				u32() // alignment hint
+				offset := u32()
+				in.memoryOp(op, offset)
+			case op >= opI32Eqz && op <= opI32GeU:
+				in.cmp32(op)
+			case op >= opI64Eqz && op <= opI64GeU:
+				in.cmp64(op)
+			case op >= opI32Clz && op <= opI32Rotr:
+				in.arith32(op)
+			case op >= opI64Clz && op <= opI64Rotr:
+				in.arith64(op)
+			default:
+				in.convert(op)
+			}
+		}
+	}
+}
+
+// effective returns the memory range [addr+offset, addr+offset+size)
+func (in *instance) effective(addr, offset uint32, size int) []byte {
+	ea := uint64(addr) + uint64(offset)
+	if ea+uint64(size) > uint64(len(in.memory)) {
+		panic(trap{ErrMemoryOutOfBounds})
+	}
+	return in.memory[ea : ea+uint64(size)]
+}
+
+func (in *instance) memoryOp(op byte, offset uint32) {
+	le := binary.LittleEndian
+	if op >= opI32Store {
+		v := in.pop()
+		addr := uint32(in.pop())
+		switch op {
+		case opI32Store:
+			le.PutUint32(in.effective(addr, offset, 4), uint32(v))
+		case opI64Store:
+			le.PutUint64(in.effective(addr, offset, 8), v)
+		case opI32Store8, opI64Store8:
+			in.effective(addr, offset, 1)[0] = byte(v)
+		case opI32Store16, opI64Store16:
+			le.PutUint16(in.effective(addr, offset, 2), uint16(v))
+		case opI64Store32:
+			le.PutUint32(in.effective(addr, offset, 4), uint32(v))
+		}
+		return
+	}
+	addr := uint32(in.pop())
+	var v uint64
+	switch op {
+	case opI32Load:
+		v = uint64(le.Uint32(in.effective(addr, offset, 4)))
+	case opI64Load:
+		v = le.Uint64(in.effective(addr, offset, 8))
+	case opI32Load8S:
+		v = uint64(uint32(int32(int8(in.effective(addr, offset, 1)[0]))))
+	case opI32Load8U, opI64Load8U:
+		v = uint64(in.effective(addr, offset, 1)[0])
+	case opI32Load16S:
+		v = uint64(uint32(int32(int16(le.Uint16(in.effective(addr, offset, 2))))))
+	case opI32Load16U, opI64Load16U:
+		v = uint64(le.Uint16(in.effective(addr, offset, 2)))
+	case opI64Load8S:
+		v = uint64(int64(int8(in.effective(addr, offset, 1)[0])))
+	case opI64Load16S:
+		v = uint64(int64(int16(le.Uint16(in.effective(addr, offset, 2)))))
+	case opI64Load32S:
+		v = uint64(int64(int32(le.Uint32(in.effective(addr, offset, 4)))))
+	case opI64Load32U:
+		v = uint64(le.Uint32(in.effective(addr, offset, 4)))
+	}
+	in.push(v)
+}
+
+func boolToU64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (in *instance) cmp32(op byte) {
+	if op == opI32Eqz {
+		in.push(boolToU64(uint32(in.pop()) == 0))
+		return
+	}
+	b, a := uint32(in.pop()), uint32(in.pop())
+	var r bool
+	switch op {
+	case opI32Eq:
+		r = a == b
+	case opI32Ne:
+		r = a != b
+	case opI32LtS:
+		r = int32(a) < int32(b)
+	case opI32LtU:
+		r = a < b
+	case opI32GtS:
+		r = int32(a) > int32(b)
+	case opI32GtU:
+		r = a > b
+	case opI32LeS:
+		r = int32(a) <= int32(b)
+	case opI32LeU:
+		r = a <= b
+	case opI32GeS:
+		r = int32(a) >= int32(b)
+	case opI32GeU:
+		r = a >= b
+	}
+	in.push(boolToU64(r))
+}
+
+func (in *instance) cmp64(op byte) {
+	if op == opI64Eqz {
+		in.push(boolToU64(in.pop() == 0))
+		return
+	}
+	b, a := in.pop(), in.pop()
+	var r bool
+	switch op {
+	case opI64Eq:
+		r = a == b
+	case opI64Ne:
+		r = a != b
+	case opI64LtS:
+		r = int64(a) < int64(b)
+	case opI64LtU:
+		r = a < b
+	case opI64GtS:
+		r = int64(a) > int64(b)
+	case opI64GtU:
+		r = a > b
+	case opI64LeS:
+		r = int64(a) <= int64(b)
+	case opI64LeU:
+		r = a <= b
+	case opI64GeS:
+		r = int64(a) >= int64(b)
+	case opI64GeU:
+		r = a >= b
+	}
+	in.push(boolToU64(r))
+}
+
+func (in *instance) arith32(op byte) {
+	switch op {
+	case opI32Clz:
+		in.push(uint64(bits.LeadingZeros32(uint32(in.pop()))))
+		return
+	case opI32Ctz:
+		in.push(uint64(bits.TrailingZeros32(uint32(in.pop()))))
+		return
+	case opI32Popcnt:
+		in.push(uint64(bits.OnesCount32(uint32(in.pop()))))
+		return
+	}
+	b, a := uint32(in.pop()), uint32(in.pop())
+	var r uint32
+	switch op {
+	case opI32Add:
+		r = a + b
+	case opI32Sub:
+		r = a - b
+	case opI32Mul:
+		r = a * b
+	case opI32DivS:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			panic(trap{ErrIntegerOverflow})
+		}
+		r = uint32(int32(a) / int32(b))
+	case opI32DivU:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		r = a / b
+	case opI32RemS:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int32(b) != -1 {
+			r = uint32(int32(a) % int32(b))
+		}
+	case opI32RemU:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		r = a % b
+	case opI32And:
+		r = a & b
+	case opI32Or:
+		r = a | b
+	case opI32Xor:
+		r = a ^ b
+	case opI32Shl:
+		r = a << (b & 31)
+	case opI32ShrS:
+		r = uint32(int32(a) >> (b & 31))
+	case opI32ShrU:
+		r = a >> (b & 31)
+	case opI32Rotl:
+		r = bits.RotateLeft32(a, int(b&31))
+	case opI32Rotr:
+		r = bits.RotateLeft32(a, -int(b&31))
+	}
+	in.push(uint64(r))
+}
+
+func (in *instance) arith64(op byte) {
+	switch op {
+	case opI64Clz:
+		in.push(uint64(bits.LeadingZeros64(in.pop())))
+		return
+	case opI64Ctz:
+		in.push(uint64(bits.TrailingZeros64(in.pop())))
+		return
+	case opI64Popcnt:
+		in.push(uint64(bits.OnesCount64(in.pop())))
+		return
+	}
+	b, a := in.pop(), in.pop()
+	var r uint64
+	switch op {
+	case opI64Add:
+		r = a + b
+	case opI64Sub:
+		r = a - b
+	case opI64Mul:
+		r = a * b
+	case opI64DivS:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			panic(trap{ErrIntegerOverflow})
+		}
+		r = uint64(int64(a) / int64(b))
+	case opI64DivU:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		r = a / b
+	case opI64RemS:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int64(b) != -1 {
+			r = uint64(int64(a) % int64(b))
+		}
+	case opI64RemU:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		r = a % b
+	case opI64And:
+		r = a & b
+	case opI64Or:
+		r = a | b
+	case opI64Xor:
+		r = a ^ b
+	case opI64Shl:
+		r = a << (b & 63)
+	case opI64ShrS:
+		r = uint64(int64(a) >> (b & 63))
+	case opI64ShrU:
+		r = a >> (b & 63)
+	case opI64Rotl:
+		r = bits.RotateLeft64(a, int(b&63))
+	case opI64Rotr:
+		r = bits.RotateLeft64(a, -int(b&63))
+	}
+	in.push(r)
+}
+
+func (in *instance) convert(op byte) {
+	v := in.pop()
+	switch op {
+	case opI32WrapI64:
+		v = uint64(uint32(v))
+	case opI64ExtendI32S:
+		v = uint64(int64(int32(uint32(v))))
+	case opI64ExtendI32U:
+		v = uint64(uint32(v))
+	case opI32Extend8S:
+		v = uint64(uint32(int32(int8(v))))
+	case opI32Extend16S:
+		v = uint64(uint32(int32(int16(v))))
+	case opI64Extend8S:
+		v = uint64(int64(int8(v)))
+	case opI64Extend16S:
+		v = uint64(int64(int16(v)))
+	case opI64Extend32S:
+		v = uint64(int64(int32(v)))
+	}
+	in.push(v)
+}
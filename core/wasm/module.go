@@ -0,0 +1,506 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+/*
+	Module is decoded from the binary format of WebAssembly MVP. Only the
+	subset needed by deterministic contracts is accepted:
+	- value types i32 and i64, no floats
+	- imported functions only, from module "env"
+	- one table of funcref and one memory at most
+	- active element and data segments with constant offsets
+	- no start function
+*/
+
+var (
+	ErrInvalidModule = errors.New("invalid wasm module")
+
+	// Header is the magic and version of wasm binary, and marks the code of wasm contracts
+	Header = []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+)
+
+type ValueType byte
+
+const (
+	I32 ValueType = 0x7f
+	I64 ValueType = 0x7e
+
+	blockTypeEmpty = 0x40
+	funcTypeForm   = 0x60
+	funcRefType    = 0x70
+)
+
+func (vt ValueType) String() string {
+	switch vt {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	}
+	return fmt.Sprintf("unknown(0x%x)", byte(vt))
+}
+
+// Section ids
+const (
+	sectionCustom byte = iota
+	sectionType
+	sectionImport
+	sectionFunction
+	sectionTable
+	sectionMemory
+	sectionGlobal
+	sectionExport
+	sectionStart
+	sectionElement
+	sectionCode
+	sectionData
+	sectionDataCount
+)
+
+// Export kinds
+const (
+	ExternalFunction byte = iota
+	ExternalTable
+	ExternalMemory
+	ExternalGlobal
+)
+
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+func (ft *FuncType) Equal(other *FuncType) bool {
+	return bytes.Equal(valueTypeBytes(ft.Params), valueTypeBytes(other.Params)) &&
+		bytes.Equal(valueTypeBytes(ft.Results), valueTypeBytes(other.Results))
+}
+
+func (ft *FuncType) String() string {
+	return fmt.Sprintf("%v -> %v", ft.Params, ft.Results)
+}
+
+func valueTypeBytes(types []ValueType) []byte {
+	b := make([]byte, len(types))
+	for i, t := range types {
+		b[i] = byte(t)
+	}
+	return b
+}
+
+// Import is an imported function
+type Import struct {
+	Module string
+	Name   string
+	Type   uint32 // index of function type
+}
+
+type Limits struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+type Global struct {
+	Type    ValueType
+	Mutable bool
+	Init    uint64
+}
+
+type Export struct {
+	Kind  byte
+	Index uint32
+}
+
+type ElemSegment struct {
+	Offset uint32
+	Funcs  []uint32
+}
+
+type DataSegment struct {
+	Offset uint32
+	Data   []byte
+}
+
+type Code struct {
+	Locals []ValueType // local variables, excluding parameters
+	Body   []byte      // instructions, ending with end
+}
+
+type Module struct {
+	Types   []FuncType
+	Imports []Import
+	Funcs   []uint32 // type indexes of functions defined in module
+	Table   *Limits
+	Memory  *Limits
+	Globals []Global
+	Exports map[string]Export
+	Elems   []ElemSegment
+	Codes   []Code
+	Data    []DataSegment
+}
+
+// FuncType returns the type of function at index of the function index space,
+// in which imported functions come first.
+func (m *Module) FuncType(index uint32) *FuncType {
+	if index < uint32(len(m.Imports)) {
+		return &m.Types[m.Imports[index].Type]
+	}
+	return &m.Types[m.Funcs[index-uint32(len(m.Imports))]]
+}
+
+func (m *Module) NumFuncs() int {
+	return len(m.Imports) + len(m.Funcs)
+}
+
+// DecodeModule decodes wasm binary, and checks the indexes in declarations.
+// Function bodies are checked by Validate.
+func DecodeModule(code []byte) (*Module, error) {
+	if !bytes.HasPrefix(code, Header) {
+		return nil, ErrInvalidModule
+	}
+	r := &reader{buf: code[len(Header):]}
+	m := &Module{Exports: make(map[string]Export)}
+	var last byte
+	for r.err == nil && r.len() > 0 {
+		id := r.byte()
+		section := &reader{buf: r.bytes(r.u32())}
+		if r.err != nil {
+			break
+		}
+		if id != sectionCustom {
+			if id <= last && id != sectionDataCount {
+				return nil, fmt.Errorf("%s, section %d out of order", ErrInvalidModule, id)
+			}
+			last = id
+		}
+		if err := m.decodeSection(id, section); err != nil {
+			return nil, err
+		}
+		if section.err == nil && section.len() > 0 {
+			section.err = ErrInvalidModule
+		}
+		if section.err != nil {
+			return nil, fmt.Errorf("%s, section %d", section.err, id)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(m.Funcs) != len(m.Codes) {
+		return nil, fmt.Errorf("%s, %d functions with %d bodies", ErrInvalidModule, len(m.Funcs), len(m.Codes))
+	}
+	return m, m.checkIndexes()
+}
+
+func (m *Module) decodeSection(id byte, r *reader) error {
+	switch id {
+	case sectionCustom, sectionDataCount:
+		r.buf = nil
+	case sectionType:
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			if r.byte() != funcTypeForm {
+				return ErrInvalidModule
+			}
+			ft := FuncType{Params: r.valueTypes(), Results: r.valueTypes()}
+			if len(ft.Results) > 1 {
+				return fmt.Errorf("%s, multiple results", ErrInvalidModule)
+			}
+			m.Types = append(m.Types, ft)
+		}
+	case sectionImport:
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			imp := Import{Module: r.name(), Name: r.name()}
+			if kind := r.byte(); kind != ExternalFunction {
+				return fmt.Errorf("%s, import %s.%s is not function", ErrInvalidModule, imp.Module, imp.Name)
+			}
+			imp.Type = r.u32()
+			m.Imports = append(m.Imports, imp)
+		}
+	case sectionFunction:
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			m.Funcs = append(m.Funcs, r.u32())
+		}
+	case sectionTable:
+		if r.u32() != 1 || r.byte() != funcRefType {
+			return fmt.Errorf("%s, only one funcref table allowed", ErrInvalidModule)
+		}
+		m.Table = r.limits()
+	case sectionMemory:
+		if r.u32() != 1 {
+			return fmt.Errorf("%s, only one memory allowed", ErrInvalidModule)
+		}
+		m.Memory = r.limits()
+	case sectionGlobal:
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			g := Global{Type: r.valueType()}
+			switch r.byte() {
+			case 0:
+			case 1:
+				g.Mutable = true
+			default:
+				return ErrInvalidModule
+			}
+			g.Init = r.constExpr(g.Type)
+			m.Globals = append(m.Globals, g)
+		}
+	case sectionExport:
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			name := r.name()
+			if _, ok := m.Exports[name]; ok {
+				return fmt.Errorf("%s, duplicate export %s", ErrInvalidModule, name)
+			}
+			m.Exports[name] = Export{Kind: r.byte(), Index: r.u32()}
+		}
+	case sectionStart:
+		return fmt.Errorf("%s, start function is not supported", ErrInvalidModule)
+	case sectionElement:
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			if r.u32() != 0 {
+				return fmt.Errorf("%s, only active element segments allowed", ErrInvalidModule)
+			}
+			seg := ElemSegment{Offset: uint32(r.constExpr(I32))}
+			for k := r.u32(); k > 0 && r.err == nil; k-- {
+				seg.Funcs = append(seg.Funcs, r.u32())
+			}
+			m.Elems = append(m.Elems, seg)
+		}
+	case sectionCode:
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			body := &reader{buf: r.bytes(r.u32())}
+			var code Code
+			for k := body.u32(); k > 0 && body.err == nil; k-- {
+				count, vt := body.u32(), body.valueType()
+				if uint64(len(code.Locals))+uint64(count) > maxLocals {
+					return fmt.Errorf("%s, too many locals", ErrInvalidModule)
+				}
+				for i := uint32(0); i < count; i++ {
+					code.Locals = append(code.Locals, vt)
+				}
+			}
+			if body.err != nil {
+				return body.err
+			}
+			code.Body = body.buf
+			m.Codes = append(m.Codes, code)
+		}
+	case sectionData:
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			if r.u32() != 0 {
+				return fmt.Errorf("%s, only active data segments allowed", ErrInvalidModule)
+			}
+			seg := DataSegment{Offset: uint32(r.constExpr(I32))}
+			seg.Data = r.bytes(r.u32())
+			m.Data = append(m.Data, seg)
+		}
+	default:
+		return fmt.Errorf("%s, unknown section %d", ErrInvalidModule, id)
+	}
+	return nil
+}
+
+func (m *Module) checkIndexes() error {
+	for _, imp := range m.Imports {
+		if imp.Type >= uint32(len(m.Types)) {
+			return fmt.Errorf("%s, import %s.%s type out of range", ErrInvalidModule, imp.Module, imp.Name)
+		}
+	}
+	for _, typ := range m.Funcs {
+		if typ >= uint32(len(m.Types)) {
+			return fmt.Errorf("%s, function type out of range", ErrInvalidModule)
+		}
+	}
+	for name, exp := range m.Exports {
+		var ok bool
+		switch exp.Kind {
+		case ExternalFunction:
+			ok = exp.Index < uint32(m.NumFuncs())
+		case ExternalTable:
+			ok = m.Table != nil && exp.Index == 0
+		case ExternalMemory:
+			ok = m.Memory != nil && exp.Index == 0
+		case ExternalGlobal:
+			ok = exp.Index < uint32(len(m.Globals))
+		}
+		if !ok {
+			return fmt.Errorf("%s, export %s out of range", ErrInvalidModule, name)
+		}
+	}
+	for _, seg := range m.Elems {
+		if m.Table == nil {
+			return fmt.Errorf("%s, element segment without table", ErrInvalidModule)
+		}
+		for _, fn := range seg.Funcs {
+			if fn >= uint32(m.NumFuncs()) {
+				return fmt.Errorf("%s, element function out of range", ErrInvalidModule)
+			}
+		}
+	}
+	if len(m.Data) > 0 && m.Memory == nil {
+		return fmt.Errorf("%s, data segment without memory", ErrInvalidModule)
+	}
+	return nil
+}
+
+// reader decodes wasm binary. The first error is kept, and the following
+// reads return zero values.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) len() int {
+	return len(r.buf)
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = ErrInvalidModule
+	}
+	r.buf = nil
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.buf) == 0 {
+		r.fail()
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) bytes(n uint32) []byte {
+	if r.err != nil || uint64(len(r.buf)) < uint64(n) {
+		r.fail()
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) u32() uint32 {
+	v, n := readULEB(r.buf, 32)
+	if r.err != nil || n == 0 {
+		r.fail()
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return uint32(v)
+}
+
+func (r *reader) s64(size uint) int64 {
+	v, n := readSLEB(r.buf, size)
+	if r.err != nil || n == 0 {
+		r.fail()
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) name() string {
+	return string(r.bytes(r.u32()))
+}
+
+func (r *reader) valueType() ValueType {
+	vt := ValueType(r.byte())
+	if vt != I32 && vt != I64 {
+		r.fail()
+	}
+	return vt
+}
+
+func (r *reader) valueTypes() []ValueType {
+	var types []ValueType
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		types = append(types, r.valueType())
+	}
+	return types
+}
+
+func (r *reader) limits() *Limits {
+	l := &Limits{}
+	switch r.byte() {
+	case 0:
+		l.Min = r.u32()
+	case 1:
+		l.Min, l.Max, l.HasMax = r.u32(), r.u32(), true
+		if l.Max < l.Min {
+			r.fail()
+		}
+	default:
+		r.fail()
+	}
+	return l
+}
+
+// constExpr decodes a constant expression of type vt
+func (r *reader) constExpr(vt ValueType) uint64 {
+	var v uint64
+	switch op := r.byte(); {
+	case op == opI32Const && vt == I32:
+		v = uint64(uint32(r.s64(32)))
+	case op == opI64Const && vt == I64:
+		v = uint64(r.s64(64))
+	default:
+		r.fail()
+	}
+	if r.byte() != opEnd {
+		r.fail()
+	}
+	return v
+}
+
+// readULEB reads an unsigned LEB128 integer of at most size bits.
+// Returns the number of bytes read, 0 if invalid.
+func readULEB(buf []byte, size uint) (uint64, int) {
+	var (
+		v     uint64
+		shift uint
+	)
+	for i, b := range buf {
+		if i >= int(size+6)/7 {
+			return 0, 0
+		}
+		v |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if size < 64 && v>>size != 0 {
+				return 0, 0
+			}
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// readSLEB reads a signed LEB128 integer of at most size bits.
+// Returns the number of bytes read, 0 if invalid.
+func readSLEB(buf []byte, size uint) (int64, int) {
+	var (
+		v     int64
+		shift uint
+	)
+	for i, b := range buf {
+		if i >= int(size+6)/7 {
+			return 0, 0
+		}
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			if size < 64 && (v < -1<<(size-1) || v >= 1<<(size-1)) {
+				return 0, 0
+			}
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
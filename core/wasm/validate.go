@@ -0,0 +1,203 @@
+package wasm
+
+import (
+	"fmt"
+)
+
+const (
+	maxLocals    = 1024  // Maximum local variables of a function
+	maxTableSize = 1024  // Maximum size of function table
+	pageSize     = 65536 // Size of memory page
+)
+
+// block is the structured control instruction block, loop or if
+type block struct {
+	end   int // position after end
+	els   int // position after else, 0 if none
+	arity int // number of results
+}
+
+// function is a validated function ready for execution
+type function struct {
+	typ    *FuncType
+	locals []ValueType    // local variables, excluding parameters
+	body   []byte         // instructions
+	blocks map[int]*block // blocks by position of block, loop and if instruction
+	host   *hostFunc      // host function if imported
+}
+
+// Validate decodes and validates the contract module at deployment:
+//   - imports are host functions in module "env" with the same signature
+//   - "call" is exported, and "deploy" is optional, both of type [] -> []
+//   - memory and table are bounded
+//   - function bodies only contain supported instructions with valid indexes
+func Validate(code []byte, config *Config) (*Module, error) {
+	m, _, err := validate(code, config)
+	return m, err
+}
+
+// validate validates the module, and returns its functions ready for execution
+func validate(code []byte, config *Config) (*Module, []*function, error) {
+	m, err := DecodeModule(code)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range []string{exportCall, exportDeploy} {
+		exp, ok := m.Exports[name]
+		if !ok {
+			if name == exportCall {
+				return nil, nil, fmt.Errorf("%s, %s not exported", ErrInvalidModule, name)
+			}
+			continue
+		}
+		if exp.Kind != ExternalFunction {
+			return nil, nil, fmt.Errorf("%s, %s is not function", ErrInvalidModule, name)
+		}
+		if typ := m.FuncType(exp.Index); len(typ.Params) > 0 || len(typ.Results) > 0 {
+			return nil, nil, fmt.Errorf("%s, %s has type %s", ErrInvalidModule, name, typ)
+		}
+	}
+	if m.Memory != nil && m.Memory.Min > config.MaxPages {
+		return nil, nil, fmt.Errorf("%s, memory exceeds %d pages", ErrInvalidModule, config.MaxPages)
+	}
+	if m.Table != nil && m.Table.Min > maxTableSize {
+		return nil, nil, fmt.Errorf("%s, table exceeds %d elements", ErrInvalidModule, maxTableSize)
+	}
+	funcs, err := compile(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, funcs, nil
+}
+
+// compile resolves imported host functions, and validates function bodies
+func compile(m *Module) ([]*function, error) {
+	funcs := make([]*function, 0, m.NumFuncs())
+	for _, imp := range m.Imports {
+		host, ok := hostFuncs[imp.Name]
+		if imp.Module != hostModule || !ok {
+			return nil, fmt.Errorf("%s, unknown import %s.%s", ErrInvalidModule, imp.Module, imp.Name)
+		}
+		typ := &m.Types[imp.Type]
+		if !typ.Equal(host.typ) {
+			return nil, fmt.Errorf("%s, import %s has type %s, expect %s", ErrInvalidModule, imp.Name, typ, host.typ)
+		}
+		funcs = append(funcs, &function{typ: typ, host: host})
+	}
+	for i, code := range m.Codes {
+		fn := &function{
+			typ:    &m.Types[m.Funcs[i]],
+			locals: code.Locals,
+			body:   code.Body,
+		}
+		if err := fn.validate(m); err != nil {
+			return nil, fmt.Errorf("%s, function %d: %s", ErrInvalidModule, len(m.Imports)+i, err)
+		}
+		funcs = append(funcs, fn)
+	}
+	return funcs, nil
+}
+
+// validate checks instructions and immediates, and builds the blocks.
+// Types of operands are checked at runtime.
+func (fn *function) validate(m *Module) error {
+	fn.blocks = make(map[int]*block)
+	var (
+		r       = &reader{buf: fn.body}
+		control []int // positions of open blocks
+		nlocals = uint32(len(fn.typ.Params) + len(fn.locals))
+	)
+	pos := func() int {
+		return len(fn.body) - r.len()
+	}
+	for r.len() > 0 {
+		start := pos()
+		op := r.byte()
+		switch {
+		case op == opUnreachable, op == opNop, op == opReturn, op == opDrop, op == opSelect:
+		case op == opBlock, op == opLoop, op == opIf:
+			b := &block{}
+			switch bt := r.byte(); bt {
+			case blockTypeEmpty:
+			case byte(I32), byte(I64):
+				if op != opLoop {
+					b.arity = 1
+				}
+			default:
+				return fmt.Errorf("unsupported block type 0x%x", bt)
+			}
+			fn.blocks[start] = b
+			control = append(control, start)
+		case op == opElse:
+			if len(control) == 0 || fn.body[control[len(control)-1]] != opIf {
+				return fmt.Errorf("else without if at %d", start)
+			}
+			b := fn.blocks[control[len(control)-1]]
+			if b.els != 0 {
+				return fmt.Errorf("duplicate else at %d", start)
+			}
+			b.els = pos()
+		case op == opEnd:
+			if len(control) == 0 {
+				// End of function
+				if r.len() > 0 {
+					return fmt.Errorf("instructions after end of function")
+				}
+				return r.err
+			}
+			fn.blocks[control[len(control)-1]].end = pos()
+			control = control[:len(control)-1]
+		case op == opBr, op == opBrIf:
+			if r.u32() > uint32(len(control)) {
+				return fmt.Errorf("branch depth out of range at %d", start)
+			}
+		case op == opBrTable:
+			for n := r.u32() + 1; n > 0 && r.err == nil; n-- {
+				if r.u32() > uint32(len(control)) {
+					return fmt.Errorf("branch depth out of range at %d", start)
+				}
+			}
+		case op == opCall:
+			if r.u32() >= uint32(m.NumFuncs()) {
+				return fmt.Errorf("function index out of range at %d", start)
+			}
+		case op == opCallIndirect:
+			if r.u32() >= uint32(len(m.Types)) || r.byte() != 0 || m.Table == nil {
+				return fmt.Errorf("invalid call_indirect at %d", start)
+			}
+		case op == opLocalGet, op == opLocalSet, op == opLocalTee:
+			if r.u32() >= nlocals {
+				return fmt.Errorf("local index out of range at %d", start)
+			}
+		case op == opGlobalGet, op == opGlobalSet:
+			idx := r.u32()
+			if idx >= uint32(len(m.Globals)) {
+				return fmt.Errorf("global index out of range at %d", start)
+			}
+			if op == opGlobalSet && !m.Globals[idx].Mutable {
+				return fmt.Errorf("global %d is immutable", idx)
+			}
+		case isMemoryOp(op):
+			r.u32() // alignment hint
+			r.u32() // offset
+			if m.Memory == nil {
+				return fmt.Errorf("memory access without memory at %d", start)
+			}
+		case op == opMemorySize, op == opMemoryGrow:
+			if r.byte() != 0 || m.Memory == nil {
+				return fmt.Errorf("invalid memory instruction at %d", start)
+			}
+		case op == opI32Const:
+			r.s64(32)
+		case op == opI64Const:
+			r.s64(64)
+		case isSimpleOp(op):
+		default:
+			return fmt.Errorf("unsupported instruction 0x%x at %d", op, start)
+		}
+		if r.err != nil {
+			return fmt.Errorf("invalid immediate at %d", start)
+		}
+	}
+	return fmt.Errorf("missing end of function")
+}
@@ -0,0 +1,245 @@
+// Package wasm implements a contract runtime executing WebAssembly contracts,
+// alongside the EVM.
+//
+// A wasm contract is deployed by a contract creation transaction whose payload
+// is the wasm binary, starting with Header. The module is validated at
+// deployment and every call, and must export function "call" of type [] -> [],
+// which is invoked by every call to the contract. The optional "deploy" function is
+// invoked once at deployment. Contracts access call data, storage, balances,
+// logs and other contracts by host functions imported from module "env".
+//
+// Execution is deterministic: float instructions are rejected, memory is
+// bounded by Config.MaxPages, and gas is charged for every instruction,
+// memory page, host function, code byte and byte of data passed from memory.
+// EVM contracts can not create code starting with Header, which is rejected
+// by vm.Config.ReservedCodePrefixes.
+package wasm
+
+import (
+	"tinychain/common"
+	"tinychain/core/vm"
+	"tinychain/core/jsvm"
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrExecutionReverted = errors.New("wasm: execution reverted")
+	ErrCodeSize          = errors.New("wasm contract code size exceeds limit")
+	ErrNotWasmContract   = errors.New("not a wasm contract")
+)
+
+const (
+	GasInstruction  = 1     // Gas of every executed instruction
+	GasMemoryPage   = 2048  // Gas of growing a page of memory
+	GasCodeByte     = 200   // Gas of storing a byte of code at deployment
+	GasHostCall     = 10    // Base gas of calling a host function
+	GasStorageLoad  = 200   // Gas of loading a storage slot
+	GasStorageStore = 5000  // Gas of storing a storage slot
+	GasLog          = 375   // Gas of emitting a log
+	GasLogTopic     = 375   // Gas of every topic of log
+	GasLogByte      = 8     // Gas of every byte of log data
+	GasCall         = 700   // Gas of calling a contract
+	GasCreate       = 32000 // Gas of creating a contract
+	GasCopyWord     = 3     // Gas of copying a word of data into memory
+	GasDataByte     = 1     // Gas of every byte of call data and output passed from memory
+	callCreateDepth = 1024  // Maximum depth of contract calls and creations
+	exportCall      = "call"
+	exportDeploy    = "deploy"
+)
+
+type Config struct {
+	MaxPages     uint32 // Maximum pages of memory
+	MaxStack     int    // Maximum height of operand stack
+	MaxCallDepth int    // Maximum depth of function calls in a contract call
+	MaxCodeSize  int    // Maximum size of contract code
+
+	JSVM *jsvm.Config // Config of js contracts called by wasm contracts, default if nil
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		MaxPages:     16,
+		MaxStack:     64 * 1024,
+		MaxCallDepth: 1024,
+		MaxCodeSize:  128 * 1024,
+	}
+}
+
+// IsWasmCode reports whether code is a wasm contract, which should be executed by WASM
+func IsWasmCode(code []byte) bool {
+	return bytes.HasPrefix(code, Header)
+}
+
+// Runtime executes contracts not written in wasm, e.g. vm.EVM
+type Runtime interface {
+	Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error)
+	Create(caller vm.ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error)
+}
+
+// WASM executes wasm contracts on the state. It shares the context with EVM,
+// and like EVM, should be used in a single transaction.
+type WASM struct {
+	vm.Context
+	StateDB vm.StateDB
+
+	config *Config
+	evm    Runtime // runtime of evm contracts and accounts without code
+	depth  int     // depth of contract calls
+}
+
+func New(ctx vm.Context, statedb vm.StateDB, config *Config, evm Runtime) *WASM {
+	return &WASM{
+		Context: ctx,
+		StateDB: statedb,
+		config:  config,
+		evm:     evm,
+	}
+}
+
+// Create validates and deploys the wasm contract code, and calls its deploy function
+func (w *WASM) Create(caller vm.ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
+	if !IsWasmCode(code) {
+		return nil, common.Address{}, gas, ErrNotWasmContract
+	}
+	if w.depth > callCreateDepth {
+		return nil, common.Address{}, gas, vm.ErrDepth
+	}
+	if len(code) > w.config.MaxCodeSize {
+		return nil, common.Address{}, gas, ErrCodeSize
+	}
+	if !w.CanTransfer(w.StateDB, caller.Address(), value) {
+		return nil, common.Address{}, gas, vm.ErrInsufficientBalance
+	}
+	nonce := w.StateDB.GetNonce(caller.Address())
+	w.StateDB.SetNonce(caller.Address(), nonce+1)
+
+	contractAddr = common.CreateAddress(caller.Address(), nonce)
+	if w.StateDB.GetNonce(contractAddr) != 0 || w.StateDB.GetCodeSize(contractAddr) != 0 {
+		return nil, common.Address{}, 0, vm.ErrContractAddressCollision
+	}
+	snapshot := w.StateDB.Snapshot()
+	w.StateDB.CreateAccount(contractAddr)
+	w.Transfer(w.StateDB, caller.Address(), contractAddr, value)
+
+	ret, leftOverGas, err = w.deploy(caller.Address(), contractAddr, code, gas, value)
+	if err != nil {
+		w.StateDB.RevertToSnapshot(snapshot)
+		if err != ErrExecutionReverted {
+			leftOverGas = 0
+		}
+		return nil, contractAddr, leftOverGas, err
+	}
+	w.StateDB.SetCode(contractAddr, code)
+	return ret, contractAddr, leftOverGas, nil
+}
+
+func (w *WASM) deploy(caller, addr common.Address, code []byte, gas uint64, value *big.Int) ([]byte, uint64, error) {
+	codeGas := uint64(len(code)) * GasCodeByte
+	if gas < codeGas {
+		return nil, 0, vm.ErrOutOfGas
+	}
+	gas -= codeGas
+	m, funcs, err := validate(code, w.config)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, ok := m.Exports[exportDeploy]; !ok {
+		return nil, gas, nil
+	}
+	return w.run(m, funcs, exportDeploy, caller, addr, nil, gas, value)
+}
+
+// Call calls the wasm contract at addr with input as call data
+func (w *WASM) Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	code := w.StateDB.GetCode(addr)
+	if !IsWasmCode(code) {
+		return nil, gas, ErrNotWasmContract
+	}
+	if w.depth > callCreateDepth {
+		return nil, gas, vm.ErrDepth
+	}
+	if !w.CanTransfer(w.StateDB, caller.Address(), value) {
+		return nil, gas, vm.ErrInsufficientBalance
+	}
+	snapshot := w.StateDB.Snapshot()
+	w.Transfer(w.StateDB, caller.Address(), addr, value)
+
+	// Code is validated again, since limits of config may change
+	m, funcs, err := validate(code, w.config)
+	if err == nil {
+		ret, leftOverGas, err = w.run(m, funcs, exportCall, caller.Address(), addr, input, gas, value)
+	}
+	if err != nil {
+		w.StateDB.RevertToSnapshot(snapshot)
+		if err != ErrExecutionReverted {
+			leftOverGas = 0
+		}
+	}
+	return ret, leftOverGas, err
+}
+
+// run instantiates the validated module and invokes the exported function entry
+func (w *WASM) run(m *Module, funcs []*function, entry string, caller, addr common.Address, input []byte, gas uint64, value *big.Int) ([]byte, uint64, error) {
+	in, err := newInstance(w, m, funcs, gas)
+	if err != nil {
+		return nil, 0, err
+	}
+	in.caller = caller
+	in.address = addr
+	in.value = value
+	in.input = input
+	err = in.invoke(entry)
+	return in.output, in.gas, err
+}
+
+// call calls the contract at addr by the runtime of its code
+func (w *WASM) call(caller, addr common.Address, input []byte, gas uint64, value *big.Int) ([]byte, uint64, error) {
+	code := w.StateDB.GetCode(addr)
+	switch {
+	case IsWasmCode(code):
+		return w.child().Call(vm.AccountRef(caller), addr, input, gas, value)
+	case jsvm.IsJSCode(code):
+		return w.jsvm().Call(vm.AccountRef(caller), addr, input, gas, value)
+	case w.evm != nil:
+		return w.evm.Call(vm.AccountRef(caller), addr, input, gas, value)
+	}
+	// Transfer value to account without code
+	if !w.CanTransfer(w.StateDB, caller, value) {
+		return nil, gas, vm.ErrInsufficientBalance
+	}
+	if !w.StateDB.Exist(addr) {
+		w.StateDB.CreateAccount(addr)
+	}
+	w.Transfer(w.StateDB, caller, addr, value)
+	return nil, gas, nil
+}
+
+// create creates the contract by the runtime of its code
+func (w *WASM) create(caller common.Address, code []byte, gas uint64, value *big.Int) ([]byte, common.Address, uint64, error) {
+	switch {
+	case IsWasmCode(code):
+		return w.child().Create(vm.AccountRef(caller), code, gas, value)
+	case jsvm.IsJSCode(code):
+		return w.jsvm().Create(vm.AccountRef(caller), code, gas, value)
+	case w.evm != nil:
+		return w.evm.Create(vm.AccountRef(caller), code, gas, value)
+	}
+	return nil, common.Address{}, gas, ErrNotWasmContract
+}
+
+// child returns the runtime of a nested contract call
+func (w *WASM) child() *WASM {
+	child := *w
+	child.depth++
+	return &child
+}
+
+func (w *WASM) jsvm() *jsvm.JSVM {
+	config := w.config.JSVM
+	if config == nil {
+		config = jsvm.DefaultConfig()
+	}
+	return jsvm.New(w.Context, w.StateDB, config)
+}
@@ -0,0 +1,290 @@
+package wasm
+
+import (
+	"tinychain/common"
+	"tinychain/core/state"
+	"tinychain/core/vm"
+	"tinychain/db/leveldb"
+	"bytes"
+	"math/big"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// testModule assembles wasm binary of a contract with one page of memory,
+// imported host functions and exported function call.
+type testModule struct {
+	imports []string
+	body    []byte // body of call, excluding locals and end
+	deploy  []byte // body of deploy if not nil
+}
+
+func uleb(v uint32) []byte {
+	var buf []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			buf = append(buf, b|0x80)
+			continue
+		}
+		return append(buf, b)
+	}
+}
+
+func vec(items ...[]byte) []byte {
+	buf := uleb(uint32(len(items)))
+	for _, item := range items {
+		buf = append(buf, item...)
+	}
+	return buf
+}
+
+func name(s string) []byte {
+	return append(uleb(uint32(len(s))), s...)
+}
+
+func section(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(uint32(len(content)))...), content...)
+}
+
+func funcBody(body []byte) []byte {
+	code := append([]byte{0x00}, body...) // no locals
+	code = append(code, opEnd)
+	return append(uleb(uint32(len(code))), code...)
+}
+
+func (tm *testModule) bytes() []byte {
+	// Type 0 is [] -> [], host function types follow
+	types := [][]byte{{funcTypeForm, 0x00, 0x00}}
+	var imports [][]byte
+	for i, imp := range tm.imports {
+		typ := hostFuncs[imp].typ
+		types = append(types, append(append([]byte{funcTypeForm}, vec(bytesOf(typ.Params)...)...), vec(bytesOf(typ.Results)...)...))
+		imports = append(imports, append(append(name(hostModule), name(imp)...), append([]byte{ExternalFunction}, uleb(uint32(i+1))...)...))
+	}
+	funcs := [][]byte{{0x00}}
+	codes := [][]byte{funcBody(tm.body)}
+	exports := [][]byte{append(name(exportCall), append([]byte{ExternalFunction}, uleb(uint32(len(tm.imports)))...)...)}
+	if tm.deploy != nil {
+		funcs = append(funcs, []byte{0x00})
+		codes = append(codes, funcBody(tm.deploy))
+		exports = append(exports, append(name(exportDeploy), append([]byte{ExternalFunction}, uleb(uint32(len(tm.imports)+1))...)...))
+	}
+	code := append([]byte{}, Header...)
+	code = append(code, section(sectionType, vec(types...))...)
+	code = append(code, section(sectionImport, vec(imports...))...)
+	code = append(code, section(sectionFunction, vec(funcs...))...)
+	code = append(code, section(sectionMemory, vec([]byte{0x00, 0x01}))...)
+	code = append(code, section(sectionExport, vec(exports...))...)
+	code = append(code, section(sectionCode, vec(codes...))...)
+	return code
+}
+
+func bytesOf(types []ValueType) [][]byte {
+	var buf [][]byte
+	for _, vt := range types {
+		buf = append(buf, []byte{byte(vt)})
+	}
+	return buf
+}
+
+func i32Const(v uint32) []byte {
+	return append([]byte{opI32Const}, uleb(v)...) // small non-negative values only
+}
+
+func ops(code ...[]byte) []byte {
+	var buf []byte
+	for _, c := range code {
+		buf = append(buf, c...)
+	}
+	return buf
+}
+
+func callHost(tm *testModule, host string, args ...uint32) []byte {
+	var buf []byte
+	for _, arg := range args {
+		buf = append(buf, i32Const(arg)...)
+	}
+	for i, imp := range tm.imports {
+		if imp == host {
+			return append(append(buf, opCall), uleb(uint32(i))...)
+		}
+	}
+	panic("host function not imported: " + host)
+}
+
+// counterModule increases storage slot 0 by one, logs and returns the new value
+func counterModule() *testModule {
+	tm := &testModule{imports: []string{"storageLoad", "storageStore", "log", "finish"}}
+	tm.body = ops(
+		callHost(tm, "storageLoad", 0, 32),
+		// mem[63] += 1
+		i32Const(63), i32Const(63), []byte{opI32Load8U, 0x00, 0x00}, i32Const(1), []byte{opI32Add}, []byte{opI32Store8, 0x00, 0x00},
+		callHost(tm, "storageStore", 0, 32),
+		callHost(tm, "log", 32, 32, 0, 0),
+		callHost(tm, "finish", 32, 32),
+	)
+	return tm
+}
+
+func newTestWASM(t *testing.T) (*WASM, *state.StateDB) {
+	db, err := leveldb.NewMemDatabase()
+	assert.Nil(t, err)
+	statedb := state.New(db, nil)
+	ctx := vm.Context{
+		CanTransfer: func(db vm.StateDB, addr common.Address, amount *big.Int) bool {
+			return db.GetBalance(addr).Cmp(amount) >= 0
+		},
+		Transfer: func(db vm.StateDB, sender, recipient common.Address, amount *big.Int) {
+			db.SubBalance(sender, amount)
+			db.AddBalance(recipient, amount)
+		},
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(1000),
+	}
+	return New(ctx, statedb, DefaultConfig(), nil), statedb
+}
+
+func deployTest(t *testing.T, w *WASM, tm *testModule) common.Address {
+	_, addr, _, err := w.Create(vm.AccountRef(common.Address{1}), tm.bytes(), 10000000, big.NewInt(0))
+	assert.Nil(t, err)
+	return addr
+}
+
+func TestWASM_Contract(t *testing.T) {
+	w, statedb := newTestWASM(t)
+	caller := vm.AccountRef(common.Address{1})
+	addr := deployTest(t, w, counterModule())
+	assert.True(t, IsWasmCode(statedb.GetCode(addr)))
+
+	txHash := common.Hash{2}
+	statedb.Prepare(txHash)
+	for i := 1; i <= 2; i++ {
+		ret, leftGas, err := w.Call(caller, addr, nil, 100000, big.NewInt(0))
+		assert.Nil(t, err)
+		assert.Equal(t, common.BigToHash(big.NewInt(int64(i))).Bytes(), ret)
+		assert.True(t, leftGas < 100000-GasStorageStore)
+	}
+	assert.Equal(t, common.BigToHash(big.NewInt(2)), statedb.GetState(addr, common.Hash{}))
+
+	logs := statedb.GetLogs(txHash)
+	assert.Equal(t, 2, len(logs))
+	assert.Equal(t, addr, logs[1].Address)
+	assert.Equal(t, common.BigToHash(big.NewInt(2)).Bytes(), logs[1].Data)
+	assert.Equal(t, uint64(1), logs[1].BlockNumber)
+}
+
+func TestWASM_Deploy(t *testing.T) {
+	w, statedb := newTestWASM(t)
+	tm := counterModule()
+	tm.deploy = ops(
+		i32Const(63), i32Const(10), []byte{opI32Store8, 0x00, 0x00},
+		callHost(tm, "storageStore", 0, 32),
+	)
+	addr := deployTest(t, w, tm)
+	assert.Equal(t, common.BigToHash(big.NewInt(10)), statedb.GetState(addr, common.Hash{}))
+}
+
+func TestWASM_Revert(t *testing.T) {
+	w, statedb := newTestWASM(t)
+	tm := &testModule{imports: []string{"storageStore", "revert"}}
+	tm.body = ops(
+		i32Const(63), i32Const(1), []byte{opI32Store8, 0x00, 0x00},
+		callHost(tm, "storageStore", 0, 32),
+		callHost(tm, "revert", 32, 32),
+	)
+	addr := deployTest(t, w, tm)
+
+	ret, leftGas, err := w.Call(vm.AccountRef(common.Address{1}), addr, nil, 100000, big.NewInt(0))
+	assert.Equal(t, ErrExecutionReverted, err)
+	assert.Equal(t, 32, len(ret))
+	assert.True(t, leftGas > 0)
+	assert.Equal(t, common.Hash{}, statedb.GetState(addr, common.Hash{}))
+}
+
+func TestWASM_OutOfGas(t *testing.T) {
+	w, _ := newTestWASM(t)
+	// loop br 0 end
+	addr := deployTest(t, w, &testModule{body: []byte{opLoop, blockTypeEmpty, opBr, 0x00, opEnd}})
+
+	_, leftGas, err := w.Call(vm.AccountRef(common.Address{1}), addr, nil, 100000, big.NewInt(0))
+	assert.Equal(t, vm.ErrOutOfGas, err)
+	assert.Equal(t, uint64(0), leftGas)
+}
+
+func TestWASM_Trap(t *testing.T) {
+	w, _ := newTestWASM(t)
+	divide := &testModule{body: ops(i32Const(1), i32Const(0), []byte{opI32DivU, opDrop})}
+	outOfBounds := &testModule{body: ops(append([]byte{opI32Const}, 0x7f), []byte{opI32Load, 0x00, 0x00, opDrop})} // load at -1
+	unreachable := &testModule{body: []byte{opUnreachable}}
+
+	for tm, expect := range map[*testModule]error{divide: ErrDivideByZero, outOfBounds: ErrMemoryOutOfBounds, unreachable: ErrUnreachable} {
+		addr := deployTest(t, w, tm)
+		_, _, err := w.Call(vm.AccountRef(common.Address{1}), addr, nil, 100000, big.NewInt(0))
+		assert.Equal(t, expect, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := DefaultConfig()
+	_, err := Validate(counterModule().bytes(), config)
+	assert.Nil(t, err)
+
+	// f32.const 0 is rejected
+	float := &testModule{body: []byte{0x43, 0x00, 0x00, 0x00, 0x00, opDrop}}
+	_, err = Validate(float.bytes(), config)
+	assert.NotNil(t, err)
+
+	// Unknown host function
+	hostFuncs["unknown"] = hostFuncs["finish"]
+	code := (&testModule{imports: []string{"unknown"}}).bytes()
+	delete(hostFuncs, "unknown")
+	_, err = Validate(code, config)
+	assert.NotNil(t, err)
+
+	// Branch out of range
+	branch := &testModule{body: []byte{opBr, 0x02}}
+	_, err = Validate(branch.bytes(), config)
+	assert.NotNil(t, err)
+}
+
+func TestWASM_CallValidates(t *testing.T) {
+	w, statedb := newTestWASM(t)
+	caller := vm.AccountRef(common.Address{1})
+
+	// Module without call export is stored by other means
+	code := bytes.Replace(counterModule().bytes(), name(exportCall), name("cell"), 1)
+	addr := common.Address{3}
+	statedb.SetCode(addr, code)
+	_, leftGas, err := w.Call(caller, addr, nil, 100000, big.NewInt(0))
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(0), leftGas)
+
+	// Memory exceeding the limit of config
+	addr = deployTest(t, w, counterModule())
+	w.config = &Config{MaxPages: 0, MaxStack: 1024, MaxCallDepth: 16, MaxCodeSize: 1024}
+	_, _, err = w.Call(caller, addr, nil, 100000, big.NewInt(0))
+	assert.NotNil(t, err)
+}
+
+func TestWASM_DataGas(t *testing.T) {
+	w, _ := newTestWASM(t)
+	caller := vm.AccountRef(common.Address{1})
+	finish := func(length uint32) common.Address {
+		tm := &testModule{imports: []string{"finish"}}
+		tm.body = callHost(tm, "finish", 0, length)
+		return deployTest(t, w, tm)
+	}
+
+	_, left1, err := w.Call(caller, finish(32), nil, 100000, big.NewInt(0))
+	assert.Nil(t, err)
+	ret, left2, err := w.Call(caller, finish(pageSize), nil, 100000, big.NewInt(0))
+	assert.Nil(t, err)
+	assert.Equal(t, pageSize, len(ret))
+	assert.Equal(t, uint64(pageSize-32)*GasDataByte, left1-left2)
+
+	// Length beyond memory traps before allocating
+	_, _, err = w.Call(caller, finish(pageSize+1), nil, 1000000, big.NewInt(0))
+	assert.Equal(t, ErrMemoryOutOfBounds, err)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 	"fmt"
 )
 
@@ -42,6 +43,15 @@ func NewLDBDataBase(filepath string) (*LDBDatabase, error) {
 	}, err
 }
 
+// NewMemDatabase returns a LDBDatabase kept in memory,
+// which is discarded after close
+func NewMemDatabase() (*LDBDatabase, error) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	return &LDBDatabase{
+		db: db,
+	}, err
+}
+
 // Put sets value for the given key, if the key exists, it will overwrite
 // the value
 func (self *LDBDatabase) Put(key []byte, value []byte) error {
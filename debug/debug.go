@@ -0,0 +1,159 @@
+// Package debug implements the debug service, which replays committed
+// transactions and blocks with tracers.
+package debug
+
+import (
+	"tinychain/common"
+	"tinychain/core"
+	"tinychain/core/state"
+	"tinychain/core/tracers"
+	"tinychain/core/types"
+	"tinychain/core/vm"
+	"errors"
+	"fmt"
+	"io"
+	json "github.com/json-iterator/go"
+)
+
+var (
+	log = common.GetLogger("debug")
+
+	ErrGenesisBlock = errors.New("genesis block is not traceable")
+	ErrTxNotFound   = errors.New("transaction not found in block")
+)
+
+type TraceConfig struct {
+	Tracer    string        // name of builtin tracer, struct logger by default
+	LogConfig *vm.LogConfig // options of struct logger
+
+	// NewTracer creates custom tracer on the state before transaction, and overrides Tracer
+	NewTracer func(statedb *state.StateDB) (tracers.Tracer, error)
+
+	// Writer receives struct logs formatted by vm.WriteTrace, if struct logger is used
+	Writer io.Writer
+}
+
+// TxTraceResult is the trace result of a transaction in block
+type TxTraceResult struct {
+	TxHash common.Hash
+	Result json.RawMessage
+	Error  string
+}
+
+func (r *TxTraceResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		TxHash string          `json:"tx_hash"`
+		Result json.RawMessage `json:"result,omitempty"`
+		Error  string          `json:"error,omitempty"`
+	}{
+		TxHash: string(common.Hex(r.TxHash[:])),
+		Result: r.Result,
+		Error:  r.Error,
+	})
+}
+
+// Service replays transactions on the historical state of blockchain.
+// The parent state of traced block must be available, see archive mode.
+type Service struct {
+	chain *core.Blockchain
+}
+
+func NewService(chain *core.Blockchain) *Service {
+	return &Service{chain: chain}
+}
+
+// TraceTransaction replays the transactions before txHash in its block, and
+// traces the execution of txHash.
+func (s *Service) TraceTransaction(txHash common.Hash, config *TraceConfig) (json.RawMessage, error) {
+	meta, err := s.chain.GetTxMeta(txHash)
+	if err != nil {
+		return nil, err
+	}
+	block, err := s.chain.GetBlock(meta.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta.TxIndex >= uint64(len(block.Transactions)) {
+		return nil, ErrTxNotFound
+	}
+	statedb, err := s.stateAtTx(block, int(meta.TxIndex))
+	if err != nil {
+		return nil, err
+	}
+	return s.traceTx(statedb, block.Header, block.Transactions[meta.TxIndex], config)
+}
+
+// TraceBlock traces every transaction in block. Tracing stops at the first
+// transaction that fails to apply, whose error is recorded in its result.
+func (s *Service) TraceBlock(hash common.Hash, config *TraceConfig) ([]*TxTraceResult, error) {
+	block, err := s.chain.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	statedb, err := s.stateAtTx(block, 0)
+	if err != nil {
+		return nil, err
+	}
+	var results []*TxTraceResult
+	for _, tx := range block.Transactions {
+		result := &TxTraceResult{TxHash: tx.Hash()}
+		results = append(results, result)
+		if result.Result, err = s.traceTx(statedb, block.Header, tx, config); err != nil {
+			result.Error = err.Error()
+			log.Errorf("Failed to trace tx %s in block %s, %s", common.Hex(result.TxHash[:]), common.Hex(hash[:]), err)
+			break
+		}
+	}
+	return results, nil
+}
+
+// stateAtTx returns the state of block before applying the transaction at index
+func (s *Service) stateAtTx(block *types.Block, index int) (*state.StateDB, error) {
+	if block.Height().Sign() == 0 {
+		return nil, ErrGenesisBlock
+	}
+	statedb, err := s.chain.StateAt(block.ParentHash())
+	if err != nil {
+		return nil, err
+	}
+	for i, tx := range block.Transactions[:index] {
+		if _, err := core.ApplyTransaction(s.chain, nil, statedb, block.Header, tx, vm.Config{}); err != nil {
+			return nil, fmt.Errorf("failed to replay tx %d, %s", i, err)
+		}
+	}
+	return statedb, nil
+}
+
+func (s *Service) traceTx(statedb *state.StateDB, header *types.Header, tx *types.Transaction, config *TraceConfig) (json.RawMessage, error) {
+	if config == nil {
+		config = &TraceConfig{}
+	}
+	var (
+		tracer tracers.Tracer
+		err    error
+	)
+	if config.NewTracer != nil {
+		tracer, err = config.NewTracer(statedb)
+	} else {
+		tracer, err = tracers.New(config.Tracer, statedb, config.LogConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pt, ok := tracer.(*tracers.PrestateTracer); ok {
+		// Coinbase receives the transaction fee
+		pt.Touch(header.Coinbase)
+	}
+
+	if _, err := core.ApplyTransaction(s.chain, nil, statedb, header, tx, vm.Config{Debug: true, Tracer: tracer}); err != nil {
+		return nil, err
+	}
+	if st, ok := tracer.(*tracers.StructTracer); ok && config.Writer != nil {
+		vm.WriteTrace(config.Writer, st.StructLogs())
+	}
+	result, err := tracer.GetResult()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
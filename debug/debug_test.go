@@ -0,0 +1,104 @@
+package debug
+
+import (
+	"tinychain/common"
+	"tinychain/consensus"
+	"tinychain/core"
+	"tinychain/core/state"
+	"tinychain/core/tracers"
+	"tinychain/core/types"
+	"tinychain/db"
+	"tinychain/db/leveldb"
+	"math/big"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	json "github.com/json-iterator/go"
+)
+
+var (
+	sender   = common.BytesToAddress([]byte("sender"))
+	contract = common.BytesToAddress([]byte("contract"))
+	// PUSH1 1 PUSH1 0 SSTORE STOP
+	storeCode = []byte{0x60, 0x01, 0x60, 0x00, 0x55, 0x00}
+)
+
+// newTestChain creates a chain with genesis state holding the contract, and
+// block 1 calling it
+func newTestChain(t *testing.T) (*core.Blockchain, *types.Block) {
+	ldb, err := leveldb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb := state.New(ldb, nil)
+	statedb.SetBalance(sender, big.NewInt(1000000))
+	statedb.SetCode(contract, storeCode)
+	if err := statedb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	root, err := statedb.IntermediateRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := types.NewBlock(&types.Header{
+		Height:    new(big.Int),
+		StateRoot: root,
+		Time:      new(big.Int),
+		GasLimit:  1000000,
+	}, nil)
+	tx := types.NewTransaction(0, 0, 100000, new(big.Int), nil, sender, contract)
+	block := types.NewBlock(&types.Header{
+		ParentHash: genesis.Hash(),
+		Height:     big.NewInt(1),
+		Time:       big.NewInt(1),
+		GasLimit:   1000000,
+	}, types.Transactions{tx})
+
+	tdb := db.NewTinyDB(ldb)
+	for _, b := range []*types.Block{genesis, block} {
+		assert.Nil(t, tdb.PutBlock(b))
+		assert.Nil(t, tdb.PutHeader(b.Header))
+		assert.Nil(t, tdb.PutHash(b.Height(), b.Hash()))
+		assert.Nil(t, tdb.PutHeight(b.Hash(), b.Height()))
+		assert.Nil(t, tdb.PutTxMetaInBatch(b))
+	}
+	assert.Nil(t, tdb.PutLastBlock(block))
+	bc, err := core.NewBlockchain(tdb, nil, consensus.New(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc, block
+}
+
+func TestService_TraceTransaction(t *testing.T) {
+	bc, block := newTestChain(t)
+	service := NewService(bc)
+	tx := block.Transactions[0]
+
+	result, err := service.TraceTransaction(tx.Hash(), nil)
+	assert.Nil(t, err)
+	res := &tracers.StructLogResult{}
+	assert.Nil(t, json.Unmarshal(result, res))
+	assert.False(t, res.Failed)
+	assert.Equal(t, 4, len(res.StructLogs))
+	assert.Equal(t, "SSTORE", res.StructLogs[2].OpName())
+
+	_, err = service.TraceTransaction(common.BytesToHash([]byte("unknown")), nil)
+	assert.NotNil(t, err)
+}
+
+func TestService_TraceBlock(t *testing.T) {
+	bc, block := newTestChain(t)
+	service := NewService(bc)
+
+	results, err := service.TraceBlock(block.Hash(), &TraceConfig{Tracer: tracers.CallTracerName})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, block.Transactions[0].Hash(), results[0].TxHash)
+	assert.Equal(t, "", results[0].Error)
+
+	// Genesis block has no parent state
+	genesis, err := bc.GetBlockByHeight(new(big.Int))
+	assert.Nil(t, err)
+	_, err = service.TraceBlock(genesis.Hash(), nil)
+	assert.Equal(t, ErrGenesisBlock, err)
+}
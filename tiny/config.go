@@ -1,7 +1,113 @@
 package tiny
 
-import "tinychain/p2p"
+import (
+	"tinychain/p2p"
+	"tinychain/consensus/dpos"
+	"tinychain/core"
+	"tinychain/core/state"
+	"math/big"
+	"github.com/spf13/viper"
+)
 
 type Config struct {
 	p2p *p2p.Config
+
+	// Rules of blocks execution, default chain config if nil
+	Chain *core.ChainConfig
+
+	// Historical state is pruned with the pruner config if set,
+	// otherwise node runs in archive mode and retains all state
+	Pruner *state.PrunerConfig
+
+	// Snapshot config, default snapshot config if nil
+	Snapshot *state.SnapshotConfig
+
+	// Consensus config, default dpos config if nil. Its parameters are
+	// overridden by the governance contract.
+	Consensus *dpos.Config
+}
+
+// LoadConfigFromFile loads node config. Pruning is enabled by "state.prune",
+// with the default pruner config overridden by other "state" options. The
+// default chain and consensus configs are overridden by "chain" and
+// "consensus" options.
+//
+// Chain options are "chain.maxCodeSize", "chain.chainId", "chain.resizeBlock"
+// and the EVM fork blocks "chain.homesteadBlock", "chain.eip150Block",
+// "chain.eip155Block", "chain.eip158Block", "chain.byzantiumBlock" and
+// "chain.constantinopleBlock". A negative block disables the fork, and forks
+// are enabled from genesis by default. They must be the same on all nodes
+// of the chain, and a fork block can only be changed before it's reached.
+func LoadConfigFromFile(path string, configName string) (*Config, error) {
+	p2pConfig, err := p2p.LoadConfigFromFile(path, configName)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{p2p: p2pConfig}
+	if viper.GetBool("state.prune") {
+		pruner := state.DefaultPrunerConfig()
+		if viper.IsSet("state.keepRecent") {
+			pruner.KeepRecent = viper.GetInt("state.keepRecent")
+		}
+		if viper.IsSet("state.pruneInterval") {
+			pruner.Interval = viper.GetInt("state.pruneInterval")
+		}
+		if viper.IsSet("state.checkpointInterval") {
+			pruner.CheckpointInterval = uint64(viper.GetInt64("state.checkpointInterval"))
+		}
+		config.Pruner = pruner
+	}
+	if chain, ok := loadChainConfig(); ok {
+		config.Chain = chain
+	}
+	consensus := dpos.DefaultConfig()
+	if viper.IsSet("consensus.blockPeriod") {
+		consensus.BlockPeriod = uint64(viper.GetInt64("consensus.blockPeriod"))
+	}
+	if viper.IsSet("consensus.maxProducers") {
+		consensus.MaxProducers = viper.GetInt("consensus.maxProducers")
+	}
+	config.Consensus = consensus
+	return config, nil
+}
+
+// loadChainConfig returns the default chain config overridden by "chain"
+// options, and false if none is set
+func loadChainConfig() (*core.ChainConfig, bool) {
+	chain := core.DefaultChainConfig()
+	set := false
+	if viper.IsSet("chain.maxCodeSize") {
+		chain.MaxCodeSize = viper.GetInt("chain.maxCodeSize")
+		set = true
+	}
+	if viper.IsSet("chain.chainId") {
+		chain.EVM.ChainId = big.NewInt(viper.GetInt64("chain.chainId"))
+		set = true
+	}
+	blocks := map[string]**big.Int{
+		"chain.resizeBlock":         &chain.ResizeBlock,
+		"chain.homesteadBlock":      &chain.EVM.HomesteadBlock,
+		"chain.eip150Block":         &chain.EVM.EIP150Block,
+		"chain.eip155Block":         &chain.EVM.EIP155Block,
+		"chain.eip158Block":         &chain.EVM.EIP158Block,
+		"chain.byzantiumBlock":      &chain.EVM.ByzantiumBlock,
+		"chain.constantinopleBlock": &chain.EVM.ConstantinopleBlock,
+	}
+	for key, block := range blocks {
+		if viper.IsSet(key) {
+			*block = forkBlock(key)
+			set = true
+		}
+	}
+	return chain, set
+}
+
+// forkBlock returns the block height of option key, or nil if it's negative,
+// which means never
+func forkBlock(key string) *big.Int {
+	height := viper.GetInt64(key)
+	if height < 0 {
+		return nil
+	}
+	return big.NewInt(height)
 }
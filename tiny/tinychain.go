@@ -9,6 +9,7 @@ import (
 	"tinychain/executor"
 	"tinychain/db/leveldb"
 	"tinychain/core/state"
+	"tinychain/debug"
 )
 
 var (
@@ -29,11 +30,17 @@ type Tinychain struct {
 
 	state *state.StateDB
 
+	snaps *state.Snapshots
+
+	pruner *state.Pruner // nil in archive mode
+
 	network Network
 
 	executor executor.Executor
 
 	pm *ProtocolManager
+
+	debug *debug.Service
 }
 
 func New(config *Config) (*Tinychain, error) {
@@ -46,18 +53,41 @@ func New(config *Config) (*Tinychain, error) {
 	}
 	// Create tiny db
 	tinyDB := db.NewTinyDB(ldb)
-	// Create state db
-	statedb := state.New(ldb, nil)
-
 	network := NewNetwork(config.p2p)
-	engine := consensus.New()
+	engine := consensus.New(config.Consensus)
 
-	bc, err := core.NewBlockchain(tinyDB, engine)
+	bc, err := core.NewBlockchain(tinyDB, config.Chain, engine)
 	if err != nil {
 		log.Error("Failed to create blockchain")
 		return nil, err
 	}
 
+	// Create state db at the head block
+	headRoot := bc.GetLastBlock().Header.StateRoot
+	var root []byte
+	if !headRoot.Nil() {
+		root = headRoot.Bytes()
+	}
+	statedb := state.New(ldb, root)
+	if statedb == nil {
+		return nil, state.ErrStateNotFound
+	}
+	var pruner *state.Pruner
+	if config.Pruner != nil {
+		pruner = state.NewPruner(ldb, config.Pruner)
+		statedb.SetPruner(pruner)
+	}
+	snapConfig := config.Snapshot
+	if snapConfig == nil {
+		snapConfig = state.DefaultSnapshotConfig()
+	}
+	snaps, err := state.NewSnapshots(ldb, headRoot, snapConfig)
+	if err != nil {
+		log.Errorf("Failed to load state snapshot, %s", err)
+		return nil, err
+	}
+	statedb.SetSnapshots(snaps)
+
 	return &Tinychain{
 		config:   config,
 		eventHub: eventHub,
@@ -66,13 +96,20 @@ func New(config *Config) (*Tinychain, error) {
 		chain:    bc,
 		engine:   engine,
 		state:    statedb,
+		snaps:    snaps,
+		pruner:   pruner,
 		pm:       NewProtocolManager(network),
+		debug:    debug.NewService(bc),
 	}, nil
 }
 
 func (chain *Tinychain) Start() {
 	// Collect protocols and register in the protocol manager
 
+	if chain.pruner != nil {
+		go chain.checkpoint(chain.eventHub.Subscribe(&core.BlockCommitEvent{}))
+	}
+
 	// start network
 	err := chain.network.Start()
 }
@@ -80,5 +117,24 @@ func (chain *Tinychain) Start() {
 func (chain *Tinychain) Stop() {
 	chain.eventHub.Stop()
 	chain.network.Stop()
+	if err := chain.snaps.Close(); err != nil {
+		log.Errorf("Failed to persist state snapshot, %s", err)
+	}
+	if chain.pruner != nil {
+		chain.pruner.Close()
+	}
+}
 
+// checkpoint retains state of committed blocks as pruner checkpoints,
+// until the event hub is stopped
+func (chain *Tinychain) checkpoint(sub event.Subscription) {
+	for ev := range sub.Chan() {
+		height := ev.(*core.BlockCommitEvent).Height
+		block, err := chain.chain.GetBlockByHeight(height)
+		if err != nil {
+			log.Errorf("Failed to get committed block %s, %s", height, err)
+			continue
+		}
+		chain.pruner.Finalize(height, block.Header.StateRoot)
+	}
 }